@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"time"
+)
+
+// Keeps the last state transition of each alarm in a DynamoDB table (with a string hash key called "alarm_key"),
+// keyed by account and alarm name, so that the next transition can tell how long the alarm was in its previous state
+type AlarmStateStore struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+// Returns nil if alarm_state_table isn't set
+func newAlarmStateStoreFromSettings(sess *session.Session) *AlarmStateStore {
+	table, exists := lookupSetting("alarm_state_table")
+	if !exists {
+		return nil
+	}
+
+	return &AlarmStateStore{
+		db: dynamodb.New(sess),
+		table: table,
+	}
+}
+
+type AlarmTransition struct {
+	State string
+	ChangedAt time.Time
+}
+
+func (s *AlarmStateStore) get(key string) (*AlarmTransition, error) {
+	res, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"alarm_key": {S: aws.String(key)},
+		},
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read alarm state from DynamoDB: " + err.Error())
+	}
+
+	state, exists := res.Item["state"]
+	changedAt, changedAtExists := res.Item["changed_at"]
+	if !exists || !changedAtExists || state.S == nil || changedAt.N == nil {
+		return nil, nil
+	}
+
+	seconds, err := strconv.ParseInt(*changedAt.N, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid alarm state change time: " + *changedAt.N)
+	}
+
+	return &AlarmTransition{State: *state.S, ChangedAt: time.Unix(seconds, 0)}, nil
+}
+
+// Transitions delivered out of order (like when an SNS delivery is retried) don't overwrite newer ones
+func (s *AlarmStateStore) put(key string, transition AlarmTransition) error {
+	changedAt := strconv.FormatInt(transition.ChangedAt.Unix(), 10)
+
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"alarm_key": {S: aws.String(key)},
+			"state": {S: aws.String(transition.State)},
+			"changed_at": {N: aws.String(changedAt)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(changed_at) OR changed_at <= :changed_at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":changed_at": {N: aws.String(changedAt)},
+		},
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	} else if err != nil {
+		return errors.New("failed to save alarm state to DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+// Like "OK → ALARM (OK for 3h 7m)", with the duration only known if the previous transition was recorded.
+// Failing to look it up only leaves out the duration.
+func (r *NotifierRegistry) alarmTransition(ctx context.Context, alarm events.CloudwatchAlarm) string {
+	summary := alarm.OldStateValue + " → " + alarm.NewStateValue
+
+	if r.alarmStates == nil {
+		return summary
+	}
+
+	changedAt, err := notify.ParseTimestamp(alarm.StateChangeTime)
+	if err != nil {
+		return summary
+	}
+
+	key := alarm.AWSAccountId + "/" + alarm.AlarmName
+
+	previous, err := r.alarmStates.get(key)
+	if err != nil {
+		logger(ctx).Warn(err.Error(), "alarm_name", alarm.AlarmName)
+	} else if previous != nil && previous.State == alarm.OldStateValue && !changedAt.Before(previous.ChangedAt) {
+		summary += " (" + alarm.OldStateValue + " for " + notify.HumanDuration(changedAt.Sub(previous.ChangedAt)) + ")"
+	}
+
+	if err := r.alarmStates.put(key, AlarmTransition{State: alarm.NewStateValue, ChangedAt: changedAt}); err != nil {
+		logger(ctx).Warn(err.Error(), "alarm_name", alarm.AlarmName)
+	}
+
+	return summary
+}
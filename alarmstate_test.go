@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"github.com/motns/aws-notifier/pkg/events"
+	"testing"
+)
+
+func TestAlarmTransition(t *testing.T) {
+	_, db := newFakeDynamoDB(t, "alarm_key")
+
+	registry := newNotifierRegistry()
+	registry.alarmStates = &AlarmStateStore{db: db, table: "alarm_states"}
+
+	// Applied in order, against the same table
+	tests := []struct {
+		name string
+		oldState string
+		newState string
+		changedAt string
+		expected string
+	}{
+		{"first transition", "OK", "ALARM", "2024-03-01T12:00:00.000+0000", "OK → ALARM"},
+		{"after the first one", "ALARM", "OK", "2024-03-01T15:07:00.000+0000", "ALARM → OK (ALARM for 3h 7m)"},
+		{"delivered out of order", "OK", "ALARM", "2024-03-01T12:00:00.000+0000", "OK → ALARM"},
+		{"not overwritten by the one out of order", "OK", "ALARM", "2024-03-02T15:07:00.000+0000", "OK → ALARM (OK for 1d)"},
+		{"previous state doesn't match", "INSUFFICIENT_DATA", "OK", "2024-03-02T16:00:00.000+0000", "INSUFFICIENT_DATA → OK"},
+		{"invalid timestamp", "OK", "ALARM", "yesterday", "OK → ALARM"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transition := registry.alarmTransition(context.Background(), events.CloudwatchAlarm {
+				AlarmName: "cpu-high",
+				AWSAccountId: "123456789012",
+				OldStateValue: test.oldState,
+				NewStateValue: test.newState,
+				StateChangeTime: test.changedAt,
+			})
+
+			if transition != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, transition)
+			}
+		})
+	}
+}
+
+// Failing to look up the previous transition only leaves out the duration
+func TestAlarmTransitionFailingTable(t *testing.T) {
+	states, db := newFakeDynamoDB(t, "alarm_key")
+	states.setFailing(true)
+
+	registry := newNotifierRegistry()
+	registry.alarmStates = &AlarmStateStore{db: db, table: "alarm_states"}
+
+	transition := registry.alarmTransition(context.Background(), events.CloudwatchAlarm {
+		AlarmName: "cpu-high",
+		OldStateValue: "OK",
+		NewStateValue: "ALARM",
+		StateChangeTime: "2024-03-01T12:00:00.000+0000",
+	})
+
+	if transition != "OK → ALARM" {
+		t.Errorf("expected the transition without a duration, got %q", transition)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/events"
+)
+
+/**
+Example Alertmanager webhook payload (POST to /webhooks/alertmanager):
+
+{
+  "version": "4",
+  "groupKey": "{}:{alertname=\"HighErrorRate\"}",
+  "truncatedAlerts": 0,
+  "status": "firing",
+  "receiver": "aws-notifier",
+  "groupLabels": {"alertname": "HighErrorRate"},
+  "commonLabels": {"alertname": "HighErrorRate", "severity": "critical"},
+  "commonAnnotations": {"summary": "High error rate on payments-api"},
+  "externalURL": "https://alertmanager.example.com",
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "HighErrorRate", "severity": "critical", "service": "payments-api"},
+      "annotations": {
+        "summary": "High error rate on payments-api",
+        "description": "5.2% of requests failed over the last 5 minutes",
+        "runbook_url": "https://wiki.example.com/runbooks/high-error-rate"
+      },
+      "startsAt": "2019-01-01T00:00:00.000Z",
+      "endsAt": "0001-01-01T00:00:00Z",
+      "generatorURL": "https://prometheus.example.com/graph?g0.expr=...",
+      "fingerprint": "c4ac2a5a6bd0e0c2"
+    }
+  ]
+}
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Event processor
+
+func init() {
+	registerWebhookHandler(WebhookHandler {
+		name: "alertmanager",
+		handle: processAlertmanagerWebhook,
+	})
+}
+
+// Alerts in a group are sent as separate notifications, so that each of them gets its own thread and
+// incident, and is resolved on its own (like Cloudwatch Alarms)
+func processAlertmanagerWebhook(ctx context.Context, notifiers *NotifierRegistry, req HTTPRequest) error {
+	var payload events.AlertmanagerWebhook
+
+	if err := json.Unmarshal([]byte(req.Body), &payload); err != nil {
+		return &InvalidPayloadError{Err: err}
+	}
+
+	if len(payload.Alerts) == 0 {
+		return &InvalidPayloadError{Err: errors.New("no alerts in payload")}
+	}
+
+	var failures MultiError
+
+	for _, alert := range payload.Alerts {
+		failures.add("alert " + alert.Labels["alertname"], notifiers.send(ctx, events.AlertNotification("alertmanager", payload.GroupKey, alert)))
+	}
+
+	return failures.errorOrNil()
+}
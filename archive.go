@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultArchivePrefix = "notifications/"
+
+// Limits of a single PutRecordBatch call
+const FirehoseMaxBatchRecords = 500
+const FirehoseMaxBatchBytes = 4 * 1024 * 1024
+
+// One line of an archive object
+type ArchiveRecord struct {
+	Time string `json:"time"` // When the notification was sent (RFC 3339, UTC)
+	EventTime string `json:"event_time,omitempty"`
+	Source string `json:"source"`
+	DetailType string `json:"detail_type"`
+	Account string `json:"account,omitempty"`
+	Region string `json:"region,omitempty"`
+	AlarmName string `json:"alarm_name,omitempty"`
+	Title string `json:"title"`
+	Summary string `json:"summary"`
+	Severity string `json:"severity"`
+	Channels []string `json:"channels"`
+	FailedChannels []string `json:"failed_channels"`
+	SavedChannels []string `json:"saved_channels"` // Failed, but saved for retrying later
+	Event interface{} `json:"event,omitempty"`
+}
+
+// Archives every notification sent (or attempted) to S3 as newline-delimited JSON, partitioned Hive-style like
+// "<prefix>source=aws.ec2/dt=2024-03-01/hour=12/", so that Athena can query it (and prune partitions) as is.
+// Records are buffered for the duration of the invocation, and written as one object per partition at the end.
+// With a Firehose delivery stream, they're sent to the stream instead, which converts them to Parquet (and
+// partitions them) on the way to S3.
+type ArchiveStore struct {
+	s3 *s3.S3
+	bucket string
+	prefix string
+	firehose *firehose.Firehose
+	stream string
+	lock sync.Mutex
+	pending map[string]*bytes.Buffer // Keyed by partition
+}
+
+// Returns nil if neither archive_bucket nor archive_firehose_stream is set
+func newArchiveStoreFromSettings(sess *session.Session) *ArchiveStore {
+	if stream, exists := lookupSetting("archive_firehose_stream"); exists {
+		return &ArchiveStore{
+			firehose: firehose.New(sess),
+			stream: stream,
+		}
+	}
+
+	bucket, exists := lookupSetting("archive_bucket")
+	if !exists {
+		return nil
+	}
+
+	archive := &ArchiveStore{
+		s3: s3.New(sess),
+		bucket: bucket,
+		prefix: DefaultArchivePrefix,
+	}
+
+	if prefix, exists := lookupSetting("archive_prefix"); exists {
+		archive.prefix = prefix
+	}
+
+	return archive
+}
+
+func (a *ArchiveStore) add(notification notify.Notification, channels []string, failed []string, saved []string, now time.Time) error {
+	now = now.UTC()
+
+	line, err := json.Marshal(ArchiveRecord {
+		Time: now.Format(time.RFC3339),
+		EventTime: notification.Time,
+		Source: notification.Source,
+		DetailType: notification.DetailType,
+		Account: notification.Account,
+		Region: notification.Region,
+		AlarmName: notification.AlarmName,
+		Title: notification.Title,
+		Summary: notification.Summary,
+		Severity: notification.Severity,
+		Channels: channels,
+		FailedChannels: failed,
+		SavedChannels: saved,
+		Event: notification.Event,
+	})
+
+	if err != nil {
+		return errors.New("failed to marshal archive record: " + err.Error())
+	}
+
+	// The delivery stream does its own partitioning
+	partition := ""
+	if a.stream == "" {
+		partition = "source=" + archivePartitionValue(notification.Source) + "/dt=" + now.Format("2006-01-02") +
+			"/hour=" + now.Format("15") + "/"
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.pending == nil {
+		a.pending = make(map[string]*bytes.Buffer)
+	}
+
+	if a.pending[partition] == nil {
+		a.pending[partition] = &bytes.Buffer{}
+	}
+
+	a.pending[partition].Write(line)
+	a.pending[partition].WriteByte('\n')
+
+	return nil
+}
+
+// Partition values end up in the object key, so they can't contain slashes
+func archivePartitionValue(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+
+	return strings.NewReplacer("/", "_", "=", "_").Replace(value)
+}
+
+// Writes out the buffered records, one object per partition
+func (a *ArchiveStore) flush(ctx context.Context) error {
+	a.lock.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.lock.Unlock()
+
+	if a.stream != "" {
+		if pending[""] == nil {
+			return nil
+		}
+
+		lines := bytes.SplitAfter(pending[""].Bytes(), []byte("\n"))
+		return a.sendToFirehose(ctx, lines[:len(lines) - 1]) // The last one is empty, after the final newline
+	}
+
+	var partitions []string
+	for partition := range pending {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+
+	var failures MultiError
+
+	for _, partition := range partitions {
+		body := pending[partition].Bytes()
+		hash := sha1.Sum(body)
+		key := a.prefix + partition + strconv.FormatInt(time.Now().UnixNano(), 10) + "-" +
+			hex.EncodeToString(hash[:])[:12] + ".json"
+
+		_, err := a.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(a.bucket),
+			Key: aws.String(key),
+			Body: bytes.NewReader(body),
+			ContentType: aws.String("application/x-ndjson"),
+		})
+
+		if err != nil {
+			failures.add("archive partition " + partition, errors.New("failed to upload archive to S3: " + err.Error()))
+			continue
+		}
+
+		logger(ctx).Debug("Archived notifications to S3", "bucket", a.bucket, "key", key)
+	}
+
+	return failures.errorOrNil()
+}
+
+// Sends the records (each one a line of JSON, so that the objects the stream writes are newline-delimited) in as few
+// batches as the limits of PutRecordBatch allow
+func (a *ArchiveStore) sendToFirehose(ctx context.Context, lines [][]byte) error {
+	var failures MultiError
+
+	for start := 0; start < len(lines); {
+		var records []*firehose.Record
+		size := 0
+
+		for start < len(lines) && len(records) < FirehoseMaxBatchRecords {
+			if len(records) != 0 && size + len(lines[start]) > FirehoseMaxBatchBytes {
+				break
+			}
+
+			records = append(records, &firehose.Record{Data: lines[start]})
+			size += len(lines[start])
+			start++
+		}
+
+		res, err := a.firehose.PutRecordBatchWithContext(ctx, &firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(a.stream),
+			Records: records,
+		})
+
+		if err != nil {
+			failures.add("archive batch", errors.New("failed to send archive to Firehose: " + err.Error()))
+		} else if failed := aws.Int64Value(res.FailedPutCount); failed != 0 {
+			failures.add("archive batch", errors.New("Firehose rejected " + strconv.FormatInt(failed, 10) + " of " +
+				strconv.Itoa(len(records)) + " archive records"))
+		} else {
+			logger(ctx).Debug("Archived notifications to Firehose", "stream", a.stream, "records", len(records))
+		}
+	}
+
+	return failures.errorOrNil()
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (r *NotifierRegistry) recordArchive(ctx context.Context, notification notify.Notification, channels []string, failed []string,
+	saved []string) {
+	if r.archive == nil {
+		return
+	}
+
+	if err := r.archive.add(notification, channels, failed, saved, time.Now()); err != nil {
+		logger(ctx).Warn(err.Error())
+	}
+}
+
+// Failing to archive doesn't fail the invocation, since the notifications have been sent already
+func (r *NotifierRegistry) flushArchive(ctx context.Context) {
+	if r.archive == nil {
+		return
+	}
+
+	if err := r.archive.flush(ctx); err != nil {
+		logger(ctx).Error(err.Error())
+	}
+}
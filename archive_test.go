@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the batches put to it, and rejects the given number of records in each
+type fakeFirehose struct {
+	batches [][][]byte
+	rejected int
+	lock sync.Mutex
+}
+
+func (f *fakeFirehose) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var req struct {
+		Records []struct{ Data []byte }
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	var batch [][]byte
+	for _, record := range req.Records {
+		batch = append(batch, record.Data)
+	}
+	f.batches = append(f.batches, batch)
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(map[string]interface{}{"FailedPutCount": f.rejected, "RequestResponses": []interface{}{}})
+}
+
+func TestArchiveToFirehose(t *testing.T) {
+	tests := []struct {
+		name string
+		notifications int
+		rejected int
+		batches []int // Number of records in each
+		valid bool
+	}{
+		{"nothing to archive", 0, 0, nil, true},
+		{"single batch", 3, 0, []int{3}, true},
+		{"split at the record limit", FirehoseMaxBatchRecords + 1, 0, []int{FirehoseMaxBatchRecords, 1}, true},
+		{"records rejected", 2, 1, []int{2}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeFirehose{rejected: test.rejected}
+			server := httptest.NewServer(fake)
+			t.Cleanup(server.Close)
+
+			sess := session.Must(session.NewSession(&aws.Config{
+				Region: aws.String("eu-west-1"),
+				Endpoint: aws.String(server.URL),
+				Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+				MaxRetries: aws.Int(0),
+			}))
+
+			archive := &ArchiveStore{firehose: firehose.New(sess), stream: "aws-notifier-archive"}
+
+			for i := 0; i < test.notifications; i++ {
+				notification := notify.Notification{Source: "aws.ec2", Title: "Notification " + strconv.Itoa(i)}
+				if err := archive.add(notification, []string{"ops"}, nil, nil, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := archive.flush(context.Background())
+			if test.valid && err != nil {
+				t.Fatal(err)
+			} else if !test.valid && err == nil {
+				t.Error("expected the rejected records to be reported")
+			}
+
+			if len(fake.batches) != len(test.batches) {
+				t.Fatalf("expected %d batches, got %d", len(test.batches), len(fake.batches))
+			}
+
+			for i, batch := range fake.batches {
+				if len(batch) != test.batches[i] {
+					t.Errorf("expected %d records in batch %d, got %d", test.batches[i], i, len(batch))
+				}
+
+				// Each record is a line of JSON on its own
+				for _, data := range batch {
+					var record ArchiveRecord
+					if data[len(data) - 1] != '\n' || json.Unmarshal(data, &record) != nil || record.Source != "aws.ec2" {
+						t.Fatalf("expected a line of JSON, got %q", data)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const AuditRetention = 7 * 24 * time.Hour
+const DailyReportPeriod = 24 * time.Hour
+
+// Scheduled events can be delivered more than once, so reports closer together than this are skipped as duplicates.
+// It's well under a day, so that the rule firing a little early doesn't skip a report.
+const DailyReportMinInterval = 12 * time.Hour
+const DailyReportTopAlarms = 5
+
+// Key of the item recording when the daily report was last posted, next to the audit records
+const DailyReportKey = "report/daily"
+
+// Keeps a record of every notification sent (or attempted), in a DynamoDB table with a string hash key called
+// "audit_key". Records expire after AuditRetention, given TTL is enabled on the "expires_at" attribute.
+type AuditStore struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+// Returns nil if audit_table isn't set
+func newAuditStoreFromSettings(sess *session.Session) *AuditStore {
+	table, exists := lookupSetting("audit_table")
+	if !exists {
+		return nil
+	}
+
+	return &AuditStore{
+		db: dynamodb.New(sess),
+		table: table,
+	}
+}
+
+type AuditRecord struct {
+	Key string
+	Source string
+	Severity string
+	Alarm string // Name of the alarm, for alarms (and alerts from monitoring tools)
+	Channels []string
+	FailedChannels []string
+	SavedChannels []string // Failed, but saved for retrying later
+	RecordedAt time.Time
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Daily Report",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			channels := getListSetting("daily_report_channels")
+			if notifiers.audit == nil || len(channels) == 0 || !scheduledBy(event, getSetting("daily_report_rule")) {
+				return nil
+			}
+
+			return notifiers.postDailyReport(ctx, channels, time.Now())
+		},
+	})
+}
+
+func (s *AuditStore) add(record AuditRecord) error {
+	channels, err := json.Marshal(record.Channels)
+	if err != nil {
+		return errors.New("failed to marshal audit channels: " + err.Error())
+	}
+
+	failed, err := json.Marshal(record.FailedChannels)
+	if err != nil {
+		return errors.New("failed to marshal failed audit channels: " + err.Error())
+	}
+
+	saved, err := json.Marshal(record.SavedChannels)
+	if err != nil {
+		return errors.New("failed to marshal saved audit channels: " + err.Error())
+	}
+
+	_, err = s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"audit_key": {S: aws.String(record.Key)},
+			"source": {S: aws.String(record.Source)},
+			"severity": {S: aws.String(record.Severity)},
+			"alarm": {S: aws.String(record.Alarm)},
+			"channels": {S: aws.String(string(channels))},
+			"failed_channels": {S: aws.String(string(failed))},
+			"saved_channels": {S: aws.String(string(saved))},
+			"recorded_at": {N: aws.String(strconv.FormatInt(record.RecordedAt.Unix(), 10))},
+			"expires_at": {N: aws.String(strconv.FormatInt(record.RecordedAt.Add(AuditRetention).Unix(), 10))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to save audit record to DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+func (s *AuditStore) since(start time.Time) ([]AuditRecord, error) {
+	var records []AuditRecord
+
+	err := s.db.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(s.table),
+		FilterExpression: aws.String("recorded_at >= :start"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":start": {N: aws.String(strconv.FormatInt(start.Unix(), 10))},
+		},
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			record := AuditRecord{}
+
+			for attribute, value := range map[string]*string{
+				"source": &record.Source,
+				"severity": &record.Severity,
+				"alarm": &record.Alarm,
+			} {
+				if item[attribute] != nil {
+					*value = aws.StringValue(item[attribute].S)
+				}
+			}
+
+			if item["channels"] != nil {
+				json.Unmarshal([]byte(aws.StringValue(item["channels"].S)), &record.Channels)
+			}
+
+			if item["failed_channels"] != nil {
+				json.Unmarshal([]byte(aws.StringValue(item["failed_channels"].S)), &record.FailedChannels)
+			}
+
+			if item["saved_channels"] != nil {
+				json.Unmarshal([]byte(aws.StringValue(item["saved_channels"].S)), &record.SavedChannels)
+			}
+
+			if item["recorded_at"] != nil {
+				seconds, _ := strconv.ParseInt(aws.StringValue(item["recorded_at"].N), 10, 64)
+				record.RecordedAt = time.Unix(seconds, 0)
+			}
+
+			records = append(records, record)
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read audit records from DynamoDB: " + err.Error())
+	}
+
+	return records, nil
+}
+
+// Returns whether the daily report is due, marking it as posted if it is. Uses a conditional write, so that
+// concurrent invocations can't both post it. If posting it fails, the claim has to be released (see
+// releaseDailyReport), so that a retry can post it.
+func (s *AuditStore) claimDailyReport(now time.Time) (bool, error) {
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"audit_key": {S: aws.String(DailyReportKey)},
+			"reported_at": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(audit_key) OR reported_at <= :cutoff"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":cutoff": {N: aws.String(strconv.FormatInt(now.Add(-DailyReportMinInterval).Unix(), 10))},
+		},
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	} else if err != nil {
+		return false, errors.New("failed to record daily report in DynamoDB: " + err.Error())
+	}
+
+	return true, nil
+}
+
+// Only removes the claim made at the given time, in case another invocation has claimed the report since
+func (s *AuditStore) releaseDailyReport(claimedAt time.Time) error {
+	_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"audit_key": {S: aws.String(DailyReportKey)},
+		},
+		ConditionExpression: aws.String("reported_at = :reported_at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":reported_at": {N: aws.String(strconv.FormatInt(claimedAt.Unix(), 10))},
+		},
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	} else if err != nil {
+		return errors.New("failed to release daily report in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Failing to write the audit log doesn't affect delivery
+func (r *NotifierRegistry) recordAudit(ctx context.Context, notification notify.Notification, channels []string, failed []string,
+	saved []string) {
+	if r.audit == nil {
+		return
+	}
+
+	now := time.Now()
+
+	// Unique enough, since the same notification isn't sent twice in the same nanosecond
+	err := r.audit.add(AuditRecord {
+		Key: strconv.FormatInt(now.UnixNano(), 10) + "-" + dedupeKey(notification)[:16],
+		Source: notification.Source,
+		Severity: notification.Severity,
+		Alarm: notification.AlarmName,
+		Channels: channels,
+		FailedChannels: failed,
+		SavedChannels: saved,
+		RecordedAt: now,
+	})
+
+	if err != nil {
+		logger(ctx).Warn(err.Error())
+	}
+}
+
+// Posts a summary of the notifications sent over the past day, once a day. Triggered by a dedicated Cloudwatch
+// Events schedule rule (like "cron(0 8 * * ? *)"), like the cost report.
+func (r *NotifierRegistry) postDailyReport(ctx context.Context, channels []string, now time.Time) error {
+	due, err := r.audit.claimDailyReport(now)
+	if err != nil || !due {
+		return err
+	}
+
+	if err := r.sendDailyReport(ctx, channels, now); err != nil {
+		if releaseErr := r.audit.releaseDailyReport(now); releaseErr != nil {
+			logger(ctx).Warn(releaseErr.Error())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (r *NotifierRegistry) sendDailyReport(ctx context.Context, channels []string, now time.Time) error {
+	records, err := r.audit.since(now.Add(-DailyReportPeriod))
+	if err != nil {
+		return err
+	}
+
+	notification := dailyReportNotification(records, now)
+
+	var failures MultiError
+
+	for _, name := range channels {
+		notifier, exists := r.notifiers[name]
+		if !exists {
+			logger(ctx).Warn("Skipping daily report for unknown channel", "channel", name)
+			continue
+		}
+
+		failures.add("daily report via " + name, notifier.Send(ctx, notification))
+	}
+
+	return failures.errorOrNil()
+}
+
+func dailyReportNotification(records []AuditRecord, now time.Time) notify.Notification {
+	sources := make(map[string]int)
+	severities := make(map[string]int)
+	alarms := make(map[string]int)
+	failures := make(map[string]int)
+	retries := make(map[string]int)
+
+	for _, record := range records {
+		sources[record.Source]++
+		severities[record.Severity]++
+
+		if record.Alarm != "" {
+			alarms[record.Alarm]++
+		}
+
+		for _, channel := range record.FailedChannels {
+			failures[channel]++
+		}
+
+		for _, channel := range record.SavedChannels {
+			retries[channel]++
+		}
+	}
+
+	countLines := func(counts map[string]int, limit int) string {
+		var lines []string
+		for i, c := range sortedCounts(counts) {
+			if limit != 0 && i == limit {
+				break
+			}
+
+			lines = append(lines, strconv.Itoa(c.count) + " x " + c.name)
+		}
+
+		if len(lines) == 0 {
+			return "None"
+		}
+
+		return strings.Join(lines, "\n")
+	}
+
+	title := "Daily Report - " + strconv.Itoa(len(records)) + " notification(s) in the last 24 hours"
+
+	severity := notify.SeverityInfo
+	if len(failures) != 0 || len(retries) != 0 {
+		severity = notify.SeverityWarn
+	}
+
+	return notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Daily Report",
+		Title: title,
+		Summary: title,
+		Severity: severity,
+		Fields: []notify.Field {
+			{
+				Title: "By Source",
+				Value: countLines(sources, 0),
+				Short: true,
+			},
+			{
+				Title: "By Severity",
+				Value: countLines(severities, 0),
+				Short: true,
+			},
+			{
+				Title: "Top Alarms",
+				Value: countLines(alarms, DailyReportTopAlarms),
+				Short: false,
+			},
+			{
+				Title: "Delivery Failures",
+				Value: countLines(failures, 0),
+				Short: false,
+			},
+			{
+				Title: "Saved for Retry",
+				Value: countLines(retries, 0),
+				Short: false,
+			},
+		},
+		Time: now.UTC().Format(time.RFC3339),
+	}
+}
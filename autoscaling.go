@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+)
+
+func init() {
+	registerEventHandler(EventHandler {
+		name: "Autoscaling Event",
+		matches: matchEvent("aws.autoscaling"),
+		handle: processAutoscalingEvent,
+	})
+}
+
+func processAutoscalingEvent(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+	var notification notify.Notification
+	var groupName string
+
+	if contains([]string{"EC2 Instance-launch Lifecycle Action", "EC2 Instance-terminate Lifecycle Action"}, event.DetailType) {
+		var eventDetail events.DetailAutoScalingLifecycleEvent
+
+		err := json.Unmarshal(event.Detail, &eventDetail)
+		if err != nil {
+			return errors.New("unsupported Autoscaling Lifecycle Event Detail: " + err.Error())
+		}
+
+		notification = events.AutoScalingLifecycleNotification(event, eventDetail)
+
+		// Allow operators to release the termination hook straight from Slack
+		if event.DetailType == "EC2 Instance-terminate Lifecycle Action" {
+			action, err := lifecycleActionButton(eventDetail)
+			if err != nil {
+				return err
+			}
+
+			notification.Actions = []notify.Action{action}
+		}
+
+		groupName = eventDetail.AutoScalingGroupName
+	} else {
+		var eventDetail events.DetailAutoScalingEC2Event
+
+		err := json.Unmarshal(event.Detail, &eventDetail)
+		if err != nil {
+			return errors.New("unsupported Autoscaling Event Detail: " + err.Error())
+		}
+
+		notification = events.AutoScalingActivityNotification(event, eventDetail)
+		groupName = eventDetail.AutoScalingGroupName
+	}
+
+	// Show the state of the fleet after the activity, not just the instance involved
+	group, err := notifiers.resources.autoScalingGroup(ctx, event.Region, groupName)
+	if err != nil {
+		logger(ctx).Warn("Could not describe Autoscaling Group", "group", groupName, "error", err.Error())
+	} else if group != nil {
+		notification.Fields = append(notification.Fields, group.fields()...)
+	}
+
+	return notifiers.send(ctx, notification)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+const DefaultBreakerThreshold = 5
+const DefaultBreakerCooldown = 5 * time.Minute
+
+// Stops sending notifications to a destination which keeps failing (with temporary errors), so that
+// we don't burn Lambda time on a dead endpoint. After threshold consecutive failures, the circuit
+// opens for the cooldown period, after which the next notification is let through as a trial. State
+// is shared across invocations via a DynamoDB table with a string hash key called "breaker_key".
+type CircuitBreaker struct {
+	db *dynamodb.DynamoDB
+	table string
+	threshold int
+	cooldown time.Duration
+}
+
+// Returns nil if circuit_breaker_table isn't set
+func newCircuitBreakerFromSettings(sess *session.Session) (*CircuitBreaker, error) {
+	table, exists := lookupSetting("circuit_breaker_table")
+	if !exists {
+		return nil, nil
+	}
+
+	breaker := &CircuitBreaker{
+		db: dynamodb.New(sess),
+		table: table,
+		threshold: DefaultBreakerThreshold,
+		cooldown: DefaultBreakerCooldown,
+	}
+
+	var err error
+
+	if threshold, exists := lookupSetting("circuit_breaker_threshold"); exists {
+		if breaker.threshold, err = strconv.Atoi(threshold); err != nil {
+			return nil, errors.New("could not parse circuit_breaker_threshold: " + err.Error())
+		}
+	}
+
+	if cooldown, exists := lookupSetting("circuit_breaker_cooldown"); exists {
+		if breaker.cooldown, err = time.ParseDuration(cooldown); err != nil {
+			return nil, errors.New("could not parse circuit_breaker_cooldown: " + err.Error())
+		}
+	}
+
+	return breaker, nil
+}
+
+// Returns whether the circuit is open, and how many consecutive failures there were
+func (b *CircuitBreaker) state(name string) (bool, int, error) {
+	res, err := b.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(b.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"breaker_key": {S: aws.String(name)},
+		},
+	})
+
+	if err != nil {
+		return false, 0, errors.New("failed to read circuit breaker from DynamoDB: " + err.Error())
+	}
+
+	failures := 0
+	if attribute, exists := res.Item["failures"]; exists && attribute.N != nil {
+		failures, _ = strconv.Atoi(*attribute.N)
+	}
+
+	openUntil, exists := res.Item["open_until"]
+	if !exists || openUntil.N == nil {
+		return false, failures, nil
+	}
+
+	until, _ := strconv.ParseInt(*openUntil.N, 10, 64)
+
+	return time.Now().Unix() < until, failures, nil
+}
+
+func (b *CircuitBreaker) recordFailure(name string) error {
+	res, err := b.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(b.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"breaker_key": {S: aws.String(name)},
+		},
+		UpdateExpression: aws.String("ADD failures :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+	})
+
+	if err != nil {
+		return errors.New("failed to record failure in DynamoDB: " + err.Error())
+	}
+
+	failures := 0
+	if attribute, exists := res.Attributes["failures"]; exists && attribute.N != nil {
+		failures, _ = strconv.Atoi(*attribute.N)
+	}
+
+	if failures < b.threshold {
+		return nil
+	}
+
+	_, err = b.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(b.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"breaker_key": {S: aws.String(name)},
+		},
+		UpdateExpression: aws.String("SET open_until = :until"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": {N: aws.String(strconv.FormatInt(time.Now().Add(b.cooldown).Unix(), 10))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to open circuit breaker in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+// Closes the circuit, and resets the failure count
+func (b *CircuitBreaker) recordSuccess(name string) error {
+	_, err := b.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(b.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"breaker_key": {S: aws.String(name)},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to close circuit breaker in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The circuit opens after threshold consecutive temporary failures, and closes again once a trial delivery
+// (after the cooldown) succeeds
+func TestCircuitBreaker(t *testing.T) {
+	breakers, db := newFakeDynamoDB(t, "breaker_key")
+
+	registry := newNotifierRegistry()
+	registry.breaker = &CircuitBreaker{db: db, table: "breakers", threshold: 3, cooldown: time.Minute}
+
+	ops := &recordingNotifier{failing: true, err: &notify.HTTPError{Service: "Slack", StatusCode: 503}}
+	notification := notify.Notification{Title: "ALARM: \"cpu-high\"", Severity: notify.SeverityError}
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		if err := registry.sendVia(ctx, "ops", ops, notification); err == nil {
+			t.Fatalf("expected delivery %d to fail", i)
+		}
+	}
+
+	if open, failures, err := registry.breaker.state("ops"); err != nil || !open || failures != 3 {
+		t.Fatalf("expected the circuit to be open after 3 failures, got open: %v, failures: %d, error: %v", open, failures, err)
+	}
+
+	// Dropped without trying, since there's no queue to put it in
+	ops.failing = false
+
+	if err := registry.sendVia(ctx, "ops", ops, notification); err != nil {
+		t.Errorf("expected the notification to be dropped without an error, got: %v", err)
+	}
+
+	if ops.count() != 0 {
+		t.Errorf("expected nothing to be sent while the circuit is open, got %d notifications", ops.count())
+	}
+
+	// Queued instead, if there is a queue
+	queue, queueDB := newFakeDynamoDB(t, "queue_key")
+	registry.queue = &NotificationQueue{db: queueDB, table: "queue"}
+
+	if err := registry.sendVia(ctx, "ops", ops, notification); err != nil {
+		t.Errorf("expected the notification to be queued without an error, got: %v", err)
+	}
+
+	if queue.count() != 1 || ops.count() != 0 {
+		t.Errorf("expected the notification to be queued, got %d queued and %d sent", queue.count(), ops.count())
+	}
+
+	// Once the cooldown is over, the trial delivery goes through and closes the circuit
+	breakers.put(map[string]map[string]string{
+		"breaker_key": {"S": "ops"},
+		"failures": {"N": "3"},
+		"open_until": {"N": strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)},
+	})
+
+	if err := registry.sendVia(ctx, "ops", ops, notification); err != nil {
+		t.Fatalf("expected the trial delivery to succeed, got: %v", err)
+	}
+
+	if ops.count() != 1 {
+		t.Errorf("expected the trial delivery to be sent, got %d notifications", ops.count())
+	}
+
+	if breakers.item("ops") != nil {
+		t.Errorf("expected the circuit to be closed, got: %v", breakers.item("ops"))
+	}
+}
+
+// Permanent errors (like a bad request) don't say anything about the health of the endpoint
+func TestCircuitBreakerPermanentErrors(t *testing.T) {
+	breakers, db := newFakeDynamoDB(t, "breaker_key")
+
+	registry := newNotifierRegistry()
+	registry.breaker = &CircuitBreaker{db: db, table: "breakers", threshold: 1, cooldown: time.Minute}
+
+	ops := &recordingNotifier{failing: true, err: &notify.HTTPError{Service: "Slack", StatusCode: 400}}
+
+	if err := registry.sendVia(context.Background(), "ops", ops, notify.Notification{Title: "Test"}); err == nil {
+		t.Fatal("expected the delivery to fail")
+	}
+
+	if breakers.count() != 0 {
+		t.Errorf("expected no failures to be recorded, got: %v", breakers.item("ops"))
+	}
+}
+
+func TestNewCircuitBreakerFromSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		threshold string
+		cooldown string
+		expectedThreshold int
+		expectedCooldown time.Duration
+		fails bool
+	}{
+		{"defaults", "", "", DefaultBreakerThreshold, DefaultBreakerCooldown, false},
+		{"configured", "10", "1m", 10, time.Minute, false},
+		{"invalid threshold", "ten", "", 0, 0, true},
+		{"invalid cooldown", "", "soon", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("circuit_breaker_table", "breakers")
+
+			if test.threshold != "" {
+				t.Setenv("circuit_breaker_threshold", test.threshold)
+			}
+
+			if test.cooldown != "" {
+				t.Setenv("circuit_breaker_cooldown", test.cooldown)
+			}
+
+			breaker, err := newCircuitBreakerFromSettings(session.Must(session.NewSession()))
+			if test.fails {
+				if err == nil {
+					t.Error("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if breaker.threshold != test.expectedThreshold || breaker.cooldown != test.expectedCooldown {
+				t.Errorf("expected threshold %d and cooldown %v, got %d and %v", test.expectedThreshold,
+					test.expectedCooldown, breaker.threshold, breaker.cooldown)
+			}
+		})
+	}
+}
@@ -1,5 +1,30 @@
 #!/usr/bin/env bash
 
+# Builds for the provided.al2023 runtime on arm64 (Graviton) by default, where the executable has to be called
+# "bootstrap". Set RUNTIME=go1.x to build for the old Go runtime instead (which only runs on x86_64).
+RUNTIME=${RUNTIME:-provided.al2023}
+
+if [ "$RUNTIME" = "go1.x" ]; then
+  ARCH=${ARCH:-amd64}
+  OUTPUT=aws-notifier
+  TAGS=""
+else
+  ARCH=${ARCH:-arm64}
+  OUTPUT=bootstrap
+  TAGS="lambda.norpc" # Leaves out the RPC mode only go1.x uses
+fi
+
 rm -f deploy.zip || true
-GOOS=linux GOARCH=amd64 go build -v
-zip deploy.zip aws-notifier
\ No newline at end of file
+VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+
+GOOS=linux GOARCH=${ARCH} CGO_ENABLED=0 go build -v -tags "${TAGS}" -o ${OUTPUT} -ldflags "-X main.version=${VERSION} -X main.commit=${COMMIT}"
+zip deploy.zip ${OUTPUT}
+
+if [ -f policy.rego ]; then
+  zip deploy.zip policy.rego
+fi
+
+if [ -f config.yaml ]; then
+  zip deploy.zip config.yaml
+fi
\ No newline at end of file
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"golang.org/x/sync/errgroup"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Days before expiry certificates are reported within, smallest first. Expired certificates (and ones within the
+// smallest window) are reported as errors, anything else as warnings.
+var DefaultCertificateWindows = []int{7, 14, 30}
+
+const CertificateHandshakeTimeout = 5 * time.Second
+const CertificateScanConcurrency = 8
+
+// Scans the certificates in ACM (in the configured regions), and the ones served by a list of external endpoints,
+// for any expiring within the configured windows, and posts a warning about them. Triggered by a dedicated
+// Cloudwatch Events schedule rule (like "cron(0 8 * * ? *)"), like the cost report. Nothing is posted if no
+// certificates are expiring.
+type CertificateScan struct {
+	sess *session.Session
+	rule string // Name of the schedule rule which triggers the scan
+	channels []string
+	windows []int // Days, smallest first
+	regions []string // ACM is skipped if empty
+	endpoints []string // Like "example.com" or "example.com:8443"
+}
+
+// Returns nil if certificate_scan_rule isn't set
+func newCertificateScanFromSettings(sess *session.Session) (*CertificateScan, error) {
+	rule, exists := lookupSetting("certificate_scan_rule")
+	if !exists {
+		return nil, nil
+	}
+
+	scan := &CertificateScan{
+		sess: sess,
+		rule: rule,
+		channels: getListSetting("certificate_scan_channels", "slack"),
+		windows: DefaultCertificateWindows,
+		regions: getListSetting("certificate_scan_regions", aws.StringValue(sess.Config.Region)),
+		endpoints: getListSetting("certificate_scan_endpoints"),
+	}
+
+	if windows, exists := lookupSetting("certificate_scan_windows"); exists {
+		scan.windows = nil
+
+		for _, window := range strings.Split(windows, ",") {
+			days, err := strconv.Atoi(strings.TrimSpace(window))
+			if err != nil || days < 1 {
+				return nil, errors.New("invalid certificate_scan_windows: " + windows)
+			}
+
+			scan.windows = append(scan.windows, days)
+		}
+
+		sort.Ints(scan.windows)
+	}
+
+	return scan, nil
+}
+
+type ExpiringCertificate struct {
+	Name string // Domain name (for ACM), or the endpoint
+	Where string // Like "ACM eu-west-1 (imported)" or "endpoint"
+	NotAfter time.Time
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Certificate Scan",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.certificates == nil || !scheduledBy(event, notifiers.certificates.rule) {
+				return nil
+			}
+
+			return notifiers.postCertificateScan(ctx, time.Now())
+		},
+	})
+}
+
+// Returns the issued certificates in ACM expiring before the cutoff
+func (c *CertificateScan) scanACM(ctx context.Context, region string, cutoff time.Time) ([]ExpiringCertificate, error) {
+	svc := acm.New(c.sess, aws.NewConfig().WithRegion(region))
+	traceAWSClient(svc.Client)
+
+	var arns []*string
+
+	err := svc.ListCertificatesPagesWithContext(ctx, &acm.ListCertificatesInput{
+		CertificateStatuses: []*string{aws.String(acm.CertificateStatusIssued)},
+	}, func(page *acm.ListCertificatesOutput, lastPage bool) bool {
+		for _, summary := range page.CertificateSummaryList {
+			arns = append(arns, summary.CertificateArn)
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to list ACM certificates in " + region + ": " + err.Error())
+	}
+
+	var expiring []ExpiringCertificate
+
+	for _, arn := range arns {
+		res, err := svc.DescribeCertificateWithContext(ctx, &acm.DescribeCertificateInput{CertificateArn: arn})
+		if err != nil {
+			return nil, errors.New("failed to describe ACM certificate " + aws.StringValue(arn) + ": " + err.Error())
+		}
+
+		certificate := res.Certificate
+		if certificate.NotAfter == nil || !certificate.NotAfter.Before(cutoff) {
+			continue
+		}
+
+		// Amazon issued certificates renew by themselves while they're in use, so it's worth knowing if they aren't
+		where := "ACM " + region
+		if aws.StringValue(certificate.Type) == acm.CertificateTypeImported {
+			where += " (imported)"
+		} else if len(certificate.InUseBy) == 0 {
+			where += " (not in use, won't renew)"
+		}
+
+		expiring = append(expiring, ExpiringCertificate{
+			Name: aws.StringValue(certificate.DomainName),
+			Where: where,
+			NotAfter: *certificate.NotAfter,
+		})
+	}
+
+	return expiring, nil
+}
+
+// Returns when the certificate served by the endpoint expires. The chain isn't verified, since an expired (or
+// otherwise invalid) certificate is exactly what we're looking for.
+func endpointCertificateExpiry(ctx context.Context, endpoint string) (time.Time, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host, port = endpoint, "443"
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: CertificateHandshakeTimeout},
+		Config: &tls.Config{ServerName: host, InsecureSkipVerify: true},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, CertificateHandshakeTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+
+	certificates := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return time.Time{}, errors.New("no certificate presented")
+	}
+
+	return certificates[0].NotAfter, nil
+}
+
+// Returns the endpoints with certificates expiring before the cutoff, and the ones which couldn't be checked
+func (c *CertificateScan) scanEndpoints(ctx context.Context, cutoff time.Time) ([]ExpiringCertificate, []string) {
+	expiries := make([]time.Time, len(c.endpoints))
+	failures := make([]error, len(c.endpoints))
+
+	var group errgroup.Group
+	group.SetLimit(CertificateScanConcurrency)
+
+	for i, endpoint := range c.endpoints {
+		i, endpoint := i, endpoint
+
+		group.Go(func() error {
+			expiries[i], failures[i] = endpointCertificateExpiry(ctx, endpoint)
+			return nil
+		})
+	}
+
+	group.Wait()
+
+	var expiring []ExpiringCertificate
+	var unreachable []string
+
+	for i, endpoint := range c.endpoints {
+		if failures[i] != nil {
+			logger(ctx).Warn("Failed to check certificate of endpoint", "endpoint", endpoint, "error", failures[i].Error())
+			unreachable = append(unreachable, endpoint + " (" + failures[i].Error() + ")")
+		} else if expiries[i].Before(cutoff) {
+			expiring = append(expiring, ExpiringCertificate{Name: endpoint, Where: "endpoint", NotAfter: expiries[i]})
+		}
+	}
+
+	return expiring, unreachable
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Sends the warning straight to the scan channels, bypassing routing (like the daily report). Failing to scan one
+// region doesn't stop the others from being reported.
+func (r *NotifierRegistry) postCertificateScan(ctx context.Context, now time.Time) error {
+	scan := r.certificates
+	cutoff := now.AddDate(0, 0, scan.windows[len(scan.windows) - 1])
+
+	var failures MultiError
+	var expiring []ExpiringCertificate
+
+	for _, region := range scan.regions {
+		certificates, err := scan.scanACM(ctx, region, cutoff)
+		if err != nil {
+			failures.add("certificate scan in " + region, err)
+			continue
+		}
+
+		expiring = append(expiring, certificates...)
+	}
+
+	certificates, unreachable := scan.scanEndpoints(ctx, cutoff)
+	expiring = append(expiring, certificates...)
+
+	if len(expiring) == 0 && len(unreachable) == 0 {
+		logger(ctx).Info("No certificates expiring", "days", scan.windows[len(scan.windows) - 1])
+		return failures.errorOrNil()
+	}
+
+	notification := scan.notification(expiring, unreachable, now)
+
+	for _, name := range scan.channels {
+		notifier, exists := r.notifiers[name]
+		if !exists {
+			logger(ctx).Warn("Skipping certificate scan for unknown channel", "channel", name)
+			continue
+		}
+
+		failures.add("certificate scan via " + name, notifier.Send(ctx, notification))
+	}
+
+	return failures.errorOrNil()
+}
+
+func (c *CertificateScan) notification(expiring []ExpiringCertificate, unreachable []string, now time.Time) notify.Notification {
+	sort.Slice(expiring, func(i, j int) bool { return expiring[i].NotAfter.Before(expiring[j].NotAfter) })
+
+	// Each certificate is listed under the smallest window it falls in
+	var expired []string
+	byWindow := make([][]string, len(c.windows))
+
+	for _, certificate := range expiring {
+		// Like "api.example.com - ACM eu-west-1 (imported): 19 Oct 2026, in 4d 4h"
+		line := certificate.Name + " - " + certificate.Where + ": " + certificate.NotAfter.UTC().Format("2 Jan 2006")
+
+		left := certificate.NotAfter.Sub(now)
+		if left <= 0 {
+			expired = append(expired, line)
+			continue
+		}
+
+		line += ", in " + notify.HumanDuration(left.Truncate(time.Hour))
+
+		for i, days := range c.windows {
+			if left <= time.Duration(days) * 24 * time.Hour {
+				byWindow[i] = append(byWindow[i], line)
+				break
+			}
+		}
+	}
+
+	var fields []notify.Field
+
+	if len(expired) != 0 {
+		fields = append(fields, notify.Field {
+			Title: "Expired",
+			Value: strings.Join(expired, "\n"),
+			Short: false,
+		})
+	}
+
+	for i, days := range c.windows {
+		if len(byWindow[i]) != 0 {
+			fields = append(fields, notify.Field {
+				Title: "Expiring Within " + strconv.Itoa(days) + " Days",
+				Value: strings.Join(byWindow[i], "\n"),
+				Short: false,
+			})
+		}
+	}
+
+	if len(unreachable) != 0 {
+		fields = append(fields, notify.Field {
+			Title: "Couldn't Check",
+			Value: strings.Join(unreachable, "\n"),
+			Short: false,
+		})
+	}
+
+	title := "Certificate Expiry - " + strconv.Itoa(len(expiring)) + " certificate(s) expiring within " +
+		strconv.Itoa(c.windows[len(c.windows) - 1]) + " days"
+
+	severity := notify.SeverityWarn
+	if len(expired) != 0 || len(byWindow[0]) != 0 {
+		severity = notify.SeverityError
+	}
+
+	return notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Certificate Expiry",
+		Title: title,
+		Summary: title,
+		Severity: severity,
+		Fields: fields,
+		Time: now.UTC().Format(time.RFC3339),
+	}
+}
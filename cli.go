@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Replaying events locally, for developing and debugging handlers against sample payloads:
+//
+//   go run . --file event.json [--dry-run]
+//
+// Settings are read the same way as in Lambda (so the environment needs slack_webhook etc., and AWS
+// credentials if anything is read from SSM, Secrets Manager or S3).
+
+// How long a local run can take, like the timeout of the Lambda function
+const LocalTimeout = 5 * time.Minute
+
+// With --dry-run, notifications are printed instead of sent, and nothing is written to DynamoDB
+var dryRun bool
+
+func runLocally(file string) error {
+	var raw []byte
+	var err error
+
+	if file == "-" {
+		raw, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		raw, err = ioutil.ReadFile(file)
+	}
+
+	if err != nil {
+		return errors.New("failed to read event: " + err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), LocalTimeout)
+	defer cancel()
+
+	response, err := HandleRequest(ctx, json.RawMessage(raw))
+	if err != nil {
+		return err
+	}
+
+	if response != nil {
+		encoded, err := json.MarshalIndent(response, "", "  ")
+		if err != nil {
+			return errors.New("failed to marshal response: " + err.Error())
+		}
+
+		fmt.Println(string(encoded))
+	}
+
+	return nil
+}
+
+// Swaps every notifier for one which prints notifications, and disables everything which keeps state
+// in DynamoDB (or writes to S3)
+func (r *NotifierRegistry) makeDryRun() {
+	for name := range r.notifiers {
+		r.notifiers[name] = &DryRunNotifier{name: name}
+	}
+
+	r.dedupe = nil
+	r.idempotency = nil
+	r.limiter = nil
+	r.digests = nil
+	r.queue = nil
+	r.breaker = nil
+	r.failed = nil
+	r.graphs = nil
+	r.snapshot = nil // Needs the graphs
+	r.escalations = nil
+	r.timeline = nil
+	r.suppressions = nil
+	r.remediator = nil
+	r.sampling = nil
+	r.alarmStates = nil
+	r.audit = nil
+	r.archive = nil
+}
+
+type DryRunNotifier struct {
+	name string
+}
+
+func (n *DryRunNotifier) Send(ctx context.Context, notification notify.Notification) error {
+	// The original event is in the input already
+	notification.Event = nil
+
+	encoded, err := json.MarshalIndent(notification, "", "  ")
+	if err != nil {
+		return errors.New("failed to marshal notification: " + err.Error())
+	}
+
+	fmt.Println("Routed to " + n.name + ":\n" + string(encoded))
+
+	return nil
+}
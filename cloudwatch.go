@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
 )
 
 /**
@@ -82,260 +86,87 @@ Example Autoscaling EC2 Instance Launch:
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Types for reading Cloudwatch payloads
 
-// Common Event headers - the "detail" part is different for each Event Type
-type CloudwatchEvent struct {
-	Id string `json:"id"`
-	DetailType string `json:"detail-type"`
-	Source string `json:"source"`
-	Account string `json:"account"`
-	Time string `json:"time"`
-	Region string `json:"region"`
-	Resources []string `json:"resources"`
-	Detail json.RawMessage `json:"detail"`
+// How the detail of events without a dedicated handler is shown, via raw_detail_format
+const RawDetailFields = "fields" // Flattened into a field per value (the default)
+const RawDetailCode = "code" // Pretty-printed as a code block
+
+func init() {
+	// Cloudwatch Scheduled Events run the scheduled tasks (see scheduled.go)
+	registerEventHandler(EventHandler {
+		name: "Scheduled Event",
+		matches: matchEvent("aws.events", "Scheduled Event"),
+		handle: processScheduledEvent,
+	})
+
+	registerEventHandler(EventHandler {
+		name: "Cloudwatch Events",
+		matches: matchEvent("aws.events"),
+		handle: ignoreEvent,
+	})
+
+	// Forward everything else to the Cloudwatch Event processor (we'll weed unsupported stuff out there)
+	registerPayloadHandler(PayloadHandler {
+		name: "Cloudwatch Event",
+		matches: func(payload GenericEvent) bool { return len(payload.Records) == 0 && payload.Test == "" },
+		handle: processCloudwatchEvent,
+	})
 }
 
-type DetailEC2StateChange struct {
-	InstanceId string `json:"instance-id"`
-	State string `json:"state"`
-}
-
-type DetailAutoScalingLifecycleEvent struct {
-	LifecycleActionToken string `json:"LifecycleActionToken"`
-	AutoScalingGroupName string `json:"AutoScalingGroupName"`
-	LifecycleHookName string `json:"LifecycleHookName"`
-	EC2InstanceId string `json:"EC2InstanceId"`
-	LifecycleTransition string `json:"LifecycleTransition"`
-	NotificationMetadata string `json:"NotificationMetadata"`
-}
-
-type DetailAutoScalingEC2Event struct {
-	StatusCode string `json:"StatusCode"`
-	AutoScalingGroupName string `json:"AutoScalingGroupName"`
-	ActivityId string `json:"ActivityId"`
-	Details DetailAutoScalingEC2EventDetails `json:"Details"`
-	RequestId string `json:"RequestId"`
-	EndTime string `json:"EndTime"`
-	EC2InstanceId string `json:"EC2InstanceId"`
-	StartTime string `json:"StartTime"`
-	Cause string `json:"Cause"`
-}
-
-type DetailAutoScalingEC2EventDetails struct {
-	AvailabilityZone string `json:"Availability Zone"`
-	SubnetID string `json:"Subnet ID"`
-}
-
-
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-
-func processCloudwatchEvent(slackNotifier *SlackNotifier, pagerdutyNotifier *PagerdutyNotifier, raw []byte) error {
-	var event CloudwatchEvent
+func processCloudwatchEvent(ctx context.Context, notifiers *NotifierRegistry, raw json.RawMessage) error {
+	var event lambdaevents.CloudWatchEvent
 
 	err := json.Unmarshal(raw, &event)
 	if err != nil {
 		return errors.New("unsupported Cloudwatch Event payload: " + err.Error())
 	}
 
-	// EC2 start/stop notifications
-	if event.Source == "aws.ec2" {
-		if event.DetailType == "EC2 Instance State-change Notification" {
-			err = processEC2StateChangeEvent(slackNotifier, pagerdutyNotifier, event)
+	ctx = withLogAttrs(ctx, "event_id", event.ID, "source", event.Source, "detail_type", event.DetailType)
+	metrics(ctx).count("EventsReceived", "Source", event.Source)
 
-			if err != nil {
-				return errors.New("failed to process EC2 Event: " + err.Error())
-			}
-		}
-	} else if event.Source == "aws.events" { // Cloudwatch Scheduled Event
-		// Ignore for now
-		return nil
-	} else if event.Source == "aws.autoscaling" {
-		err = processAutoscalingEvent(slackNotifier, pagerdutyNotifier, event)
-
-		if err != nil {
-			return errors.New("failed to process Autoscaling Event: " + err.Error())
-		}
-	} else {
-		// Generic handler for all other types
-		title := event.Source
-		slackMessage := SlackMessage {
-			Attachments: []SlackAttachment {
-				{
-					Fallback: title,
-					Color: ColorInfo,
-					Fields: []SlackField {
-						{
-							Title: "CloudWatch Event",
-							Value: title,
-							Short: false,
-						},
-						{
-							Title: "Event Detail JSON",
-							Value: string(event.Detail),
-							Short: false,
-						},
-					},
-				},
-			},
-		}
+	invocationEvent(ctx).add("events", 1)
+	invocationEvent(ctx).include("event_ids", event.ID)
 
-		if err := slackNotifier.sendMessage(slackMessage); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return notifiers.once(ctx, "event/" + event.ID, event.Source, func() error {
+		return handleCloudwatchEvent(ctx, notifiers, event)
+	})
 }
 
+func handleCloudwatchEvent(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+	handler := findEventHandler(event)
+	if handler == nil {
+		if notifiers.config != nil {
+			if mapping := notifiers.config.eventMapping(event); mapping != nil {
+				return processMappedEvent(ctx, notifiers, mapping, event)
+			}
+		}
 
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-
-func processEC2StateChangeEvent(slackNotifier *SlackNotifier, pagerdutyNotifier *PagerdutyNotifier, event CloudwatchEvent) error {
-	// TODO - Grab instance more info here
-	var eventDetail DetailEC2StateChange
-
-	err := json.Unmarshal(event.Detail, &eventDetail)
-	if err != nil {
-		return errors.New("unsupported EC2 Cloudwatch Event Detail: " + err.Error())
-	}
-
-	var color string
-	if contains([]string{"shutting-down", "terminated", "stopping", "stopped"}, eventDetail.State) {
-		color = ColorWarn
-	} else {
-		color = ColorInfo
-	}
-
-	title := "EC2 Instance State-change"
-	slackMessage := SlackMessage {
-		Attachments: []SlackAttachment {
-			{
-				Fallback: title,
-				Color: color,
-				Fields: []SlackField {
-					{
-						Title: "CloudWatch Event",
-						Value: title,
-						Short: false,
-					},
-					{
-						Title: "instance-id",
-						Value: eventDetail.InstanceId,
-						Short: true,
-					},
-					{
-						Title: "state",
-						Value: eventDetail.State,
-						Short: true,
-					},
-				},
-			},
-		},
+		return processGenericCloudwatchEvent(ctx, notifiers, event)
 	}
 
-	if err := slackNotifier.sendMessage(slackMessage); err != nil {
-		return err
+	if err := handler.handle(ctx, notifiers, event); err != nil {
+		return errors.New("failed to process " + handler.name + ": " + err.Error())
 	}
 
 	return nil
 }
 
-func processAutoscalingEvent(slackNotifier *SlackNotifier, pagerdutyNotifier *PagerdutyNotifier, event CloudwatchEvent) error {
-	var slackMessage SlackMessage
-
-	if contains([]string{"EC2 Instance-launch Lifecycle Action", "EC2 Instance-terminate Lifecycle Action"}, event.DetailType) {
-		var eventDetail DetailAutoScalingLifecycleEvent
+// Generic handler for all other types, which shows the detail of the event as flattened fields, so that it's
+// still readable without a dedicated handler
+func processGenericCloudwatchEvent(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+	reportMessage(ctx, "Unsupported Cloudwatch Event: " + event.Source + "/" + event.DetailType)
 
-		title := "Autoscaling - Lifecycle Action"
-		slackMessage = SlackMessage {
-			Attachments: []SlackAttachment {
-				{
-					Fallback: title,
-					Color: ColorInfo,
-					Fields: []SlackField {
-						{
-							Title: "CloudWatch Event",
-							Value: title,
-							Short: false,
-						},
-						{
-							Title: "AutoScalingGroupName",
-							Value: eventDetail.AutoScalingGroupName,
-							Short: true,
-						},
-						{
-							Title: "EC2InstanceId",
-							Value: eventDetail.EC2InstanceId,
-							Short: true,
-						},
-						{
-							Title: "LifecycleTransition",
-							Value: eventDetail.LifecycleTransition,
-							Short: true,
-						},
-					},
-				},
-			},
-		}
+	var detail []notify.Field
+	var err error
 
-		if err := slackNotifier.sendMessage(slackMessage); err != nil {
-			return err
-		}
-
-	} else {
-		var eventDetail DetailAutoScalingEC2Event
-
-		var color string
-		if contains([]string{"EC2 Instance Launch Unsuccessful", "EC2 Instance Terminate Unsuccessful"}, event.DetailType) {
-			color = ColorWarn
-		} else {
-			color = ColorInfo
-		}
-
-		title := "Autoscaling - " + event.DetailType
-		slackMessage = SlackMessage {
-			Attachments: []SlackAttachment {
-				{
-					Fallback: title,
-					Color: color,
-					Fields: []SlackField {
-						{
-							Title: "CloudWatch Event",
-							Value: title,
-							Short: false,
-						},
-						{
-							Title: "EC2InstanceId",
-							Value: eventDetail.EC2InstanceId,
-							Short: true,
-						},
-						{
-							Title: "StatusCode",
-							Value: eventDetail.StatusCode,
-							Short: true,
-						},
-						{
-							Title: "Availability Zone",
-							Value: eventDetail.Details.AvailabilityZone,
-							Short: true,
-						},
-						{
-							Title: "Cause",
-							Value: eventDetail.Cause,
-							Short: true,
-						},
-					},
-				},
-			},
-		}
-
-		if err := slackNotifier.sendMessage(slackMessage); err != nil {
-			return err
-		}
+	if getSetting("raw_detail_format") == RawDetailCode {
+		detail = []notify.Field{events.PrettyJSONField("Event Detail", event.Detail)}
+	} else if detail, err = events.FlattenJSON(event.Detail); err != nil {
+		logger(ctx).Warn("Failed to flatten event detail", "error", err.Error())
+		detail = []notify.Field{events.PrettyJSONField("Event Detail", event.Detail)}
 	}
 
-	return nil
-}
\ No newline at end of file
+	notification := events.GenericEventNotification(event, detail)
+
+	return notifiers.send(ctx, notification)
+}
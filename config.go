@@ -0,0 +1,698 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/ghodss/yaml"
+	"github.com/google/cel-go/cel"
+	"github.com/jmespath/go-jmespath"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+Example routing configuration (YAML or JSON):
+
+channels:
+  ops:
+    type: slack
+    webhook: https://hooks.slack.com/services/XXX/YYY/ZZZ
+  payments:
+    type: slack
+    channel: "#payments-alerts" # Posted via the Web API, using slack_token
+  oncall:
+    type: pagerduty
+    service_key: xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx
+
+default_channels: [ops, oncall]
+
+tag_routing:
+  tag: team
+  channels:
+    payments: [payments, oncall]
+
+accounts:
+  "123456789012":
+    name: Production
+    channels: [ops, oncall]
+  "210987654321":
+    name: Staging
+    channels: [ops]
+    color: "#808080"
+
+filters:
+  allow:
+    - account: "123456789012"
+  deny:
+    - region: us-west-*
+    - alarm_name: "test-*"
+    - source: aws.ec2
+      expression: "detail.state == 'pending'"
+
+fields:
+  - match:
+      source: aws.autoscaling
+    title: Cause
+    expression: detail.Cause
+
+routes:
+  - match:
+      source: aws.events
+    suppress: true
+  - match:
+      source: aws.autoscaling
+      detail_type: "EC2 Instance Launch *"
+    channels: [ops]
+    severity: info
+    digest: true
+  - match:
+      source: aws.cloudwatch
+      title: "ALARM: \"payments-*"
+    channels: [payments, oncall]
+  - match:
+      source: aws.ec2
+    condition: "detail.state in ['stopped', 'terminated']"
+    channels: [ops]
+  - match:
+      region: us-*
+    channels: [ops-us]
+
+severities:
+  - match:
+      source: aws.ec2
+    condition: "detail.state == 'terminated'"
+    severity: error
+  - match:
+      source: aws.cloudwatch
+      title: "ALARM: \"dev-*"
+    severity: warn
+
+maintenance_windows:
+  - name: Weekly patching
+    match:
+      source: aws.ec2
+    schedule: "0 2 * * SUN"
+    duration: 2h
+    time_zone: Europe/London
+  - name: Database migration
+    match:
+      alarm_name: "payments-db-*"
+    start: 2019-08-01T22:00:00Z
+    end: 2019-08-02T02:00:00Z
+    action: digest
+
+runbooks:
+  - match:
+      alarm_name: "payments-*"
+    url: https://wiki.example.com/runbooks/payments
+  - match:
+      source: aws.autoscaling
+      detail_type: "EC2 Instance * Unsuccessful"
+    url: https://wiki.example.com/runbooks/autoscaling-failures
+
+escalations:
+  - match:
+      source: aws.cloudwatch
+      severity: critical
+    after: 15m
+    channels: [oncall]
+
+quiet_hours:
+  ops:
+    start: "22:00"
+    end: "07:00"
+    time_zone: Europe/London
+    min_severity: error
+
+locale: de
+translations:
+  de:
+    "EC2 Instance State-change": "EC2-Instanz-Statusänderung"
+    "instance-id": "Instanz-ID"
+    "state": "Status"
+
+templates:
+  aws.ec2/EC2 Instance State-change Notification:
+    text: "Instance {{index .detail \"instance-id\"}} is now *{{.detail.state}}*"
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type Config struct {
+	Channels map[string]ChannelConfig `json:"channels"`
+	DefaultChannels []string `json:"default_channels"`
+	Filters route.Filters `json:"filters"`
+	Fields []FieldConfig `json:"fields"`
+	Severities []SeverityRule `json:"severities"`
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows"`
+	Runbooks []RunbookConfig `json:"runbooks"`
+	Escalations []EscalationRule `json:"escalations"` // Requires escalation_table
+	QuietHours map[string]*QuietHours `json:"quiet_hours"` // Keyed by channel name
+	Accounts map[string]AccountConfig `json:"accounts"` // Keyed by AWS account ID
+	TagRouting *TagRoutingConfig `json:"tag_routing"`
+	Delivery DeliveryConfig `json:"delivery"`
+	Sampling []SamplingRule `json:"sampling"` // Requires sampling_table
+	Mappings []EventMapping `json:"mappings"` // For Cloudwatch Events without a dedicated handler
+	Routes []RouteConfig `json:"routes"`
+	Templates map[string]MessageTemplateDefinition `json:"templates"`
+	Locale string `json:"locale"` // Default locale for all channels, like "de"
+	Translations map[string]notify.Catalog `json:"translations"` // Keyed by locale
+	fingerprint string
+}
+
+// A notification destination, on top of the "slack" and "pagerduty" ones configured via the environment
+// (if any)
+type ChannelConfig struct {
+	Type string `json:"type"` // "slack", "pagerduty" or "webhook"
+	Webhook string `json:"webhook"` // For Slack, or the URL to post to for webhook channels
+	WebhookFormat string `json:"webhook_format"`
+	Channel string `json:"channel"` // For posting via the Slack Web API
+	Mention string `json:"mention"`
+	ServiceKey string `json:"service_key"`
+	MinSeverity string `json:"min_severity"` // Lowest severity to trigger Pagerduty incidents for
+	RateLimitPerMinute float64 `json:"rate_limit_per_minute"` // Requires rate_limit_table
+	RateLimitBurst float64 `json:"rate_limit_burst"`
+	Locale string `json:"locale"` // Language to render notifications in, if there are translations for it
+	Secret string `json:"secret"` // For signing webhook payloads, instead of webhook_signing_secret
+	SignatureHeader string `json:"signature_header"` // Header to send the signature in, instead of X-Signature-256
+}
+
+// Notifications from the account are labelled with its name, and sent to its channels (instead of
+// default_channels) unless a route says otherwise
+type AccountConfig struct {
+	Name string `json:"name"`
+	Channels []string `json:"channels"`
+	Color string `json:"color"` // Overrides the color derived from the severity (eg. "#800080")
+}
+
+// Routes notifications based on the value of a tag on the resources involved (like "team"), to the
+// channels listed for that value. Takes precedence over accounts, but not routes.
+type TagRoutingConfig struct {
+	Tag string `json:"tag"`
+	Channels map[string][]string `json:"channels"` // Keyed by tag value
+}
+
+// By default, notifications are delivered to all their channels concurrently, and any failed delivery fails the
+// invocation (so that Lambda retries it, for asynchronous invocations)
+type DeliveryConfig struct {
+	Order []string `json:"order"` // Delivered to one after the other in this order, before the rest of the channels
+	BestEffort []string `json:"best_effort"` // Failed deliveries to these are only logged
+}
+
+// Extra fields to add to matching notifications, extracted from the original event via a JMESPath expression
+type FieldConfig struct {
+	Match route.Match `json:"match"`
+	Title string `json:"title"`
+	Expression string `json:"expression"`
+	Short bool `json:"short"`
+	compiled *jmespath.JMESPath
+}
+
+// Routes are evaluated in order, and the first one matching a notification is applied
+type RouteConfig struct {
+	Match route.Match `json:"match"`
+	Channels []string `json:"channels"` // Overrides default_channels
+	Severity string `json:"severity"` // Overrides the severity set by the handler (or severity rules)
+	Suppress bool `json:"suppress"` // Drop matching notifications altogether
+	Digest bool `json:"digest"` // Buffer matching notifications, and only post them as part of a digest
+	Template string `json:"template"` // Render with this template, instead of the one for the event type
+	Condition string `json:"condition"` // CEL expression, which has to evaluate to true on top of the match
+	Remediation *RemediationConfig `json:"remediation"` // Action to take automatically, on top of notifying
+	program cel.Program
+}
+
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// The config from S3 or SSM is checked for changes this often (across warm invocations), via the ETag of the
+// object or the version of the parameter, and reloaded if it has changed
+const DefaultConfigTTL = 5 * time.Minute
+
+var configVersion string // ETag of the S3 object, or version of the SSM parameter, the config was loaded from
+var configCheckedAt time.Time
+
+// Loads the routing configuration from (in order of precedence) a file via config_file, the
+// config setting itself (eg. loaded via ssm_prefix), an S3 object via config_s3 (s3://bucket/key),
+// an SSM parameter via config_ssm, or the config.yaml bundled with the function. Returns nil if
+// there is no configuration.
+func loadConfig(sess *session.Session) (*Config, error) {
+	var raw []byte
+	var err error
+
+	if file, exists := lookupSetting("config_file"); exists {
+		raw, err = ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.New("failed to read config file: " + err.Error())
+		}
+	} else if inline, exists := lookupSetting("config"); exists {
+		raw = []byte(inline)
+	} else if s3Uri, exists := lookupSetting("config_s3"); exists {
+		if err := recordConfigVersion(sess); err != nil {
+			return nil, err
+		}
+
+		if raw, err = readS3Object(sess, s3Uri); err != nil {
+			return nil, err
+		}
+	} else if parameter, exists := lookupSetting("config_ssm"); exists {
+		if err := recordConfigVersion(sess); err != nil {
+			return nil, err
+		}
+
+		value, err := readSSMParameter(sess, parameter)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = []byte(value)
+	} else {
+		bundled := filepath.Join(os.Getenv("LAMBDA_TASK_ROOT"), "config.yaml")
+
+		raw, err = ioutil.ReadFile(bundled)
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, errors.New("failed to read bundled config file: " + err.Error())
+		}
+	}
+
+	return parseConfig(raw)
+}
+
+// Records the version of the config about to be loaded. It's read before the config itself, so that if the
+// config changes in between, it's only reloaded one more time, rather than the change being missed.
+func recordConfigVersion(sess *session.Session) error {
+	version, err := remoteConfigVersion(sess)
+	if err != nil {
+		return err
+	}
+
+	configVersion = version
+	configCheckedAt = time.Now()
+
+	return nil
+}
+
+// Returns the version of the config in S3 or SSM (an empty string for any other source, which never changes
+// without a new container), only actually checking it once per config_ttl
+func currentConfigVersion(sess *session.Session) string {
+	if configCheckedAt.IsZero() {
+		return configVersion
+	}
+
+	ttl := DefaultConfigTTL
+	if value, exists := lookupSetting("config_ttl"); exists {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			ttl = parsed
+		}
+	}
+
+	if time.Since(configCheckedAt) < ttl {
+		return configVersion
+	}
+
+	configCheckedAt = time.Now()
+
+	version, err := remoteConfigVersion(sess)
+	if err != nil {
+		slog.Warn("Failed to check the config for changes", "error", err.Error())
+		return configVersion
+	}
+
+	if version != configVersion {
+		slog.Info("Config has changed - reloading", "version", version)
+	}
+
+	return version
+}
+
+func remoteConfigVersion(sess *session.Session) (string, error) {
+	if _, exists := lookupSetting("config_file"); exists {
+		return "", nil
+	} else if _, exists := lookupSetting("config"); exists {
+		return "", nil
+	} else if s3Uri, exists := lookupSetting("config_s3"); exists {
+		u, err := url.Parse(s3Uri)
+		if err != nil || u.Scheme != "s3" {
+			return "", errors.New("invalid S3 URI: " + s3Uri)
+		}
+
+		res, err := s3.New(sess).HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(u.Host),
+			Key: aws.String(strings.TrimPrefix(u.Path, "/")),
+		})
+		if err != nil {
+			return "", errors.New("failed to check " + s3Uri + ": " + err.Error())
+		}
+
+		return aws.StringValue(res.ETag), nil
+	} else if parameter, exists := lookupSetting("config_ssm"); exists {
+		res, err := ssm.New(sess).GetParameter(&ssm.GetParameterInput{
+			Name: aws.String(parameter),
+		})
+		if err != nil {
+			return "", errors.New("failed to check SSM parameter " + parameter + ": " + err.Error())
+		}
+
+		return strconv.FormatInt(aws.Int64Value(res.Parameter.Version), 10), nil
+	}
+
+	return "", nil
+}
+
+// YAML is a superset of JSON, so this handles both. Every problem with the config is reported at once
+// (as a ConfigError), rather than just the first one.
+func parseConfig(raw []byte) (*Config, error) {
+	var config Config
+
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return nil, &ConfigError{Problems: []string{"failed to parse config: " + err.Error()}}
+	}
+
+	config.fingerprint = configFingerprint(raw)
+
+	var problems ConfigError
+
+	for _, rules := range [][]route.FilterRule{config.Filters.Allow, config.Filters.Deny} {
+		for i := range rules {
+			if err := rules[i].Compile(); err != nil {
+				problems.add("invalid filter expression " + rules[i].Expression + ": " + err.Error())
+			}
+		}
+	}
+
+	for i := range config.Fields {
+		compiled, err := jmespath.Compile(config.Fields[i].Expression)
+		if err != nil {
+			problems.add("invalid field expression " + config.Fields[i].Expression + ": " + err.Error())
+			continue
+		}
+
+		config.Fields[i].compiled = compiled
+	}
+
+	for i := range config.Routes {
+		if config.Routes[i].Condition == "" {
+			continue
+		}
+
+		program, err := route.CompileCondition(config.Routes[i].Condition)
+		if err != nil {
+			problems.add(err.Error())
+			continue
+		}
+
+		config.Routes[i].program = program
+	}
+
+	for i := range config.Severities {
+		if !notify.ValidSeverity(config.Severities[i].Severity) {
+			problems.add("invalid severity in severity rule: " + config.Severities[i].Severity)
+		}
+
+		if config.Severities[i].Condition == "" {
+			continue
+		}
+
+		program, err := route.CompileCondition(config.Severities[i].Condition)
+		if err != nil {
+			problems.add(err.Error())
+			continue
+		}
+
+		config.Severities[i].program = program
+	}
+
+	for i := range config.MaintenanceWindows {
+		if err := config.MaintenanceWindows[i].compile(); err != nil {
+			problems.add(err.Error())
+		}
+
+		if err := config.MaintenanceWindows[i].Match.Compile(); err != nil {
+			problems.add("invalid maintenance window expression " + config.MaintenanceWindows[i].Match.Expression + ": " +
+				err.Error())
+		}
+	}
+
+	if err := config.compileRunbooks(); err != nil {
+		problems.add(err.Error())
+	}
+
+	if err := config.compileEscalations(); err != nil {
+		problems.add(err.Error())
+	}
+
+	if err := config.compileSampling(); err != nil {
+		problems.add(err.Error())
+	}
+
+	if err := config.compileMappings(); err != nil {
+		problems.add(err.Error())
+	}
+
+	config.validateLocales()
+
+	for name, quiet := range config.QuietHours {
+		if err := quiet.compile(); err != nil {
+			problems.add("invalid quiet hours for channel " + name + ": " + err.Error())
+		}
+	}
+
+	for i := range config.Routes {
+		if config.Routes[i].Severity != "" && !notify.ValidSeverity(config.Routes[i].Severity) {
+			problems.add("invalid severity in route: " + config.Routes[i].Severity)
+		}
+
+		if config.Routes[i].Remediation != nil {
+			if err := config.Routes[i].Remediation.compile(); err != nil {
+				problems.add(err.Error())
+			}
+		}
+	}
+
+	if _, err := compileMessageTemplates(config.Templates); err != nil {
+		if err := problems.merge(err); err != nil {
+			problems.add(err.Error())
+		}
+	}
+
+	config.validateChannels(&problems)
+
+	if err := problems.errorOrNil(); err != nil {
+		return nil, err
+	}
+
+	slog.Info("Loaded config", "channels", len(config.Channels), "routes", len(config.Routes))
+
+	return &config, nil
+}
+
+// Returns the first route matching the notification, or nil if there isn't one
+func (c *Config) route(notification notify.Notification) *RouteConfig {
+	for i := range c.Routes {
+		if c.Routes[i].Match.Matches(notification) && route.ConditionHolds(c.Routes[i].program, c.Routes[i].Condition, notification) {
+			return &c.Routes[i]
+		}
+	}
+
+	return nil
+}
+
+func (a AccountConfig) apply(notification notify.Notification, withOrigin bool) notify.Notification {
+	notification.AccountName = a.Name
+
+	// With origin labels the account is already part of the "Origin" field
+	if a.Name != "" && !withOrigin {
+		notification.Fields = append([]notify.Field {
+			{
+				Title: "Account",
+				Value: a.Name + " (" + notification.Account + ")",
+				Short: true,
+			},
+		}, notification.Fields...)
+	}
+
+	if a.Color != "" {
+		notification.Color = a.Color
+	}
+
+	return notification
+}
+
+// Labels the notification with the account (by name, if configured) and region it originated from, for
+// centralized deployments where events from the whole organization end up in the same channels
+func labelOrigin(notification notify.Notification) notify.Notification {
+	origin := notification.Account
+	if notification.AccountName != "" {
+		origin = notification.AccountName + " (" + notification.Account + ")"
+	}
+
+	if notification.Region != "" {
+		if origin != "" {
+			origin += " / "
+		}
+
+		origin += notification.Region
+	}
+
+	if origin == "" {
+		return notification
+	}
+
+	notification.Fields = append([]notify.Field {
+		{
+			Title: "Origin",
+			Value: origin,
+			Short: true,
+		},
+	}, notification.Fields...)
+
+	return notification
+}
+
+// Adds the configured fields matching the notification, skipping ones where the expression doesn't yield anything
+func (c *Config) extractFields(notification notify.Notification) notify.Notification {
+	// Don't modify the handler's slice in place
+	notification.Fields = append([]notify.Field{}, notification.Fields...)
+
+	for _, field := range c.Fields {
+		if !field.Match.Matches(notification) || notification.Event == nil {
+			continue
+		}
+
+		value, err := searchString(field.compiled, notification.Event)
+		if err != nil {
+			slog.Warn("Failed to evaluate field expression", "expression", field.Expression, "error", err.Error())
+			continue
+		}
+
+		if value == "" {
+			continue
+		}
+
+		notification.Fields = append(notification.Fields, notify.Field {
+			Title: field.Title,
+			Value: value,
+			Short: field.Short,
+		})
+	}
+
+	return notification
+}
+
+// Results other than strings are rendered as JSON, and null as an empty string
+func searchString(expression *jmespath.JMESPath, data interface{}) (string, error) {
+	result, err := expression.Search(data)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := result.(string)
+	if !ok && result != nil {
+		raw, _ := json.Marshal(result)
+		value = string(raw)
+	}
+
+	return value, nil
+}
+
+// Registers a notifier for each configured channel. Slack channels inherit everything not set
+// in the config (identities, templates, etc.) from the Slack notifier configured via the environment.
+func registerConfiguredChannels(config *Config, notifiers *NotifierRegistry, slackNotifier *notify.SlackNotifier) error {
+	for name, channel := range config.Channels {
+		switch channel.Type {
+		case "slack":
+			notifier := *slackNotifier
+			notifier.Threads = nil // Thread keys aren't scoped by channel
+
+			if channel.Webhook != "" {
+				notifier.Webhook = channel.Webhook
+				notifier.Token = ""
+			} else if channel.Channel != "" {
+				if notifier.Token == "" {
+					return errors.New("channel " + name + " needs slack_token for posting to " + channel.Channel)
+				}
+
+				notifier.Channel = channel.Channel
+			}
+
+			if channel.WebhookFormat != "" {
+				if !contains([]string{notify.WebhookFormatAttachments, notify.WebhookFormatWorkflow}, channel.WebhookFormat) {
+					return errors.New("unsupported webhook_format for channel " + name + ": " + channel.WebhookFormat)
+				}
+
+				notifier.WebhookFormat = channel.WebhookFormat
+			}
+
+			if channel.Mention != "" {
+				notifier.Mention = channel.Mention
+			}
+
+			if limit, limited := notifiers.rateLimits["slack"]; limited {
+				notifiers.rateLimits[name] = limit
+			}
+
+			notifiers.register(name, &notifier)
+		case "pagerduty":
+			if channel.ServiceKey == "" {
+				return errors.New("channel " + name + " is missing service_key")
+			}
+
+			if channel.MinSeverity != "" && !notify.ValidSeverity(channel.MinSeverity) {
+				return errors.New("invalid min_severity for channel " + name + ": " + channel.MinSeverity)
+			}
+
+			notifiers.register(name, notify.NewPagerdutyNotifier(channel.ServiceKey, channel.MinSeverity))
+		case "webhook":
+			if !validWebhookURL(channel.Webhook) {
+				return errors.New("webhook for channel " + name + " is not a valid https URL")
+			}
+
+			secret := channel.Secret
+			if secret == "" {
+				secret = getSetting("webhook_signing_secret")
+			}
+
+			notifier := newWebhookNotifier(channel.Webhook, secret)
+
+			if channel.SignatureHeader != "" {
+				notifier.signatureHeader = channel.SignatureHeader
+			}
+
+			notifiers.register(name, notifier)
+		default:
+			return errors.New("unsupported type for channel " + name + ": " + channel.Type)
+		}
+
+		if channel.RateLimitPerMinute != 0 {
+			limit := RateLimit{perMinute: channel.RateLimitPerMinute, burst: channel.RateLimitBurst}
+			if limit.burst == 0 {
+				limit.burst = defaultBurst(limit.perMinute)
+			} else if limit.burst < 1 {
+				return errors.New("rate_limit_burst for channel " + name + " has to be at least 1")
+			}
+
+			notifiers.rateLimits[name] = limit
+		}
+	}
+
+	notifiers.config = config
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultCostReportChannel = "finops"
+const DefaultCostReportLookback = 7 // Days the average is taken over
+const DefaultCostReportThreshold = 20.0 // Percent
+const DefaultCostReportMinSpend = 1.0 // Services spending less than this (on both sides) are never flagged
+const CostReportTopServices = 10
+
+// Cost Explorer only has an endpoint in us-east-1, whichever region the data is for
+const CostExplorerRegion = "us-east-1"
+
+const CostMetric = "UnblendedCost"
+
+// Posts yesterday's spend by service, compared to the average over the days before it, once a day. Triggered by a
+// dedicated Cloudwatch Events schedule rule (like "cron(0 8 * * ? *)"), since Cost Explorer data for a day only
+// settles some hours after it ends, and every call to it is charged for.
+type CostReport struct {
+	sess *session.Session
+	rule string // Name of the schedule rule which triggers the report
+	channels []string
+	lookback int
+	threshold float64
+	minSpend float64
+}
+
+// Returns nil if cost_report_rule isn't set
+func newCostReportFromSettings(sess *session.Session) (*CostReport, error) {
+	rule, exists := lookupSetting("cost_report_rule")
+	if !exists {
+		return nil, nil
+	}
+
+	report := &CostReport{
+		sess: sess,
+		rule: rule,
+		channels: getListSetting("cost_report_channels", DefaultCostReportChannel),
+		lookback: DefaultCostReportLookback,
+		threshold: DefaultCostReportThreshold,
+		minSpend: DefaultCostReportMinSpend,
+	}
+
+	var err error
+
+	if lookback, exists := lookupSetting("cost_report_lookback"); exists {
+		if report.lookback, err = strconv.Atoi(lookback); err != nil || report.lookback < 1 {
+			return nil, errors.New("invalid cost_report_lookback: " + lookback)
+		}
+	}
+
+	if threshold, exists := lookupSetting("cost_report_threshold"); exists {
+		if report.threshold, err = strconv.ParseFloat(threshold, 64); err != nil {
+			return nil, errors.New("could not parse cost_report_threshold: " + err.Error())
+		}
+	}
+
+	if minSpend, exists := lookupSetting("cost_report_min_spend"); exists {
+		if report.minSpend, err = strconv.ParseFloat(minSpend, 64); err != nil {
+			return nil, errors.New("could not parse cost_report_min_spend: " + err.Error())
+		}
+	}
+
+	return report, nil
+}
+
+// Spend on a service yesterday, and the average daily spend over the lookback period before that
+type ServiceCost struct {
+	Service string
+	Yesterday float64
+	Average float64
+}
+
+// Percentage change of yesterday's spend, compared to the average
+func (c ServiceCost) change() float64 {
+	if c.Average == 0 && c.Yesterday == 0 {
+		return 0
+	} else if c.Average == 0 {
+		return math.Inf(1)
+	}
+
+	return (c.Yesterday - c.Average) / c.Average * 100
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Cost Report",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.costReport == nil || !scheduledBy(event, notifiers.costReport.rule) {
+				return nil
+			}
+
+			return notifiers.postCostReport(ctx, time.Now())
+		},
+	})
+}
+
+// Returns the cost of each service for the day before today (in UTC, like Cost Explorer), along with the currency
+func (c *CostReport) fetch(ctx context.Context, today time.Time) ([]ServiceCost, string, error) {
+	svc := costexplorer.New(c.sess, aws.NewConfig().WithRegion(CostExplorerRegion))
+	traceAWSClient(svc.Client)
+
+	yesterday := today.AddDate(0, 0, -1).Format("2006-01-02")
+
+	input := &costexplorer.GetCostAndUsageInput{
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(today.AddDate(0, 0, -1 - c.lookback).Format("2006-01-02")),
+			End: aws.String(today.Format("2006-01-02")), // Exclusive
+		},
+		Granularity: aws.String(costexplorer.GranularityDaily),
+		Metrics: []*string{aws.String(CostMetric)},
+		GroupBy: []*costexplorer.GroupDefinition{
+			{
+				Type: aws.String(costexplorer.GroupDefinitionTypeDimension),
+				Key: aws.String("SERVICE"),
+			},
+		},
+	}
+
+	costs := make(map[string]*ServiceCost)
+	unit := ""
+
+	for {
+		res, err := svc.GetCostAndUsageWithContext(ctx, input)
+		if err != nil {
+			return nil, "", errors.New("failed to get costs from Cost Explorer: " + err.Error())
+		}
+
+		for _, result := range res.ResultsByTime {
+			day := aws.StringValue(result.TimePeriod.Start)
+
+			for _, group := range result.Groups {
+				if len(group.Keys) == 0 || group.Metrics[CostMetric] == nil {
+					continue
+				}
+
+				service := aws.StringValue(group.Keys[0])
+				amount, err := strconv.ParseFloat(aws.StringValue(group.Metrics[CostMetric].Amount), 64)
+				if err != nil {
+					continue
+				}
+
+				if unit == "" {
+					unit = aws.StringValue(group.Metrics[CostMetric].Unit)
+				}
+
+				if costs[service] == nil {
+					costs[service] = &ServiceCost{Service: service}
+				}
+
+				// Days a service isn't listed for count as zero towards the average
+				if day == yesterday {
+					costs[service].Yesterday += amount
+				} else {
+					costs[service].Average += amount / float64(c.lookback)
+				}
+			}
+		}
+
+		if aws.StringValue(res.NextPageToken) == "" {
+			break
+		}
+
+		input.NextPageToken = res.NextPageToken
+	}
+
+	var services []ServiceCost
+	for _, cost := range costs {
+		services = append(services, *cost)
+	}
+
+	// Biggest spend first
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Yesterday != services[j].Yesterday {
+			return services[i].Yesterday > services[j].Yesterday
+		}
+
+		return services[i].Service < services[j].Service
+	})
+
+	return services, unit, nil
+}
+
+// Whether the change in spend on the service is big enough to flag, in either direction
+func (c *CostReport) anomalous(cost ServiceCost) bool {
+	if cost.Yesterday < c.minSpend && cost.Average < c.minSpend {
+		return false
+	}
+
+	return math.Abs(cost.change()) >= c.threshold
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Sends the report straight to the cost report channels, bypassing routing (like the daily report)
+func (r *NotifierRegistry) postCostReport(ctx context.Context, now time.Time) error {
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	services, unit, err := r.costReport.fetch(ctx, today)
+	if err != nil {
+		return err
+	}
+
+	notification := r.costReport.notification(services, unit, today.AddDate(0, 0, -1), now)
+
+	var failures MultiError
+
+	for _, name := range r.costReport.channels {
+		notifier, exists := r.notifiers[name]
+		if !exists {
+			logger(ctx).Warn("Skipping cost report for unknown channel", "channel", name)
+			continue
+		}
+
+		failures.add("cost report via " + name, notifier.Send(ctx, notification))
+	}
+
+	return failures.errorOrNil()
+}
+
+func (c *CostReport) notification(services []ServiceCost, unit string, day time.Time, now time.Time) notify.Notification {
+	var total ServiceCost
+	var anomalies []string
+	var top []string
+
+	for i, cost := range services {
+		total.Yesterday += cost.Yesterday
+		total.Average += cost.Average
+
+		if c.anomalous(cost) {
+			anomalies = append(anomalies, cost.Service + ": " + costComparison(cost, unit))
+		}
+
+		if i < CostReportTopServices {
+			top = append(top, cost.Service + ": " + formatCost(cost.Yesterday, unit))
+		}
+	}
+
+	severity := notify.SeverityInfo
+	if len(anomalies) != 0 {
+		severity = notify.SeverityWarn
+	} else {
+		anomalies = []string{"None"}
+	}
+
+	if len(top) == 0 {
+		top = []string{"None"}
+	}
+
+	title := "Cost Report - " + formatCost(total.Yesterday, unit) + " spent on " + day.Format("Mon 2 Jan 2006")
+
+	return notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Cost Report",
+		Title: title,
+		Summary: title,
+		Severity: severity,
+		Fields: []notify.Field {
+			{
+				Title: "Total",
+				Value: costComparison(total, unit),
+				Short: false,
+			},
+			{
+				Title: "Anomalies (" + events.FormatNumber(c.threshold) + "% or more from the " + strconv.Itoa(c.lookback) + " day average)",
+				Value: strings.Join(anomalies, "\n"),
+				Short: false,
+			},
+			{
+				Title: "Top Services",
+				Value: strings.Join(top, "\n"),
+				Short: false,
+			},
+		},
+		ConsoleURL: "https://console.aws.amazon.com/cost-management/home#/cost-explorer",
+		Time: now.UTC().Format(time.RFC3339),
+	}
+}
+
+// Like "$412.50 (avg $298.10, +38.4%)"
+func costComparison(cost ServiceCost, unit string) string {
+	change := "new"
+	if value := cost.change(); !math.IsInf(value, 1) {
+		change = strconv.FormatFloat(value, 'f', 1, 64) + "%"
+		if value >= 0 {
+			change = "+" + change
+		}
+	}
+
+	return formatCost(cost.Yesterday, unit) + " (avg " + formatCost(cost.Average, unit) + ", " + change + ")"
+}
+
+func formatCost(amount float64, unit string) string {
+	formatted := strconv.FormatFloat(amount, 'f', 2, 64)
+
+	if unit == "USD" || unit == "" {
+		return "$" + formatted
+	}
+
+	return formatted + " " + unit
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultMaxAccessKeyAge = 90 // Days
+const DefaultUnusedCredentialsAge = 90 // Days
+
+// How long to wait for IAM to generate the credential report (which usually takes a few seconds)
+const CredentialReportTimeout = 30 * time.Second
+const CredentialReportPollInterval = 2 * time.Second
+
+// Findings listed per field, so that the message stays readable in accounts with lots of users
+const CredentialReportMaxFindings = 15
+
+const RootAccountUser = "<root_account>"
+
+/**
+Example credential report (CSV, some columns left out):
+
+user,arn,user_creation_time,password_enabled,password_last_used,mfa_active,access_key_1_active,access_key_1_last_rotated,access_key_1_last_used_date,access_key_2_active,access_key_2_last_rotated,access_key_2_last_used_date
+<root_account>,arn:aws:iam::123456789012:root,2019-03-01T10:00:00+00:00,not_supported,2024-02-20T08:12:00+00:00,true,false,N/A,N/A,false,N/A,N/A
+alice,arn:aws:iam::123456789012:user/alice,2020-06-11T09:30:00+00:00,true,2024-03-01T07:45:00+00:00,false,true,2023-01-05T12:00:00+00:00,2024-03-01T06:00:00+00:00,false,N/A,N/A
+*/
+
+// Posts a summary of IAM credential hygiene problems (old access keys, users without MFA, and credentials which
+// haven't been used for a while) from the IAM credential report, once a week. Triggered by a dedicated Cloudwatch
+// Events schedule rule (like "cron(0 8 ? * MON *)"), like the cost report.
+type CredentialReport struct {
+	sess *session.Session
+	rule string // Name of the schedule rule which triggers the report
+	channels []string
+	maxKeyAge int // Days
+	unusedAge int // Days
+}
+
+// Returns nil if credential_report_rule isn't set
+func newCredentialReportFromSettings(sess *session.Session) (*CredentialReport, error) {
+	rule, exists := lookupSetting("credential_report_rule")
+	if !exists {
+		return nil, nil
+	}
+
+	report := &CredentialReport{
+		sess: sess,
+		rule: rule,
+		channels: getListSetting("credential_report_channels", "slack"),
+		maxKeyAge: DefaultMaxAccessKeyAge,
+		unusedAge: DefaultUnusedCredentialsAge,
+	}
+
+	var err error
+
+	if maxKeyAge, exists := lookupSetting("credential_report_max_key_age"); exists {
+		if report.maxKeyAge, err = strconv.Atoi(maxKeyAge); err != nil || report.maxKeyAge < 1 {
+			return nil, errors.New("invalid credential_report_max_key_age: " + maxKeyAge)
+		}
+	}
+
+	if unusedAge, exists := lookupSetting("credential_report_unused_age"); exists {
+		if report.unusedAge, err = strconv.Atoi(unusedAge); err != nil || report.unusedAge < 1 {
+			return nil, errors.New("invalid credential_report_unused_age: " + unusedAge)
+		}
+	}
+
+	return report, nil
+}
+
+// Problems found in the credential report, as lines like "alice (access key 1, 212 days old)"
+type CredentialFindings struct {
+	Users int
+	OldAccessKeys []string
+	NoMFA []string
+	Unused []string
+	RootWithoutMFA bool
+}
+
+func (f CredentialFindings) count() int {
+	return len(f.OldAccessKeys) + len(f.NoMFA) + len(f.Unused)
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Credential Report",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.credentialReport == nil || !scheduledBy(event, notifiers.credentialReport.rule) {
+				return nil
+			}
+
+			return notifiers.postCredentialReport(ctx, time.Now())
+		},
+	})
+}
+
+// Has IAM generate a fresh report (it reuses the last one if it's less than 4 hours old), and returns its rows,
+// keyed by column name
+func (c *CredentialReport) fetch(ctx context.Context) ([]map[string]string, error) {
+	svc := iam.New(c.sess)
+	traceAWSClient(svc.Client)
+
+	deadline := time.Now().Add(CredentialReportTimeout)
+
+	for {
+		res, err := svc.GenerateCredentialReportWithContext(ctx, &iam.GenerateCredentialReportInput{})
+		if err != nil {
+			return nil, errors.New("failed to generate IAM credential report: " + err.Error())
+		}
+
+		if aws.StringValue(res.State) == iam.ReportStateTypeComplete {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for IAM credential report")
+		}
+
+		select {
+		case <-time.After(CredentialReportPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	res, err := svc.GetCredentialReportWithContext(ctx, &iam.GetCredentialReportInput{})
+	if err != nil {
+		return nil, errors.New("failed to get IAM credential report: " + err.Error())
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(res.Content)).ReadAll()
+	if err != nil {
+		return nil, errors.New("failed to parse IAM credential report: " + err.Error())
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(records[0]))
+		for i, column := range records[0] {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Values are timestamps, or placeholders like "N/A", "no_information" and "not_supported" (which aren't dates)
+func credentialReportTime(value string) (time.Time, bool) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	return parsed, err == nil
+}
+
+func daysSince(t time.Time, now time.Time) int {
+	return int(now.Sub(t).Hours() / 24)
+}
+
+func (c *CredentialReport) check(rows []map[string]string, now time.Time) CredentialFindings {
+	findings := CredentialFindings{Users: len(rows)}
+
+	for _, row := range rows {
+		user := row["user"]
+		created, _ := credentialReportTime(row["user_creation_time"])
+
+		// The root user's password shows up as "not_supported", but it always has one
+		if row["mfa_active"] == "false" && (row["password_enabled"] == "true" || user == RootAccountUser) {
+			findings.NoMFA = append(findings.NoMFA, user)
+			findings.RootWithoutMFA = findings.RootWithoutMFA || user == RootAccountUser
+		}
+
+		if row["password_enabled"] == "true" {
+			if unused, ok := c.unusedFor(row["password_last_used"], created, now); ok {
+				findings.Unused = append(findings.Unused, user + " (password, " + unused + ")")
+			}
+		}
+
+		for _, key := range []string{"1", "2"} {
+			prefix := "access_key_" + key + "_"
+			if row[prefix + "active"] != "true" {
+				continue
+			}
+
+			rotated, hasRotated := credentialReportTime(row[prefix + "last_rotated"])
+			if hasRotated && daysSince(rotated, now) > c.maxKeyAge {
+				findings.OldAccessKeys = append(findings.OldAccessKeys,
+					user + " (access key " + key + ", " + strconv.Itoa(daysSince(rotated, now)) + " days old)")
+			}
+
+			if !hasRotated {
+				rotated = created
+			}
+
+			if unused, ok := c.unusedFor(row[prefix + "last_used_date"], rotated, now); ok {
+				findings.Unused = append(findings.Unused, user + " (access key " + key + ", " + unused + ")")
+			}
+		}
+	}
+
+	return findings
+}
+
+// Whether a credential hasn't been used for longer than allowed, along with a description like "last used 140 days
+// ago". Credentials which were never used count from when they were created.
+func (c *CredentialReport) unusedFor(lastUsed string, created time.Time, now time.Time) (string, bool) {
+	if used, ok := credentialReportTime(lastUsed); ok {
+		days := daysSince(used, now)
+		return "last used " + strconv.Itoa(days) + " days ago", days > c.unusedAge
+	}
+
+	if created.IsZero() {
+		return "", false
+	}
+
+	days := daysSince(created, now)
+	return "never used in " + strconv.Itoa(days) + " days", days > c.unusedAge
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Sends the report straight to the report channels, bypassing routing (like the daily report)
+func (r *NotifierRegistry) postCredentialReport(ctx context.Context, now time.Time) error {
+	rows, err := r.credentialReport.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	notification := r.credentialReport.notification(r.credentialReport.check(rows, now), now)
+
+	var failures MultiError
+
+	for _, name := range r.credentialReport.channels {
+		notifier, exists := r.notifiers[name]
+		if !exists {
+			logger(ctx).Warn("Skipping credential report for unknown channel", "channel", name)
+			continue
+		}
+
+		failures.add("credential report via " + name, notifier.Send(ctx, notification))
+	}
+
+	return failures.errorOrNil()
+}
+
+func (c *CredentialReport) notification(findings CredentialFindings, now time.Time) notify.Notification {
+	findingLines := func(lines []string) string {
+		if len(lines) == 0 {
+			return "None"
+		}
+
+		if len(lines) > CredentialReportMaxFindings {
+			more := len(lines) - CredentialReportMaxFindings
+			lines = append(lines[:CredentialReportMaxFindings:CredentialReportMaxFindings], "... and " + strconv.Itoa(more) + " more")
+		}
+
+		return strings.Join(lines, "\n")
+	}
+
+	title := "IAM Credential Report - " + strconv.Itoa(findings.count()) + " finding(s) for " +
+		strconv.Itoa(findings.Users) + " user(s)"
+
+	severity := notify.SeveritySuccess
+	if findings.RootWithoutMFA {
+		severity = notify.SeverityError
+	} else if findings.count() != 0 {
+		severity = notify.SeverityWarn
+	}
+
+	return notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Credential Report",
+		Title: title,
+		Summary: title,
+		Severity: severity,
+		Fields: []notify.Field {
+			{
+				Title: "Access Keys Older Than " + strconv.Itoa(c.maxKeyAge) + " Days",
+				Value: findingLines(findings.OldAccessKeys),
+				Short: false,
+			},
+			{
+				Title: "Users Without MFA",
+				Value: findingLines(findings.NoMFA),
+				Short: false,
+			},
+			{
+				Title: "Unused For " + strconv.Itoa(c.unusedAge) + " Days",
+				Value: findingLines(findings.Unused),
+				Short: false,
+			},
+		},
+		ConsoleURL: "https://console.aws.amazon.com/iam/home#/credential_report",
+		Time: now.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultDashboardSnapshotTitle = "Morning Status"
+
+// How much history widgets show, unless the widget (or the dashboard) says otherwise
+const DefaultDashboardSnapshotRange = "-PT24H"
+
+// Properties of dashboard widgets which GetMetricWidgetImage understands as well. Anything else (like "sparkline")
+// is only meaningful in the console, and is left out.
+var snapshotWidgetProperties = []string{
+	"metrics", "annotations", "title", "view", "stacked", "yAxis", "legend", "period", "stat", "region", "timezone",
+	"start", "end",
+}
+
+/**
+Example dashboard body (from GetDashboard):
+
+{
+  "start": "-PT12H",
+  "widgets": [
+    {
+      "type": "metric",
+      "x": 0,
+      "y": 0,
+      "width": 12,
+      "height": 6,
+      "properties": {
+        "title": "API Latency",
+        "view": "timeSeries",
+        "region": "eu-west-1",
+        "metrics": [
+          ["AWS/ApiGateway", "Latency", "ApiName", "checkout", {"stat": "p99"}]
+        ],
+        "period": 300
+      }
+    },
+    {
+      "type": "text",
+      "x": 12,
+      "y": 0,
+      "width": 12,
+      "height": 6,
+      "properties": {
+        "markdown": "# Checkout"
+      }
+    }
+  ]
+}
+*/
+
+type DashboardBody struct {
+	Start string `json:"start"`
+	End string `json:"end"`
+	Widgets []DashboardWidget `json:"widgets"`
+}
+
+type DashboardWidget struct {
+	Type string `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func (w DashboardWidget) title() string {
+	title, _ := w.Properties["title"].(string)
+	return title
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Posts images of the metric widgets of a Cloudwatch dashboard (or the selected ones), as a visual health check.
+// Triggered by a dedicated Cloudwatch Events schedule rule (like "cron(0 8 ? * MON-FRI *)"), like the cost report.
+type DashboardSnapshot struct {
+	sess *session.Session
+	rule string // Name of the schedule rule which triggers the snapshot
+	dashboard string
+	region string // Where the dashboard is
+	widgets []string // Titles of the widgets to include, or all metric widgets if empty
+	channels []string
+	title string
+}
+
+// Returns nil if dashboard_snapshot_rule isn't set. The images are uploaded via the metric graphs.
+func newDashboardSnapshotFromSettings(sess *session.Session, graphs *MetricGraphs) (*DashboardSnapshot, error) {
+	rule, exists := lookupSetting("dashboard_snapshot_rule")
+	if !exists {
+		return nil, nil
+	}
+
+	if graphs == nil {
+		return nil, errors.New("dashboard_snapshot_rule requires metric_graph_bucket, to upload the images to")
+	}
+
+	snapshot := &DashboardSnapshot{
+		sess: sess,
+		rule: rule,
+		dashboard: getSetting("dashboard_snapshot_dashboard"),
+		region: aws.StringValue(sess.Config.Region),
+		widgets: getListSetting("dashboard_snapshot_widgets"),
+		channels: getListSetting("dashboard_snapshot_channels", "slack"),
+		title: DefaultDashboardSnapshotTitle,
+	}
+
+	if snapshot.dashboard == "" {
+		return nil, errors.New("dashboard_snapshot_rule requires dashboard_snapshot_dashboard")
+	}
+
+	if region, exists := lookupSetting("dashboard_snapshot_region"); exists {
+		snapshot.region = region
+	}
+
+	if title, exists := lookupSetting("dashboard_snapshot_title"); exists {
+		snapshot.title = title
+	}
+
+	return snapshot, nil
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Dashboard Snapshot",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.snapshot == nil || !scheduledBy(event, notifiers.snapshot.rule) {
+				return nil
+			}
+
+			return notifiers.postDashboardSnapshot(ctx, time.Now())
+		},
+	})
+}
+
+func (d *DashboardSnapshot) fetch(ctx context.Context) (DashboardBody, error) {
+	svc := cloudwatch.New(d.sess, aws.NewConfig().WithRegion(d.region))
+	traceAWSClient(svc.Client)
+
+	var body DashboardBody
+
+	res, err := svc.GetDashboardWithContext(ctx, &cloudwatch.GetDashboardInput{
+		DashboardName: aws.String(d.dashboard),
+	})
+	if err != nil {
+		return body, errors.New("failed to get dashboard " + d.dashboard + ": " + err.Error())
+	}
+
+	if err := json.Unmarshal([]byte(aws.StringValue(res.DashboardBody)), &body); err != nil {
+		return body, errors.New("failed to parse dashboard " + d.dashboard + ": " + err.Error())
+	}
+
+	return body, nil
+}
+
+// Returns the metric widgets to render, in the order they appear on the dashboard
+func (d *DashboardSnapshot) selectWidgets(body DashboardBody) []DashboardWidget {
+	var selected []DashboardWidget
+
+	for _, widget := range body.Widgets {
+		if widget.Type != "metric" {
+			continue
+		}
+
+		if len(d.widgets) != 0 && !contains(d.widgets, widget.title()) {
+			continue
+		}
+
+		selected = append(selected, widget)
+	}
+
+	return selected
+}
+
+// See: https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/CloudWatch-Metric-Widget-Structure.html
+func snapshotWidget(body DashboardBody, widget DashboardWidget) (string, error) {
+	properties := map[string]interface{}{
+		"start": DefaultDashboardSnapshotRange,
+		"width": 600,
+		"height": 300,
+	}
+
+	// The time range of the dashboard applies to widgets which don't have their own
+	if body.Start != "" {
+		properties["start"] = body.Start
+	}
+
+	if body.End != "" {
+		properties["end"] = body.End
+	}
+
+	for _, name := range snapshotWidgetProperties {
+		if value, exists := widget.Properties[name]; exists {
+			properties[name] = value
+		}
+	}
+
+	encoded, err := json.Marshal(properties)
+	if err != nil {
+		return "", errors.New("failed to marshal dashboard widget: " + err.Error())
+	}
+
+	return string(encoded), nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Sends the snapshot straight to the snapshot channels, bypassing routing (like the daily report). Widgets which
+// fail to render are listed, rather than holding back the rest.
+func (r *NotifierRegistry) postDashboardSnapshot(ctx context.Context, now time.Time) error {
+	snapshot := r.snapshot
+
+	body, err := snapshot.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	widgets := snapshot.selectWidgets(body)
+	if len(widgets) == 0 {
+		return errors.New("no metric widgets to snapshot on dashboard " + snapshot.dashboard)
+	}
+
+	var images []notify.Image
+	var failed []string
+
+	for i, widget := range widgets {
+		title := widget.title()
+		if title == "" {
+			title = "Widget " + strconv.Itoa(i + 1)
+		}
+
+		// Widgets can show metrics from other regions than the dashboard's
+		region := snapshot.region
+		if value, ok := widget.Properties["region"].(string); ok && value != "" {
+			region = value
+		}
+
+		image, err := snapshotWidget(body, widget)
+		if err == nil {
+			image, err = r.graphs.renderWidget(ctx, region, image, snapshot.dashboard + "/" + strconv.Itoa(i))
+		}
+
+		if err != nil {
+			logger(ctx).Warn("Failed to render dashboard widget", "dashboard", snapshot.dashboard, "widget", title,
+				"error", err.Error())
+			failed = append(failed, title)
+			continue
+		}
+
+		images = append(images, notify.Image{Title: title, URL: image})
+	}
+
+	if len(images) == 0 {
+		return errors.New("failed to render any widgets of dashboard " + snapshot.dashboard)
+	}
+
+	title := snapshot.title + " - " + snapshot.dashboard
+
+	notification := notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Dashboard Snapshot",
+		Title: title,
+		Summary: title,
+		Severity: notify.SeverityInfo,
+		Fields: []notify.Field {
+			{
+				Title: snapshot.title,
+				Value: "Dashboard: " + snapshot.dashboard,
+				Short: false,
+			},
+		},
+		ConsoleURL: events.DashboardConsoleURL(snapshot.region, snapshot.dashboard),
+		Images: images,
+		Time: now.UTC().Format(time.RFC3339),
+	}
+
+	if len(failed) != 0 {
+		notification.Fields = append(notification.Fields, notify.Field {
+			Title: "Failed to Render",
+			Value: strings.Join(failed, "\n"),
+			Short: false,
+		})
+	}
+
+	var failures MultiError
+
+	for _, name := range snapshot.channels {
+		notifier, exists := r.notifiers[name]
+		if !exists {
+			logger(ctx).Warn("Skipping dashboard snapshot for unknown channel", "channel", name)
+			continue
+		}
+
+		failures.add("dashboard snapshot via " + name, notifier.Send(ctx, notification))
+	}
+
+	return failures.errorOrNil()
+}
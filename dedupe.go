@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultDedupeWindow = 15 * time.Minute
+
+// Keeps track of recently sent notifications, so that repeated identical events within the window
+// are only sent once. Backed by a DynamoDB table with a string hash key called "dedupe_key", and
+// (ideally) TTL enabled on the "expires_at" attribute, so that old entries get cleaned up.
+type DedupeStore struct {
+	db *dynamodb.DynamoDB
+	table string
+	window time.Duration
+}
+
+// Returns nil if dedupe_table isn't set
+func newDedupeStoreFromSettings(sess *session.Session) (*DedupeStore, error) {
+	table, exists := lookupSetting("dedupe_table")
+	if !exists {
+		return nil, nil
+	}
+
+	dedupe := &DedupeStore{
+		db: dynamodb.New(sess),
+		table: table,
+		window: DefaultDedupeWindow,
+	}
+
+	if window, exists := lookupSetting("dedupe_window"); exists {
+		var err error
+		if dedupe.window, err = time.ParseDuration(window); err != nil {
+			return nil, errors.New("could not parse dedupe_window: " + err.Error())
+		}
+	}
+
+	return dedupe, nil
+}
+
+// Identifies a notification by its source and type, title, the resource it's about, its state (which the severity
+// follows, like an alarm going into ALARM or back to OK) and the DedupeKey set by its handler (like the state an
+// instance went into, or the body of a plain SNS message). Fields are left out, since they change between otherwise
+// identical events (like an alarm's datapoints, or its transition history).
+func dedupeKey(notification notify.Notification) string {
+	hash := sha256.New()
+
+	resource := notification.AlarmName
+	if resource == "" {
+		resource = strings.Join(notification.Resources, ",")
+	}
+
+	for _, part := range []string{
+		notification.Source,
+		notification.DetailType,
+		notification.Title,
+		notification.Account,
+		notification.Region,
+		resource,
+		notification.Severity,
+		notification.DedupeKey,
+	} {
+		hash.Write([]byte(part + "\n"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Records the notification as sent, and returns whether it was already sent within the window. Uses
+// a conditional write, so that concurrent invocations can't both send the same notification. If sending
+// it fails, the record has to be removed (see forget), so that a retry isn't dropped as a duplicate.
+func (s *DedupeStore) seen(notification notify.Notification) (bool, error) {
+	now := time.Now()
+
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"dedupe_key": {S: aws.String(dedupeKey(notification))},
+			"expires_at": {N: aws.String(strconv.FormatInt(now.Add(s.window).Unix(), 10))},
+		},
+		// DynamoDB only deletes expired items eventually, so we can't rely on them being gone
+		ConditionExpression: aws.String("attribute_not_exists(dedupe_key) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return true, nil
+	} else if err != nil {
+		return false, errors.New("failed to record notification in DynamoDB: " + err.Error())
+	}
+
+	return false, nil
+}
+
+func (s *DedupeStore) forget(notification notify.Notification) error {
+	_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"dedupe_key": {S: aws.String(dedupeKey(notification))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to remove notification from DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
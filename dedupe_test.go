@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDedupeKey(t *testing.T) {
+	base := notify.Notification {
+		Source: "aws.cloudwatch",
+		Title: "ALARM: \"cpu-high\"",
+		Account: "123456789012",
+		Region: "eu-west-1",
+		AlarmName: "cpu-high",
+		Severity: notify.SeverityError,
+		Fields: []notify.Field{{Title: "Value", Value: "92.5"}},
+	}
+
+	tests := []struct {
+		name string
+		change func(n *notify.Notification)
+		same bool
+	}{
+		{"identical", func(n *notify.Notification) {}, true},
+		{"different fields", func(n *notify.Notification) { n.Fields = []notify.Field{{Title: "Value", Value: "97.1"}} }, true},
+		{"different summary", func(n *notify.Notification) { n.Summary = "Threshold crossed again" }, true},
+		{"different resources of an alarm", func(n *notify.Notification) { n.Resources = []string{"arn:aws:ec2:eu-west-1:123456789012:instance/i-1"} }, true},
+		{"different source", func(n *notify.Notification) { n.Source = "aws:sns" }, false},
+		{"different title", func(n *notify.Notification) { n.Title = "OK: \"cpu-high\"" }, false},
+		{"different account", func(n *notify.Notification) { n.Account = "210987654321" }, false},
+		{"different region", func(n *notify.Notification) { n.Region = "us-east-1" }, false},
+		{"different alarm", func(n *notify.Notification) { n.AlarmName = "cpu-low" }, false},
+		{"different severity", func(n *notify.Notification) { n.Severity = notify.SeveritySuccess }, false},
+		{"different detail type", func(n *notify.Notification) { n.DetailType = "Cloudwatch Alarm State Change" }, false},
+		{"different dedupe key", func(n *notify.Notification) { n.DedupeKey = "other" }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed := base
+			test.change(&changed)
+
+			if same := dedupeKey(base) == dedupeKey(changed); same != test.same {
+				t.Errorf("expected the keys to match: %v, got: %v", test.same, same)
+			}
+		})
+	}
+}
+
+// Events of the same type about the same resource are only repeats if the handler's DedupeKey matches too
+func TestDedupeKeyEvents(t *testing.T) {
+	ec2 := func(instanceID string, state string) notify.Notification {
+		event := lambdaevents.CloudWatchEvent {
+			Source: "aws.ec2",
+			DetailType: "EC2 Instance State-change Notification",
+			AccountID: "123456789012",
+			Region: "eu-west-1",
+			Resources: []string{"arn:aws:ec2:eu-west-1:123456789012:instance/" + instanceID},
+		}
+
+		return events.EC2StateChangeNotification(event, events.DetailEC2StateChange{InstanceId: instanceID, State: state})
+	}
+
+	lifecycle := func(instanceID string, token string) notify.Notification {
+		event := lambdaevents.CloudWatchEvent {
+			Source: "aws.autoscaling",
+			DetailType: "EC2 Instance-terminate Lifecycle Action",
+			AccountID: "123456789012",
+			Region: "eu-west-1",
+			Resources: []string{"arn:aws:autoscaling:eu-west-1:123456789012:autoScalingGroup:1:autoScalingGroupName/web"},
+		}
+
+		return events.AutoScalingLifecycleNotification(event, events.DetailAutoScalingLifecycleEvent {
+			LifecycleActionToken: token,
+			AutoScalingGroupName: "web",
+			EC2InstanceId: instanceID,
+		})
+	}
+
+	sns := func(subject string, body string) notify.Notification {
+		message := events.SNSMessage{SNSEntity: lambdaevents.SNSEntity {
+			TopicArn: "arn:aws:sns:eu-west-1:123456789012:alerts",
+			Subject: subject,
+			Message: body,
+		}}
+
+		return events.SNSNotification(message)
+	}
+
+	withDetailType := func(n notify.Notification, detailType string) notify.Notification {
+		n.DetailType = detailType
+		return n
+	}
+
+	tests := []struct {
+		name string
+		a notify.Notification
+		b notify.Notification
+		same bool
+	}{
+		{"same instance and state", ec2("i-1", "stopped"), ec2("i-1", "stopped"), true},
+		{"same instance, stopping then stopped", ec2("i-1", "stopping"), ec2("i-1", "stopped"), false},
+		{"another instance", ec2("i-1", "stopped"), ec2("i-2", "stopped"), false},
+		{"same lifecycle action", lifecycle("i-1", "token-1"), lifecycle("i-1", "token-1"), true},
+		{"lifecycle actions for different instances of a group", lifecycle("i-1", "token-1"), lifecycle("i-2", "token-2"), false},
+		{"same SNS message", sns("Backup", "Backup of db-1 failed"), sns("Backup", "Backup of db-1 failed"), true},
+		{"SNS messages with the same subject", sns("Backup", "Backup of db-1 failed"), sns("Backup", "Backup of db-2 failed"), false},
+		{"different event types", ec2("i-1", "stopped"), withDetailType(ec2("i-1", "stopped"), "EC2 Spot Instance Interruption Warning"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := dedupeKey(test.a) == dedupeKey(test.b); same != test.same {
+				t.Errorf("expected the keys to match: %v, got: %v", test.same, same)
+			}
+		})
+	}
+}
+
+func TestDedupeStoreSeen(t *testing.T) {
+	notification := notify.Notification {
+		Source: "aws.cloudwatch",
+		Title: "ALARM: \"cpu-high\"",
+		AlarmName: "cpu-high",
+		Severity: notify.SeverityError,
+	}
+
+	tests := []struct {
+		name string
+		existing func(key string) map[string]map[string]string
+		duplicate bool
+	}{
+		{
+			name: "first occurrence",
+			existing: func(key string) map[string]map[string]string { return nil },
+			duplicate: false,
+		},
+		{
+			name: "sent within the window",
+			existing: func(key string) map[string]map[string]string {
+				return map[string]map[string]string{
+					"dedupe_key": {"S": key},
+					"expires_at": {"N": strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)},
+				}
+			},
+			duplicate: true,
+		},
+		{
+			// DynamoDB only deletes expired items eventually
+			name: "expired but not deleted yet",
+			existing: func(key string) map[string]map[string]string {
+				return map[string]map[string]string{
+					"dedupe_key": {"S": key},
+					"expires_at": {"N": strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)},
+				}
+			},
+			duplicate: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, db := newFakeDynamoDB(t, "dedupe_key")
+			store := &DedupeStore{db: db, table: "dedupe", window: DefaultDedupeWindow}
+
+			if existing := test.existing(dedupeKey(notification)); existing != nil {
+				fake.put(existing)
+			}
+
+			duplicate, err := store.seen(notification)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if duplicate != test.duplicate {
+				t.Errorf("expected duplicate: %v, got: %v", test.duplicate, duplicate)
+			}
+
+			// Either way, it's recorded as sent for the rest of the window
+			expiresAt, _ := strconv.ParseInt(fake.item(dedupeKey(notification))["expires_at"]["N"], 10, 64)
+			if expiresAt <= time.Now().Unix() {
+				t.Errorf("expected the record to expire after now, got %v", expiresAt)
+			}
+		})
+	}
+}
+
+func TestSendDeduplicates(t *testing.T) {
+	notification := notify.Notification {
+		Source: "aws.cloudwatch",
+		Title: "ALARM: \"cpu-high\"",
+		AlarmName: "cpu-high",
+		Severity: notify.SeverityError,
+	}
+
+	tests := []struct {
+		name string
+		failFirst bool // Whether the first delivery fails
+		storeFailing bool // Whether DynamoDB can't be reached
+		delivered int // Out of the sends which didn't fail
+	}{
+		{"duplicate dropped", false, false, 1},
+		{"retry after a failed delivery goes through", true, false, 1},
+		{"sent anyway if duplicates can't be checked", false, true, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, db := newFakeDynamoDB(t, "dedupe_key")
+			fake.setFailing(test.storeFailing)
+
+			notifier := &recordingNotifier{failing: test.failFirst}
+			registry := newNotifierRegistry()
+			registry.register("test", notifier)
+			registry.dedupe = &DedupeStore{db: db, table: "dedupe", window: DefaultDedupeWindow}
+
+			err := registry.send(context.Background(), notification)
+			if test.failFirst && err == nil {
+				t.Fatal("expected the first delivery to fail")
+			}
+
+			notifier.failing = false
+
+			if err := registry.send(context.Background(), notification); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := registry.send(context.Background(), notification); err != nil {
+				t.Fatal(err)
+			}
+
+			if delivered := notifier.count(); delivered != test.delivered {
+				t.Errorf("expected %d deliveries, got %d", test.delivered, delivered)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The HTTP client shared by the notifiers (notify.HTTPClient) is built from the settings here
+
+const DefaultHTTPTimeout = 10 * time.Second
+
+// TLS versions accepted by tls_min_version
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// How the shared HTTP client is set up, from the http_timeout, proxy_url, proxy_bypass, ca_bundle and
+// tls_min_version settings
+type HTTPClientConfig struct {
+	timeout time.Duration
+	proxy *url.URL // Overrides HTTP_PROXY / HTTPS_PROXY (and NO_PROXY) from the environment
+	bypass []string // Hosts to connect to directly, like "hooks.internal" (including its subdomains), or "*"
+	rootCAs *x509.CertPool // The system roots, plus the ones from ca_bundle (nil to use the system roots)
+	minTLSVersion uint16 // Go's default if zero
+}
+
+// Rebuilt along with the runtime, and when tracing is enabled
+var httpClientConfig = HTTPClientConfig{timeout: DefaultHTTPTimeout}
+
+func init() {
+	notify.HTTPClient = newHTTPClient(httpClientConfig)
+}
+
+func newHTTPClient(config HTTPClientConfig) *http.Client {
+	client := &http.Client{
+		Timeout: config.timeout, // Per request, including reading the response
+		Transport: &http.Transport{
+			Proxy: config.proxyFunc(),
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns: 20,
+			MaxIdleConnsPerHost: 5,
+			IdleConnTimeout: 90 * time.Second,
+			TLSHandshakeTimeout: 5 * time.Second,
+			TLSClientConfig: &tls.Config{
+				RootCAs: config.rootCAs,
+				MinVersion: config.minTLSVersion,
+			},
+			ResponseHeaderTimeout: config.timeout,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+
+	if tracingEnabled {
+		return xray.Client(client)
+	}
+
+	return client
+}
+
+func httpClientConfigFromSettings() (HTTPClientConfig, error) {
+	config := HTTPClientConfig{timeout: DefaultHTTPTimeout}
+
+	if timeout, exists := lookupSetting("http_timeout"); exists {
+		var err error
+		if config.timeout, err = time.ParseDuration(timeout); err != nil {
+			return config, errors.New("could not parse http_timeout: " + err.Error())
+		}
+	}
+
+	if proxy, exists := lookupSetting("proxy_url"); exists {
+		parsed, err := url.Parse(proxy)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return config, errors.New("proxy_url is not a valid http(s) URL")
+		}
+
+		config.proxy = parsed
+
+		for _, host := range strings.Split(getSetting("proxy_bypass"), ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				config.bypass = append(config.bypass, strings.ToLower(host))
+			}
+		}
+	}
+
+	if bundle, exists := lookupSetting("ca_bundle"); exists {
+		var err error
+		if config.rootCAs, err = loadCABundle(bundle); err != nil {
+			return config, err
+		}
+	}
+
+	if value, exists := lookupSetting("tls_min_version"); exists {
+		version, supported := tlsVersions[value]
+		if !supported {
+			return config, errors.New("unsupported tls_min_version: " + value)
+		}
+
+		config.minTLSVersion = version
+	}
+
+	return config, nil
+}
+
+// Adds the certificates from the bundle (PEM encoded, either inline or in a file, like one packaged with the
+// function) to the system roots, for endpoints signed by a private CA, or behind a TLS-intercepting proxy
+func loadCABundle(bundle string) (*x509.CertPool, error) {
+	pem := []byte(bundle)
+
+	if !strings.HasPrefix(strings.TrimSpace(bundle), "-----BEGIN") {
+		var err error
+		if pem, err = ioutil.ReadFile(bundle); err != nil {
+			return nil, errors.New("failed to read ca_bundle: " + err.Error())
+		}
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in ca_bundle")
+	}
+
+	return pool, nil
+}
+
+func (c HTTPClientConfig) proxyFunc() func(req *http.Request) (*url.URL, error) {
+	if c.proxy == nil {
+		return http.ProxyFromEnvironment
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Hostname())
+
+		for _, bypass := range c.bypass {
+			bypass = strings.TrimPrefix(bypass, ".")
+
+			if bypass == "*" || host == bypass || strings.HasSuffix(host, "." + bypass) {
+				return nil, nil
+			}
+		}
+
+		return c.proxy, nil
+	}
+}
+
+// Errors which know whether they are worth retrying (network errors, rate limiting, server errors)
+type temporaryError interface {
+	Temporary() bool
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many of the most frequent resources to list in a digest
+const DigestTopResources = 5
+
+// Notifications routed to a digest are buffered in a DynamoDB table (with a string hash key called
+// "digest_key"), and posted as a single summary per set of channels by the "Digest" scheduled task, when it's
+// triggered by the digest_rule schedule rule
+type DigestStore struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+// Returns nil if digest_table isn't set
+func newDigestStoreFromSettings(sess *session.Session) *DigestStore {
+	table, exists := lookupSetting("digest_table")
+	if !exists {
+		return nil
+	}
+
+	return &DigestStore{
+		db: dynamodb.New(sess),
+		table: table,
+	}
+}
+
+type DigestEntry struct {
+	Key string
+	Channels []string
+	Source string
+	DetailType string
+	Resource string
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Digest",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			// Other schedule rules (like the one retrying failed notifications) run far more often than digests should go out
+			if notifiers.digests == nil || !scheduledBy(event, getSetting("digest_rule")) {
+				return nil
+			}
+
+			return notifiers.flushDigests(ctx)
+		},
+	})
+}
+
+// Best effort name of the resource the notification is about, for listing the top resources
+func digestResource(notification notify.Notification) string {
+	if notification.AlarmName != "" {
+		return notification.AlarmName
+	}
+
+	if event, ok := notification.Event.(map[string]interface{}); ok {
+		if resources, ok := event["resources"].([]interface{}); ok && len(resources) != 0 {
+			if resource, ok := resources[0].(string); ok {
+				return resource
+			}
+		}
+	}
+
+	return notification.Title
+}
+
+func (s *DigestStore) add(notification notify.Notification, channels []string) error {
+	encodedChannels, err := json.Marshal(channels)
+	if err != nil {
+		return errors.New("failed to marshal digest channels: " + err.Error())
+	}
+
+	// Unique enough, since the same notification isn't buffered twice in the same nanosecond
+	key := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + dedupeKey(notification)[:16]
+
+	_, err = s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"digest_key": {S: aws.String(key)},
+			"channels": {S: aws.String(string(encodedChannels))},
+			"source": {S: aws.String(notification.Source)},
+			"detail_type": {S: aws.String(notification.DetailType)},
+			"resource": {S: aws.String(digestResource(notification))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to buffer notification in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+func (s *DigestStore) list() ([]DigestEntry, error) {
+	var entries []DigestEntry
+
+	err := s.db.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(s.table),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			entry := DigestEntry {
+				Key: aws.StringValue(item["digest_key"].S),
+			}
+
+			if item["channels"] != nil {
+				json.Unmarshal([]byte(aws.StringValue(item["channels"].S)), &entry.Channels)
+			}
+
+			for attribute, value := range map[string]*string{
+				"source": &entry.Source,
+				"detail_type": &entry.DetailType,
+				"resource": &entry.Resource,
+			} {
+				if item[attribute] != nil {
+					*value = aws.StringValue(item[attribute].S)
+				}
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read digest from DynamoDB: " + err.Error())
+	}
+
+	return entries, nil
+}
+
+func (s *DigestStore) remove(entries []DigestEntry) error {
+	// BatchWriteItem takes at most 25 requests at a time
+	for start := 0; start < len(entries); start += 25 {
+		end := start + 25
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		var requests []*dynamodb.WriteRequest
+		for _, entry := range entries[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{
+					Key: map[string]*dynamodb.AttributeValue{
+						"digest_key": {S: aws.String(entry.Key)},
+					},
+				},
+			})
+		}
+
+		unprocessed := map[string][]*dynamodb.WriteRequest{s.table: requests}
+
+		for len(unprocessed) != 0 {
+			res, err := s.db.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: unprocessed})
+			if err != nil {
+				return errors.New("failed to remove digest entries from DynamoDB: " + err.Error())
+			}
+
+			unprocessed = res.UnprocessedItems
+		}
+	}
+
+	return nil
+}
+
+// Keeps the entries buffered for the given channels only, once the digest has been posted to the rest
+func (s *DigestStore) retain(entries []DigestEntry, channels []string) error {
+	encodedChannels, err := json.Marshal(channels)
+	if err != nil {
+		return errors.New("failed to marshal digest channels: " + err.Error())
+	}
+
+	for _, entry := range entries {
+		_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]*dynamodb.AttributeValue{
+				"digest_key": {S: aws.String(entry.Key)},
+			},
+			UpdateExpression: aws.String("SET channels = :channels"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":channels": {S: aws.String(string(encodedChannels))},
+			},
+		})
+
+		if err != nil {
+			return errors.New("failed to update digest entry in DynamoDB: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Posts a summary of the buffered notifications to each set of channels they were routed to. Channels failing to
+// post don't hold up the rest: the notifications stay buffered for them only, and are posted with the next digest.
+func (r *NotifierRegistry) flushDigests(ctx context.Context) error {
+	entries, err := r.digests.list()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		logger(ctx).Info("Digest is empty")
+		return nil
+	}
+
+	groups := make(map[string][]DigestEntry)
+	for _, entry := range entries {
+		key := strings.Join(entry.Channels, ",")
+		groups[key] = append(groups[key], entry)
+	}
+
+	var keys []string
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var failures MultiError
+
+	for _, key := range keys {
+		group := groups[key]
+		notification := digestNotification(group)
+
+		var failed []string
+
+		for _, name := range strings.Split(key, ",") {
+			notifier, exists := r.notifiers[name]
+			if !exists {
+				logger(ctx).Warn("Skipping digest for unknown channel", "channel", name)
+				continue
+			}
+
+			if err := notifier.Send(ctx, notification); err != nil {
+				logger(ctx).Error("Failed to send digest", "channel", name, "error", err.Error())
+				failures.add("channel " + name, err)
+				failed = append(failed, name)
+			}
+		}
+
+		if len(failed) == 0 {
+			err = r.digests.remove(group)
+		} else {
+			err = r.digests.retain(group, failed)
+		}
+
+		if err != nil {
+			failures.add("digest for " + key, err)
+		}
+	}
+
+	return failures.errorOrNil()
+}
+
+type digestCount struct {
+	name string
+	count int
+}
+
+// Sorts by count (descending), then name
+func sortedCounts(counts map[string]int) []digestCount {
+	var sorted []digestCount
+	for name, count := range counts {
+		sorted = append(sorted, digestCount{name, count})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+
+		return sorted[i].name < sorted[j].name
+	})
+
+	return sorted
+}
+
+func digestNotification(entries []DigestEntry) notify.Notification {
+	types := make(map[string]int)
+	resources := make(map[string]int)
+
+	for _, entry := range entries {
+		types[entry.Source + " - " + entry.DetailType]++
+		resources[entry.Resource]++
+	}
+
+	var typeLines []string
+	for _, c := range sortedCounts(types) {
+		typeLines = append(typeLines, strconv.Itoa(c.count) + " x " + c.name)
+	}
+
+	var resourceLines []string
+	for i, c := range sortedCounts(resources) {
+		if i == DigestTopResources {
+			break
+		}
+
+		resourceLines = append(resourceLines, strconv.Itoa(c.count) + " x " + c.name)
+	}
+
+	title := "Digest - " + strconv.Itoa(len(entries)) + " notification(s)"
+
+	return notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Digest",
+		Title: title,
+		Summary: title,
+		Severity: notify.SeverityInfo,
+		Fields: []notify.Field {
+			{
+				Title: "Events",
+				Value: strings.Join(typeLines, "\n"),
+				Short: false,
+			},
+			{
+				Title: "Top Resources",
+				Value: strings.Join(resourceLines, "\n"),
+				Short: false,
+			},
+		},
+		Time: time.Now().UTC().Format(time.RFC3339),
+	}
+}
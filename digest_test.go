@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"testing"
+)
+
+// Buffers a notification about the resource for the channels, the way routing does
+func bufferDigest(t *testing.T, store *DigestStore, resource string, channels ...string) {
+	notification := notify.Notification {
+		Source: "aws.autoscaling",
+		DetailType: "EC2 Instance Launch Successful",
+		Title: "Autoscaling - EC2 Instance Launch Successful",
+		Event: map[string]interface{}{"resources": []interface{}{resource}},
+	}
+
+	if err := store.add(notification, channels); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The digest only goes out on its own schedule, and not on every scheduled event (like the ones retrying failed
+// notifications every few minutes)
+func TestDigestSchedule(t *testing.T) {
+	rule := func(name string) []string {
+		return []string{"arn:aws:events:eu-west-1:123456789012:rule/" + name}
+	}
+
+	tests := []struct {
+		name string
+		digestRule string
+		resources []string
+		posted bool
+	}{
+		{"digest rule", "aws-notifier-digest", rule("aws-notifier-digest"), true},
+		{"another rule", "aws-notifier-digest", rule("aws-notifier-retries"), false},
+		{"rule with the digest rule as a prefix", "aws-notifier-digest", rule("aws-notifier-digest-weekly"), false},
+		{"no rule configured", "", rule("aws-notifier-digest"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("digest_rule", test.digestRule)
+
+			fake, db := newFakeDynamoDB(t, "digest_key")
+
+			notifier := &recordingNotifier{}
+			registry := newNotifierRegistry()
+			registry.register("ops", notifier)
+			registry.digests = &DigestStore{db: db, table: "digest"}
+
+			bufferDigest(t, registry.digests, "web-asg", "ops")
+
+			event := lambdaevents.CloudWatchEvent{Source: "aws.events", DetailType: "Scheduled Event", Resources: test.resources}
+			if err := processScheduledEvent(context.Background(), registry, event); err != nil {
+				t.Fatal(err)
+			}
+
+			if posted := notifier.count() == 1; posted != test.posted {
+				t.Errorf("expected the digest to be posted: %v, got %d notifications", test.posted, notifier.count())
+			}
+
+			// Buffered until it's posted
+			if buffered := fake.count() != 0; buffered == test.posted {
+				t.Errorf("expected the notification to stay buffered: %v, got %d entries", !test.posted, fake.count())
+			}
+		})
+	}
+}
+
+// A channel failing to post doesn't hold up the others, and only keeps its own notifications buffered
+func TestFlushDigestsFailingChannel(t *testing.T) {
+	fake, db := newFakeDynamoDB(t, "digest_key")
+
+	ops := &recordingNotifier{failing: true}
+	dev := &recordingNotifier{}
+
+	registry := newNotifierRegistry()
+	registry.register("ops", ops)
+	registry.register("dev", dev)
+	registry.digests = &DigestStore{db: db, table: "digest"}
+
+	bufferDigest(t, registry.digests, "web-asg", "ops", "dev")
+	bufferDigest(t, registry.digests, "web-asg", "ops", "dev")
+	bufferDigest(t, registry.digests, "worker-asg", "dev")
+
+	if err := registry.flushDigests(context.Background()); err == nil {
+		t.Fatal("expected the failing channel to be reported")
+	}
+
+	if dev.count() != 2 {
+		t.Fatalf("expected both digests to be posted to dev, got %d", dev.count())
+	}
+
+	entries, err := registry.digests.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected the 2 notifications for ops to stay buffered, got %d entries", len(entries))
+	}
+
+	for _, entry := range entries {
+		if encoded, _ := json.Marshal(entry.Channels); string(encoded) != `["ops"]` {
+			t.Errorf("expected the entry to be kept for ops only, got %s", encoded)
+		}
+	}
+
+	// Once ops recovers, only it gets the rest of the digest
+	ops.failing = false
+
+	if err := registry.flushDigests(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if ops.count() != 1 || dev.count() != 2 {
+		t.Errorf("expected 1 digest for ops and still 2 for dev, got %d and %d", ops.count(), dev.count())
+	}
+
+	if title := ops.sent[0].Title; title != "Digest - 2 notification(s)" {
+		t.Errorf("expected the digest of the 2 buffered notifications, got %q", title)
+	}
+
+	if fake.count() != 0 {
+		t.Errorf("expected the buffer to be empty, got %d entries", fake.count())
+	}
+}
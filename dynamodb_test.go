@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// An in-memory stand-in for a DynamoDB table, which supports just the calls and condition expressions the stores
+// make, so that their conditional writes can be tested without AWS. Attribute values are kept as they are sent,
+// like {"S": "..."} or {"N": "..."}.
+type fakeDynamoDB struct {
+	hashKey string
+	items map[string]map[string]map[string]string
+	failing bool // Every call fails, like when the table doesn't exist
+	lock sync.Mutex
+}
+
+func newFakeDynamoDB(t *testing.T, hashKey string) (*fakeDynamoDB, *dynamodb.DynamoDB) {
+	fake := &fakeDynamoDB{
+		hashKey: hashKey,
+		items: make(map[string]map[string]map[string]string),
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String("eu-west-1"),
+		Endpoint: aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		MaxRetries: aws.Int(0),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, dynamodb.New(sess)
+}
+
+type fakeDynamoDBRequest struct {
+	Key map[string]map[string]string
+	Item map[string]map[string]string
+	ConditionExpression string
+	UpdateExpression string
+	ExpressionAttributeNames map[string]string
+	ExpressionAttributeValues map[string]map[string]string
+	ReturnValues string
+	RequestItems map[string][]fakeDynamoDBWriteRequest // For BatchWriteItem, by table (only one table is faked)
+}
+
+type fakeDynamoDBWriteRequest struct {
+	PutRequest *struct{ Item map[string]map[string]string }
+	DeleteRequest *struct{ Key map[string]map[string]string }
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.failing {
+		f.fail(w, "ResourceNotFoundException", "Requested resource not found")
+		return
+	}
+
+	var req fakeDynamoDBRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.fail(w, "SerializationException", err.Error())
+		return
+	}
+
+	key := req.Key
+	if key == nil {
+		key = req.Item
+	}
+
+	hash := key[f.hashKey]["S"]
+	item := f.items[hash]
+
+	if req.ConditionExpression != "" && !f.matches(req.ConditionExpression, item, req.ExpressionAttributeValues) {
+		f.fail(w, "ConditionalCheckFailedException", "The conditional request failed")
+		return
+	}
+
+	res := make(map[string]interface{})
+
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.") {
+	case "GetItem":
+		if item != nil {
+			res["Item"] = item
+		}
+	case "PutItem":
+		f.items[hash] = req.Item
+	case "DeleteItem":
+		if req.ReturnValues != "" && item != nil {
+			res["Attributes"] = item
+		}
+
+		delete(f.items, hash)
+	case "Scan":
+		items := []map[string]map[string]string{}
+		for _, item := range f.items {
+			items = append(items, item)
+		}
+
+		res["Items"] = items
+		res["Count"] = len(items)
+	case "BatchWriteItem":
+		for _, requests := range req.RequestItems {
+			for _, request := range requests {
+				if request.PutRequest != nil {
+					f.items[request.PutRequest.Item[f.hashKey]["S"]] = request.PutRequest.Item
+				} else if request.DeleteRequest != nil {
+					delete(f.items, request.DeleteRequest.Key[f.hashKey]["S"])
+				}
+			}
+		}
+
+		res["UnprocessedItems"] = map[string]interface{}{}
+	case "UpdateItem":
+		if item == nil {
+			item = map[string]map[string]string{f.hashKey: {"S": hash}}
+			f.items[hash] = item
+		}
+
+		// Like "ADD a :a SET b = :b, c = if_not_exists(c, :c)", where ADD is for numbers
+		expression := req.UpdateExpression
+		clauses := regexp.MustCompile(`(^| )(ADD|SET) `).FindAllStringSubmatchIndex(expression, -1)
+
+		for i, clause := range clauses {
+			end := len(expression)
+			if i + 1 < len(clauses) {
+				end = clauses[i + 1][0]
+			}
+
+			if expression[clause[4]:clause[5]] == "ADD" {
+				for _, parts := range regexp.MustCompile(`([#\w]+) (:\w+)`).FindAllStringSubmatch(expression[clause[1]:end], -1) {
+					current, _ := strconv.ParseFloat(item[parts[1]]["N"], 64)
+					add, _ := strconv.ParseFloat(req.ExpressionAttributeValues[parts[2]]["N"], 64)
+					item[parts[1]] = map[string]string{"N": strconv.FormatFloat(current + add, 'f', -1, 64)}
+				}
+
+				continue
+			}
+
+			assignments := regexp.MustCompile(`([#\w]+) = (?:if_not_exists\([#\w]+, (:\w+)\)|(:\w+))`)
+
+			for _, parts := range assignments.FindAllStringSubmatch(expression[clause[1]:end], -1) {
+				name := parts[1]
+				if alias, exists := req.ExpressionAttributeNames[name]; exists {
+					name = alias
+				}
+
+				if parts[2] != "" {
+					if item[name] == nil {
+						item[name] = req.ExpressionAttributeValues[parts[2]]
+					}
+
+					continue
+				}
+
+				item[name] = req.ExpressionAttributeValues[parts[3]]
+			}
+		}
+
+		if req.ReturnValues != "" {
+			res["Attributes"] = item
+		}
+	default:
+		f.fail(w, "UnknownOperationException", r.Header.Get("X-Amz-Target"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	json.NewEncoder(w).Encode(res)
+}
+
+// Supports attribute_not_exists(name), "name = :value", and "name < :value" and "name <= :value" (for numbers),
+// joined by OR
+func (f *fakeDynamoDB) matches(condition string, item map[string]map[string]string, values map[string]map[string]string) bool {
+	for _, clause := range strings.Split(condition, " OR ") {
+		if strings.HasPrefix(clause, "attribute_not_exists(") {
+			name := strings.TrimSuffix(strings.TrimPrefix(clause, "attribute_not_exists("), ")")
+			if item == nil || item[name] == nil {
+				return true
+			}
+
+			continue
+		}
+
+		parts := strings.Fields(clause)
+		if item == nil || item[parts[0]] == nil {
+			continue
+		}
+
+		actual, expected := item[parts[0]], values[parts[2]]
+
+		switch parts[1] {
+		case "=":
+			if actual["S"] == expected["S"] && actual["N"] == expected["N"] {
+				return true
+			}
+		case "<":
+			a, _ := strconv.ParseFloat(actual["N"], 64)
+			b, _ := strconv.ParseFloat(expected["N"], 64)
+
+			if a < b {
+				return true
+			}
+		case "<=":
+			a, _ := strconv.ParseFloat(actual["N"], 64)
+			b, _ := strconv.ParseFloat(expected["N"], 64)
+
+			if a <= b {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (f *fakeDynamoDB) fail(w http.ResponseWriter, code string, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(400)
+	json.NewEncoder(w).Encode(map[string]string{
+		"__type": "com.amazonaws.dynamodb.v20120810#" + code,
+		"message": message,
+	})
+}
+
+func (f *fakeDynamoDB) item(hash string) map[string]map[string]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.items[hash]
+}
+
+func (f *fakeDynamoDB) count() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return len(f.items)
+}
+
+func (f *fakeDynamoDB) put(item map[string]map[string]string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.items[item[f.hashKey]["S"]] = item
+}
+
+func (f *fakeDynamoDB) setFailing(failing bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.failing = failing
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Records the notifications it was sent, and fails while failing is set
+type recordingNotifier struct {
+	sent []notify.Notification
+	failing bool
+	err error // Returned while failing, instead of a permanent error
+	lock sync.Mutex
+}
+
+func (n *recordingNotifier) Send(ctx context.Context, notification notify.Notification) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.failing {
+		if n.err != nil {
+			return n.err
+		}
+
+		return errors.New("delivery failed")
+	}
+
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func (n *recordingNotifier) count() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return len(n.sent)
+}
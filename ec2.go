@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/events"
+)
+
+func init() {
+	registerEventHandler(EventHandler {
+		name: "EC2 Event",
+		matches: matchEvent("aws.ec2", "EC2 Instance State-change Notification"),
+		handle: processEC2StateChangeEvent,
+	})
+
+	// We only notify about start/stop for now
+	registerEventHandler(EventHandler {
+		name: "EC2 Event",
+		matches: matchEvent("aws.ec2"),
+		handle: ignoreEvent,
+	})
+}
+
+func processEC2StateChangeEvent(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+	var eventDetail events.DetailEC2StateChange
+
+	err := json.Unmarshal(event.Detail, &eventDetail)
+	if err != nil {
+		return errors.New("unsupported EC2 Cloudwatch Event Detail: " + err.Error())
+	}
+
+	notification := events.EC2StateChangeNotification(event, eventDetail)
+
+	// The instance is described where possible, but the notification is sent either way
+	description, err := notifiers.resources.instance(ctx, event.Region, eventDetail.InstanceId)
+	if err != nil {
+		logger(ctx).Warn("Could not describe EC2 instance", "instance_id", eventDetail.InstanceId, "error", err.Error())
+	} else if description != nil {
+		notification.Fields = append(notification.Fields, description.fields()...)
+	}
+
+	return notifiers.send(ctx, notification)
+}
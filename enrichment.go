@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long descriptions are reused for, across (warm) invocations
+const DescriptionCacheTTL = 5 * time.Minute
+
+// Describes the resources events are about (like the Name tag and type of an EC2 instance), so that
+// notifications can show more than just their IDs. Can be disabled with resource_enrichment set to "false"
+// (in which case it's nil), for accounts where the function isn't allowed to describe resources.
+type ResourceDescriber struct {
+	sess *session.Session
+}
+
+type InstanceDescription struct {
+	Name string
+	InstanceType string
+	AvailabilityZone string
+	AutoScalingGroup string
+}
+
+type AutoScalingGroupDescription struct {
+	DesiredCapacity int64
+	MinSize int64
+	MaxSize int64
+	InService int // Number of instances in the InService lifecycle state
+}
+
+type cachedDescription struct {
+	description interface{}
+	expires time.Time
+}
+
+// Shared between invocations, and keyed by region and resource ID
+var descriptionCache = make(map[string]cachedDescription)
+var descriptionCacheLock sync.Mutex
+
+func newResourceDescriber(sess *session.Session) *ResourceDescriber {
+	if getSetting("resource_enrichment") == "false" {
+		return nil
+	}
+
+	return &ResourceDescriber{sess: sess}
+}
+
+func cachedDescriptionFor(key string) (interface{}, bool) {
+	descriptionCacheLock.Lock()
+	defer descriptionCacheLock.Unlock()
+
+	cached, exists := descriptionCache[key]
+	if !exists || time.Now().After(cached.expires) {
+		delete(descriptionCache, key)
+		return nil, false
+	}
+
+	return cached.description, true
+}
+
+func cacheDescription(key string, description interface{}) {
+	descriptionCacheLock.Lock()
+	defer descriptionCacheLock.Unlock()
+
+	descriptionCache[key] = cachedDescription{description: description, expires: time.Now().Add(DescriptionCacheTTL)}
+}
+
+// Returns nil (without an error) if enrichment is disabled
+func (d *ResourceDescriber) instance(ctx context.Context, region string, instanceID string) (*InstanceDescription, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	key := region + "/" + instanceID
+	if cached, exists := cachedDescriptionFor(key); exists {
+		return cached.(*InstanceDescription), nil
+	}
+
+	svc := ec2.New(d.sess, aws.NewConfig().WithRegion(region))
+	traceAWSClient(svc.Client)
+
+	res, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		return nil, errors.New("failed to describe EC2 instance: " + err.Error())
+	}
+
+	if len(res.Reservations) == 0 || len(res.Reservations[0].Instances) == 0 {
+		return nil, errors.New("EC2 instance not found: " + instanceID)
+	}
+
+	instance := res.Reservations[0].Instances[0]
+	description := &InstanceDescription{
+		InstanceType: aws.StringValue(instance.InstanceType),
+	}
+
+	if instance.Placement != nil {
+		description.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+
+	for _, tag := range instance.Tags {
+		switch aws.StringValue(tag.Key) {
+		case "Name":
+			description.Name = aws.StringValue(tag.Value)
+		case "aws:autoscaling:groupName":
+			description.AutoScalingGroup = aws.StringValue(tag.Value)
+		}
+	}
+
+	cacheDescription(key, description)
+
+	return description, nil
+}
+
+// Fields to add to notifications about the instance
+func (i *InstanceDescription) fields() []notify.Field {
+	var fields []notify.Field
+
+	for _, field := range []notify.Field {
+		{Title: "Name", Value: i.Name, Short: true},
+		{Title: "Instance Type", Value: i.InstanceType, Short: true},
+		{Title: "Availability Zone", Value: i.AvailabilityZone, Short: true},
+		{Title: "Autoscaling Group", Value: i.AutoScalingGroup, Short: true},
+	} {
+		if field.Value != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+// Groups aren't cached, since their capacity is what changes with every scaling activity
+func (d *ResourceDescriber) autoScalingGroup(ctx context.Context, region string, name string) (*AutoScalingGroupDescription, error) {
+	if d == nil || name == "" {
+		return nil, nil
+	}
+
+	svc := autoscaling.New(d.sess, aws.NewConfig().WithRegion(region))
+	traceAWSClient(svc.Client)
+
+	res, err := svc.DescribeAutoScalingGroupsWithContext(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return nil, errors.New("failed to describe Autoscaling Group: " + err.Error())
+	}
+
+	if len(res.AutoScalingGroups) == 0 {
+		return nil, errors.New("Autoscaling Group not found: " + name)
+	}
+
+	group := res.AutoScalingGroups[0]
+	description := &AutoScalingGroupDescription{
+		DesiredCapacity: aws.Int64Value(group.DesiredCapacity),
+		MinSize: aws.Int64Value(group.MinSize),
+		MaxSize: aws.Int64Value(group.MaxSize),
+	}
+
+	for _, instance := range group.Instances {
+		if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+			description.InService++
+		}
+	}
+
+	return description, nil
+}
+
+func (g *AutoScalingGroupDescription) fields() []notify.Field {
+	return []notify.Field {
+		{
+			Title: "Capacity (Desired / Min / Max)",
+			Value: strconv.FormatInt(g.DesiredCapacity, 10) + " / " + strconv.FormatInt(g.MinSize, 10) + " / " +
+				strconv.FormatInt(g.MaxSize, 10),
+			Short: true,
+		},
+		{
+			Title: "In Service",
+			Value: strconv.Itoa(g.InService),
+			Short: true,
+		},
+	}
+}
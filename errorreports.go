@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/getsentry/sentry-go"
+	"time"
+)
+
+// Reports errors in the notifier itself (failed invocations, tolerated delivery failures, panics and payloads it
+// doesn't know what to do with) to Sentry, with the redacted payload attached, so that maintainers find out about
+// unsupported payloads in the wild. Enabled by setting sentry_dsn.
+
+const ErrorReportsFlushTimeout = 2 * time.Second
+
+var errorReportingEnabled bool
+
+// Sentry is only initialised once, since the DSN can't be changed without a redeploy anyway
+func configureErrorReporting() error {
+	dsn, exists := lookupSetting("sentry_dsn")
+	if !exists || errorReportingEnabled {
+		return nil
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn: dsn,
+		Release: version,
+		Environment: getSetting("sentry_environment"),
+	})
+
+	if err != nil {
+		return errors.New("failed to configure Sentry: " + err.Error())
+	}
+
+	errorReportingEnabled = true
+	return nil
+}
+
+// Attaches the payload being processed to everything reported for the invocation
+func withErrorReporting(ctx context.Context, redactor *Redactor, raw json.RawMessage) context.Context {
+	if !errorReportingEnabled {
+		return ctx
+	}
+
+	payload := redactor.redactPayload(raw)
+	if len(payload) > MaxLoggedPayloadLength {
+		payload = payload[:MaxLoggedPayloadLength] + "..."
+	}
+
+	hub := sentry.CurrentHub().Clone()
+	hub.Scope().SetTag("source", payloadSource(raw))
+	hub.Scope().SetContext("payload", sentry.Context{
+		"event": describePayload(raw),
+		"body": payload,
+	})
+
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		hub.Scope().SetTag("request_id", lc.AwsRequestID)
+	}
+
+	return sentry.SetHubOnContext(ctx, hub)
+}
+
+// Failures collected in a MultiError (like for separate SNS records, or channels) are reported separately, so
+// that they're grouped by what actually failed. Tags are given as key-value pairs.
+func reportError(ctx context.Context, err error, tags ...string) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil || err == nil {
+		return
+	}
+
+	if multi, ok := err.(*MultiError); ok {
+		for _, failure := range multi.errors {
+			reportError(ctx, failure, tags...)
+		}
+
+		return
+	}
+
+	hub.WithScope(func(scope *sentry.Scope) {
+		for i := 0; i + 1 < len(tags); i += 2 {
+			scope.SetTag(tags[i], tags[i + 1])
+		}
+
+		if failure, ok := err.(*FailureError); ok {
+			scope.SetTag("failed", failure.What)
+		}
+
+		hub.CaptureException(err)
+	})
+}
+
+// Reports something worth knowing about which isn't an error as such, like a payload without a handler. These are
+// grouped by the message, rather than by where they were reported from.
+func reportMessage(ctx context.Context, message string) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		return
+	}
+
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelWarning)
+		scope.SetFingerprint([]string{message})
+		hub.CaptureMessage(message)
+	})
+}
+
+func reportPanic(ctx context.Context, recovered interface{}) {
+	if hub := sentry.GetHubFromContext(ctx); hub != nil {
+		hub.Recover(recovered)
+	}
+}
+
+// Events are sent in the background, so they have to be flushed before the invocation ends (and Lambda freezes
+// the container)
+func flushErrorReports() {
+	if errorReportingEnabled {
+		sentry.Flush(ErrorReportsFlushTimeout)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collects the errors of independent pieces of work (like SNS records, or deliveries to different
+// channels), so that one failing doesn't stop the rest from being processed. Safe for concurrent use.
+type MultiError struct {
+	lock sync.Mutex
+	errors []error
+}
+
+// Records the error (if there is one), prefixed with what failed, like "SNS record 1234"
+func (m *MultiError) add(what string, err error) {
+	if err == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.errors = append(m.errors, &FailureError{What: what, Err: err})
+}
+
+// Returns nil if nothing failed, so that it can be returned as is
+func (m *MultiError) errorOrNil() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.errors) == 0 {
+		return nil
+	}
+
+	return m
+}
+
+func (m *MultiError) Error() string {
+	if len(m.errors) == 1 {
+		return m.errors[0].Error()
+	}
+
+	messages := make([]string, len(m.errors))
+	for i, err := range m.errors {
+		messages[i] = err.Error()
+	}
+
+	return strconv.Itoa(len(m.errors)) + " failures: " + strings.Join(messages, "; ")
+}
+
+// A single failure within a MultiError
+type FailureError struct {
+	What string
+	Err error
+}
+
+func (e *FailureError) Error() string {
+	return e.What + ": " + e.Err.Error()
+}
+
+// A delivery which failed, but was saved for retrying later (see FailedStore). It doesn't fail the invocation, but the
+// channel doesn't count as delivered to either.
+type SavedError struct {
+	Err error
+}
+
+func (e *SavedError) Error() string {
+	return "saved for retrying later: " + e.Err.Error()
+}
+
+// Everything wrong with the configuration (settings, routing config and templates), so that it can all be
+// fixed in one go, rather than one deployment per problem
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) add(problem string) {
+	e.Problems = append(e.Problems, problem)
+}
+
+// Takes on the problems of another ConfigError - any other (non-nil) error is returned as is, since it isn't
+// about the configuration itself (like failing to fetch it)
+func (e *ConfigError) merge(err error) error {
+	if config, ok := err.(*ConfigError); ok {
+		e.Problems = append(e.Problems, config.Problems...)
+		return nil
+	}
+
+	return err
+}
+
+func (e *ConfigError) errorOrNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+func (e *ConfigError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid configuration: " + e.Problems[0]
+	}
+
+	return "invalid configuration (" + strconv.Itoa(len(e.Problems)) + " problems): " + strings.Join(e.Problems, "; ")
+}
+
+// A panic recovered while processing an event (see withRecovery)
+type PanicError struct {
+	What string // Description of the payload being processed
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return "panic while processing " + e.What + ": " + fmt.Sprint(e.Value)
+}
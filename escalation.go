@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CallbackAcknowledge = "acknowledge" // Only sent for messages posted before CallbackIncident was introduced
+
+// Escalation rules are evaluated in order, and the first one matching a notification which starts a thread
+// (like an alarm going off) applies. If the notification isn't acknowledged (or resolved) within the given
+// time, it's sent again to the escalation channels (or the ones it was sent to originally), with a raised
+// severity.
+type EscalationRule struct {
+	Match route.Match `json:"match"`
+	After string `json:"after"` // Like "15m" or "1h"
+	Channels []string `json:"channels"`
+	Severity string `json:"severity"` // Defaults to critical
+	after time.Duration
+}
+
+// Notifications which may need escalating are tracked in a DynamoDB table (with a string hash key called
+// "escalation_key"), keyed by their thread key, until they are acknowledged, resolved or escalated
+type EscalationStore struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+// Returns nil if escalation_table isn't set
+func newEscalationStoreFromSettings(sess *session.Session) *EscalationStore {
+	table, exists := lookupSetting("escalation_table")
+	if !exists {
+		return nil
+	}
+
+	return &EscalationStore{
+		db: dynamodb.New(sess),
+		table: table,
+	}
+}
+
+type TrackedNotification struct {
+	Key string
+	Notification notify.Notification
+	Channels []string // Where to send the escalation
+	Severity string
+	After time.Duration
+	EscalateAt time.Time
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Escalations",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.escalations == nil {
+				return nil
+			}
+
+			return notifiers.escalate(ctx, time.Now())
+		},
+	})
+}
+
+func (c *Config) compileEscalations() error {
+	for i := range c.Escalations {
+		rule := &c.Escalations[i]
+
+		after, err := time.ParseDuration(rule.After)
+		if err != nil || after <= 0 {
+			return errors.New("invalid escalation delay: " + rule.After)
+		}
+
+		rule.after = after
+
+		if rule.Severity == "" {
+			rule.Severity = notify.SeverityCritical
+		} else if !notify.ValidSeverity(rule.Severity) {
+			return errors.New("invalid severity in escalation rule: " + rule.Severity)
+		}
+	}
+
+	return nil
+}
+
+// Returns the first escalation rule matching the notification, or nil if there isn't one
+func (c *Config) escalation(notification notify.Notification) *EscalationRule {
+	for i := range c.Escalations {
+		if c.Escalations[i].Match.Matches(notification) {
+			return &c.Escalations[i]
+		}
+	}
+
+	return nil
+}
+
+// Starts tracking the notification (sent to the given channels) if an escalation rule applies to it, and
+// stops tracking it once it's resolved. Returns the notification with an "Acknowledge" button if it's tracked.
+func (r *NotifierRegistry) trackEscalation(ctx context.Context, notification notify.Notification, names []string) notify.Notification {
+	if r.escalations == nil || r.config == nil || notification.ThreadKey == "" {
+		return notification
+	}
+
+	switch notification.ThreadAction {
+	case notify.ThreadResolve:
+		if err := r.escalations.remove(notification.ThreadKey); err != nil {
+			logger(ctx).Warn(err.Error())
+		}
+	case notify.ThreadStart:
+		rule := r.config.escalation(notification)
+		if rule == nil {
+			return notification
+		}
+
+		channels := rule.Channels
+		if len(channels) == 0 {
+			channels = names
+		}
+
+		err := r.escalations.track(TrackedNotification {
+			Key: notification.ThreadKey,
+			Notification: notification,
+			Channels: channels,
+			Severity: rule.Severity,
+			After: rule.after,
+			EscalateAt: time.Now().Add(rule.after),
+		})
+
+		if err != nil {
+			logger(ctx).Warn(err.Error())
+			return notification
+		}
+
+		notification.Actions = append(append([]notify.Action{}, notification.Actions...), notify.Action {
+			CallbackId: CallbackIncident,
+			Name: ActionAcknowledge,
+			Text: "Acknowledge",
+			Value: notification.ThreadKey,
+			Style: "primary",
+		})
+	}
+
+	return notification
+}
+
+// Sends the escalations which are due
+func (r *NotifierRegistry) escalate(ctx context.Context, now time.Time) error {
+	tracked, err := r.escalations.list()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(tracked, func(i, j int) bool { return tracked[i].EscalateAt.Before(tracked[j].EscalateAt) })
+
+	var failures MultiError
+
+	for _, entry := range tracked {
+		if now.Before(entry.EscalateAt) {
+			continue
+		}
+
+		notification := entry.Notification
+		notification.Title = "ESCALATED: " + notification.Title
+		notification.Severity = entry.Severity
+		notification.ThreadAction = ""
+		notification.Actions = nil
+		notification.Fields = append([]notify.Field {
+			{
+				Title: "Escalation",
+				Value: "Not acknowledged within " + entry.After.String(),
+				Short: false,
+			},
+		}, notification.Fields...)
+
+		logger(ctx).Info("Escalating notification", "thread_key", entry.Key, "channels", entry.Channels)
+
+		// Escalations go straight to their channels, regardless of routing (or quiet hours)
+		for _, name := range entry.Channels {
+			notifier, exists := r.notifiers[name]
+			if !exists {
+				logger(ctx).Warn("Dropping escalation for unknown channel", "channel", name)
+				continue
+			}
+
+			localized := notification
+			if r.config != nil {
+				localized = r.config.localize(name, notification)
+			}
+
+			failures.add("escalation via " + name, r.sendVia(ctx, name, notifier, localized))
+		}
+
+		// Escalated once only, even if some channels failed (the failures are reported)
+		if err := r.escalations.remove(entry.Key); err != nil {
+			failures.add("escalation of " + entry.Key, err)
+		}
+	}
+
+	return failures.errorOrNil()
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (s *EscalationStore) track(entry TrackedNotification) error {
+	encoded, err := json.Marshal(entry.Notification)
+	if err != nil {
+		return errors.New("failed to marshal tracked notification: " + err.Error())
+	}
+
+	_, err = s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"escalation_key": {S: aws.String(entry.Key)},
+			"notification": {S: aws.String(string(encoded))},
+			"channels": {S: aws.String(strings.Join(entry.Channels, ","))},
+			"severity": {S: aws.String(entry.Severity)},
+			"after": {N: aws.String(strconv.FormatInt(int64(entry.After / time.Second), 10))},
+			"escalate_at": {N: aws.String(strconv.FormatInt(entry.EscalateAt.Unix(), 10))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to track notification for escalation in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+func (s *EscalationStore) list() ([]TrackedNotification, error) {
+	var tracked []TrackedNotification
+	var decodeErr error
+
+	err := s.db.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(s.table),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			entry := TrackedNotification {
+				Key: aws.StringValue(item["escalation_key"].S),
+			}
+
+			if item["channels"] != nil {
+				entry.Channels = strings.Split(aws.StringValue(item["channels"].S), ",")
+			}
+
+			if item["severity"] != nil {
+				entry.Severity = aws.StringValue(item["severity"].S)
+			}
+
+			if item["after"] != nil {
+				seconds, _ := strconv.ParseInt(aws.StringValue(item["after"].N), 10, 64)
+				entry.After = time.Duration(seconds) * time.Second
+			}
+
+			if item["escalate_at"] != nil {
+				at, _ := strconv.ParseInt(aws.StringValue(item["escalate_at"].N), 10, 64)
+				entry.EscalateAt = time.Unix(at, 0)
+			}
+
+			if item["notification"] != nil {
+				if err := json.Unmarshal([]byte(aws.StringValue(item["notification"].S)), &entry.Notification); err != nil {
+					decodeErr = errors.New("failed to unmarshal tracked notification: " + err.Error())
+					return false
+				}
+			}
+
+			tracked = append(tracked, entry)
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read escalations from DynamoDB: " + err.Error())
+	}
+
+	return tracked, decodeErr
+}
+
+// Stops tracking the notification (if it was tracked)
+func (s *EscalationStore) remove(key string) error {
+	_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"escalation_key": {S: aws.String(key)},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to remove escalation from DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
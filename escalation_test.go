@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+	"testing"
+	"time"
+)
+
+func newEscalationRegistry(t *testing.T) (*NotifierRegistry, *fakeDynamoDB, *recordingNotifier, *recordingNotifier) {
+	tracked, db := newFakeDynamoDB(t, "escalation_key")
+
+	config := &Config{
+		Escalations: []EscalationRule {
+			{Match: route.Match{Source: "aws.cloudwatch"}, After: "15m", Channels: []string{"oncall"}},
+			{Match: route.Match{Source: "aws.ec2"}, After: "1h", Severity: notify.SeverityError},
+		},
+	}
+
+	if err := config.compileEscalations(); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := &recordingNotifier{}
+	oncall := &recordingNotifier{}
+
+	registry := newNotifierRegistry()
+	registry.register("ops", ops)
+	registry.register("oncall", oncall)
+	registry.config = config
+	registry.escalations = &EscalationStore{db: db, table: "escalations"}
+
+	return registry, tracked, ops, oncall
+}
+
+func TestTrackEscalation(t *testing.T) {
+	tests := []struct {
+		name string
+		notification notify.Notification
+		tracked bool
+		channels string
+		severity string
+	}{
+		{
+			"escalation channels",
+			notify.Notification{Source: "aws.cloudwatch", ThreadKey: "cpu-high", ThreadAction: notify.ThreadStart},
+			true, "oncall", notify.SeverityCritical,
+		},
+		{
+			"original channels",
+			notify.Notification{Source: "aws.ec2", ThreadKey: "i-123", ThreadAction: notify.ThreadStart},
+			true, "ops", notify.SeverityError,
+		},
+		{
+			"no matching rule",
+			notify.Notification{Source: "aws.guardduty", ThreadKey: "finding", ThreadAction: notify.ThreadStart},
+			false, "", "",
+		},
+		{
+			"not starting a thread",
+			notify.Notification{Source: "aws.cloudwatch", ThreadKey: "cpu-high", ThreadAction: notify.ThreadReply},
+			false, "", "",
+		},
+		{
+			"without a thread key",
+			notify.Notification{Source: "aws.cloudwatch", ThreadAction: notify.ThreadStart},
+			false, "", "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry, tracked, _, _ := newEscalationRegistry(t)
+
+			notification := registry.trackEscalation(context.Background(), test.notification, []string{"ops"})
+
+			if !test.tracked {
+				if tracked.count() != 0 {
+					t.Errorf("expected nothing to be tracked, got %d entries", tracked.count())
+				}
+
+				if len(notification.Actions) != 0 {
+					t.Errorf("expected no actions on an untracked notification, got: %v", notification.Actions)
+				}
+
+				return
+			}
+
+			if tracked.count() != 1 {
+				t.Fatalf("expected the notification to be tracked, got %d entries", tracked.count())
+			}
+
+			item := tracked.item(test.notification.ThreadKey)
+			if item["channels"]["S"] != test.channels || item["severity"]["S"] != test.severity {
+				t.Errorf("expected channels %q and severity %q, got: %v", test.channels, test.severity, item)
+			}
+
+			if len(notification.Actions) != 1 || notification.Actions[0].Name != ActionAcknowledge ||
+				notification.Actions[0].Value != test.notification.ThreadKey {
+				t.Errorf("expected an Acknowledge button, got: %v", notification.Actions)
+			}
+		})
+	}
+}
+
+// Resolving the incident stops it from being escalated
+func TestTrackEscalationResolved(t *testing.T) {
+	registry, tracked, _, _ := newEscalationRegistry(t)
+	ctx := context.Background()
+
+	notification := notify.Notification{Source: "aws.cloudwatch", ThreadKey: "cpu-high", ThreadAction: notify.ThreadStart}
+	registry.trackEscalation(ctx, notification, []string{"ops"})
+
+	notification.ThreadAction = notify.ThreadResolve
+	registry.trackEscalation(ctx, notification, []string{"ops"})
+
+	if tracked.count() != 0 {
+		t.Errorf("expected the escalation to be removed, got %d entries", tracked.count())
+	}
+}
+
+func TestEscalate(t *testing.T) {
+	registry, tracked, ops, oncall := newEscalationRegistry(t)
+	ctx := context.Background()
+
+	registry.trackEscalation(ctx, notify.Notification {
+		Source: "aws.cloudwatch",
+		Title: "ALARM: \"cpu-high\"",
+		Severity: notify.SeverityWarn,
+		ThreadKey: "cpu-high",
+		ThreadAction: notify.ThreadStart,
+	}, []string{"ops"})
+
+	// Not due yet
+	if err := registry.escalate(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if oncall.count() != 0 || tracked.count() != 1 {
+		t.Fatalf("expected nothing to be escalated yet, got %d sent and %d tracked", oncall.count(), tracked.count())
+	}
+
+	if err := registry.escalate(ctx, time.Now().Add(16 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if oncall.count() != 1 || ops.count() != 0 {
+		t.Fatalf("expected the escalation to go to the escalation channel only, got %d to oncall and %d to ops",
+			oncall.count(), ops.count())
+	}
+
+	escalated := oncall.sent[0]
+	if escalated.Title != "ESCALATED: ALARM: \"cpu-high\"" || escalated.Severity != notify.SeverityCritical {
+		t.Errorf("expected a critical escalation, got %q with severity %q", escalated.Title, escalated.Severity)
+	}
+
+	if escalated.ThreadAction != "" || len(escalated.Actions) != 0 {
+		t.Errorf("expected the escalation to neither thread nor have buttons, got %q and %v", escalated.ThreadAction,
+			escalated.Actions)
+	}
+
+	if len(escalated.Fields) == 0 || escalated.Fields[0].Value != "Not acknowledged within 15m0s" {
+		t.Errorf("expected an Escalation field, got: %v", escalated.Fields)
+	}
+
+	// Escalated once only
+	if tracked.count() != 0 {
+		t.Errorf("expected the escalation to be removed, got %d entries", tracked.count())
+	}
+}
+
+// Escalations are removed even if delivering them failed, so that they aren't sent over and over
+func TestEscalateFailingChannel(t *testing.T) {
+	registry, tracked, _, oncall := newEscalationRegistry(t)
+	ctx := context.Background()
+
+	registry.trackEscalation(ctx, notify.Notification {
+		Source: "aws.cloudwatch",
+		ThreadKey: "cpu-high",
+		ThreadAction: notify.ThreadStart,
+	}, []string{"ops"})
+
+	oncall.failing = true
+
+	if err := registry.escalate(ctx, time.Now().Add(time.Hour)); err == nil {
+		t.Error("expected the failed escalation to be reported")
+	}
+
+	if tracked.count() != 0 {
+		t.Errorf("expected the escalation to be removed, got %d entries", tracked.count())
+	}
+}
+
+func TestCompileEscalations(t *testing.T) {
+	tests := []struct {
+		name string
+		rule EscalationRule
+		fails bool
+	}{
+		{"valid", EscalationRule{After: "30m"}, false},
+		{"invalid delay", EscalationRule{After: "soon"}, true},
+		{"no delay", EscalationRule{After: "0s"}, true},
+		{"invalid severity", EscalationRule{After: "30m", Severity: "urgent"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{Escalations: []EscalationRule{test.rule}}
+
+			if err := config.compileEscalations(); (err != nil) != test.fails {
+				t.Errorf("expected failure: %v, got: %v", test.fails, err)
+			}
+		})
+	}
+}
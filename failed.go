@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"sort"
+	"strconv"
+)
+
+const DefaultFailedMaxAttempts = 5
+
+// Notifications which couldn't be delivered (even after retrying) are saved in a DynamoDB table (with a
+// string hash key called "queue_key"), and retried by the "Failed Notifications" scheduled task, so that
+// they aren't lost while Slack or Pagerduty are down. They are given up on after maxAttempts retries.
+type FailedNotifications struct {
+	NotificationQueue
+	maxAttempts int
+}
+
+// Returns nil if failed_notifications_table isn't set
+func newFailedNotificationsFromSettings(sess *session.Session) (*FailedNotifications, error) {
+	table, exists := lookupSetting("failed_notifications_table")
+	if !exists {
+		return nil, nil
+	}
+
+	failed := &FailedNotifications{
+		NotificationQueue: NotificationQueue{
+			db: dynamodb.New(sess),
+			table: table,
+		},
+		maxAttempts: DefaultFailedMaxAttempts,
+	}
+
+	if maxAttempts, exists := lookupSetting("failed_notifications_max_attempts"); exists {
+		var err error
+		if failed.maxAttempts, err = strconv.Atoi(maxAttempts); err != nil {
+			return nil, errors.New("could not parse failed_notifications_max_attempts: " + err.Error())
+		}
+	}
+
+	return failed, nil
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Failed Notifications",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.failed == nil {
+				return nil
+			}
+
+			return notifiers.retryFailed(ctx)
+		},
+	})
+}
+
+// Retries (in the order they failed) the failed notifications for destinations which don't have an
+// open circuit breaker. Notifications failing again are left for the next run.
+func (r *NotifierRegistry) retryFailed(ctx context.Context) error {
+	failed, err := r.failed.list()
+	if err != nil {
+		return err
+	}
+
+	// Keys end with the time they were saved at
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Key < failed[j].Key })
+
+	for _, entry := range failed {
+		if r.breaker != nil {
+			if open, _, err := r.breaker.state(entry.Channel); err == nil && open {
+				continue
+			}
+		}
+
+		notifier, exists := r.notifiers[entry.Channel]
+		if !exists {
+			logger(ctx).Warn("Dropping failed notification for unknown channel", "channel", entry.Channel)
+		} else if err := r.sendVia(ctx, entry.Channel, notifier, entry.Notification); err != nil {
+			if entry.Attempts + 1 < r.failed.maxAttempts {
+				logger(ctx).Warn("Failed to resend notification, will try again later", "channel", entry.Channel,
+					"attempts", entry.Attempts + 1, "error", err.Error())
+
+				if err := r.failed.recordAttempt(entry.Key); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			logger(ctx).Error("Giving up on failed notification", "channel", entry.Channel, "attempts", r.failed.maxAttempts,
+				"title", entry.Notification.Title)
+		}
+
+		if err := r.failed.remove(entry.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
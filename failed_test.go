@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"testing"
+)
+
+// Deliveries saved for retrying later don't fail the invocation, but don't count as delivered either
+func TestSendSavedForRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		failedTable bool
+		bestEffort bool
+		failsInvocation bool
+		failedChannels string // As recorded in the audit log
+		savedChannels string
+	}{
+		{"saved for retry", true, false, false, `null`, `["ops"]`},
+		{"best effort channel saved for retry", true, true, false, `null`, `["ops"]`},
+		{"nowhere to save it", false, false, true, `["ops"]`, `null`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := &recordingNotifier{failing: true}
+			dev := &recordingNotifier{}
+
+			registry := newNotifierRegistry()
+			registry.register("ops", ops)
+			registry.register("dev", dev)
+
+			if test.bestEffort {
+				registry.config = &Config{Delivery: DeliveryConfig{BestEffort: []string{"ops"}}}
+			}
+
+			dedupe, dedupeDB := newFakeDynamoDB(t, "dedupe_key")
+			registry.dedupe = &DedupeStore{db: dedupeDB, table: "dedupe", window: DefaultDedupeWindow}
+
+			audit, auditDB := newFakeDynamoDB(t, "audit_key")
+			registry.audit = &AuditStore{db: auditDB, table: "audit"}
+
+			failed, failedDB := newFakeDynamoDB(t, "queue_key")
+			if test.failedTable {
+				registry.failed = &FailedNotifications {
+					NotificationQueue: NotificationQueue{db: failedDB, table: "failed"},
+					maxAttempts: DefaultFailedMaxAttempts,
+				}
+			}
+
+			notification := notify.Notification {
+				Source: "aws.cloudwatch",
+				Title: "ALARM: \"cpu-high\"",
+				AlarmName: "cpu-high",
+				Severity: notify.SeverityError,
+			}
+
+			err := registry.send(context.Background(), notification)
+			if failsInvocation := err != nil; failsInvocation != test.failsInvocation {
+				t.Errorf("expected the invocation to fail: %v, got: %v", test.failsInvocation, err)
+			}
+
+			if dev.count() != 1 {
+				t.Errorf("expected the other channel to be delivered to, got %d notifications", dev.count())
+			}
+
+			if test.failedTable && failed.count() != 1 {
+				t.Errorf("expected the notification to be saved, got %d saved", failed.count())
+			}
+
+			// Not delivered everywhere, so a redelivery of the event isn't dropped as a duplicate
+			if dedupe.count() != 0 {
+				t.Errorf("expected the dedupe entry to be forgotten, got %d entries", dedupe.count())
+			}
+
+			if audit.count() != 1 {
+				t.Fatalf("expected 1 audit record, got %d", audit.count())
+			}
+
+			for _, record := range audit.items {
+				if actual := record["failed_channels"]["S"]; actual != test.failedChannels {
+					t.Errorf("expected failed channels %s, got %s", test.failedChannels, actual)
+				}
+
+				if actual := record["saved_channels"]["S"]; actual != test.savedChannels {
+					t.Errorf("expected saved channels %s, got %s", test.savedChannels, actual)
+				}
+			}
+		})
+	}
+}
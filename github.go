@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strings"
+)
+
+/**
+Example GitHub workflow_run payload (POST to /webhooks/github, with an X-GitHub-Event header of workflow_run):
+
+{
+  "action": "completed",
+  "workflow_run": {
+    "id": 30433642,
+    "name": "Deploy",
+    "head_branch": "main",
+    "head_sha": "acb5820ced9479c074f688cc328bf03f341a511d",
+    "run_number": 562,
+    "run_attempt": 1,
+    "event": "push",
+    "status": "completed",
+    "conclusion": "failure",
+    "html_url": "https://github.com/example/payments-api/actions/runs/30433642",
+    "actor": {"login": "alice"},
+    "head_commit": {"message": "Add refunds endpoint"}
+  },
+  "repository": {"full_name": "example/payments-api", "html_url": "https://github.com/example/payments-api"},
+  "sender": {"login": "alice"}
+}
+
+Example GitHub deployment_status payload (with an X-GitHub-Event header of deployment_status):
+
+{
+  "action": "created",
+  "deployment_status": {
+    "state": "failure",
+    "description": "Health checks failed",
+    "environment": "production",
+    "target_url": "https://github.com/example/payments-api/actions/runs/30433642",
+    "creator": {"login": "alice"}
+  },
+  "deployment": {
+    "id": 145988746,
+    "sha": "acb5820ced9479c074f688cc328bf03f341a511d",
+    "ref": "main",
+    "environment": "production"
+  },
+  "repository": {"full_name": "example/payments-api", "html_url": "https://github.com/example/payments-api"},
+  "sender": {"login": "alice"}
+}
+
+The same payloads can be relayed via SNS, with the event name in an X-GitHub-Event message attribute (or in
+the Subject). Without either, the event is worked out from the payload.
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Event processor
+
+func init() {
+	registerWebhookHandler(WebhookHandler {
+		name: "github",
+		authenticate: authenticateGitHubWebhook,
+		handle: func(ctx context.Context, notifiers *NotifierRegistry, req HTTPRequest) error {
+			return processGitHubEvent(ctx, notifiers, req.Headers["x-github-event"], []byte(req.Body))
+		},
+	})
+}
+
+// GitHub can't send a token, but signs requests with the secret configured for the webhook
+// See: https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+func authenticateGitHubWebhook(ctx context.Context, req HTTPRequest) *HTTPResponse {
+	secret := getSetting("github_webhook_secret")
+	if secret == "" {
+		logger(ctx).Warn("Rejecting webhook, as github_webhook_secret is not configured")
+		return textResponse(403, "Forbidden")
+	}
+
+	if err := verifyGitHubSignature(secret, req); err != nil {
+		logger(ctx).Warn("Rejecting webhook with invalid signature", "error", err.Error())
+		return textResponse(401, "Unauthorized")
+	}
+
+	return nil
+}
+
+func verifyGitHubSignature(secret string, req HTTPRequest) error {
+	signature := req.Headers["x-hub-signature-256"]
+	if !strings.HasPrefix(signature, "sha256=") {
+		return errors.New("missing or invalid signature")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(req.Body))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+// Returns the name of the GitHub event relayed in an SNS message, or an empty string if it isn't one
+func gitHubEventFromSNS(message events.SNSMessage) string {
+	if name := events.SNSAttribute(message, "X-GitHub-Event"); name != "" {
+		return name
+	}
+
+	if message.Subject == "workflow_run" || message.Subject == "deployment_status" {
+		return message.Subject
+	}
+
+	var event events.GitHubEvent
+	if err := json.Unmarshal([]byte(message.Message), &event); err != nil || event.Repository.FullName == "" {
+		return ""
+	}
+
+	if event.WorkflowRun != nil {
+		return "workflow_run"
+	} else if event.DeploymentStatus != nil {
+		return "deployment_status"
+	}
+
+	return ""
+}
+
+// Only completed workflow runs and finished deployments are notified about - everything else is ignored
+func processGitHubEvent(ctx context.Context, notifiers *NotifierRegistry, name string, raw []byte) error {
+	ctx = withLogAttrs(ctx, "github_event", name)
+
+	if name == "ping" {
+		logger(ctx).Info("Received GitHub ping")
+		return nil
+	}
+
+	var event events.GitHubEvent
+
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return &InvalidPayloadError{Err: err}
+	}
+
+	var notification *notify.Notification
+
+	switch {
+	case name == "workflow_run" && event.WorkflowRun != nil:
+		notification = events.WorkflowRunNotification(event)
+	case name == "deployment_status" && event.DeploymentStatus != nil:
+		notification = events.DeploymentStatusNotification(event)
+	default:
+		logger(ctx).Debug("Ignoring GitHub event")
+		return nil
+	}
+
+	if notification == nil {
+		logger(ctx).Debug("Ignoring GitHub event", "action", event.Action)
+		return nil
+	}
+
+	notification.Event = events.TemplateData(event)
+
+	return notifiers.send(ctx, *notification)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/events"
+)
+
+/**
+Example Grafana (unified alerting) webhook payload (POST to /webhooks/grafana):
+
+{
+  "receiver": "aws-notifier",
+  "status": "firing",
+  "orgId": 1,
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "High CPU", "grafana_folder": "Payments", "instance": "payments-api-1"},
+      "annotations": {"summary": "CPU usage above 90%"},
+      "startsAt": "2019-01-01T00:00:00Z",
+      "endsAt": "0001-01-01T00:00:00Z",
+      "generatorURL": "https://grafana.example.com/alerting/grafana/cdeqmlhvflz40f/view",
+      "fingerprint": "57c6d9296de2ad39",
+      "silenceURL": "https://grafana.example.com/alerting/silence/new?...",
+      "dashboardURL": "https://grafana.example.com/d/abcdef",
+      "panelURL": "https://grafana.example.com/d/abcdef?viewPanel=2",
+      "imageURL": "https://grafana.example.com/public/img/attachments/abc.png",
+      "valueString": "[ var='B' labels={instance=payments-api-1} value=93.4 ]"
+    }
+  ],
+  "groupLabels": {"alertname": "High CPU"},
+  "commonLabels": {"alertname": "High CPU"},
+  "commonAnnotations": {},
+  "externalURL": "https://grafana.example.com/",
+  "version": "1",
+  "groupKey": "{}:{alertname=\"High CPU\"}",
+  "title": "[FIRING:1] High CPU (Payments)",
+  "state": "alerting",
+  "message": "..."
+}
+
+Example legacy Grafana alerting webhook payload:
+
+{
+  "dashboardId": 1,
+  "evalMatches": [
+    {"value": 93.4, "metric": "payments-api-1", "tags": {"instance": "payments-api-1"}}
+  ],
+  "imageUrl": "https://grafana.example.com/public/img/attachments/abc.png",
+  "message": "CPU usage above 90%",
+  "orgId": 1,
+  "panelId": 2,
+  "ruleId": 1,
+  "ruleName": "High CPU",
+  "ruleUrl": "https://grafana.example.com/d/abcdef?tab=alert&viewPanel=2&orgId=1",
+  "state": "alerting",
+  "tags": {"team": "payments"},
+  "title": "[Alerting] High CPU"
+}
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Event processor
+
+func init() {
+	registerWebhookHandler(WebhookHandler {
+		name: "grafana",
+		handle: processGrafanaWebhook,
+	})
+}
+
+func processGrafanaWebhook(ctx context.Context, notifiers *NotifierRegistry, req HTTPRequest) error {
+	var payload events.GrafanaWebhook
+
+	if err := json.Unmarshal([]byte(req.Body), &payload); err != nil {
+		return &InvalidPayloadError{Err: err}
+	}
+
+	if len(payload.Alerts) == 0 {
+		if payload.RuleName == "" {
+			return &InvalidPayloadError{Err: errors.New("no alerts in payload")}
+		}
+
+		return notifiers.send(ctx, events.GrafanaLegacyNotification(payload))
+	}
+
+	var failures MultiError
+
+	for _, alert := range payload.Alerts {
+		failures.add("alert " + alert.Labels["alertname"], notifiers.send(ctx, events.GrafanaNotification(payload, alert)))
+	}
+
+	return failures.errorOrNil()
+}
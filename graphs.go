@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/motns/aws-notifier/pkg/events"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Presigned URLs can't be valid for longer than this
+const MetricGraphURLExpiry = 7 * 24 * time.Hour
+
+// Graphs show at least this much of the metric's history
+const MinMetricGraphRange = 3 * time.Hour
+
+// Statistics as they appear in alarm payloads, and the way metric widgets expect them
+var widgetStatistics = map[string]string{
+	"AVERAGE": "Average",
+	"SUM": "Sum",
+	"MINIMUM": "Minimum",
+	"MAXIMUM": "Maximum",
+	"SAMPLE_COUNT": "SampleCount",
+}
+
+// Renders graphs of the metric which triggered an alarm, and uploads them to S3, so that they can be shown
+// in notifications via a presigned URL
+type MetricGraphs struct {
+	sess *session.Session
+	s3 *s3.S3
+	bucket string
+	prefix string
+}
+
+// Returns nil if metric_graph_bucket isn't set
+func newMetricGraphsFromSettings(sess *session.Session) *MetricGraphs {
+	bucket, exists := lookupSetting("metric_graph_bucket")
+	if !exists {
+		return nil
+	}
+
+	return &MetricGraphs{
+		sess: sess,
+		s3: s3.New(sess),
+		bucket: bucket,
+		prefix: getSetting("metric_graph_prefix"),
+	}
+}
+
+// Returns a presigned URL to a graph of the alarm's metric, with its threshold, or an empty string (without
+// an error) if graphs are disabled, or the alarm isn't for a single metric
+func (g *MetricGraphs) render(ctx context.Context, region string, alarm events.CloudwatchAlarm) (string, error) {
+	if g == nil || alarm.Trigger.MetricName == "" {
+		return "", nil
+	}
+
+	widget, err := metricWidget(alarm)
+	if err != nil {
+		return "", err
+	}
+
+	return g.renderWidget(ctx, region, widget, alarm.AlarmArn)
+}
+
+// Renders a metric widget (as JSON), returning a presigned URL to the image. The name only has to tell apart
+// images uploaded in the same second.
+func (g *MetricGraphs) renderWidget(ctx context.Context, region string, widget string, name string) (string, error) {
+	svc := cloudwatch.New(g.sess, aws.NewConfig().WithRegion(region))
+	traceAWSClient(svc.Client)
+
+	res, err := svc.GetMetricWidgetImageWithContext(ctx, &cloudwatch.GetMetricWidgetImageInput{
+		MetricWidget: aws.String(widget),
+		OutputFormat: aws.String("png"),
+	})
+	if err != nil {
+		return "", errors.New("failed to render metric graph: " + err.Error())
+	}
+
+	hash := sha1.Sum([]byte(name))
+	key := g.prefix + "graphs/" + time.Now().UTC().Format("2006/01/02/150405") + "-" +
+		hex.EncodeToString(hash[:])[:12] + ".png"
+
+	_, err = g.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(g.bucket),
+		Key: aws.String(key),
+		Body: bytes.NewReader(res.MetricWidgetImage),
+		ContentType: aws.String("image/png"),
+	})
+	if err != nil {
+		return "", errors.New("failed to upload metric graph to S3: " + err.Error())
+	}
+
+	req, _ := g.s3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(g.bucket),
+		Key: aws.String(key),
+	})
+
+	link, err := req.Presign(MetricGraphURLExpiry)
+	if err != nil {
+		return "", errors.New("failed to presign metric graph URL: " + err.Error())
+	}
+
+	logger(ctx).Debug("Uploaded metric graph", "bucket", g.bucket, "key", key)
+
+	return link, nil
+}
+
+// See: https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/CloudWatch-Metric-Widget-Structure.html
+func metricWidget(alarm events.CloudwatchAlarm) (string, error) {
+	trigger := alarm.Trigger
+
+	metric := []interface{}{trigger.Namespace, trigger.MetricName}
+	for _, d := range trigger.Dimensions {
+		metric = append(metric, d.Name, d.Value)
+	}
+
+	options := map[string]interface{}{}
+	if stat, exists := widgetStatistics[strings.ToUpper(trigger.Statistic)]; exists {
+		options["stat"] = stat
+	}
+	metric = append(metric, options)
+
+	period := trigger.Period
+	if period <= 0 {
+		period = 300
+	}
+
+	// Enough history to see the trend leading up to the alarm
+	history := time.Duration(period * trigger.EvaluationPeriods * 20) * time.Second
+	if history < MinMetricGraphRange {
+		history = MinMetricGraphRange
+	}
+
+	widget := map[string]interface{}{
+		"metrics": [][]interface{}{metric},
+		"period": period,
+		"start": "-PT" + strconv.Itoa(int(history.Minutes())) + "M",
+		"end": "PT0H",
+		"title": alarm.AlarmName,
+		"width": 600,
+		"height": 300,
+		"annotations": map[string]interface{}{
+			"horizontal": []map[string]interface{}{
+				{"label": "Threshold", "value": trigger.Threshold},
+			},
+		},
+	}
+
+	encoded, err := json.Marshal(widget)
+	if err != nil {
+		return "", errors.New("failed to marshal metric widget: " + err.Error())
+	}
+
+	return string(encoded), nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/events"
+)
+
+/**
+Example GuardDuty finding (Cloudwatch Event detail, some fields left out):
+
+{
+  "schemaVersion": "2.0",
+  "accountId": "123456789012",
+  "region": "eu-west-1",
+  "id": "16afba5c5c43e07c9e3e5e2e544e95df",
+  "arn": "arn:aws:guardduty:eu-west-1:123456789012:detector/123456789012345678901234567890/finding/16afba5c5c43e07c9e3e5e2e544e95df",
+  "type": "UnauthorizedAccess:EC2/SSHBruteForce",
+  "resource": {
+    "resourceType": "Instance",
+    "instanceDetails": {
+      "instanceId": "i-99999999"
+    }
+  },
+  "severity": 8,
+  "title": "198.51.100.0 is performing SSH brute force attacks against i-99999999.",
+  "description": "198.51.100.0 is performing SSH brute force attacks against i-99999999. Brute force attacks are used to gain unauthorized access to your instance by guessing the SSH password."
+}
+*/
+
+func init() {
+	registerEventHandler(EventHandler {
+		name: "GuardDuty Finding",
+		matches: matchEvent("aws.guardduty", "GuardDuty Finding"),
+		handle: processGuardDutyFinding,
+	})
+}
+
+func processGuardDutyFinding(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+	var finding events.DetailGuardDutyFinding
+
+	err := json.Unmarshal(event.Detail, &finding)
+	if err != nil {
+		return errors.New("unsupported GuardDuty Cloudwatch Event Detail: " + err.Error())
+	}
+
+	return notifiers.send(ctx, events.GuardDutyFindingNotification(event, finding))
+}
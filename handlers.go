@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+)
+
+// Handlers register themselves (from an init function in their own file) in one of two registries:
+//  - payload handlers are picked based on the shape of the raw payload (eg. SNS records)
+//  - event handlers are picked based on the source and detail-type of Cloudwatch Events
+// The first matching handler wins, so more specific handlers need to be registered first.
+
+type PayloadHandler struct {
+	name string
+	matches func(payload GenericEvent) bool
+	handle func(ctx context.Context, notifiers *NotifierRegistry, raw json.RawMessage) error
+	respond func(ctx context.Context, notifiers *NotifierRegistry, raw json.RawMessage) (interface{}, error) // Used instead of handle, for payloads expecting a response (eg. SQS batches)
+}
+
+type EventHandler struct {
+	name string
+	matches func(event lambdaevents.CloudWatchEvent) bool
+	handle func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error
+}
+
+var payloadHandlers []PayloadHandler
+var eventHandlers []EventHandler
+
+func registerPayloadHandler(handler PayloadHandler) {
+	payloadHandlers = append(payloadHandlers, handler)
+}
+
+func registerEventHandler(handler EventHandler) {
+	eventHandlers = append(eventHandlers, handler)
+}
+
+func findPayloadHandler(payload GenericEvent) *PayloadHandler {
+	for i := range payloadHandlers {
+		if payloadHandlers[i].matches(payload) {
+			return &payloadHandlers[i]
+		}
+	}
+
+	return nil
+}
+
+func findEventHandler(event lambdaevents.CloudWatchEvent) *EventHandler {
+	for i := range eventHandlers {
+		if eventHandlers[i].matches(event) {
+			return &eventHandlers[i]
+		}
+	}
+
+	return nil
+}
+
+// Matches Cloudwatch Events from the given source, and with one of the given detail-types
+// (or any detail-type, if none are given)
+func matchEvent(source string, detailTypes ...string) func(event lambdaevents.CloudWatchEvent) bool {
+	return func(event lambdaevents.CloudWatchEvent) bool {
+		return event.Source == source && (len(detailTypes) == 0 || contains(detailTypes, event.DetailType))
+	}
+}
+
+// For events we deliberately don't notify about
+func ignoreEvent(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+	logger(ctx).Info("Ignoring event")
+	return nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// List of supported event sources available here: https://docs.aws.amazon.com/lambda/latest/dg/invoking-lambda-function.html
+// List of example event payloads available here: https://docs.aws.amazon.com/lambda/latest/dg/eventsources.html
+
+// Struct with generic type, which we'll use only for detecting what sort of
+// Event is being passed to our Lambda
+type GenericEvent struct {
+	Records []map[string]interface{} `json:"Records,omitempty"`
+	Id string `json:"id,omitempty"`
+	DetailType string `json:"detail-type,omitempty"`
+	Source string `json:"source,omitempty"`
+	Test string `json:"test"`
+}
+
+// Returns the response to pass back to Lambda, for payloads which expect one
+func processMessage(ctx context.Context, notifiers *NotifierRegistry, raw json.RawMessage) (interface{}, error) {
+	var data GenericEvent
+
+	err := json.Unmarshal(raw, &data)
+	if err != nil {
+		return nil, errors.New("unsupported payload: " + err.Error())
+	}
+
+	handler := findPayloadHandler(data)
+	if handler == nil {
+		logger(ctx).Info("No handler for payload - ignoring")
+		reportMessage(ctx, "No handler for payload")
+		return nil, nil
+	}
+
+	invocationEvent(ctx).set("handler", handler.name)
+
+	var response interface{}
+
+	err = traceSegment(ctx, handler.name, func(ctx context.Context) error {
+		if handler.respond != nil {
+			response, err = handler.respond(ctx, notifiers, raw)
+			return err
+		}
+
+		return handler.handle(ctx, notifiers, raw)
+	})
+
+	return response, err
+}
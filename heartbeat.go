@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/http"
+)
+
+// Pings the heartbeat_url (like a healthchecks.io check, or a Cronitor heartbeat monitor) once every scheduled task
+// has run successfully, so that the monitor alerts if the notifier stops receiving or processing scheduled events.
+// A failed ping is only logged, since retrying the scheduled event would run the tasks again.
+func pingHeartbeat(ctx context.Context) {
+	heartbeatURL := getSetting("heartbeat_url")
+	if heartbeatURL == "" || dryRun {
+		return
+	}
+
+	err := notify.RetryDelivery(ctx, func() error {
+		req, err := http.NewRequest("GET", heartbeatURL, nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := notify.ClientOrShared(nil).Do(req.WithContext(ctx))
+		if err != nil {
+			return &notify.HTTPError{Service: "Heartbeat", Err: err}
+		}
+		defer res.Body.Close()
+
+		return notify.CheckHTTPResponse("Heartbeat", res)
+	})
+
+	if err != nil {
+		logger(ctx).Error("Failed to ping heartbeat URL", "error", err.Error())
+		metrics(ctx).count("HeartbeatsFailed", "", "")
+		return
+	}
+
+	logger(ctx).Info("Pinged heartbeat URL")
+}
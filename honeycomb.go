@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultHoneycombAPIURL = "https://api.honeycomb.io"
+const DefaultHoneycombDataset = "aws-notifier"
+
+// Exporting has to be quick, since it holds up the end of the invocation
+const HoneycombTimeout = 2 * time.Second
+
+// A wide event describing an invocation (what was processed, where it went, how long it took and what failed),
+// which is sent to Honeycomb at the end of it, so that slow or failing event types can be sliced and diced on any
+// field. Collected like metrics, via the context. Safe for concurrent use.
+//
+// Example:
+//
+// {
+//   "request_id": "6f1c3d5e-...",
+//   "version": "1.4.0",
+//   "payload_source": "aws:sns",
+//   "payload_bytes": 1832,
+//   "handler": "SNS Notification",
+//   "sources": "aws.cloudwatch",
+//   "detail_types": "Alarm",
+//   "notifications": 1,
+//   "channels": "pagerduty,slack",
+//   "deliveries": 2,
+//   "delivery_ms.slack": 182,
+//   "delivery_ms.pagerduty": 341,
+//   "outcome": "processed",
+//   "duration_ms": 604
+// }
+type InvocationEvent struct {
+	lock sync.Mutex
+	start time.Time
+	fields map[string]interface{}
+	lists map[string]map[string]bool // Fields holding a set of values, like the channels sent to
+}
+
+func newInvocationEvent(start time.Time) *InvocationEvent {
+	return &InvocationEvent{
+		start: start,
+		fields: make(map[string]interface{}),
+		lists: make(map[string]map[string]bool),
+	}
+}
+
+type invocationEventKey struct{}
+
+func withInvocationEvent(ctx context.Context, e *InvocationEvent) context.Context {
+	return context.WithValue(ctx, invocationEventKey{}, e)
+}
+
+// Returns the event for the invocation, or nil if exporting is disabled (which is fine to call methods on)
+func invocationEvent(ctx context.Context) *InvocationEvent {
+	e, _ := ctx.Value(invocationEventKey{}).(*InvocationEvent)
+	return e
+}
+
+func (e *InvocationEvent) set(name string, value interface{}) {
+	if e == nil {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.fields[name] = value
+}
+
+func (e *InvocationEvent) add(name string, value float64) {
+	if e == nil {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	current, _ := e.fields[name].(float64)
+	e.fields[name] = current + value
+}
+
+// Adds the value to a set, which is sent as a sorted, comma-separated list
+func (e *InvocationEvent) include(name string, value string) {
+	if e == nil || value == "" {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.lists[name] == nil {
+		e.lists[name] = make(map[string]bool)
+	}
+
+	e.lists[name][value] = true
+}
+
+func (e *InvocationEvent) document() map[string]interface{} {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	document := make(map[string]interface{}, len(e.fields) + len(e.lists))
+	for name, value := range e.fields {
+		document[name] = value
+	}
+
+	for name, set := range e.lists {
+		var values []string
+		for value := range set {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+
+		document[name] = strings.Join(values, ",")
+	}
+
+	return document
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Returns nil if honeycomb_api_key isn't set
+func newInvocationEventFromSettings(start time.Time) *InvocationEvent {
+	if _, exists := lookupSetting("honeycomb_api_key"); !exists {
+		return nil
+	}
+
+	return newInvocationEvent(start)
+}
+
+// Failing to export is only logged, since it's no reason to fail (and retry) the invocation
+func exportInvocationEvent(ctx context.Context, e *InvocationEvent) {
+	if e == nil || dryRun {
+		return
+	}
+
+	apiURL := DefaultHoneycombAPIURL
+	if value, exists := lookupSetting("honeycomb_api_url"); exists {
+		apiURL = strings.TrimSuffix(value, "/")
+	}
+
+	dataset := DefaultHoneycombDataset
+	if value, exists := lookupSetting("honeycomb_dataset"); exists {
+		dataset = value
+	}
+
+	if err := sendHoneycombEvent(ctx, apiURL, dataset, getSetting("honeycomb_api_key"), e); err != nil {
+		logger(ctx).Warn("Failed to export invocation event to Honeycomb", "error", err.Error())
+	}
+}
+
+func sendHoneycombEvent(ctx context.Context, apiURL string, dataset string, apiKey string, e *InvocationEvent) error {
+	body, err := json.Marshal(e.document())
+	if err != nil {
+		return errors.New("failed to marshal invocation event: " + err.Error())
+	}
+
+	req, err := http.NewRequest("POST", apiURL + "/1/events/" + url.PathEscape(dataset), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Honeycomb-Team", apiKey)
+	req.Header.Set("X-Honeycomb-Event-Time", e.start.UTC().Format(time.RFC3339Nano))
+
+	ctx, cancel := context.WithTimeout(ctx, HoneycombTimeout)
+	defer cancel()
+
+	res, err := notify.ClientOrShared(nil).Do(req.WithContext(ctx))
+	if err != nil {
+		return &notify.HTTPError{Service: "Honeycomb", Err: err}
+	}
+	defer res.Body.Close()
+
+	return notify.CheckHTTPResponse("Honeycomb", res)
+}
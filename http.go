@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"log/slog"
+	"strings"
+)
+
+/**
+Example Lambda Function URL request (API Gateway HTTP API payload format 2.0):
+
+{
+  "version": "2.0",
+  "rawPath": "/slack/interactivity",
+  "rawQueryString": "",
+  "headers": {
+    "content-type": "application/x-www-form-urlencoded",
+    "x-slack-request-timestamp": "1531420618",
+    "x-slack-signature": "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+  },
+  "requestContext": {
+    "http": {
+      "method": "POST",
+      "path": "/slack/interactivity"
+    }
+  },
+  "body": "payload=%7B%22type%22%3A%22interactive_message%22...",
+  "isBase64Encoded": false
+}
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Types for HTTP requests coming in via a Lambda Function URL or API Gateway
+
+type HTTPRequest struct {
+	RawPath string `json:"rawPath"` // Function URL and HTTP API (payload format 2.0)
+	Path string `json:"path"` // REST API (payload format 1.0)
+	RawQueryString string `json:"rawQueryString"` // Payload format 2.0
+	QueryStringParameters map[string]string `json:"queryStringParameters"` // Payload format 1.0
+	Headers map[string]string `json:"headers"`
+	Body string `json:"body"`
+	IsBase64Encoded bool `json:"isBase64Encoded"`
+	RequestContext json.RawMessage `json:"requestContext"`
+}
+
+type HTTPResponse struct {
+	StatusCode int `json:"statusCode"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body string `json:"body"`
+}
+
+func isHTTPRequest(raw json.RawMessage) bool {
+	var req HTTPRequest
+
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return false
+	}
+
+	return req.RequestContext != nil
+}
+
+func textResponse(statusCode int, body string) *HTTPResponse {
+	return &HTTPResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body: body,
+	}
+}
+
+func jsonResponse(statusCode int, body interface{}) *HTTPResponse {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		slog.Error("Failed to marshal HTTP response", "error", err.Error())
+		return textResponse(500, "Internal Server Error")
+	}
+
+	return &HTTPResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body: string(payload),
+	}
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Errors are reported via the HTTP status code, rather than failing the invocation
+func processHTTPRequest(ctx context.Context, notifiers *NotifierRegistry, slackNotifier *notify.SlackNotifier, sess *session.Session,
+	raw json.RawMessage) *HTTPResponse {
+	var req HTTPRequest
+
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return textResponse(400, "Bad Request")
+	}
+
+	// Header names are lowercase for Function URLs, but not necessarily for API Gateway
+	headers := make(map[string]string)
+	for k, v := range req.Headers {
+		headers[strings.ToLower(k)] = v
+	}
+	req.Headers = headers
+
+	if req.IsBase64Encoded {
+		body, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return textResponse(400, "Bad Request")
+		}
+
+		req.Body = string(body)
+		req.IsBase64Encoded = false
+	}
+
+	path := req.RawPath
+	if path == "" {
+		path = req.Path
+	}
+
+	logger(ctx).Info("Processing HTTP request", "path", path)
+
+	switch {
+	case path == "/slack/interactivity":
+		return processSlackInteraction(ctx, notifiers, slackNotifier, sess, req)
+	case strings.HasPrefix(path, "/webhooks/"):
+		return processWebhook(ctx, notifiers, strings.TrimPrefix(path, "/webhooks/"), req)
+	default:
+		return textResponse(404, "Not Found")
+	}
+}
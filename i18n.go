@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"log/slog"
+	"strings"
+)
+
+// Translations of the titles and field labels of notifications into a language (a Catalog), keyed by the original
+// (English) text, like "EC2 Instance State-change" or "instance-id". Anything without a translation is left as is.
+
+// Returns the locale notifications to the channel are rendered in (or an empty string for English)
+func (c *Config) localeFor(channel string) string {
+	if config, exists := c.Channels[channel]; exists && config.Locale != "" {
+		return config.Locale
+	}
+
+	return c.Locale
+}
+
+// Falls back from a regional locale (like "de-AT") to the language ("de")
+func (c *Config) catalog(locale string) (notify.Catalog, bool) {
+	if catalog, exists := c.Translations[locale]; exists {
+		return catalog, true
+	}
+
+	if i := strings.IndexAny(locale, "-_"); i != -1 {
+		catalog, exists := c.Translations[locale[:i]]
+		return catalog, exists
+	}
+
+	return nil, false
+}
+
+// Translates the notification for the channel it's about to be sent to
+func (c *Config) localize(channel string, notification notify.Notification) notify.Notification {
+	locale := c.localeFor(channel)
+	if locale == "" {
+		return notification
+	}
+
+	catalog, exists := c.catalog(locale)
+	if !exists {
+		return notification
+	}
+
+	notification.Catalog = catalog
+	notification.Title = catalog.Translate(notification.Title)
+	notification.Summary = catalog.Translate(notification.Summary)
+
+	// Don't modify the slice shared with the other channels
+	fields := make([]notify.Field, len(notification.Fields))
+	for i, field := range notification.Fields {
+		field.Title = catalog.Translate(field.Title)
+		fields[i] = field
+	}
+	notification.Fields = fields
+
+	actions := make([]notify.Action, len(notification.Actions))
+	for i, action := range notification.Actions {
+		action.Text = catalog.Translate(action.Text)
+		actions[i] = action
+	}
+	notification.Actions = actions
+
+	return notification
+}
+
+func (c *Config) validateLocales() {
+	locales := map[string]bool{c.Locale: true}
+	for _, channel := range c.Channels {
+		locales[channel.Locale] = true
+	}
+
+	for locale := range locales {
+		if _, exists := c.catalog(locale); locale != "" && !exists {
+			slog.Warn("No translations for locale", "locale", locale)
+		}
+	}
+}
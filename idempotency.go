@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// How long a claim on an event holds while it's being processed, which is the longest a Lambda invocation can run.
+// If the invocation dies without releasing it, the event can be processed again after this.
+const IdempotencyLease = 15 * time.Minute
+
+// Records the IDs of processed events (SNS MessageIds and Cloudwatch Event ids) in a DynamoDB table (with a string
+// hash key called "idempotency_key"), so that SNS redeliveries and Lambda retries of events which were already
+// processed are skipped. Entries are kept for the retention period, given TTL is enabled on the "expires_at" attribute.
+type IdempotencyStore struct {
+	db *dynamodb.DynamoDB
+	table string
+	retention time.Duration
+}
+
+// Returns nil if idempotency_table isn't set
+func newIdempotencyStoreFromSettings(sess *session.Session) (*IdempotencyStore, error) {
+	table, exists := lookupSetting("idempotency_table")
+	if !exists {
+		return nil, nil
+	}
+
+	idempotency := &IdempotencyStore{
+		db: dynamodb.New(sess),
+		table: table,
+		retention: DefaultIdempotencyRetention,
+	}
+
+	if retention, exists := lookupSetting("idempotency_retention"); exists {
+		var err error
+		if idempotency.retention, err = time.ParseDuration(retention); err != nil {
+			return nil, errors.New("could not parse idempotency_retention: " + err.Error())
+		}
+	}
+
+	return idempotency, nil
+}
+
+// Returns false if the event was processed already (or is being processed by another invocation). Uses a
+// conditional write, so that concurrent deliveries of the same event can't both claim it.
+func (s *IdempotencyStore) claim(key string, now time.Time) (bool, error) {
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"idempotency_key": {S: aws.String(key)},
+			"status": {S: aws.String("processing")},
+			"expires_at": {N: aws.String(strconv.FormatInt(now.Add(IdempotencyLease).Unix(), 10))},
+		},
+		// DynamoDB only deletes expired items eventually, so we can't rely on them being gone
+		ConditionExpression: aws.String("attribute_not_exists(idempotency_key) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	} else if err != nil {
+		return false, errors.New("failed to claim event in DynamoDB: " + err.Error())
+	}
+
+	return true, nil
+}
+
+func (s *IdempotencyStore) complete(key string, now time.Time) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"idempotency_key": {S: aws.String(key)},
+		},
+		UpdateExpression: aws.String("SET #status = :done, expires_at = :expires_at"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":done": {S: aws.String("done")},
+			":expires_at": {N: aws.String(strconv.FormatInt(now.Add(s.retention).Unix(), 10))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to mark event as processed in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+// Gives up the claim, so that a retry can process the event
+func (s *IdempotencyStore) release(key string) error {
+	_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"idempotency_key": {S: aws.String(key)},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to release event in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Processes the event with the given key (like "sns/<MessageId>") only if it hasn't been processed already. If
+// processing fails, the claim is released, so that the retry goes through. If we can't tell whether the event was
+// processed, it's processed anyway - we'd rather send a notification twice than not at all.
+func (r *NotifierRegistry) once(ctx context.Context, key string, source string, process func() error) error {
+	if r.idempotency == nil || key == "" {
+		return process()
+	}
+
+	claimed, err := r.idempotency.claim(key, time.Now())
+	if err != nil {
+		logger(ctx).Warn(err.Error())
+		return process()
+	}
+
+	if !claimed {
+		logger(ctx).Info("Skipping event which was already processed", "outcome", "duplicate", "idempotency_key", key)
+		metrics(ctx).count("EventsDuplicate", "Source", source)
+		return nil
+	}
+
+	if err := process(); err != nil {
+		if releaseErr := r.idempotency.release(key); releaseErr != nil {
+			logger(ctx).Warn(releaseErr.Error())
+		}
+
+		return err
+	}
+
+	if err := r.idempotency.complete(key, time.Now()); err != nil {
+		logger(ctx).Warn(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStoreClaim(t *testing.T) {
+	now := time.Now()
+
+	existing := func(status string, expiresAt time.Time) map[string]map[string]string {
+		return map[string]map[string]string{
+			"idempotency_key": {"S": "sns/1"},
+			"status": {"S": status},
+			"expires_at": {"N": strconv.FormatInt(expiresAt.Unix(), 10)},
+		}
+	}
+
+	tests := []struct {
+		name string
+		existing map[string]map[string]string
+		claimed bool
+	}{
+		{"new event", nil, true},
+		{"being processed", existing("processing", now.Add(IdempotencyLease)), false},
+		{"already processed", existing("done", now.Add(DefaultIdempotencyRetention)), false},
+		{"lease of a dead invocation ran out", existing("processing", now.Add(-time.Second)), true},
+		{"processed before the retention", existing("done", now.Add(-time.Hour)), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, db := newFakeDynamoDB(t, "idempotency_key")
+			store := &IdempotencyStore{db: db, table: "idempotency", retention: DefaultIdempotencyRetention}
+
+			if test.existing != nil {
+				fake.put(test.existing)
+			}
+
+			claimed, err := store.claim("sns/1", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claimed != test.claimed {
+				t.Errorf("expected claimed: %v, got: %v", test.claimed, claimed)
+			}
+
+			if test.claimed && fake.item("sns/1")["status"]["S"] != "processing" {
+				t.Errorf("expected the claim to be recorded, got %v", fake.item("sns/1"))
+			}
+		})
+	}
+}
+
+func TestOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		failFirst bool // Whether processing fails the first time
+		storeFailing bool // Whether DynamoDB can't be reached
+		processed int
+		status string // Of the event afterwards
+	}{
+		{"redelivery skipped", false, false, 1, "done"},
+		{"retry after failed processing goes through", true, false, 2, "done"},
+		{"processed anyway if it can't be claimed", false, true, 3, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, db := newFakeDynamoDB(t, "idempotency_key")
+			fake.setFailing(test.storeFailing)
+
+			registry := newNotifierRegistry()
+			registry.idempotency = &IdempotencyStore{db: db, table: "idempotency", retention: DefaultIdempotencyRetention}
+
+			processed := 0
+			fail := test.failFirst
+
+			process := func() error {
+				processed++
+
+				if fail {
+					fail = false
+					return errors.New("processing failed")
+				}
+
+				return nil
+			}
+
+			err := registry.once(context.Background(), "sns/1", "aws:sns", process)
+			if test.failFirst && err == nil {
+				t.Fatal("expected the first attempt to fail")
+			}
+
+			for i := 0; i < 2; i++ {
+				if err := registry.once(context.Background(), "sns/1", "aws:sns", process); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if processed != test.processed {
+				t.Errorf("expected the event to be processed %d times, got %d", test.processed, processed)
+			}
+
+			fake.setFailing(false)
+
+			if status := fake.item("sns/1")["status"]["S"]; status != test.status {
+				t.Errorf("expected the event to be %q, got %q", test.status, status)
+			}
+		})
+	}
+}
+
+// Events without a key (and invocations without an idempotency table) are always processed
+func TestOnceWithoutKey(t *testing.T) {
+	_, db := newFakeDynamoDB(t, "idempotency_key")
+
+	tests := []struct {
+		name string
+		store *IdempotencyStore
+		key string
+	}{
+		{"no key", &IdempotencyStore{db: db, table: "idempotency", retention: DefaultIdempotencyRetention}, ""},
+		{"no table", nil, "sns/1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newNotifierRegistry()
+			registry.idempotency = test.store
+
+			processed := 0
+			for i := 0; i < 2; i++ {
+				registry.once(context.Background(), test.key, "aws:sns", func() error {
+					processed++
+					return nil
+				})
+			}
+
+			if processed != 2 {
+				t.Errorf("expected the event to be processed twice, got %d", processed)
+			}
+		})
+	}
+}
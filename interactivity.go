@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CallbackCompleteLifecycleAction = "complete_lifecycle_action"
+const CallbackIncident = "incident" // Buttons for dealing with incidents, told apart by their name
+
+const ActionAcknowledge = "acknowledge"
+const ActionSilence = "silence"
+const ActionRepage = "repage"
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Types for reading Slack interaction payloads
+
+type SlackInteraction struct {
+	Type string `json:"type"`
+	CallbackId string `json:"callback_id"`
+	Actions []notify.SlackAction `json:"actions"`
+	User SlackUser `json:"user"`
+	OriginalMessage notify.SlackMessage `json:"original_message"`
+}
+
+type SlackUser struct {
+	Id string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Everything we need for completing a lifecycle action, passed around as the button value
+type LifecycleActionRef struct {
+	AutoScalingGroupName string `json:"asg"`
+	LifecycleHookName string `json:"hook"`
+	LifecycleActionToken string `json:"token"`
+	EC2InstanceId string `json:"instance"`
+}
+
+// Passed around as the value of silence buttons
+type SilenceRef struct {
+	Key string `json:"key"`
+	For string `json:"for"` // Like "4h"
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func lifecycleActionButton(detail events.DetailAutoScalingLifecycleEvent) (notify.Action, error) {
+	ref := LifecycleActionRef {
+		AutoScalingGroupName: detail.AutoScalingGroupName,
+		LifecycleHookName: detail.LifecycleHookName,
+		LifecycleActionToken: detail.LifecycleActionToken,
+		EC2InstanceId: detail.EC2InstanceId,
+	}
+
+	value, err := json.Marshal(ref)
+	if err != nil {
+		return notify.Action{}, errors.New("failed to marshal lifecycle action reference: " + err.Error())
+	}
+
+	return notify.Action {
+		CallbackId: CallbackCompleteLifecycleAction,
+		Name: "complete",
+		Text: "Complete Lifecycle Action",
+		Value: string(value),
+		Style: "danger",
+	}, nil
+}
+
+// Notifications which start a thread get buttons for silencing them (if there's a suppression table), and for
+// paging someone again (if there's Pagerduty), on top of any added for escalation
+func (r *NotifierRegistry) addIncidentActions(notification notify.Notification) notify.Notification {
+	if notification.ThreadKey == "" || notification.ThreadAction != notify.ThreadStart {
+		return notification
+	}
+
+	actions := append([]notify.Action{}, notification.Actions...)
+
+	if r.suppressions != nil {
+		for _, duration := range SilenceDurations {
+			hours := strconv.Itoa(int(duration.Hours())) + "h"
+
+			value, err := json.Marshal(SilenceRef{Key: notification.ThreadKey, For: hours})
+			if err != nil {
+				continue
+			}
+
+			actions = append(actions, notify.Action {
+				CallbackId: CallbackIncident,
+				Name: ActionSilence,
+				Text: "Silence " + hours,
+				Value: string(value),
+			})
+		}
+	}
+
+	if r.get("pagerduty") != nil {
+		actions = append(actions, notify.Action {
+			CallbackId: CallbackIncident,
+			Name: ActionRepage,
+			Text: "Re-page",
+			Value: notification.ThreadKey,
+			Style: "danger",
+		})
+	}
+
+	notification.Actions = actions
+
+	return notification
+}
+
+// See: https://api.slack.com/authentication/verifying-requests-from-slack
+func verifySlackSignature(signingSecret string, req HTTPRequest) error {
+	ts, err := strconv.ParseInt(req.Headers["x-slack-request-timestamp"], 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid request timestamp")
+	}
+
+	// Protect against replay attacks
+	if age := time.Now().Unix() - ts; age > 300 || age < -300 {
+		return errors.New("request timestamp is too far off")
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("v0:" + strconv.FormatInt(ts, 10) + ":" + req.Body))
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(req.Headers["x-slack-signature"])) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+func processSlackInteraction(ctx context.Context, notifiers *NotifierRegistry, slackNotifier *notify.SlackNotifier,
+	sess *session.Session, req HTTPRequest) *HTTPResponse {
+	if slackNotifier.SigningSecret == "" {
+		logger(ctx).Warn("Rejecting Slack interaction, as slack_signing_secret is not configured")
+		return textResponse(403, "Forbidden")
+	}
+
+	if err := verifySlackSignature(slackNotifier.SigningSecret, req); err != nil {
+		logger(ctx).Warn("Rejecting Slack interaction", "error", err.Error())
+		return textResponse(401, "Unauthorized")
+	}
+
+	form, err := url.ParseQuery(req.Body)
+	if err != nil {
+		return textResponse(400, "Bad Request")
+	}
+
+	// Slash commands are form encoded, while interactions are a JSON payload in a form field
+	if form.Get("command") != "" {
+		return processSlackCommand(ctx, notifiers, form)
+	}
+
+	var interaction SlackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &interaction); err != nil {
+		logger(ctx).Warn("Unsupported Slack interaction payload", "error", err.Error())
+		return textResponse(400, "Bad Request")
+	}
+
+	if interaction.CallbackId == CallbackCompleteLifecycleAction && len(interaction.Actions) != 0 {
+		var ref LifecycleActionRef
+
+		if err := json.Unmarshal([]byte(interaction.Actions[0].Value), &ref); err != nil {
+			logger(ctx).Warn("Invalid lifecycle action reference", "error", err.Error())
+			return textResponse(400, "Bad Request")
+		}
+
+		msg := interaction.OriginalMessage
+		msg.ReplaceOriginal = true
+
+		var outcome string
+		svc := autoscaling.New(sess)
+		traceAWSClient(svc.Client)
+
+		if err := completeLifecycleAction(ctx, svc, ref); err != nil {
+			logger(ctx).Error(err.Error())
+			outcome = "Failed to complete for <@" + interaction.User.Id + ">: " + err.Error()
+		} else {
+			outcome = "Completed by <@" + interaction.User.Id + ">"
+		}
+
+		// Remove the button, and record who clicked it
+		for i := range msg.Attachments {
+			msg.Attachments[i].Actions = nil
+		}
+
+		if len(msg.Attachments) != 0 {
+			msg.Attachments[0].Fields = append(msg.Attachments[0].Fields, notify.SlackField {
+				Title: "Lifecycle Action",
+				Value: outcome,
+				Short: false,
+			})
+		}
+
+		return jsonResponse(200, msg)
+	}
+
+	if interaction.CallbackId == CallbackAcknowledge && len(interaction.Actions) != 0 {
+		return acknowledgeNotification(ctx, notifiers, interaction)
+	}
+
+	if interaction.CallbackId == CallbackIncident && len(interaction.Actions) != 0 {
+		switch interaction.Actions[0].Name {
+		case ActionAcknowledge:
+			return acknowledgeNotification(ctx, notifiers, interaction)
+		case ActionSilence:
+			return silenceNotification(ctx, notifiers, interaction)
+		case ActionRepage:
+			return repageNotification(ctx, notifiers, interaction)
+		}
+	}
+
+	logger(ctx).Info("Ignoring unsupported Slack interaction", "callback_id", interaction.CallbackId)
+
+	return textResponse(200, "")
+}
+
+func completeLifecycleAction(ctx context.Context, svc *autoscaling.AutoScaling, ref LifecycleActionRef) error {
+	logger(ctx).Info("Completing lifecycle action", "instance_id", ref.EC2InstanceId, "autoscaling_group", ref.AutoScalingGroupName)
+
+	_, err := svc.CompleteLifecycleActionWithContext(ctx, &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName: aws.String(ref.AutoScalingGroupName),
+		LifecycleHookName: aws.String(ref.LifecycleHookName),
+		LifecycleActionToken: aws.String(ref.LifecycleActionToken),
+		InstanceId: aws.String(ref.EC2InstanceId),
+		LifecycleActionResult: aws.String("CONTINUE"),
+	})
+
+	if err != nil {
+		return errors.New("failed to complete lifecycle action: " + err.Error())
+	}
+
+	logger(ctx).Info("Lifecycle action completed", "instance_id", ref.EC2InstanceId)
+
+	return nil
+}
+
+// Stops the notification from being escalated, and records who acknowledged it
+func acknowledgeNotification(ctx context.Context, notifiers *NotifierRegistry, interaction SlackInteraction) *HTTPResponse {
+	outcome := "Acknowledged by <@" + interaction.User.Id + ">"
+
+	if err := acknowledgeIncident(ctx, notifiers, interaction.Actions[0].Value, interaction.User.Name); err != nil {
+		logger(ctx).Error(err.Error())
+		outcome = "Failed to acknowledge for <@" + interaction.User.Id + ">: " + err.Error()
+	}
+
+	return jsonResponse(200, withOutcome(interaction.OriginalMessage, ActionAcknowledge, "Acknowledgement", outcome))
+}
+
+func silenceNotification(ctx context.Context, notifiers *NotifierRegistry, interaction SlackInteraction) *HTTPResponse {
+	var ref SilenceRef
+
+	if err := json.Unmarshal([]byte(interaction.Actions[0].Value), &ref); err != nil {
+		logger(ctx).Warn("Invalid silence reference", "error", err.Error())
+		return textResponse(400, "Bad Request")
+	}
+
+	duration, err := time.ParseDuration(ref.For)
+	if err != nil {
+		logger(ctx).Warn("Invalid silence duration", "error", err.Error())
+		return textResponse(400, "Bad Request")
+	}
+
+	outcome := "Silenced for " + ref.For + " by <@" + interaction.User.Id + ">"
+
+	if err := silenceIncident(ctx, notifiers, ref.Key, duration, interaction.User.Name); err != nil {
+		logger(ctx).Error(err.Error())
+		outcome = "Failed to silence for <@" + interaction.User.Id + ">: " + err.Error()
+	}
+
+	return jsonResponse(200, withOutcome(interaction.OriginalMessage, ActionSilence, "Silence", outcome))
+}
+
+// Pages again via Pagerduty (regardless of the severity), with the content of the original message. Since it's
+// meant to reach someone the first page didn't, it goes out as a new incident.
+func repageNotification(ctx context.Context, notifiers *NotifierRegistry, interaction SlackInteraction) *HTTPResponse {
+	key := interaction.Actions[0].Value
+	logger(ctx).Info("Re-paging notification", "thread_key", key, "user", interaction.User.Name)
+
+	outcome := "Re-paged by <@" + interaction.User.Id + ">"
+
+	pagerduty := notifiers.get("pagerduty")
+	if pagerduty == nil {
+		outcome = "Failed to re-page for <@" + interaction.User.Id + ">: Pagerduty is not configured"
+	} else if err := pagerduty.Send(ctx, repageFromMessage(interaction.OriginalMessage, interaction.User.Name)); err != nil {
+		logger(ctx).Error(err.Error())
+		outcome = "Failed to re-page for <@" + interaction.User.Id + ">: " + err.Error()
+	} else {
+		notifiers.recordIncidentAction(ctx, key, "Re-paged by " + interaction.User.Name)
+	}
+
+	return jsonResponse(200, withOutcome(interaction.OriginalMessage, ActionRepage, "Re-page", outcome))
+}
+
+func repageFromMessage(msg notify.SlackMessage, user string) notify.Notification {
+	notification := notify.Notification {
+		Summary: "Re-paged from Slack by " + user,
+		Severity: notify.SeverityCritical,
+		Details: map[string]string{},
+		Page: true,
+	}
+
+	if len(msg.Attachments) != 0 {
+		notification.Title = msg.Attachments[0].Fallback
+
+		for _, f := range msg.Attachments[0].Fields {
+			notification.Details[f.Title] = f.Value
+		}
+	}
+
+	if notification.Title == "" {
+		notification.Title = msg.Text
+	}
+
+	return notification
+}
+
+// Handles slash commands (like "/notifier silence 123456789012/HighCPU 4"), where the key is the thread key of the
+// incident, and the duration of silences is in hours. Responses are only shown to the user issuing the command.
+func processSlackCommand(ctx context.Context, notifiers *NotifierRegistry, form url.Values) *HTTPResponse {
+	args := strings.Fields(form.Get("text"))
+	user := form.Get("user_name")
+
+	var err error
+	var outcome string
+
+	switch {
+	case len(args) == 2 && args[0] == ActionAcknowledge:
+		err = acknowledgeIncident(ctx, notifiers, args[1], user)
+		outcome = "Acknowledged " + args[1]
+	case len(args) == 3 && args[0] == ActionSilence:
+		hours, parseErr := strconv.Atoi(args[2])
+		if parseErr != nil || hours <= 0 {
+			return slackCommandResponse("Invalid number of hours: " + args[2])
+		}
+
+		err = silenceIncident(ctx, notifiers, args[1], time.Duration(hours) * time.Hour, user)
+		outcome = "Silenced " + args[1] + " for " + args[2] + "h"
+	default:
+		return slackCommandResponse("Usage: " + form.Get("command") + " acknowledge <key> | silence <key> <hours>")
+	}
+
+	if err != nil {
+		logger(ctx).Error(err.Error())
+		return slackCommandResponse("Failed: " + err.Error())
+	}
+
+	return slackCommandResponse(outcome)
+}
+
+func slackCommandResponse(text string) *HTTPResponse {
+	return jsonResponse(200, map[string]string{
+		"response_type": "ephemeral",
+		"text": text,
+	})
+}
+
+func acknowledgeIncident(ctx context.Context, notifiers *NotifierRegistry, key string, user string) error {
+	logger(ctx).Info("Acknowledging notification", "thread_key", key, "user", user)
+
+	if notifiers.escalations != nil {
+		if err := notifiers.escalations.remove(key); err != nil {
+			return err
+		}
+	}
+
+	notifiers.recordIncidentAction(ctx, key, "Acknowledged by " + user)
+
+	return nil
+}
+
+// Silencing also counts as acknowledging, so the incident isn't escalated either
+func silenceIncident(ctx context.Context, notifiers *NotifierRegistry, key string, duration time.Duration, user string) error {
+	if notifiers.suppressions == nil {
+		return errors.New("suppression_table is not configured")
+	}
+
+	logger(ctx).Info("Silencing notification", "thread_key", key, "user", user, "duration", duration.String())
+
+	if err := notifiers.suppressions.silence(key, time.Now().Add(duration), user); err != nil {
+		return err
+	}
+
+	if notifiers.escalations != nil {
+		if err := notifiers.escalations.remove(key); err != nil {
+			logger(ctx).Warn(err.Error())
+		}
+	}
+
+	notifiers.recordIncidentAction(ctx, key, "Silenced for " + duration.String() + " by " + user)
+
+	return nil
+}
+
+// Removes the buttons for the action taken from the original message, and records the outcome underneath
+func withOutcome(msg notify.SlackMessage, action string, title string, outcome string) notify.SlackMessage {
+	msg.ReplaceOriginal = true
+
+	for i := range msg.Attachments {
+		var remaining []notify.SlackAction
+
+		for _, a := range msg.Attachments[i].Actions {
+			if a.Name != action {
+				remaining = append(remaining, a)
+			}
+		}
+
+		msg.Attachments[i].Actions = remaining
+	}
+
+	if len(msg.Attachments) != 0 {
+		msg.Attachments[0].Fields = append(msg.Attachments[0].Fields, notify.SlackField {
+			Title: title,
+			Value: outcome,
+			Short: false,
+		})
+	}
+
+	return msg
+}
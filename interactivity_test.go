@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Signs the body the way Slack does, for the given timestamp
+func signSlackRequest(signingSecret string, ts int64, body string) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("v0:" + strconv.FormatInt(ts, 10) + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	secret := "8f742231b10e8888abcd99yyyzzz85a5"
+	body := "payload=%7B%22type%22%3A%22block_actions%22%7D"
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name string
+		timestamp string
+		signature string
+		body string
+		valid bool
+	}{
+		{
+			name: "valid signature",
+			timestamp: strconv.FormatInt(now, 10),
+			signature: signSlackRequest(secret, now, body),
+			body: body,
+			valid: true,
+		},
+		{
+			name: "clock skew within five minutes",
+			timestamp: strconv.FormatInt(now - 290, 10),
+			signature: signSlackRequest(secret, now - 290, body),
+			body: body,
+			valid: true,
+		},
+		{
+			name: "replayed request",
+			timestamp: strconv.FormatInt(now - 600, 10),
+			signature: signSlackRequest(secret, now - 600, body),
+			body: body,
+			valid: false,
+		},
+		{
+			name: "timestamp in the future",
+			timestamp: strconv.FormatInt(now + 600, 10),
+			signature: signSlackRequest(secret, now + 600, body),
+			body: body,
+			valid: false,
+		},
+		{
+			name: "tampered body",
+			timestamp: strconv.FormatInt(now, 10),
+			signature: signSlackRequest(secret, now, body),
+			body: body + "&extra=1",
+			valid: false,
+		},
+		{
+			name: "signed with another secret",
+			timestamp: strconv.FormatInt(now, 10),
+			signature: signSlackRequest("another-secret", now, body),
+			body: body,
+			valid: false,
+		},
+		{
+			name: "signed with another timestamp",
+			timestamp: strconv.FormatInt(now, 10),
+			signature: signSlackRequest(secret, now - 1, body),
+			body: body,
+			valid: false,
+		},
+		{
+			name: "missing signature",
+			timestamp: strconv.FormatInt(now, 10),
+			body: body,
+			valid: false,
+		},
+		{
+			name: "missing timestamp",
+			signature: signSlackRequest(secret, now, body),
+			body: body,
+			valid: false,
+		},
+		{
+			name: "invalid timestamp",
+			timestamp: "yesterday",
+			signature: signSlackRequest(secret, now, body),
+			body: body,
+			valid: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := HTTPRequest {
+				Headers: map[string]string{
+					"x-slack-request-timestamp": test.timestamp,
+					"x-slack-signature": test.signature,
+				},
+				Body: test.body,
+			}
+
+			err := verifySlackSignature(secret, req)
+			if test.valid && err != nil {
+				t.Errorf("expected the signature to be accepted, got: %v", err)
+			} else if !test.valid && err == nil {
+				t.Error("expected the signature to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"time"
+)
+
+// Adds the request ID and the build to everything logged during the invocation
+func withInvocationLogAttrs(ctx context.Context) context.Context {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = withLogAttrs(ctx, "request_id", lc.AwsRequestID)
+	}
+
+	return withLogAttrs(ctx, "version", version, "commit", commit)
+}
+
+// Runs the processing of a payload with everything recorded about the invocation around it: metrics, the archive,
+// error reports (to Sentry), the wide event (to Honeycomb) and the outcome logged. Panics are recovered from, and
+// returned as a PanicError.
+func instrumentInvocation(ctx context.Context, notifiers *NotifierRegistry, rawData json.RawMessage, start time.Time,
+	process func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if notifiers.config != nil {
+		ctx = withLogAttrs(ctx, "config_fingerprint", notifiers.config.fingerprint)
+	}
+
+	namespace := DefaultMetricsNamespace
+	if value, exists := lookupSetting("metrics_namespace"); exists {
+		namespace = value
+	}
+
+	if namespace != "" {
+		m := newMetrics(namespace)
+		ctx = withMetrics(ctx, m)
+
+		m.add("BytesProcessed", "Bytes", float64(len(rawData)), "Source", payloadSource(rawData))
+
+		defer func() {
+			m.add("ProcessingLatency", "Milliseconds", float64(time.Since(start).Milliseconds()), "", "")
+			m.flush()
+		}()
+	}
+
+	defer notifiers.flushArchive(ctx)
+
+	ctx = withErrorReporting(ctx, notifiers.redactor, rawData)
+	defer flushErrorReports()
+
+	event := newInvocationEventFromSettings(start)
+	ctx = withInvocationEvent(ctx, event)
+
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		event.set("request_id", lc.AwsRequestID)
+	}
+
+	event.set("version", version)
+	event.set("commit", commit)
+	event.set("payload_source", payloadSource(rawData))
+	event.set("payload_bytes", len(rawData))
+
+	if notifiers.config != nil {
+		event.set("config_fingerprint", notifiers.config.fingerprint)
+	}
+
+	response, err := notifiers.withRecovery(ctx, rawData, func() (interface{}, error) {
+		return process(ctx)
+	})
+
+	if err != nil {
+		logger(ctx).Error("Failed to process Event(s)", "outcome", "failed", "duration_ms", time.Since(start).Milliseconds(),
+			"error", err.Error())
+
+		// Panics are reported (with their stack) as they're recovered
+		if _, panicked := err.(*PanicError); panicked {
+			event.set("outcome", "panic")
+		} else {
+			event.set("outcome", "failed")
+			reportError(ctx, err)
+		}
+
+		event.set("error", err.Error())
+	} else {
+		logger(ctx).Info("Processed Event(s)", "outcome", "processed", "duration_ms", time.Since(start).Milliseconds())
+		event.set("outcome", "processed")
+	}
+
+	event.set("duration_ms", time.Since(start).Milliseconds())
+	exportInvocationEvent(ctx, event)
+
+	return response, err
+}
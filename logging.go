@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Logs are written as JSON (one object per line), so that they can be queried with Cloudwatch Logs Insights.
+// Loggers carrying the attributes of what's being processed (like the event ID, source and detail-type) are
+// passed down via the context.
+
+// Can be changed via the log_level setting, without replacing the handler
+var logLevel = new(slog.LevelVar)
+
+func init() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})))
+}
+
+// Sets the level to log at, to one of debug, info (the default), warn or error
+func configureLogging() error {
+	level := slog.LevelInfo
+
+	if value, exists := lookupSetting("log_level"); exists {
+		if err := level.UnmarshalText([]byte(strings.ToUpper(value))); err != nil {
+			return errors.New("invalid log_level: " + value)
+		}
+	}
+
+	logLevel.Set(level)
+
+	return nil
+}
+
+// Returns a context with a logger which adds the given attributes (key-value pairs) to every message. It's
+// carried the way pkg/notify expects, so that the notifiers log with the same attributes.
+func withLogAttrs(ctx context.Context, args ...interface{}) context.Context {
+	return notify.WithLogger(ctx, logger(ctx).With(args...))
+}
+
+// Returns the logger for the context, or the default logger if it doesn't have one
+func logger(ctx context.Context) *slog.Logger {
+	return notify.Logger(ctx)
+}
@@ -1,101 +1,56 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
-	"github.com/aws/aws-lambda-go/lambda"
-	"log"
+	"flag"
+	"fmt"
 	"os"
+	"time"
 )
 
 
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-
-func contains(s []string, el string) bool {
-	for _, v := range s {
-		if v == el {
-			return true
-		}
-	}
-
-	return false
-}
-
-
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-
-// List of supported event sources available here: https://docs.aws.amazon.com/lambda/latest/dg/invoking-lambda-function.html
-// List of example event payloads available here: https://docs.aws.amazon.com/lambda/latest/dg/eventsources.html
+///////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////
 
-// Struct with generic type, which we'll use only for detecting what sort of
-// Event is being passed to our Lambda
-type GenericEvent struct {
-	Records []map[string]interface{} `json:"Records,omitempty"`
-	Id string `json:"id,omitempty"`
-	DetailType string `json:"detail-type,omitempty"`
-	Source string `json:"source,omitempty"`
-	Test string `json:"test"`
-}
+// The response is only used for requests coming in via a Function URL or API Gateway (an HTTPResponse),
+// and for SQS batches (an SQSBatchResponse)
+func HandleRequest(ctx context.Context, rawData json.RawMessage) (interface{}, error) {
+	start := time.Now()
+	ctx = withInvocationLogAttrs(ctx)
 
-func processMessage(slackNotifier *SlackNotifier, pagerdutyNotifier *PagerdutyNotifier, raw json.RawMessage) error {
-	var data GenericEvent
+	logger(ctx).Info("Receiving new Event(s)")
 
-	err := json.Unmarshal(raw, &data)
+	rt, err := loadRuntime()
 	if err != nil {
-		return errors.New("unsupported payload: " + err.Error())
+		return nil, err
 	}
 
-	if data.Records != nil && len(data.Records) != 0 {
-		if data.Records[0]["EventSource"] == "aws:sns" {
-			err = processSNSRecords(slackNotifier, pagerdutyNotifier, raw)
-
-			if err != nil {
-				return err
-			}
-		} else {
-			log.Print("No SNS records to process")
+	return instrumentInvocation(ctx, rt.notifiers, rawData, start, func(ctx context.Context) (interface{}, error) {
+		// Errors are reported via the status code for HTTP requests
+		if isHTTPRequest(rawData) {
+			return processHTTPRequest(ctx, rt.notifiers, rt.slackNotifier, rt.sess, rawData), nil
 		}
-	} else { // Forward everything else to Cloudwatch Event processor (we'll weed unsupported stuff out there)
-		err = processCloudwatchEvent(slackNotifier, pagerdutyNotifier, raw)
-
-		if err != nil {
-			return err
-		}
-	}
 
-	return nil
+		return processMessage(ctx, rt.notifiers, rawData)
+	})
 }
 
 
-///////////////////////////////////////////////////////////////////////////////////////////////////
-///////////////////////////////////////////////////////////////////////////////////////////////////
-
-func HandleRequest(rawData json.RawMessage) error {
-	log.Print("Receiving new Event(s)")
-
-	slackWebhook, exists := os.LookupEnv("slack_webhook")
-	if !exists {
-		return errors.New("could not read slack_webhook_enc from environment")
-	}
-
-	slackNotifier := &SlackNotifier{
-		webhook: slackWebhook,
-	}
-
-	pagerdutyKey, exists := os.LookupEnv("pagerduty_key")
-	if !exists {
-		return errors.New("could not read pagerduty_key from environment")
+func main() {
+	file := flag.String("file", "", "Process the event in this file (or - for stdin) locally, instead of running in Lambda")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print notifications instead of sending them (with --file)")
+	flag.Parse()
+
+	var err error
+	if *file == "" {
+		err = startLambda()
+	} else {
+		err = runLocally(*file)
 	}
 
-	pagerdutyNotifier := &PagerdutyNotifier{
-		serviceKey: pagerdutyKey,
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
 	}
-
-	return processMessage(slackNotifier, pagerdutyNotifier, rawData)
-}
-
-func main() {
-	lambda.Start(HandleRequest)
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaintenanceSuppress = "suppress"
+const MaintenanceDigest = "digest" // Downgrade to info, and only post as part of a digest
+
+// Planned maintenance, during which matching notifications are suppressed (or sent to the digest),
+// so that they don't wake people up. Windows are either recurring (a cron schedule and a duration),
+// or a fixed interval (start and end).
+type MaintenanceWindow struct {
+	Name string `json:"name"`
+	Match route.FilterRule `json:"match"`
+	Schedule string `json:"schedule"` // Cron expression: minute hour day-of-month month day-of-week
+	Duration string `json:"duration"` // Like "2h" or "90m"
+	TimeZone string `json:"time_zone"` // For the schedule, defaults to UTC
+	Start string `json:"start"` // RFC3339 timestamps, for fixed intervals
+	End string `json:"end"`
+	Action string `json:"action"` // MaintenanceSuppress (default) or MaintenanceDigest
+	schedule *CronSchedule
+	duration time.Duration
+	location *time.Location
+	start time.Time
+	end time.Time
+}
+
+func (w *MaintenanceWindow) compile() error {
+	if w.Action == "" {
+		w.Action = MaintenanceSuppress
+	} else if w.Action != MaintenanceSuppress && w.Action != MaintenanceDigest {
+		return errors.New("unsupported action for maintenance window " + w.Name + ": " + w.Action)
+	}
+
+	if w.Schedule == "" {
+		var err error
+
+		if w.start, err = time.Parse(time.RFC3339, w.Start); err != nil {
+			return errors.New("invalid start for maintenance window " + w.Name + ": " + err.Error())
+		}
+
+		if w.end, err = time.Parse(time.RFC3339, w.End); err != nil {
+			return errors.New("invalid end for maintenance window " + w.Name + ": " + err.Error())
+		}
+
+		return nil
+	}
+
+	schedule, err := parseCronSchedule(w.Schedule)
+	if err != nil {
+		return errors.New("invalid schedule for maintenance window " + w.Name + ": " + err.Error())
+	}
+
+	if w.duration, err = time.ParseDuration(w.Duration); err != nil {
+		return errors.New("invalid duration for maintenance window " + w.Name + ": " + err.Error())
+	}
+
+	w.location = time.UTC
+	if w.TimeZone != "" {
+		if w.location, err = time.LoadLocation(w.TimeZone); err != nil {
+			return errors.New("invalid time_zone for maintenance window " + w.Name + ": " + err.Error())
+		}
+	}
+
+	w.schedule = schedule
+
+	return nil
+}
+
+func (w *MaintenanceWindow) active(now time.Time) bool {
+	if w.schedule == nil {
+		return !now.Before(w.start) && now.Before(w.end)
+	}
+
+	// Check whether the window started at any minute within the duration before now
+	local := now.In(w.location).Truncate(time.Minute)
+	for t := local; now.Sub(t) < w.duration; t = t.Add(-time.Minute) {
+		if w.schedule.matches(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the first active maintenance window matching the notification, or nil if there isn't one
+func (c *Config) maintenanceWindow(notification notify.Notification, now time.Time) *MaintenanceWindow {
+	for i := range c.MaintenanceWindows {
+		if c.MaintenanceWindows[i].active(now) && c.MaintenanceWindows[i].Match.Matches(notification) {
+			return &c.MaintenanceWindows[i]
+		}
+	}
+
+	return nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Minimal cron expressions: "*", numbers, ranges ("1-5"), lists ("1,15") and steps ("*/15", "0-30/10"),
+// with day-of-week also accepting names (SUN-SAT, where Sunday is 0 or 7)
+
+type CronSchedule struct {
+	minutes map[int]bool
+	hours map[int]bool
+	days map[int]bool
+	months map[int]bool
+	weekdays map[int]bool
+	anyDay bool // Day-of-month is "*", so only the day-of-week counts (and vice versa)
+	anyWeekday bool
+}
+
+var cronWeekdays = strings.NewReplacer("SUN", "0", "MON", "1", "TUE", "2", "WED", "3", "THU", "4", "FRI", "5", "SAT", "6")
+
+func parseCronSchedule(expr string) (*CronSchedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, errors.New("expected 5 fields, got " + strconv.Itoa(len(parts)))
+	}
+
+	schedule := &CronSchedule{
+		anyDay: parts[2] == "*",
+		anyWeekday: parts[4] == "*",
+	}
+
+	var err error
+	if schedule.minutes, err = parseCronField(parts[0], 0, 59); err != nil {
+		return nil, err
+	}
+
+	if schedule.hours, err = parseCronField(parts[1], 0, 23); err != nil {
+		return nil, err
+	}
+
+	if schedule.days, err = parseCronField(parts[2], 1, 31); err != nil {
+		return nil, err
+	}
+
+	if schedule.months, err = parseCronField(parts[3], 1, 12); err != nil {
+		return nil, err
+	}
+
+	if schedule.weekdays, err = parseCronField(cronWeekdays.Replace(strings.ToUpper(parts[4])), 0, 7); err != nil {
+		return nil, err
+	}
+
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+
+	return schedule, nil
+}
+
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+
+		if i := strings.Index(item, "/"); i != -1 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return nil, errors.New("invalid step in " + field)
+			}
+
+			item = item[:i]
+		}
+
+		from, to := min, max
+
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.New("invalid value in " + field)
+			}
+
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, errors.New("invalid range in " + field)
+				}
+			} else if step != 1 {
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return nil, errors.New("out of range: " + field)
+		}
+
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// Like cron, if both day-of-month and day-of-week are restricted, either of them matching is enough
+func (s *CronSchedule) matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+
+	dayMatches := s.days[t.Day()]
+	weekdayMatches := s.weekdays[int(t.Weekday())]
+
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekdayMatches
+	case s.anyWeekday:
+		return dayMatches
+	default:
+		return dayMatches || weekdayMatches
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/jmespath/go-jmespath"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+	"log/slog"
+	"text/template"
+)
+
+/**
+Example event mapping, in the routing config:
+
+mappings:
+  - source: aws.health
+    detail_type: AWS Health Event
+    title: "{{.detail.service}} {{.detail.eventTypeCategory}}: {{.detail.eventTypeCode}}"
+    summary: "{{(index .detail.eventDescription 0).latestDescription}}"
+    severity: warn
+    color: "#FF9900"
+    page: false
+    fields:
+      - title: Service
+        expression: detail.service
+        short: true
+      - title: Affected Resources
+        expression: join(', ', resources)
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Describes how to turn Cloudwatch Events without a dedicated handler into notifications, so that new event types
+// can be supported via the config. The first mapping matching an event is used.
+type EventMapping struct {
+	Source string `json:"source"` // Patterns, like for routes
+	DetailType string `json:"detail_type"`
+	Title string `json:"title"` // Go template, executed against the event (defaults to the detail-type)
+	Summary string `json:"summary"` // Go template (defaults to the title)
+	Severity string `json:"severity"` // Defaults to info
+	Color string `json:"color"` // Overrides the color derived from the severity (eg. "#FF9900")
+	Page bool `json:"page"` // Page someone, regardless of the severity
+	Fields []MappedField `json:"fields"`
+	title *template.Template
+	summary *template.Template
+}
+
+// A field extracted from the event via a JMESPath expression. Left out if the expression doesn't yield anything.
+type MappedField struct {
+	Title string `json:"title"`
+	Expression string `json:"expression"`
+	Short bool `json:"short"`
+	compiled *jmespath.JMESPath
+}
+
+func (c *Config) compileMappings() error {
+	for i := range c.Mappings {
+		mapping := &c.Mappings[i]
+		name := mapping.Source + "/" + mapping.DetailType
+
+		if mapping.Source == "" {
+			return errors.New("event mapping without source")
+		}
+
+		if mapping.Severity != "" && !notify.ValidSeverity(mapping.Severity) {
+			return errors.New("invalid severity in event mapping " + name + ": " + mapping.Severity)
+		}
+
+		var err error
+
+		if mapping.Title != "" {
+			if mapping.title, err = newTemplate(name + " title", mapping.Title); err != nil {
+				return err
+			}
+		}
+
+		if mapping.Summary != "" {
+			if mapping.summary, err = newTemplate(name + " summary", mapping.Summary); err != nil {
+				return err
+			}
+		}
+
+		for j := range mapping.Fields {
+			field := &mapping.Fields[j]
+
+			if field.compiled, err = jmespath.Compile(field.Expression); err != nil {
+				return errors.New("invalid field expression " + field.Expression + " in event mapping " + name + ": " +
+					err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the first mapping matching the event, or nil if there isn't one
+func (c *Config) eventMapping(event lambdaevents.CloudWatchEvent) *EventMapping {
+	for i := range c.Mappings {
+		if route.MatchPattern(c.Mappings[i].Source, event.Source) && route.MatchPattern(c.Mappings[i].DetailType, event.DetailType) {
+			return &c.Mappings[i]
+		}
+	}
+
+	return nil
+}
+
+// Falls back to the detail-type for the title (and the title for the summary) if the template fails to render
+func (m *EventMapping) notification(event lambdaevents.CloudWatchEvent) notify.Notification {
+	data := events.TemplateData(event)
+
+	title := event.DetailType
+	if m.title != nil {
+		if rendered, err := executeTemplate(m.title, data); err != nil {
+			slog.Warn("Failed to render event mapping title", "error", err.Error())
+		} else if rendered != "" {
+			title = rendered
+		}
+	}
+
+	summary := title
+	if m.summary != nil {
+		if rendered, err := executeTemplate(m.summary, data); err != nil {
+			slog.Warn("Failed to render event mapping summary", "error", err.Error())
+		} else if rendered != "" {
+			summary = rendered
+		}
+	}
+
+	severity := m.Severity
+	if severity == "" {
+		severity = notify.SeverityInfo
+	}
+
+	var fields []notify.Field
+
+	for _, field := range m.Fields {
+		value, err := searchString(field.compiled, data)
+		if err != nil {
+			slog.Warn("Failed to evaluate event mapping field", "expression", field.Expression, "error", err.Error())
+			continue
+		}
+
+		if value == "" {
+			continue
+		}
+
+		fields = append(fields, notify.Field {
+			Title: field.Title,
+			Value: value,
+			Short: field.Short,
+		})
+	}
+
+	return notify.Notification {
+		Source: event.Source,
+		DetailType: event.DetailType,
+		Account: event.AccountID,
+		Region: event.Region,
+		Event: data,
+		Title: title,
+		Summary: summary,
+		Severity: severity,
+		Color: m.Color,
+		Fields: fields,
+		Time: events.RawTimestamp(event.Time),
+		ConsoleURL: events.CloudWatchEventConsoleURL(event),
+		Resources: event.Resources,
+		Page: m.Page,
+	}
+}
+
+func processMappedEvent(ctx context.Context, notifiers *NotifierRegistry, mapping *EventMapping, event lambdaevents.CloudWatchEvent) error {
+	logger(ctx).Debug("Using event mapping", "mapping", mapping.Source + "/" + mapping.DetailType)
+	return notifiers.send(ctx, mapping.notification(event))
+}
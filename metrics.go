@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DefaultMetricsNamespace = "AWSNotifier"
+
+// Metrics collected over an invocation, and written to the logs in Cloudwatch Embedded Metric Format
+// at the end of it, so that Cloudwatch turns them into metrics we can alarm on. Safe for concurrent use.
+//
+// Example:
+//
+// {
+//   "_aws": {
+//     "Timestamp": 1546300800000,
+//     "CloudWatchMetrics": [
+//       {
+//         "Namespace": "AWSNotifier",
+//         "Dimensions": [["Channel"]],
+//         "Metrics": [{"Name": "NotificationsSent", "Unit": "Count"}]
+//       }
+//     ]
+//   },
+//   "Channel": "slack",
+//   "NotificationsSent": 2,
+//   "version": "1.4.0"
+// }
+type Metrics struct {
+	lock sync.Mutex
+	namespace string
+	values map[metricDimension]map[string]metricValue
+}
+
+// Metrics are grouped by a dimension, or a pair of them (or none, if the names are empty)
+type metricDimension struct {
+	name string
+	value string
+	secondName string
+	secondValue string
+}
+
+type metricValue struct {
+	value float64
+	unit string
+	samples []float64 // Individual observations (like latencies), which are written as is rather than summed
+}
+
+func newMetrics(namespace string) *Metrics {
+	return &Metrics{
+		namespace: namespace,
+		values: make(map[metricDimension]map[string]metricValue),
+	}
+}
+
+type metricsKey struct{}
+
+func withMetrics(ctx context.Context, m *Metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// Returns the metrics for the context, or nil if metrics are disabled (which is fine to call methods on)
+func metrics(ctx context.Context) *Metrics {
+	m, _ := ctx.Value(metricsKey{}).(*Metrics)
+	return m
+}
+
+// Adds one to the count of the metric, for the given dimension (eg. "Channel", "slack")
+func (m *Metrics) count(name string, dimension string, value string) {
+	m.add(name, "Count", 1, dimension, value)
+}
+
+func (m *Metrics) add(name string, unit string, value float64, dimension string, dimensionValue string) {
+	m.addTo(metricDimension{name: dimension, value: dimensionValue}, name, unit, value)
+}
+
+// Adds to the metric for a combination of two dimensions, like "Source" and "DetailType"
+func (m *Metrics) addPair(name string, unit string, value float64, dimension string, dimensionValue string,
+	secondDimension string, secondValue string) {
+	m.addTo(metricDimension{dimension, dimensionValue, secondDimension, secondValue}, name, unit, value)
+}
+
+func (m *Metrics) addTo(key metricDimension, name string, unit string, value float64) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.values[key] == nil {
+		m.values[key] = make(map[string]metricValue)
+	}
+
+	current := m.values[key][name]
+	m.values[key][name] = metricValue{value: current.value + value, unit: unit}
+}
+
+// Records a single observation of the metric (like the latency of a delivery), so that Cloudwatch can work out
+// percentiles, rather than only seeing the sum over the invocation
+func (m *Metrics) observe(name string, unit string, value float64, dimension string, dimensionValue string) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := metricDimension{name: dimension, value: dimensionValue}
+	if m.values[key] == nil {
+		m.values[key] = make(map[string]metricValue)
+	}
+
+	current := m.values[key][name]
+	m.values[key][name] = metricValue{unit: unit, samples: append(current.samples, value)}
+}
+
+// Writes the collected metrics to stdout (one line per dimension value), where the Lambda runtime
+// picks them up
+func (m *Metrics) flush() {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+
+	var keys []metricDimension
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].name + keys[i].value + keys[i].secondName + keys[i].secondValue <
+			keys[j].name + keys[j].value + keys[j].secondName + keys[j].secondValue
+	})
+
+	for _, key := range keys {
+		dimensions := []string{}
+		document := map[string]interface{}{"version": version}
+
+		if key.name != "" {
+			dimensions = append(dimensions, key.name)
+			document[key.name] = key.value
+		}
+
+		if key.secondName != "" {
+			dimensions = append(dimensions, key.secondName)
+			document[key.secondName] = key.secondValue
+		}
+
+		var definitions []map[string]string
+		for name, value := range m.values[key] {
+			definitions = append(definitions, map[string]string{"Name": name, "Unit": value.unit})
+
+			// Cloudwatch takes up to 100 values per metric in a document
+			if len(value.samples) > 100 {
+				document[name] = value.samples[:100]
+			} else if value.samples != nil {
+				document[name] = value.samples
+			} else {
+				document[name] = value.value
+			}
+		}
+
+		document["_aws"] = map[string]interface{}{
+			"Timestamp": timestamp,
+			"CloudWatchMetrics": []map[string]interface{}{
+				{
+					"Namespace": m.namespace,
+					"Dimensions": [][]string{dimensions},
+					"Metrics": definitions,
+				},
+			},
+		}
+
+		line, err := json.Marshal(document)
+		if err != nil {
+			slog.Warn("Failed to marshal metrics", "error", err.Error())
+			continue
+		}
+
+		os.Stdout.Write(append(line, '\n'))
+	}
+
+	m.values = make(map[metricDimension]map[string]metricValue)
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"golang.org/x/sync/errgroup"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How many notifiers a notification is sent to at the same time, by default
+const DefaultDispatchConcurrency = 4
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Holds the notifiers configured for this deployment by name, and decides which of them each
+// notification goes to, based on the routing config (if there is one)
+type NotifierRegistry struct {
+	names []string
+	notifiers map[string]notify.Notifier
+	config *Config
+	dedupe *DedupeStore
+	idempotency *IdempotencyStore // Optional
+	limiter *RateLimiter
+	digests *DigestStore
+	queue *NotificationQueue
+	tags *TagResolver
+	resources *ResourceDescriber
+	graphs *MetricGraphs
+	redactor *Redactor
+	policy *RoutingPolicy // Optional
+	allowedAccounts []string // Notifications from any other AWS account are dropped, if set
+	labelOrigin bool // Add the account and region events originated from to every notification
+	timeline *TimelineStore // Optional
+	suppressions *SuppressionStore // Optional
+	remediator *Remediator
+	sampling *SamplingStore // Optional
+	alarmStates *AlarmStateStore // Optional
+	audit *AuditStore // Optional
+	archive *ArchiveStore // Optional
+	costReport *CostReport // Optional
+	snapshot *DashboardSnapshot // Optional
+	trustedAdvisor *TrustedAdvisorReport // Optional
+	credentialReport *CredentialReport // Optional
+	certificates *CertificateScan // Optional
+	escalations *EscalationStore
+	breaker *CircuitBreaker
+	failed *FailedNotifications
+	concurrency int // How many notifiers to send to at the same time
+	rateLimits map[string]RateLimit // Only destinations listed here are rate limited
+}
+
+func newNotifierRegistry() *NotifierRegistry {
+	return &NotifierRegistry{
+		notifiers: make(map[string]notify.Notifier),
+		concurrency: DefaultDispatchConcurrency,
+		rateLimits: make(map[string]RateLimit),
+	}
+}
+
+// Registers the Slack notifier (if a web hook or token is set) and the Pagerduty one (if a key is set), and
+// sets up the optional stores and scheduled reports which have their settings configured
+func newNotifierRegistryFromSettings(sess *session.Session, slackNotifier *notify.SlackNotifier) (*NotifierRegistry, error) {
+	var err error
+
+	notifiers := newNotifierRegistry()
+
+	// Each channel is only enabled when it's configured, so Slack-only or Pagerduty-only deployments work
+	_, webhookExists := lookupSetting("slack_webhook")
+	_, tokenExists := lookupSetting("slack_token")
+
+	if webhookExists || tokenExists {
+		notifiers.register("slack", slackNotifier)
+	}
+
+	pagerdutyNotifier, err := newPagerdutyNotifierFromSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	if pagerdutyNotifier != nil {
+		notifiers.register("pagerduty", pagerdutyNotifier)
+	}
+
+	notifiers.tags = newTagResolver(sess)
+	notifiers.resources = newResourceDescriber(sess)
+	notifiers.redactor = newRedactor()
+	notifiers.remediator = &Remediator{sess: sess}
+
+	if notifiers.policy, err = loadRoutingPolicy(context.Background()); err != nil {
+		return nil, err
+	}
+
+	notifiers.allowedAccounts = getListSetting("allowed_accounts")
+	notifiers.labelOrigin = getSetting("label_origin") == "true"
+
+	if concurrency, exists := lookupSetting("dispatch_concurrency"); exists {
+		if notifiers.concurrency, err = strconv.Atoi(concurrency); err != nil || notifiers.concurrency < 1 {
+			return nil, errors.New("invalid dispatch_concurrency: " + concurrency)
+		}
+	}
+
+	var limit RateLimit
+	if notifiers.limiter, limit, err = newRateLimiterFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if notifiers.limiter != nil {
+		notifiers.rateLimits["slack"] = limit
+	}
+
+	notifiers.graphs = newMetricGraphsFromSettings(sess)
+	notifiers.digests = newDigestStoreFromSettings(sess)
+	notifiers.queue = newNotificationQueueFromSettings(sess)
+	notifiers.escalations = newEscalationStoreFromSettings(sess)
+	notifiers.suppressions = newSuppressionStoreFromSettings(sess)
+	notifiers.archive = newArchiveStoreFromSettings(sess)
+	notifiers.audit = newAuditStoreFromSettings(sess)
+	notifiers.trustedAdvisor = newTrustedAdvisorReportFromSettings(sess)
+	notifiers.sampling = newSamplingStoreFromSettings(sess)
+	notifiers.alarmStates = newAlarmStateStoreFromSettings(sess)
+
+	if notifiers.snapshot, err = newDashboardSnapshotFromSettings(sess, notifiers.graphs); err != nil {
+		return nil, err
+	}
+
+	if notifiers.breaker, err = newCircuitBreakerFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if notifiers.failed, err = newFailedNotificationsFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if notifiers.costReport, err = newCostReportFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if notifiers.credentialReport, err = newCredentialReportFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if notifiers.certificates, err = newCertificateScanFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if notifiers.timeline, err = newTimelineStoreFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if notifiers.idempotency, err = newIdempotencyStoreFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if notifiers.dedupe, err = newDedupeStoreFromSettings(sess); err != nil {
+		return nil, err
+	}
+
+	return notifiers, nil
+}
+
+func (r *NotifierRegistry) register(name string, notifier notify.Notifier) {
+	if _, exists := r.notifiers[name]; !exists {
+		r.names = append(r.names, name)
+	}
+
+	r.notifiers[name] = notifier
+}
+
+func (r *NotifierRegistry) get(name string) notify.Notifier {
+	return r.notifiers[name]
+}
+
+// Sends the notification to the notifiers it's routed to (or every registered notifier, in the
+// order they were registered, without routing config). Notifiers are sent to concurrently, and the
+// failed deliveries are reported together once all of them are done.
+func (r *NotifierRegistry) send(ctx context.Context, notification notify.Notification) error {
+	names := r.names
+	digest := false
+	var remediation *RemediationConfig
+
+	notification = r.redactor.redact(notification)
+
+	// Counted before anything gets filtered, so that noisy event types show up
+	metrics(ctx).addPair("EventsProcessed", "Count", 1, "Source", notification.Source, "DetailType", notification.DetailType)
+	invocationEvent(ctx).add("notifications", 1)
+	invocationEvent(ctx).include("sources", notification.Source)
+	invocationEvent(ctx).include("detail_types", notification.DetailType)
+	invocationEvent(ctx).include("accounts", notification.Account)
+	invocationEvent(ctx).include("alarm_names", notification.AlarmName)
+
+	// Shared SNS topics and event buses can carry events from accounts we don't want to hear from (or ones
+	// pretending to be something they aren't). Notifications without an account (like webhooks) are let through.
+	if len(r.allowedAccounts) != 0 && notification.Account != "" && !contains(r.allowedAccounts, notification.Account) {
+		invocationEvent(ctx).include("notification_outcomes", "rejected")
+		logger(ctx).Warn("Dropping notification from account which isn't allowed", "outcome", "rejected",
+			"account", notification.Account, "title", notification.Title)
+		metrics(ctx).count("NotificationsRejected", "Account", notification.Account)
+		return nil
+	}
+
+	if r.config != nil {
+		if !r.config.Filters.Allows(notification) {
+			invocationEvent(ctx).include("notification_outcomes", "filtered")
+			logger(ctx).Info("Notification dropped by filters", "outcome", "filtered", "title", notification.Title)
+			metrics(ctx).count("NotificationsFiltered", "Source", notification.Source)
+			return nil
+		}
+
+		notification = r.config.extractFields(notification)
+		notification = r.config.classify(notification)
+		notification = r.config.linkRunbook(notification)
+
+		if len(r.config.DefaultChannels) != 0 {
+			names = r.config.DefaultChannels
+		}
+
+		if account, exists := r.config.Accounts[notification.Account]; exists {
+			notification = account.apply(notification, r.labelOrigin)
+
+			if len(account.Channels) != 0 {
+				names = account.Channels
+			}
+		}
+
+		if r.config.TagRouting != nil && r.tags != nil {
+			value := r.tags.resolve(ctx, notification)[r.config.TagRouting.Tag]
+
+			if channels, exists := r.config.TagRouting.Channels[value]; exists && value != "" {
+				names = channels
+			}
+		}
+
+		if route := r.config.route(notification); route != nil {
+			if route.Suppress {
+				invocationEvent(ctx).include("notification_outcomes", "suppressed")
+				logger(ctx).Info("Notification suppressed by routing config", "outcome", "suppressed", "title", notification.Title)
+				metrics(ctx).count("NotificationsSuppressed", "Source", notification.Source)
+				return nil
+			}
+
+			if len(route.Channels) != 0 {
+				names = route.Channels
+			}
+
+			if route.Severity != "" {
+				notification.Severity = route.Severity
+			}
+
+			if route.Template != "" {
+				notification.Template = route.Template
+			}
+
+			digest = route.Digest
+			remediation = route.Remediation
+		}
+
+		if window := r.config.maintenanceWindow(notification, time.Now()); window != nil {
+			if window.Action == MaintenanceSuppress {
+				invocationEvent(ctx).include("notification_outcomes", "suppressed")
+				logger(ctx).Info("Notification suppressed by maintenance window", "outcome", "suppressed", "window", window.Name,
+					"title", notification.Title)
+				metrics(ctx).count("NotificationsSuppressed", "Source", notification.Source)
+				return nil
+			}
+
+			notification.Severity = notify.SeverityInfo
+			digest = true
+		}
+	}
+
+	notification, names, routed := r.applyPolicy(ctx, notification, names)
+	if !routed {
+		invocationEvent(ctx).include("notification_outcomes", "suppressed")
+		logger(ctx).Info("Notification suppressed by routing policy", "outcome", "suppressed", "title", notification.Title)
+		metrics(ctx).count("NotificationsSuppressed", "Source", notification.Source)
+		return nil
+	}
+
+	if r.labelOrigin {
+		notification = labelOrigin(notification)
+	}
+
+	// Channels requested by publishers (rather than the config) are dropped if they don't exist
+	if len(notification.Channels) != 0 {
+		names = nil
+
+		for _, name := range notification.Channels {
+			if _, exists := r.notifiers[name]; exists {
+				names = append(names, name)
+			} else {
+				logger(ctx).Warn("Dropping requested channel which doesn't exist", "channel", name)
+			}
+		}
+	}
+
+	if notification.Page {
+		if _, exists := r.notifiers["pagerduty"]; exists && !contains(names, "pagerduty") {
+			names = append(append([]string{}, names...), "pagerduty")
+		}
+	}
+
+	if len(names) == 0 {
+		return errors.New("no notifiers registered")
+	}
+
+	// If we can't tell whether it's silenced, we'd rather send it. Resolutions go through, so that threads are closed.
+	if r.suppressions != nil && notification.ThreadKey != "" && notification.ThreadAction != notify.ThreadResolve {
+		silenced, err := r.suppressions.silenced(notification.ThreadKey, time.Now())
+		if err != nil {
+			logger(ctx).Warn(err.Error())
+		} else if silenced {
+			invocationEvent(ctx).include("notification_outcomes", "silenced")
+			logger(ctx).Info("Dropping silenced notification", "outcome", "silenced", "thread_key", notification.ThreadKey,
+				"title", notification.Title)
+			metrics(ctx).count("NotificationsSuppressed", "Source", notification.Source)
+			return nil
+		}
+	}
+
+	notification, sampled := r.sample(ctx, notification, names)
+	if !sampled {
+		invocationEvent(ctx).include("notification_outcomes", "sampled")
+		logger(ctx).Debug("Dropping notification not picked by sampling", "outcome", "sampled", "title", notification.Title)
+		metrics(ctx).count("NotificationsSampled", "Source", notification.Source)
+		return nil
+	}
+
+	// Remediations run for every matching event (unless it's suppressed or silenced), so they should be safe to repeat
+	notification = r.remediate(ctx, remediation, notification)
+
+	if digest {
+		if r.digests == nil {
+			logger(ctx).Warn("Notification routed to digest, but digest_table is not configured - sending it now")
+		} else {
+			invocationEvent(ctx).include("notification_outcomes", "digest")
+			logger(ctx).Info("Adding notification to digest", "outcome", "digest", "channels", names, "title", notification.Title)
+			return r.digests.add(notification, names)
+		}
+	}
+
+	// Unknown channels are a config error, so we don't send to any of them
+	for _, name := range names {
+		if _, exists := r.notifiers[name]; !exists {
+			return errors.New("notification routed to unknown channel: " + name)
+		}
+	}
+
+	// If we can't tell whether it's a duplicate, we'd rather send it twice than not at all
+	recorded := false
+	deduped := notification // Escalations and history below can change the content the key is built from
+
+	if r.dedupe != nil {
+		duplicate, err := r.dedupe.seen(notification)
+		recorded = err == nil && !duplicate
+
+		if err != nil {
+			logger(ctx).Warn(err.Error())
+		} else if duplicate {
+			invocationEvent(ctx).include("notification_outcomes", "duplicate")
+			logger(ctx).Info("Dropping duplicate notification", "outcome", "duplicate", "title", notification.Title)
+			metrics(ctx).count("NotificationsSuppressed", "Source", notification.Source)
+			return nil
+		}
+	}
+
+	notification = r.trackEscalation(ctx, notification, names)
+	notification = r.addIncidentActions(notification)
+	notification = r.addHistory(ctx, notification)
+
+	// A failing notifier doesn't cancel the others, since they are independent destinations
+	var group errgroup.Group
+	var failures MultiError
+	group.SetLimit(r.concurrency)
+
+	// Failed channels (and the ones saved for retrying later) are collected for the audit log
+	var failed, saved []string
+	var failedLock sync.Mutex
+
+	deliver := func(name string) {
+		err := r.deliver(ctx, name, notification)
+
+		failedLock.Lock()
+		defer failedLock.Unlock()
+
+		if _, ok := err.(*SavedError); ok {
+			saved = append(saved, name)
+		} else if err != nil {
+			failed = append(failed, name)
+			failures.add("delivery via " + name, err)
+		}
+	}
+
+	ordered, rest := r.deliveryOrder(names)
+
+	for _, name := range ordered {
+		deliver(name)
+	}
+
+	for _, name := range rest {
+		name := name
+
+		group.Go(func() error {
+			deliver(name)
+			return nil
+		})
+	}
+
+	group.Wait()
+
+	var delivered []string
+	for _, name := range names {
+		if !contains(failed, name) && !contains(saved, name) {
+			delivered = append(delivered, name)
+		}
+	}
+
+	r.recordTimeline(ctx, notification, delivered)
+	r.recordAudit(ctx, notification, names, failed, saved)
+	r.recordArchive(ctx, notification, names, failed, saved)
+
+	// Forgotten, so that the retry of a failed delivery (or a redelivery of the event while the saved one is still
+	// waiting to be retried) isn't dropped as a duplicate of itself
+	if recorded && len(delivered) != len(names) {
+		if err := r.dedupe.forget(deduped); err != nil {
+			logger(ctx).Warn(err.Error())
+		}
+	}
+
+	return failures.errorOrNil()
+}
+
+// Splits the channels into the ones to deliver to one by one (in the configured order), and the rest
+func (r *NotifierRegistry) deliveryOrder(names []string) ([]string, []string) {
+	if r.config == nil || len(r.config.Delivery.Order) == 0 {
+		return nil, names
+	}
+
+	var ordered []string
+	for _, name := range r.config.Delivery.Order {
+		if contains(names, name) {
+			ordered = append(ordered, name)
+		}
+	}
+
+	var rest []string
+	for _, name := range names {
+		if !contains(ordered, name) {
+			rest = append(rest, name)
+		}
+	}
+
+	return ordered, rest
+}
+
+// Failures of best effort channels are swallowed, so that they don't fail (and retry) the whole invocation. Deliveries
+// saved for retrying later are returned as a *SavedError either way, so that they aren't mistaken for delivered ones.
+func (r *NotifierRegistry) deliver(ctx context.Context, name string, notification notify.Notification) error {
+	err := r.dispatch(ctx, name, notification)
+
+	if _, saved := err.(*SavedError); saved {
+		return err
+	}
+
+	if err != nil && r.config != nil && contains(r.config.Delivery.BestEffort, name) {
+		logger(ctx).Warn("Tolerating failed delivery to best effort channel", "outcome", "tolerated", "channel", name,
+			"title", notification.Title, "error", err.Error())
+		reportError(ctx, err, "channel", name)
+		return nil
+	}
+
+	return err
+}
+
+// Sends to a single channel, unless it's in quiet hours or over its rate limit
+func (r *NotifierRegistry) dispatch(ctx context.Context, name string, notification notify.Notification) error {
+	if r.config != nil {
+		notification = r.config.localize(name, notification)
+	}
+
+	if r.config != nil && r.queue != nil {
+		if quiet, exists := r.config.QuietHours[name]; exists && quiet.holds(notification, time.Now()) {
+			logger(ctx).Info("Queueing notification until quiet hours are over", "outcome", "queued", "channel", name,
+				"title", notification.Title)
+			return r.queue.add(name, notification)
+		}
+	}
+
+	// If we can't check the limit, we'd rather send it than not
+	if limit, limited := r.rateLimits[name]; limited && r.limiter != nil {
+		allowed, suppressed, err := r.limiter.take(name, limit)
+		if err != nil {
+			logger(ctx).Warn(err.Error(), "channel", name)
+		} else if !allowed {
+			logger(ctx).Info("Notification suppressed by rate limit", "outcome", "rate_limited", "channel", name,
+				"title", notification.Title)
+			metrics(ctx).count("NotificationsSuppressed", "Channel", name)
+			return nil
+		} else if suppressed != 0 {
+			notification.Fields = append(append([]notify.Field{}, notification.Fields...), notify.Field {
+				Title: "Rate Limited",
+				Value: strconv.Itoa(suppressed) + " more suppressed",
+				Short: true,
+			})
+		}
+	}
+
+	err := r.sendVia(ctx, name, r.notifiers[name], notification)
+
+	// Saved for retrying later, so that it isn't lost while the destination is down
+	if err != nil && r.failed != nil {
+		if saveErr := r.failed.add(name, notification); saveErr != nil {
+			logger(ctx).Error(saveErr.Error(), "channel", name)
+			return err
+		}
+
+		logger(ctx).Warn("Saved notification for retrying later", "outcome", "saved", "channel", name, "title", notification.Title)
+		invocationEvent(ctx).include("saved_channels", name)
+		return &SavedError{Err: err}
+	}
+
+	return err
+}
+
+// Sends via a single notifier, unless its circuit breaker is open, in which case the notification is
+// queued (if there's a queue), or dropped
+func (r *NotifierRegistry) sendVia(ctx context.Context, name string, notifier notify.Notifier, notification notify.Notification) error {
+	failures := 0
+
+	if r.breaker != nil {
+		var open bool
+		var err error
+
+		if open, failures, err = r.breaker.state(name); err != nil {
+			logger(ctx).Warn(err.Error(), "channel", name)
+		}
+
+		if open {
+			if r.queue == nil {
+				logger(ctx).Warn("Circuit breaker is open - dropping notification", "outcome", "dropped", "channel", name,
+					"title", notification.Title)
+				return nil
+			}
+
+			logger(ctx).Warn("Circuit breaker is open - queueing notification", "outcome", "queued", "channel", name,
+				"title", notification.Title)
+			return r.queue.add(name, notification)
+		}
+	}
+
+	start := time.Now()
+	err := traceSegment(ctx, "Send via " + name, func(ctx context.Context) error {
+		return notifier.Send(ctx, notification)
+	})
+	duration := time.Since(start).Milliseconds()
+
+	// Failed deliveries count too, since a destination timing out shows up as latency first
+	metrics(ctx).observe("DeliveryLatency", "Milliseconds", float64(duration), "Channel", name)
+	invocationEvent(ctx).add("deliveries", 1)
+	invocationEvent(ctx).add("delivery_ms." + name, float64(duration))
+	invocationEvent(ctx).include("channels", name)
+
+	if err != nil {
+		logger(ctx).Error("Failed to send notification", "outcome", "failed", "channel", name, "duration_ms", duration,
+			"error", err.Error())
+		metrics(ctx).count("NotificationsFailed", "Channel", name)
+		invocationEvent(ctx).include("failed_channels", name)
+
+		// Permanent errors (like a bad request) don't say anything about the health of the endpoint
+		if temporary, ok := err.(temporaryError); ok && temporary.Temporary() && r.breaker != nil {
+			if err := r.breaker.recordFailure(name); err != nil {
+				logger(ctx).Warn(err.Error(), "channel", name)
+			}
+		}
+
+		return err
+	}
+
+	logger(ctx).Info("Notification sent", "outcome", "sent", "channel", name, "duration_ms", duration)
+	metrics(ctx).count("NotificationsSent", "Channel", name)
+
+	if failures != 0 {
+		if err := r.breaker.recordSuccess(name); err != nil {
+			logger(ctx).Warn(err.Error(), "channel", name)
+		}
+	}
+
+	return nil
+}
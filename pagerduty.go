@@ -1,65 +1,22 @@
 package main
 
 import (
-	"net/http"
-	"encoding/json"
-	"bytes"
 	"errors"
-	"log"
+	"github.com/motns/aws-notifier/pkg/notify"
 )
 
-type PagerdutyIncidentDetails struct {
-	Fields map[string]string `json:"fields"`
-}
-
-type PagerdutyIncident struct {
-	Description string `json:"description"`
-	IncidentKey string `json:"incident_key"`
-	Details PagerdutyIncidentDetails `json:"details"`
-}
-
-type PagerdutyIncidentRequest struct {
-	ServiceKey string `json:"service_key"`
-	EventType string `json:"event_type"`
-	Description string `json:"description"`
-	IncidentKey string `json:"incident_key"`
-	Client string `json:"client"`
-	Details PagerdutyIncidentDetails `json:"details"`
-}
-
-type PagerdutyNotifier struct {
-	serviceKey  string
-}
-
-func (p *PagerdutyNotifier) triggerIncident(incident PagerdutyIncident) error {
-	log.Print("Triggering Pagerduty incident...")
-
-	req := PagerdutyIncidentRequest {
-		ServiceKey: p.serviceKey,
-		EventType: "trigger",
-		Description: incident.Description,
-		IncidentKey: incident.IncidentKey,
-		Client: "AWS Event Processor",
-		Details: incident.Details,
-	}
-
-	payload, err := json.Marshal(req)
-	if err != nil {
-		return errors.New("failed to marshal Pagerduty request: " + err.Error())
+// Returns nil if pagerduty_key isn't set
+func newPagerdutyNotifierFromSettings() (*notify.PagerdutyNotifier, error) {
+	pagerdutyKey, exists := lookupSetting("pagerduty_key")
+	if !exists {
+		return nil, nil
 	}
 
-	res, err := http.Post(
-		"https://events.pagerduty.com/generic/2010-04-15/create_event.json",
-		"application/json",
-		bytes.NewBuffer(payload))
-
-	res.Body.Close()
+	pagerdutyNotifier := notify.NewPagerdutyNotifier(pagerdutyKey, getSetting("pagerduty_min_severity"))
 
-	if err != nil {
-		return errors.New("failed to trigger Pagerduty Incident - got error: " + err.Error())
+	if pagerdutyNotifier.MinSeverity != "" && !notify.ValidSeverity(pagerdutyNotifier.MinSeverity) {
+		return nil, errors.New("invalid pagerduty_min_severity: " + pagerdutyNotifier.MinSeverity)
 	}
 
-	log.Print("Pagerduty incident triggered")
-
-	return nil
+	return pagerdutyNotifier, nil
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payloads are cut down to this in logs (and error reports), so that huge batches don't blow the log event size
+const MaxLoggedPayloadLength = 16 * 1024
+
+// Channel crash notifications are sent to, unless fallback_channels says otherwise
+const DefaultFallbackChannel = "slack"
+
+// Runs the processing of an event, turning a panic into an error (so that Lambda still retries the event, or sends
+// it to the dead-letter queue), after logging it along with the payload and letting someone know via the fallback
+// channels. Only panics on the goroutine processing the event are caught, not ones in concurrent deliveries.
+func (r *NotifierRegistry) withRecovery(ctx context.Context, raw json.RawMessage, process func() (interface{}, error)) (response interface{}, err error) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		description := describePayload(raw)
+		payload := r.redactor.redactPayload(raw)
+		if len(payload) > MaxLoggedPayloadLength {
+			payload = payload[:MaxLoggedPayloadLength] + "..."
+		}
+
+		logger(ctx).Error("Panic while processing event", "outcome", "panic", "panic", fmt.Sprint(recovered),
+			"event", description, "payload", payload, "stack", string(debug.Stack()))
+		metrics(ctx).count("Panics", "Source", payloadSource(raw))
+
+		reportPanic(ctx, recovered)
+		r.notifyCrash(ctx, description, fmt.Sprint(recovered))
+
+		response = nil
+		err = &PanicError{What: description, Value: recovered}
+	}()
+
+	return process()
+}
+
+// Sends a minimal notification straight to the fallback channels, bypassing routing (which may be what crashed)
+func (r *NotifierRegistry) notifyCrash(ctx context.Context, description string, reason string) {
+	channels := []string{DefaultFallbackChannel}
+	if value, exists := lookupSetting("fallback_channels"); exists {
+		channels = strings.Split(value, ",")
+	}
+
+	title := "Notifier crashed processing " + description
+
+	notification := notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Crash",
+		Title: title,
+		Summary: title,
+		Severity: notify.SeverityError,
+		Fields: []notify.Field {
+			{
+				Title: "Panic",
+				Value: reason,
+				Short: false,
+			},
+		},
+		Time: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, name := range channels {
+		name = strings.TrimSpace(name)
+
+		notifier, exists := r.notifiers[name]
+		if !exists {
+			if name != "" {
+				logger(ctx).Warn("Skipping crash notification for unknown channel", "channel", name)
+			}
+
+			continue
+		}
+
+		if err := sendRecovering(ctx, notifier, notification); err != nil {
+			logger(ctx).Error("Failed to send crash notification", "channel", name, "error", err.Error())
+		}
+	}
+}
+
+// The notifier itself could panic too, which mustn't take the invocation down with it
+func sendRecovering(ctx context.Context, notifier notify.Notifier, notification notify.Notification) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = errors.New("panic: " + fmt.Sprint(recovered))
+		}
+	}()
+
+	return notifier.Send(ctx, notification)
+}
+
+// Like "aws.ec2/EC2 Instance State-change Notification event 7bf73129-..." or "aws:sns record 95df01b4-..."
+func describePayload(raw json.RawMessage) string {
+	var payload GenericEvent
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return "unparseable payload"
+	}
+
+	if payload.Id != "" {
+		return payload.Source + "/" + payload.DetailType + " event " + payload.Id
+	}
+
+	if len(payload.Records) != 0 {
+		record := payload.Records[0]
+		description := payloadSource(raw) + " record"
+
+		// SNS records carry their message ID under "Sns", SQS ones at the top
+		if sns, ok := record["Sns"].(map[string]interface{}); ok {
+			if id, ok := sns["MessageId"].(string); ok {
+				description += " " + id
+			}
+		} else if id, ok := record["messageId"].(string); ok {
+			description += " " + id
+		}
+
+		if len(payload.Records) > 1 {
+			description += " (and " + strconv.Itoa(len(payload.Records) - 1) + " more)"
+		}
+
+		return description
+	}
+
+	return "event"
+}
+
+func payloadSource(raw json.RawMessage) string {
+	var payload GenericEvent
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return "unknown"
+	}
+
+	if payload.Source != "" {
+		return payload.Source
+	}
+
+	if len(payload.Records) != 0 {
+		for _, key := range []string{"EventSource", "eventSource"} {
+			if source, ok := payload.Records[0][key].(string); ok {
+				return source
+			}
+		}
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// Stores full event payloads in S3, for messages which had to be truncated
+type PayloadStore struct {
+	s3 *s3.S3
+	bucket string
+	prefix string
+}
+
+// Returns a link to the stored object in the S3 console, so access is subject to the usual IAM permissions
+func (p *PayloadStore) Store(source string, payload interface{}) (string, error) {
+	body, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return "", errors.New("failed to marshal payload: " + err.Error())
+	}
+
+	hash := sha1.Sum(body)
+	key := p.prefix + source + "/" + time.Now().UTC().Format("2006/01/02/150405") + "-" +
+		hex.EncodeToString(hash[:])[:12] + ".json"
+
+	_, err = p.s3.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key: aws.String(key),
+		Body: bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+
+	if err != nil {
+		return "", errors.New("failed to upload payload to S3: " + err.Error())
+	}
+
+	slog.Info("Stored full payload in S3", "bucket", p.bucket, "key", key)
+
+	return "https://s3.console.aws.amazon.com/s3/object/" + p.bucket + "?prefix=" + url.QueryEscape(key), nil
+}
@@ -0,0 +1,151 @@
+package events
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strings"
+)
+
+// Prometheus Alertmanager webhook payloads
+
+type AlertmanagerWebhook struct {
+	Version string `json:"version"`
+	GroupKey string `json:"groupKey"`
+	Status string `json:"status"`
+	Receiver string `json:"receiver"`
+	GroupLabels map[string]string `json:"groupLabels"`
+	CommonLabels map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL string `json:"externalURL"`
+	Alerts []AlertmanagerAlert `json:"alerts"`
+}
+
+type AlertmanagerAlert struct {
+	Status string `json:"status"` // Either "firing" or "resolved"
+	Labels map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt string `json:"startsAt"`
+	EndsAt string `json:"endsAt"`
+	GeneratorURL string `json:"generatorURL"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Values of the "severity" label commonly used in Prometheus alerting rules
+var alertmanagerSeverities = map[string]string{
+	"critical": notify.SeverityCritical,
+	"page": notify.SeverityCritical,
+	"error": notify.SeverityError,
+	"warning": notify.SeverityWarn,
+	"warn": notify.SeverityWarn,
+	"info": notify.SeverityInfo,
+	"none": notify.SeverityInfo,
+}
+
+// Also used for Grafana, which sends alerts in the same format (with a few extra fields)
+func AlertNotification(source string, groupKey string, alert AlertmanagerAlert) notify.Notification {
+	alertName := alert.Labels["alertname"]
+	if alertName == "" {
+		alertName = "Alert"
+	}
+
+	isFiring := alert.Status != "resolved"
+
+	var title string
+	var severity string
+	var timestamp string
+
+	if isFiring {
+		title = "FIRING: \"" + alertName + "\""
+		timestamp = alert.StartsAt
+
+		// Firing alerts are errors (like alarms), unless the alerting rule says otherwise
+		severity = notify.SeverityError
+		if mapped, exists := alertmanagerSeverities[strings.ToLower(alert.Labels["severity"])]; exists {
+			severity = mapped
+		}
+	} else {
+		title = "RESOLVED: \"" + alertName + "\""
+		timestamp = alert.EndsAt
+		severity = notify.SeveritySuccess
+	}
+
+	summary := alert.Annotations["summary"]
+	if summary == "" {
+		summary = alert.Annotations["description"]
+	}
+	if summary == "" {
+		summary = title
+	}
+
+	description := alert.Annotations["description"]
+	if description == "" {
+		description = alert.Annotations["summary"]
+	}
+
+	fields := []notify.Field {
+		{
+			Title: title,
+			Value: description,
+			Short: false,
+		},
+	}
+
+	// Sorted, since maps don't keep the order they were sent in
+	var labels []string
+	for label := range alert.Labels {
+		if label != "alertname" && label != "severity" {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+
+	details := make(map[string]string)
+
+	for _, label := range labels {
+		fields = append(fields, notify.Field {
+			Title: label,
+			Value: alert.Labels[label],
+			Short: true,
+		})
+
+		details[label] = alert.Labels[label]
+	}
+
+	if alert.GeneratorURL != "" {
+		fields = append(fields, notify.Field {
+			Title: "Source",
+			Value: alert.GeneratorURL,
+			Short: true,
+		})
+	}
+
+	// The fingerprint identifies the alert (by its labels), and stays the same between firing and resolving
+	key := alert.Fingerprint
+	if key == "" {
+		key = groupKey + "/" + alertName
+	}
+
+	notification := notify.Notification {
+		Source: source,
+		DetailType: "Alert",
+		AlarmName: alertName,
+		Event: TemplateData(alert),
+		Title: title,
+		Summary: summary,
+		Severity: severity,
+		Fields: fields,
+		Time: timestamp,
+		ThreadKey: source + "/" + key,
+		IncidentKey: source + key,
+		Details: details,
+		RunbookURL: alert.Annotations["runbook_url"],
+	}
+
+	if isFiring {
+		notification.ThreadAction = notify.ThreadStart
+	} else {
+		notification.ThreadAction = notify.ThreadResolve
+	}
+
+	return notification
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+)
+
+// Lifecycle actions (like "EC2 Instance-terminate Lifecycle Action"), which hold up the instance until they're
+// completed
+func AutoScalingLifecycleNotification(event lambdaevents.CloudWatchEvent, eventDetail DetailAutoScalingLifecycleEvent) notify.Notification {
+	title := "Autoscaling - Lifecycle Action"
+	notification := notify.Notification {
+		Source: event.Source,
+		DetailType: event.DetailType,
+		Account: event.AccountID,
+		Region: event.Region,
+		Event: TemplateData(event),
+		Title: title,
+		Summary: title,
+		Severity: notify.SeverityInfo,
+		Fields: []notify.Field {
+			{
+				Title: "CloudWatch Event",
+				Value: title,
+				Short: false,
+			},
+			{
+				Title: "AutoScalingGroupName",
+				Value: eventDetail.AutoScalingGroupName,
+				Short: true,
+			},
+			{
+				Title: "EC2InstanceId",
+				Value: eventDetail.EC2InstanceId,
+				Short: true,
+			},
+			{
+				Title: "LifecycleTransition",
+				Value: eventDetail.LifecycleTransition,
+				Short: true,
+			},
+		},
+		Time: RawTimestamp(event.Time),
+		ConsoleURL: CloudWatchEventConsoleURL(event),
+		Resources: event.Resources,
+		DedupeKey: eventDetail.EC2InstanceId + "/" + eventDetail.LifecycleActionToken, // The only resource is the group
+	}
+
+	return withInstanceThread(notification, event.AccountID, eventDetail.EC2InstanceId)
+}
+
+// Launches and terminations (like "EC2 Instance Launch Successful"), where the unsuccessful ones are warnings
+func AutoScalingActivityNotification(event lambdaevents.CloudWatchEvent, eventDetail DetailAutoScalingEC2Event) notify.Notification {
+	severity := notify.SeverityInfo
+	if event.DetailType == "EC2 Instance Launch Unsuccessful" || event.DetailType == "EC2 Instance Terminate Unsuccessful" {
+		severity = notify.SeverityWarn
+	}
+
+	title := "Autoscaling - " + event.DetailType
+	notification := notify.Notification {
+		Source: event.Source,
+		DetailType: event.DetailType,
+		Account: event.AccountID,
+		Region: event.Region,
+		Event: TemplateData(event),
+		Title: title,
+		Summary: title,
+		Severity: severity,
+		Fields: []notify.Field {
+			{
+				Title: "CloudWatch Event",
+				Value: title,
+				Short: false,
+			},
+			{
+				Title: "EC2InstanceId",
+				Value: eventDetail.EC2InstanceId,
+				Short: true,
+			},
+			{
+				Title: "StatusCode",
+				Value: eventDetail.StatusCode,
+				Short: true,
+			},
+			{
+				Title: "Availability Zone",
+				Value: eventDetail.Details.AvailabilityZone,
+				Short: true,
+			},
+			{
+				Title: "Cause",
+				Value: eventDetail.Cause,
+				Short: true,
+			},
+		},
+		Time: RawTimestamp(event.Time),
+		ConsoleURL: CloudWatchEventConsoleURL(event),
+		Resources: event.Resources,
+		DedupeKey: eventDetail.EC2InstanceId + "/" + eventDetail.ActivityId,
+	}
+
+	if took := tookBetween(eventDetail.StartTime, eventDetail.EndTime); took != "" {
+		notification.Fields = append(notification.Fields, notify.Field {
+			Title: "Duration",
+			Value: took,
+			Short: true,
+		})
+	}
+
+	return withInstanceThread(notification, event.AccountID, eventDetail.EC2InstanceId)
+}
+
+func withInstanceThread(notification notify.Notification, account string, instanceID string) notify.Notification {
+	if instanceID != "" {
+		notification.ThreadKey = InstanceThreadKey(account, instanceID)
+		notification.ThreadAction = notify.ThreadCorrelate
+	}
+
+	return notification
+}
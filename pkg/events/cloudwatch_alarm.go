@@ -0,0 +1,33 @@
+package events
+
+// Cloudwatch Alarm state changes, as published to SNS (in the Message of the notification)
+
+type CloudwatchAlarm struct {
+	AlarmName string `json:"AlarmName"`
+	AlarmArn string `json:"AlarmArn"`
+	AlarmDescription string `json:"AlarmDescription"`
+	AWSAccountId string `json:"AWSAccountId"`
+	NewStateValue string `json:"NewStateValue"`
+	NewStateReason string `json:"NewStateReason"`
+	StateChangeTime string `json:"StateChangeTime"`
+	Region string `json:"Region"`
+	OldStateValue string `json:"OldStateValue"`
+	Trigger CloudwatchAlarmTrigger `json:"Trigger"`
+}
+
+type CloudwatchAlarmTrigger struct {
+	MetricName string `json:"MetricName"`
+	Namespace string `json:"Namespace"`
+	Statistic string `json:"Statistic"`
+	Unit string `json:"Unit,omitempty"`
+	Dimensions []CloudwatchAlarmTriggerDimension
+	Period int `json:"Period"`
+	EvaluationPeriods int `json:"EvaluationPeriods"`
+	ComparisonOperator string `json:"ComparisonOperator"`
+	Threshold float32 `json:"Threshold"`
+}
+
+type CloudwatchAlarmTriggerDimension struct {
+	Name string `json:"name"`
+	Value string `json:"value"`
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+)
+
+// Event headers are read into events.CloudWatchEvent (from github.com/aws/aws-lambda-go), with the "detail" part
+// (which is different for each Event Type) decoded into one of these
+
+type DetailEC2StateChange struct {
+	InstanceId string `json:"instance-id"`
+	State string `json:"state"`
+}
+
+type DetailAutoScalingLifecycleEvent struct {
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	LifecycleHookName string `json:"LifecycleHookName"`
+	EC2InstanceId string `json:"EC2InstanceId"`
+	LifecycleTransition string `json:"LifecycleTransition"`
+	NotificationMetadata string `json:"NotificationMetadata"`
+}
+
+type DetailAutoScalingEC2Event struct {
+	StatusCode string `json:"StatusCode"`
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	ActivityId string `json:"ActivityId"`
+	Details DetailAutoScalingEC2EventDetails `json:"Details"`
+	RequestId string `json:"RequestId"`
+	EndTime string `json:"EndTime"`
+	EC2InstanceId string `json:"EC2InstanceId"`
+	StartTime string `json:"StartTime"`
+	Cause string `json:"Cause"`
+}
+
+type DetailAutoScalingEC2EventDetails struct {
+	AvailabilityZone string `json:"Availability Zone"`
+	SubnetID string `json:"Subnet ID"`
+}
+
+type DetailGuardDutyFinding struct {
+	Id string `json:"id"`
+	Arn string `json:"arn"`
+	Type string `json:"type"` // Like "UnauthorizedAccess:EC2/SSHBruteForce"
+	Title string `json:"title"`
+	Description string `json:"description"`
+	Severity float64 `json:"severity"` // 1.0 - 10.0, see the GuardDuty docs for the bands
+	Resource DetailGuardDutyResource `json:"resource"`
+}
+
+type DetailGuardDutyResource struct {
+	ResourceType string `json:"resourceType"`
+	InstanceDetails DetailGuardDutyInstanceDetails `json:"instanceDetails"` // Only for EC2 findings
+}
+
+type DetailGuardDutyInstanceDetails struct {
+	InstanceId string `json:"instanceId"`
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// For all other Event Types, which shows the detail (as fields, like from FlattenJSON) so that it's still readable
+// without dedicated handling
+func GenericEventNotification(event lambdaevents.CloudWatchEvent, detail []notify.Field) notify.Notification {
+	title := event.Source
+
+	fields := []notify.Field {
+		{
+			Title: "CloudWatch Event",
+			Value: title,
+			Short: false,
+		},
+	}
+
+	return notify.Notification {
+		Source: event.Source,
+		DetailType: event.DetailType,
+		Account: event.AccountID,
+		Region: event.Region,
+		Event: TemplateData(event),
+		Title: title,
+		Summary: title,
+		Severity: notify.SeverityInfo,
+		Fields: append(fields, detail...),
+		Time: RawTimestamp(event.Time),
+		ConsoleURL: CloudWatchEventConsoleURL(event),
+		Resources: event.Resources,
+	}
+}
@@ -0,0 +1,155 @@
+package events
+
+import (
+	"encoding/json"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"net/url"
+	"strings"
+)
+
+// Helpers for generating links to the relevant page of the AWS Management Console
+
+func consoleBaseURL(region string) string {
+	if region == "" {
+		return "https://console.aws.amazon.com/"
+	}
+
+	return "https://" + region + ".console.aws.amazon.com/"
+}
+
+func AlarmConsoleURL(region string, alarmName string) string {
+	return consoleBaseURL(region) + "cloudwatch/home?region=" + region + "#alarmsV2:alarm/" + url.PathEscape(alarmName)
+}
+
+func DashboardConsoleURL(region string, dashboardName string) string {
+	return consoleBaseURL(region) + "cloudwatch/home?region=" + region + "#dashboards:name=" + url.PathEscape(dashboardName)
+}
+
+func EC2InstanceConsoleURL(region string, instanceId string) string {
+	return consoleBaseURL(region) + "ec2/home?region=" + region + "#InstanceDetails:instanceId=" + instanceId
+}
+
+func AutoScalingGroupConsoleURL(region string, groupName string) string {
+	return consoleBaseURL(region) + "ec2/home?region=" + region + "#AutoScalingGroupDetails:id=" + url.PathEscape(groupName)
+}
+
+func CodePipelineExecutionConsoleURL(region string, pipeline string, executionId string) string {
+	return consoleBaseURL(region) + "codesuite/codepipeline/pipelines/" + url.PathEscape(pipeline) +
+		"/executions/" + executionId + "/timeline?region=" + region
+}
+
+func GuardDutyFindingConsoleURL(region string, findingId string) string {
+	return consoleBaseURL(region) + "guardduty/home?region=" + region + "#/findings?macros=current&fId=" + url.QueryEscape(findingId)
+}
+
+func SNSTopicConsoleURL(region string, topicArn string) string {
+	return consoleBaseURL(region) + "sns/v3/home?region=" + region + "#/topic/" + topicArn
+}
+
+// Splits an ARN (arn:partition:service:region:account:resource) into its parts. Returns nil
+// if the string is not a valid ARN.
+func ParseARN(arn string) []string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return nil
+	}
+
+	return parts
+}
+
+func RegionFromARN(arn string) string {
+	if parts := ParseARN(arn); parts != nil {
+		return parts[3]
+	}
+
+	return ""
+}
+
+func AccountFromARN(arn string) string {
+	if parts := ParseARN(arn); parts != nil {
+		return parts[4]
+	}
+
+	return ""
+}
+
+// Best effort link for any resource ARN, for services we have a specific page for
+func ResourceConsoleURL(arn string) string {
+	parts := ParseARN(arn)
+	if parts == nil {
+		return ""
+	}
+
+	service, region, resource := parts[2], parts[3], parts[5]
+
+	switch {
+	case service == "ec2" && strings.HasPrefix(resource, "instance/"):
+		return EC2InstanceConsoleURL(region, strings.TrimPrefix(resource, "instance/"))
+	case service == "autoscaling" && strings.Contains(resource, "autoScalingGroupName/"):
+		return AutoScalingGroupConsoleURL(region, resource[strings.Index(resource, "autoScalingGroupName/") + 21:])
+	case service == "cloudwatch" && strings.HasPrefix(resource, "alarm:"):
+		return AlarmConsoleURL(region, strings.TrimPrefix(resource, "alarm:"))
+	case service == "codepipeline":
+		return consoleBaseURL(region) + "codesuite/codepipeline/pipelines/" + url.PathEscape(resource) + "/view?region=" + region
+	case service == "sns":
+		return SNSTopicConsoleURL(region, arn)
+	}
+
+	return ""
+}
+
+// Works out the most relevant console page for a Cloudwatch Event
+func CloudWatchEventConsoleURL(event lambdaevents.CloudWatchEvent) string {
+	switch event.Source {
+	case "aws.codepipeline":
+		var detail struct {
+			Pipeline string `json:"pipeline"`
+			ExecutionId string `json:"execution-id"`
+		}
+
+		if err := json.Unmarshal(event.Detail, &detail); err == nil && detail.Pipeline != "" && detail.ExecutionId != "" {
+			return CodePipelineExecutionConsoleURL(event.Region, detail.Pipeline, detail.ExecutionId)
+		}
+	case "aws.ec2":
+		var detail DetailEC2StateChange
+
+		if err := json.Unmarshal(event.Detail, &detail); err == nil && detail.InstanceId != "" {
+			return EC2InstanceConsoleURL(event.Region, detail.InstanceId)
+		}
+	case "aws.guardduty":
+		var detail DetailGuardDutyFinding
+
+		if err := json.Unmarshal(event.Detail, &detail); err == nil && detail.Id != "" {
+			return GuardDutyFindingConsoleURL(event.Region, detail.Id)
+		}
+	case "aws.autoscaling":
+		var detail struct {
+			AutoScalingGroupName string `json:"AutoScalingGroupName"`
+		}
+
+		if err := json.Unmarshal(event.Detail, &detail); err == nil && detail.AutoScalingGroupName != "" {
+			return AutoScalingGroupConsoleURL(event.Region, detail.AutoScalingGroupName)
+		}
+	}
+
+	for _, arn := range event.Resources {
+		if link := ResourceConsoleURL(arn); link != "" {
+			return link
+		}
+	}
+
+	return ""
+}
+
+// RDS notifications come with a link to the affected resource already
+func RDSConsoleURL(msg SNSMessage) string {
+	var rdsEvent struct {
+		IdentifierLink string `json:"Identifier Link"`
+	}
+
+	if err := json.Unmarshal([]byte(msg.Message), &rdsEvent); err == nil && rdsEvent.IdentifierLink != "" {
+		return rdsEvent.IdentifierLink
+	}
+
+	return SNSTopicConsoleURL(RegionFromARN(msg.TopicArn), msg.TopicArn)
+}
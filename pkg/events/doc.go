@@ -0,0 +1,5 @@
+// Package events has the types for reading the payloads the notifier handles: Cloudwatch Alarms, the detail of
+// Cloudwatch Events, SNS messages, and the webhooks of monitoring tools (Alertmanager, Grafana, GitHub and Sentry).
+// Each payload has a function building the Notification for it, leaving anything which needs AWS calls (like
+// describing the resources involved) to the caller.
+package events
@@ -0,0 +1,59 @@
+package events
+
+import (
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+)
+
+// States which mean the instance is going away (or already gone)
+var stoppingStates = []string{"shutting-down", "terminated", "stopping", "stopped"}
+
+func EC2StateChangeNotification(event lambdaevents.CloudWatchEvent, eventDetail DetailEC2StateChange) notify.Notification {
+	severity := notify.SeverityInfo
+	for _, state := range stoppingStates {
+		if eventDetail.State == state {
+			severity = notify.SeverityWarn
+		}
+	}
+
+	title := "EC2 Instance State-change"
+	return notify.Notification {
+		Source: event.Source,
+		DetailType: event.DetailType,
+		Account: event.AccountID,
+		Region: event.Region,
+		Event: TemplateData(event),
+		Title: title,
+		Summary: title,
+		Severity: severity,
+		Fields: []notify.Field {
+			{
+				Title: "CloudWatch Event",
+				Value: title,
+				Short: false,
+			},
+			{
+				Title: "instance-id",
+				Value: eventDetail.InstanceId,
+				Short: true,
+			},
+			{
+				Title: "state",
+				Value: eventDetail.State,
+				Short: true,
+			},
+		},
+		Time: RawTimestamp(event.Time),
+		ConsoleURL: CloudWatchEventConsoleURL(event),
+		Resources: event.Resources,
+		ThreadKey: InstanceThreadKey(event.AccountID, eventDetail.InstanceId),
+		ThreadAction: notify.ThreadCorrelate,
+		DedupeKey: eventDetail.InstanceId + "/" + eventDetail.State,
+	}
+}
+
+// Autoscaling activities, GuardDuty findings and the state changes of the instances involved share a thread, so
+// that launching or terminating an instance shows up as one conversation instead of separate posts
+func InstanceThreadKey(account string, instanceID string) string {
+	return "ec2/" + account + "/" + instanceID
+}
@@ -0,0 +1,137 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strconv"
+)
+
+// Nested values deeper than this are shown as JSON, rather than flattened any further
+const MaxFlattenDepth = 4
+
+// Beyond this many fields, the rest are only counted
+const MaxFlattenFields = 20
+
+// Values shorter than this are shown side by side
+const MaxShortFieldLength = 40
+
+// Turns a JSON document (like the detail of an event we have no handler for) into fields, keyed by the path of
+// each value, like "requestParameters.bucketName" or "resources[0].arn". Keys are sorted, so that the fields are
+// in the same order every time. Nulls and empty values are left out.
+func FlattenJSON(raw []byte) ([]notify.Field, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, errors.New("failed to unmarshal JSON: " + err.Error())
+	}
+
+	var fields []notify.Field
+	flattenValue(&fields, "", document, 0)
+
+	if len(fields) > MaxFlattenFields {
+		omitted := len(fields) - MaxFlattenFields
+
+		fields = append(fields[:MaxFlattenFields], notify.Field {
+			Title: "...",
+			Value: strconv.Itoa(omitted) + " more fields",
+			Short: false,
+		})
+	}
+
+	return fields, nil
+}
+
+func flattenValue(fields *[]notify.Field, path string, value interface{}, depth int) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return
+		}
+
+		if depth >= MaxFlattenDepth {
+			addFlattenedField(fields, path, compactJSON(v))
+			return
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+
+			flattenValue(fields, child, v[key], depth + 1)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return
+		}
+
+		if depth >= MaxFlattenDepth {
+			addFlattenedField(fields, path, compactJSON(v))
+			return
+		}
+
+		for i, item := range v {
+			flattenValue(fields, path + "[" + strconv.Itoa(i) + "]", item, depth + 1)
+		}
+	case string:
+		if v != "" {
+			addFlattenedField(fields, path, v)
+		}
+	case json.Number:
+		addFlattenedField(fields, path, v.String())
+	case bool:
+		addFlattenedField(fields, path, strconv.FormatBool(v))
+	}
+}
+
+// Shows the JSON indented, in a code block (or as is, if it isn't valid JSON)
+func PrettyJSONField(title string, raw []byte) notify.Field {
+	var indented bytes.Buffer
+
+	value := string(raw)
+	if err := json.Indent(&indented, raw, "", "  "); err == nil {
+		value = indented.String()
+	}
+
+	return notify.Field {
+		Title: title,
+		Value: value,
+		Short: false,
+		Code: true,
+	}
+}
+
+func addFlattenedField(fields *[]notify.Field, path string, value string) {
+	if path == "" {
+		path = "Value"
+	}
+
+	*fields = append(*fields, notify.Field {
+		Title: path,
+		Value: value,
+		Short: len(value) < MaxShortFieldLength,
+	})
+}
+
+func compactJSON(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+
+	return string(encoded)
+}
@@ -0,0 +1,159 @@
+package events
+
+import (
+	"encoding/json"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Helpers for turning payloads into notifications
+
+// Converts a typed event into generic maps, so that templates can refer to fields by their
+// JSON names
+func TemplateData(event interface{}) interface{} {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil
+	}
+
+	return data
+}
+
+// Raw timestamp for notifications, from events which come with a parsed time (empty if it wasn't set)
+func RawTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+// Like "took 34s", for events with a start and end time (empty if either can't be parsed)
+func tookBetween(rawStart string, rawEnd string) string {
+	start, err := notify.ParseTimestamp(rawStart)
+	if err != nil {
+		return ""
+	}
+
+	end, err := notify.ParseTimestamp(rawEnd)
+	if err != nil || end.Before(start) {
+		return ""
+	}
+
+	return "took " + notify.HumanDuration(end.Sub(start))
+}
+
+// Like "2 minutes ago", for channels which don't render timestamps themselves (empty if it can't be parsed)
+func RelativeTimestamp(raw string, now time.Time) string {
+	t, err := notify.ParseTimestamp(raw)
+	if err != nil {
+		return ""
+	}
+
+	return notify.RelativeTime(t, now)
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Metric values and thresholds
+
+// The first datapoint in reasons like "Threshold Crossed: 1 datapoint (10.0) was greater than ..." or
+// "Threshold Crossed: 1 out of the last 1 datapoints [92.5 (01/03/24 12:00:00)] was greater than ..."
+var observedValuePattern = regexp.MustCompile(`datapoints? [\[(]([-+0-9.eE]+)`)
+var thresholdPattern = regexp.MustCompile(`the threshold \(([-+0-9.eE]+)\)`)
+
+var byteUnits = map[string]float64{
+	"Bytes": 1,
+	"Kilobytes": 1 << 10,
+	"Megabytes": 1 << 20,
+	"Gigabytes": 1 << 30,
+	"Terabytes": 1 << 40,
+}
+
+// Like "observed 95.2% vs threshold 80%", with the values in the unit of the metric. Empty if the reason doesn't
+// mention a datapoint (like for INSUFFICIENT_DATA).
+func ObservedVsThreshold(alarm CloudwatchAlarm) string {
+	match := observedValuePattern.FindStringSubmatch(alarm.NewStateReason)
+	if match == nil {
+		return ""
+	}
+
+	observed, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return ""
+	}
+
+	// The reason has the threshold at full precision, unlike the trigger
+	threshold := float64(alarm.Trigger.Threshold)
+	if match := thresholdPattern.FindStringSubmatch(alarm.NewStateReason); match != nil {
+		if parsed, err := strconv.ParseFloat(match[1], 64); err == nil {
+			threshold = parsed
+		}
+	}
+
+	unit := alarm.Trigger.Unit
+	return "observed " + FormatMetricValue(observed, unit) + " vs threshold " + FormatMetricValue(threshold, unit)
+}
+
+// Renders a metric value in its Cloudwatch unit, like "95.2%", "1.5 GB", "2m 5s" or "12/s"
+func FormatMetricValue(value float64, unit string) string {
+	if strings.HasSuffix(unit, "/Second") {
+		return FormatMetricValue(value, strings.TrimSuffix(unit, "/Second")) + "/s"
+	}
+
+	if size, exists := byteUnits[unit]; exists {
+		return formatBytes(value * size)
+	}
+
+	switch unit {
+	case "Percent":
+		return FormatNumber(value) + "%"
+	case "Count", "None", "":
+		return FormatNumber(value)
+	case "Seconds":
+		return formatSeconds(value)
+	case "Milliseconds":
+		return formatSeconds(value / 1000)
+	case "Microseconds":
+		return formatSeconds(value / 1000000)
+	default:
+		return FormatNumber(value) + " " + unit
+	}
+}
+
+// Up to 2 decimals, without trailing zeros
+func FormatNumber(value float64) string {
+	formatted := strconv.FormatFloat(value, 'f', 2, 64)
+	return strings.TrimSuffix(strings.TrimRight(formatted, "0"), ".")
+}
+
+func formatBytes(bytes float64) string {
+	suffixes := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+
+	i := 0
+	for math.Abs(bytes) >= 1024 && i < len(suffixes) - 1 {
+		bytes /= 1024
+		i++
+	}
+
+	return FormatNumber(bytes) + " " + suffixes[i]
+}
+
+// Sub-second values keep milliseconds, since latencies are usually well under a second
+func formatSeconds(seconds float64) string {
+	if math.Abs(seconds) < 1 {
+		return FormatNumber(seconds * 1000) + "ms"
+	}
+
+	return notify.HumanDuration(time.Duration(seconds * float64(time.Second)))
+}
@@ -0,0 +1,168 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTookBetween(t *testing.T) {
+	tests := []struct {
+		name string
+		start string
+		end string
+		expected string
+	}{
+		{"seconds", "2019-01-01T00:00:00.000Z", "2019-01-01T00:00:34.000Z", "took 34s"},
+		{"minutes", "2019-01-01T00:00:00Z", "2019-01-01T00:02:05Z", "took 2m 5s"},
+		{"instant", "2019-01-01T00:00:00Z", "2019-01-01T00:00:00Z", "took 0s"},
+		{"end before start", "2019-01-01T00:01:00Z", "2019-01-01T00:00:00Z", ""},
+		{"still in progress", "2019-01-01T00:00:00Z", "", ""},
+		{"invalid start", "yesterday", "2019-01-01T00:00:00Z", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := tookBetween(test.start, test.end); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRelativeTimestamp(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		raw string
+		expected string
+	}{
+		{"Cloudwatch Alarm", "2019-01-01T11:57:00.000+0000", "3 minutes ago"},
+		{"Cloudwatch Event", "2019-01-01T10:00:00Z", "2 hours ago"},
+		{"just now", "2019-01-01T12:00:00Z", "just now"},
+		{"unparseable", "01/01/19 12:00:00", ""},
+		{"missing", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := RelativeTimestamp(test.raw, now); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFormatMetricValue(t *testing.T) {
+	tests := []struct {
+		name string
+		value float64
+		unit string
+		expected string
+	}{
+		{"percent", 95.24, "Percent", "95.24%"},
+		{"whole percent", 80, "Percent", "80%"},
+		{"negative percent", -5, "Percent", "-5%"},
+		{"rounded to 2 decimals", 3.14159, "None", "3.14"},
+		{"count", 12, "Count", "12"},
+		{"no unit", 3, "", "3"},
+		{"tiny value", 0.001, "Count", "0"},
+		{"bytes", 512, "Bytes", "512 B"},
+		{"bytes above a kilobyte", 1536, "Bytes", "1.5 KB"},
+		{"negative bytes", -2048, "Bytes", "-2 KB"},
+		{"kilobytes", 2048, "Kilobytes", "2 MB"},
+		{"gigabytes", 1.5, "Gigabytes", "1.5 GB"},
+		{"terabytes beyond the largest unit", 4096 * 1024, "Terabytes", "4096 PB"},
+		{"seconds", 125, "Seconds", "2m 5s"},
+		{"sub-second", 0.25, "Seconds", "250ms"},
+		{"milliseconds", 250, "Milliseconds", "250ms"},
+		{"milliseconds above a second", 1500, "Milliseconds", "1s"},
+		{"microseconds", 1500, "Microseconds", "1.5ms"},
+		{"rate", 12, "Count/Second", "12/s"},
+		{"byte rate", 1048576, "Bytes/Second", "1 MB/s"},
+		{"other unit", 42, "Bits", "42 Bits"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := FormatMetricValue(test.value, test.unit); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestObservedVsThreshold(t *testing.T) {
+	tests := []struct {
+		name string
+		reason string
+		threshold float32
+		unit string
+		expected string
+	}{
+		{
+			name: "current reason format",
+			reason: "Threshold Crossed: 1 out of the last 1 datapoints [92.5 (01/03/24 12:00:00)] was greater than the threshold (80.0) (minimum 1 datapoint for OK -> ALARM transition).",
+			threshold: 80,
+			unit: "Percent",
+			expected: "observed 92.5% vs threshold 80%",
+		},
+		{
+			name: "legacy reason format",
+			reason: "Threshold Crossed: 1 datapoint (10.0) was greater than or equal to the threshold (5.0).",
+			threshold: 5,
+			unit: "Count",
+			expected: "observed 10 vs threshold 5",
+		},
+		{
+			name: "threshold from the reason, at full precision",
+			reason: "Threshold Crossed: 1 datapoint (0.75) was greater than the threshold (0.123456789).",
+			threshold: 0.12,
+			unit: "None",
+			expected: "observed 0.75 vs threshold 0.12",
+		},
+		{
+			name: "threshold from the trigger",
+			reason: "Threshold Crossed: 1 datapoint (0.75) was greater than the configured limit.",
+			threshold: 0.5,
+			unit: "Seconds",
+			expected: "observed 750ms vs threshold 500ms",
+		},
+		{
+			name: "scientific notation",
+			reason: "Threshold Crossed: 1 datapoint [1.2E7 (01/03/24 12:00:00)] was greater than the threshold (1.0E7).",
+			threshold: 10000000,
+			unit: "Bytes",
+			expected: "observed 11.44 MB vs threshold 9.54 MB",
+		},
+		{
+			name: "insufficient data",
+			reason: "Insufficient Data: 1 datapoint was unknown.",
+			threshold: 80,
+			unit: "Percent",
+			expected: "",
+		},
+		{
+			name: "no reason",
+			threshold: 80,
+			unit: "Percent",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			alarm := CloudwatchAlarm {
+				NewStateReason: test.reason,
+				Trigger: CloudwatchAlarmTrigger {
+					Threshold: test.threshold,
+					Unit: test.unit,
+				},
+			}
+
+			if actual := ObservedVsThreshold(alarm); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package events
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"strings"
+)
+
+// GitHub webhook payloads (workflow_run, deployment and deployment_status events)
+
+type GitHubEvent struct {
+	Action string `json:"action"`
+	WorkflowRun *GitHubWorkflowRun `json:"workflow_run"`
+	Deployment *GitHubDeployment `json:"deployment"`
+	DeploymentStatus *GitHubDeploymentStatus `json:"deployment_status"`
+	Repository GitHubRepository `json:"repository"`
+	Sender GitHubUser `json:"sender"`
+}
+
+type GitHubWorkflowRun struct {
+	ID int64 `json:"id"`
+	Name string `json:"name"`
+	HeadBranch string `json:"head_branch"`
+	HeadSHA string `json:"head_sha"`
+	RunNumber int `json:"run_number"`
+	Event string `json:"event"`
+	Status string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	HTMLURL string `json:"html_url"`
+	Actor GitHubUser `json:"actor"`
+	HeadCommit struct {
+		Message string `json:"message"`
+	} `json:"head_commit"`
+}
+
+type GitHubDeployment struct {
+	ID int64 `json:"id"`
+	SHA string `json:"sha"`
+	Ref string `json:"ref"`
+	Environment string `json:"environment"`
+}
+
+type GitHubDeploymentStatus struct {
+	State string `json:"state"`
+	Description string `json:"description"`
+	Environment string `json:"environment"`
+	TargetURL string `json:"target_url"`
+	LogURL string `json:"log_url"`
+	Creator GitHubUser `json:"creator"`
+}
+
+type GitHubRepository struct {
+	FullName string `json:"full_name"`
+	HTMLURL string `json:"html_url"`
+}
+
+type GitHubUser struct {
+	Login string `json:"login"`
+}
+
+func WorkflowRunNotification(event GitHubEvent) *notify.Notification {
+	run := event.WorkflowRun
+
+	if event.Action != "completed" {
+		return nil
+	}
+
+	var severity string
+	var action string
+
+	switch run.Conclusion {
+	case "success":
+		severity = notify.SeveritySuccess
+		action = notify.ThreadResolve
+	case "failure", "timed_out", "startup_failure":
+		severity = notify.SeverityError
+		action = notify.ThreadStart
+	case "cancelled", "action_required", "stale":
+		severity = notify.SeverityWarn
+		action = notify.ThreadReply
+	default:
+		return nil
+	}
+
+	title := event.Repository.FullName + ": " + run.Name + " #" + strconv.Itoa(run.RunNumber) + " " +
+		strings.Replace(run.Conclusion, "_", " ", -1)
+
+	summary := run.HeadCommit.Message
+	if i := strings.Index(summary, "\n"); i != -1 {
+		summary = summary[:i]
+	}
+
+	fields := []notify.Field {
+		{
+			Title: title,
+			Value: summary,
+			Short: false,
+		},
+		{
+			Title: "Branch",
+			Value: run.HeadBranch,
+			Short: true,
+		},
+		{
+			Title: "Commit",
+			Value: shortSHA(run.HeadSHA),
+			Short: true,
+		},
+		{
+			Title: "Triggered By",
+			Value: run.Actor.Login + " (" + run.Event + ")",
+			Short: true,
+		},
+		{
+			Title: "Link",
+			Value: run.HTMLURL,
+			Short: true,
+		},
+	}
+
+	if summary == "" {
+		summary = title
+	}
+
+	// Runs of the same workflow on the same branch are grouped, so that a fix resolves the failure
+	key := event.Repository.FullName + "/" + run.Name + "/" + run.HeadBranch
+
+	return &notify.Notification {
+		Source: "github",
+		DetailType: "Workflow Run",
+		Title: title,
+		Summary: summary,
+		Severity: severity,
+		Fields: fields,
+		ThreadKey: "github/" + key,
+		ThreadAction: action,
+		IncidentKey: "github" + key,
+		Details: map[string]string{
+			"Repository": event.Repository.FullName,
+			"Workflow": run.Name,
+			"Branch": run.HeadBranch,
+			"Commit": run.HeadSHA,
+		},
+	}
+}
+
+func DeploymentStatusNotification(event GitHubEvent) *notify.Notification {
+	status := event.DeploymentStatus
+
+	var severity string
+	var action string
+
+	switch status.State {
+	case "success":
+		severity = notify.SeveritySuccess
+		action = notify.ThreadResolve
+	case "failure", "error":
+		severity = notify.SeverityError
+		action = notify.ThreadStart
+	default:
+		return nil
+	}
+
+	environment := status.Environment
+	var ref, sha string
+
+	if event.Deployment != nil {
+		ref = event.Deployment.Ref
+		sha = event.Deployment.SHA
+
+		if environment == "" {
+			environment = event.Deployment.Environment
+		}
+	}
+
+	title := event.Repository.FullName + ": Deployment to " + environment + " " + status.State
+
+	summary := status.Description
+	if summary == "" {
+		summary = title
+	}
+
+	link := status.LogURL
+	if link == "" {
+		link = status.TargetURL
+	}
+
+	fields := []notify.Field {
+		{
+			Title: title,
+			Value: status.Description,
+			Short: false,
+		},
+		{
+			Title: "Environment",
+			Value: environment,
+			Short: true,
+		},
+		{
+			Title: "Ref",
+			Value: ref + " (" + shortSHA(sha) + ")",
+			Short: true,
+		},
+		{
+			Title: "Deployed By",
+			Value: status.Creator.Login,
+			Short: true,
+		},
+	}
+
+	if link != "" {
+		fields = append(fields, notify.Field {
+			Title: "Link",
+			Value: link,
+			Short: true,
+		})
+	}
+
+	key := event.Repository.FullName + "/deployment/" + environment
+
+	return &notify.Notification {
+		Source: "github",
+		DetailType: "Deployment Status",
+		Title: title,
+		Summary: summary,
+		Severity: severity,
+		Fields: fields,
+		ThreadKey: "github/" + key,
+		ThreadAction: action,
+		IncidentKey: "github" + key,
+		Details: map[string]string{
+			"Repository": event.Repository.FullName,
+			"Environment": environment,
+			"Ref": ref,
+			"Commit": sha,
+		},
+	}
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+
+	return sha
+}
@@ -0,0 +1,166 @@
+package events
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strconv"
+)
+
+// Grafana alerting webhook payloads
+
+// Unified alerting extends the Alertmanager format, while legacy alerting sends a single rule with its evalMatches
+type GrafanaWebhook struct {
+	Version string `json:"version"`
+	GroupKey string `json:"groupKey"`
+	Status string `json:"status"`
+	Alerts []GrafanaAlert `json:"alerts"`
+	ExternalURL string `json:"externalURL"`
+	Title string `json:"title"`
+	State string `json:"state"` // One of alerting, ok, no_data, pending or paused for legacy alerts
+	Message string `json:"message"`
+	RuleID int64 `json:"ruleId"`
+	RuleName string `json:"ruleName"`
+	RuleURL string `json:"ruleUrl"`
+	EvalMatches []GrafanaEvalMatch `json:"evalMatches"`
+	ImageURL string `json:"imageUrl"`
+	Tags map[string]string `json:"tags"`
+}
+
+type GrafanaAlert struct {
+	AlertmanagerAlert
+	DashboardURL string `json:"dashboardURL"`
+	PanelURL string `json:"panelURL"`
+	SilenceURL string `json:"silenceURL"`
+	ImageURL string `json:"imageURL"`
+	ValueString string `json:"valueString"`
+}
+
+type GrafanaEvalMatch struct {
+	Value *float64 `json:"value"`
+	Metric string `json:"metric"`
+	Tags map[string]string `json:"tags"`
+}
+
+// Unified alerts are handled like the ones from Alertmanager, with links to the dashboard and panel added
+func GrafanaNotification(payload GrafanaWebhook, alert GrafanaAlert) notify.Notification {
+	notification := AlertNotification("grafana", payload.GroupKey, alert.AlertmanagerAlert)
+	notification.Event = TemplateData(alert)
+
+	if alert.ValueString != "" {
+		notification.Fields = append(notification.Fields, notify.Field {
+			Title: "Values",
+			Value: alert.ValueString,
+			Short: false,
+		})
+	}
+
+	if alert.DashboardURL != "" {
+		notification.Fields = append(notification.Fields, notify.Field {
+			Title: "Dashboard",
+			Value: alert.DashboardURL,
+			Short: true,
+		})
+	}
+
+	if alert.PanelURL != "" {
+		notification.Fields = append(notification.Fields, notify.Field {
+			Title: "Panel",
+			Value: alert.PanelURL,
+			Short: true,
+		})
+	}
+
+	return notification
+}
+
+// Legacy alerts are sent for every state change of a rule, which maps to the alarm states of Cloudwatch
+func GrafanaLegacyNotification(payload GrafanaWebhook) notify.Notification {
+	var severity string
+	var action string
+
+	switch payload.State {
+	case "alerting":
+		severity = notify.SeverityError
+		action = notify.ThreadStart
+	case "ok":
+		severity = notify.SeveritySuccess
+		action = notify.ThreadResolve
+	case "no_data":
+		severity = notify.SeverityWarn
+		action = notify.ThreadReply
+	default:
+		severity = notify.SeverityInfo
+		action = notify.ThreadReply
+	}
+
+	title := payload.Title
+	if title == "" {
+		title = payload.RuleName
+	}
+
+	summary := payload.Message
+	if summary == "" {
+		summary = title
+	}
+
+	fields := []notify.Field {
+		{
+			Title: title,
+			Value: payload.Message,
+			Short: false,
+		},
+	}
+
+	for _, match := range payload.EvalMatches {
+		value := "null"
+		if match.Value != nil {
+			value = strconv.FormatFloat(*match.Value, 'f', -1, 64)
+		}
+
+		fields = append(fields, notify.Field {
+			Title: match.Metric,
+			Value: value,
+			Short: true,
+		})
+	}
+
+	// Sorted, since maps don't keep the order they were sent in
+	var tags []string
+	for tag := range payload.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		fields = append(fields, notify.Field {
+			Title: tag,
+			Value: payload.Tags[tag],
+			Short: true,
+		})
+	}
+
+	if payload.RuleURL != "" {
+		fields = append(fields, notify.Field {
+			Title: "Panel",
+			Value: payload.RuleURL,
+			Short: true,
+		})
+	}
+
+	ruleID := strconv.FormatInt(payload.RuleID, 10)
+
+	return notify.Notification {
+		Source: "grafana",
+		DetailType: "Alert",
+		AlarmName: payload.RuleName,
+		Event: TemplateData(payload),
+		Title: title,
+		Summary: summary,
+		Severity: severity,
+		Fields: fields,
+		ThreadKey: "grafana/rule/" + ruleID,
+		ThreadAction: action,
+		IncidentKey: "grafanarule" + ruleID,
+		Details: payload.Tags,
+	}
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+)
+
+// Maps the numeric severity of a finding to its GuardDuty band (as shown in the console), and the severity we
+// notify with. High (and critical) findings mention people, since they mean a resource is likely compromised.
+// See: https://docs.aws.amazon.com/guardduty/latest/ug/guardduty_findings-severity.html
+func GuardDutySeverity(severity float64) (string, string) {
+	switch {
+	case severity >= 9:
+		return "Critical", notify.SeverityCritical
+	case severity >= 7:
+		return "High", notify.SeverityCritical
+	case severity >= 4:
+		return "Medium", notify.SeverityWarn
+	default:
+		return "Low", notify.SeverityInfo
+	}
+}
+
+func GuardDutyFindingNotification(event lambdaevents.CloudWatchEvent, finding DetailGuardDutyFinding) notify.Notification {
+	band, severity := GuardDutySeverity(finding.Severity)
+
+	title := "GuardDuty Finding - " + finding.Type
+	notification := notify.Notification {
+		Source: event.Source,
+		DetailType: event.DetailType,
+		Account: event.AccountID,
+		Region: event.Region,
+		Event: TemplateData(event),
+		Title: title,
+		Summary: finding.Title,
+		Severity: severity,
+		Fields: []notify.Field {
+			{
+				Title: "Finding",
+				Value: finding.Title,
+				Short: false,
+			},
+			{
+				Title: "Severity",
+				Value: band + " (" + FormatNumber(finding.Severity) + ")",
+				Short: true,
+			},
+			{
+				Title: "Resource Type",
+				Value: finding.Resource.ResourceType,
+				Short: true,
+			},
+			{
+				Title: "Description",
+				Value: finding.Description,
+				Short: false,
+			},
+		},
+		Time: RawTimestamp(event.Time),
+		ConsoleURL: GuardDutyFindingConsoleURL(event.Region, finding.Id),
+		Resources: event.Resources,
+		IncidentKey: finding.Arn,
+	}
+
+	// Findings about an instance join its thread, alongside its state changes and Autoscaling activities
+	if instanceID := finding.Resource.InstanceDetails.InstanceId; instanceID != "" {
+		notification.Fields = append(notification.Fields, notify.Field {
+			Title: "instance-id",
+			Value: instanceID,
+			Short: true,
+		})
+
+		notification = withInstanceThread(notification, event.AccountID, instanceID)
+	}
+
+	return notification
+}
@@ -0,0 +1,31 @@
+package events
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"testing"
+)
+
+func TestGuardDutySeverity(t *testing.T) {
+	tests := []struct {
+		severity float64
+		band string
+		expected string
+	}{
+		{0.1, "Low", notify.SeverityInfo},
+		{3.9, "Low", notify.SeverityInfo},
+		{4, "Medium", notify.SeverityWarn},
+		{6.9, "Medium", notify.SeverityWarn},
+		{7, "High", notify.SeverityCritical},
+		{8.9, "High", notify.SeverityCritical},
+		{9, "Critical", notify.SeverityCritical},
+		{10, "Critical", notify.SeverityCritical},
+	}
+
+	for _, test := range tests {
+		band, severity := GuardDutySeverity(test.severity)
+
+		if band != test.band || severity != test.expected {
+			t.Errorf("expected %v to be %s (%s), got %s (%s)", test.severity, test.band, test.expected, band, severity)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package events
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+)
+
+// Sentry webhook payloads, from internal integrations (issue alerts and issues) or the legacy webhook plugin
+
+type SentryWebhook struct {
+	Action string `json:"action"`
+	Data SentryWebhookData `json:"data"`
+	// Legacy webhook plugin
+	ID string `json:"id"`
+	Project string `json:"project"`
+	ProjectName string `json:"project_name"`
+	Level string `json:"level"`
+	Culprit string `json:"culprit"`
+	Message string `json:"message"`
+	URL string `json:"url"`
+	TriggeringRules []string `json:"triggering_rules"`
+	Event *SentryEvent `json:"event"`
+}
+
+type SentryWebhookData struct {
+	Event *SentryEvent `json:"event"`
+	Issue *SentryIssue `json:"issue"`
+	TriggeredRule string `json:"triggered_rule"`
+}
+
+type SentryEvent struct {
+	EventID string `json:"event_id"`
+	IssueID string `json:"issue_id"`
+	Title string `json:"title"`
+	Culprit string `json:"culprit"`
+	Level string `json:"level"`
+	Environment string `json:"environment"`
+	WebURL string `json:"web_url"`
+}
+
+type SentryIssue struct {
+	ID string `json:"id"`
+	ShortID string `json:"shortId"`
+	Title string `json:"title"`
+	Culprit string `json:"culprit"`
+	Level string `json:"level"`
+	Status string `json:"status"`
+	Count string `json:"count"`
+	Permalink string `json:"permalink"`
+	Project SentryProject `json:"project"`
+}
+
+type SentryProject struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// Sentry levels, from fatal to debug
+var sentrySeverities = map[string]string{
+	"fatal": notify.SeverityCritical,
+	"error": notify.SeverityError,
+	"warning": notify.SeverityWarn,
+	"info": notify.SeverityInfo,
+	"debug": notify.SeverityInfo,
+}
+
+// The parts of an issue alert we display, whichever format it came in
+type SentryAlert struct {
+	IssueID string
+	Project string
+	Title string
+	Culprit string
+	Level string
+	Environment string
+	Count string
+	Rule string
+	URL string
+}
+
+func SentryNotification(alert SentryAlert, action string) notify.Notification {
+	severity := notify.SeverityError
+	if mapped, exists := sentrySeverities[alert.Level]; exists {
+		severity = mapped
+	}
+
+	title := alert.Title
+	if alert.Project != "" {
+		title = "[" + alert.Project + "] " + title
+	}
+
+	if action == notify.ThreadResolve {
+		title = "RESOLVED: " + title
+		severity = notify.SeveritySuccess
+	}
+
+	summary := alert.Culprit
+	if summary == "" {
+		summary = alert.Title
+	}
+
+	fields := []notify.Field {
+		{
+			Title: alert.Title,
+			Value: alert.Culprit,
+			Short: false,
+		},
+	}
+
+	optional := []notify.Field {
+		{Title: "Project", Value: alert.Project, Short: true},
+		{Title: "Level", Value: alert.Level, Short: true},
+		{Title: "Environment", Value: alert.Environment, Short: true},
+		{Title: "Events", Value: alert.Count, Short: true},
+		{Title: "Rule", Value: alert.Rule, Short: true},
+		{Title: "Link", Value: alert.URL, Short: true},
+	}
+
+	details := make(map[string]string)
+
+	for _, field := range optional {
+		if field.Value != "" {
+			fields = append(fields, field)
+			details[field.Title] = field.Value
+		}
+	}
+
+	return notify.Notification {
+		Source: "sentry",
+		DetailType: "Issue Alert",
+		Title: title,
+		Summary: summary,
+		Severity: severity,
+		Fields: fields,
+		// Alerts for the same issue (and its resolution) are grouped together, and only page once
+		ThreadKey: "sentry/" + alert.IssueID,
+		ThreadAction: action,
+		IncidentKey: "sentry" + alert.IssueID,
+		Details: details,
+	}
+}
@@ -0,0 +1,202 @@
+package events
+
+import (
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strings"
+	"time"
+)
+
+// The SNS message as delivered to SQS (or HTTP endpoints), which also has the SubscribeURL of
+// subscription confirmations
+type SNSMessage struct {
+	lambdaevents.SNSEntity
+	SubscribeURL string `json:"SubscribeURL"` // Only for SubscriptionConfirmation messages
+}
+
+// Alarms published to SNS, with the transition looked up from the alarm history passed in
+func SNSAlarmNotification(message SNSMessage, alarm CloudwatchAlarm, transition string) notify.Notification {
+	isFailing := strings.Contains(message.Subject, "ALARM:")
+
+	fields := []notify.Field {
+		{
+			Title: message.Subject,
+			Value: alarm.NewStateReason,
+			Short: false,
+		},
+	}
+
+	if observed := ObservedVsThreshold(alarm); observed != "" {
+		fields = append(fields, notify.Field {
+			Title: "Value",
+			Value: observed,
+			Short: false,
+		})
+	}
+
+	fields = append(fields, notify.Field {
+		Title: "Transition",
+		Value: transition,
+		Short: false,
+	})
+
+	for _, d := range alarm.Trigger.Dimensions {
+		fields = append(fields, notify.Field {
+			Title: d.Name,
+			Value: d.Value,
+			Short: true,
+		})
+	}
+
+	var severity string
+	if isFailing {
+		severity = notify.SeverityError
+	} else if alarm.NewStateValue == "INSUFFICIENT_DATA" {
+		severity = notify.SeverityWarn
+	} else {
+		severity = notify.SeveritySuccess
+	}
+
+	fields = append(fields, notify.Field {
+		Title: "Namespace",
+		Value: alarm.Trigger.Namespace,
+		Short: true,
+	})
+
+	fields = append(fields, notify.Field {
+		Title: "MetricName",
+		Value: alarm.Trigger.MetricName,
+		Short: true,
+	})
+
+	region := RegionFromARN(alarm.AlarmArn)
+	if region == "" {
+		region = RegionFromARN(message.TopicArn)
+	}
+
+	incidentKey := "incident"
+	detailFields := make(map[string]string)
+
+	for _, dv := range alarm.Trigger.Dimensions {
+		incidentKey += dv.Value
+		detailFields[dv.Name] = dv.Value
+	}
+
+	// Slack shows the time relative to when it's rendered, but incidents only get the details
+	if changed := RelativeTimestamp(alarm.StateChangeTime, time.Now()); changed != "" {
+		detailFields["StateChanged"] = changed
+	}
+
+	notification := notify.Notification {
+		Source: "aws.cloudwatch",
+		DetailType: "Alarm",
+		Account: alarm.AWSAccountId,
+		Region: region,
+		AlarmName: alarm.AlarmName,
+		Event: TemplateData(alarm),
+		Title: message.Subject,
+		Summary: alarm.NewStateReason,
+		Severity: severity,
+		Fields: fields,
+		Time: alarm.StateChangeTime,
+		ConsoleURL: AlarmConsoleURL(region, alarm.AlarmName),
+		Resources: []string{alarm.AlarmArn},
+		// Subsequent transitions for the same alarm are grouped with the ALARM that started it
+		ThreadKey: alarm.AWSAccountId + "/" + alarm.AlarmName,
+		IncidentKey: incidentKey,
+		Details: detailFields,
+	}
+
+	if isFailing {
+		notification.ThreadAction = notify.ThreadStart
+	} else if alarm.NewStateValue == "OK" {
+		notification.ThreadAction = notify.ThreadResolve
+	} else {
+		notification.ThreadAction = notify.ThreadReply
+	}
+
+	return notification
+}
+
+// Treated as a plain message for now
+func RDSNotification(message SNSMessage) notify.Notification {
+	return notify.Notification {
+		Source: "aws.rds",
+		DetailType: "RDS Notification Message",
+		Account: AccountFromARN(message.TopicArn),
+		Region: RegionFromARN(message.TopicArn),
+		Event: TemplateData(message),
+		Title: message.Subject,
+		Summary: message.Message,
+		Severity: notify.SeverityInfo,
+		Fields: []notify.Field {
+			{
+				Title: message.Subject,
+				Value: message.Message,
+				Short: false,
+			},
+		},
+		Time: RawTimestamp(message.Timestamp),
+		ConsoleURL: RDSConsoleURL(message),
+	}
+}
+
+// Basic processing for all other (plain) SNS messages
+func SNSNotification(message SNSMessage) notify.Notification {
+	return notify.Notification {
+		Source: "aws:sns",
+		DetailType: "Notification",
+		Account: AccountFromARN(message.TopicArn),
+		Region: RegionFromARN(message.TopicArn),
+		Event: TemplateData(message),
+		Title: message.Subject,
+		Summary: message.Message,
+		Severity: notify.SeverityInfo,
+		Fields: []notify.Field {
+			{
+				Title: message.Subject,
+				Value: message.Message,
+				Short: false,
+			},
+		},
+		Time: RawTimestamp(message.Timestamp),
+		ConsoleURL: SNSTopicConsoleURL(RegionFromARN(message.TopicArn), message.TopicArn),
+		DedupeKey: message.TopicArn + "\n" + message.Message,
+	}
+}
+
+// Sent once a subscription has been confirmed, so that it doesn't go unnoticed
+func SNSSubscriptionNotification(message SNSMessage) notify.Notification {
+	region := RegionFromARN(message.TopicArn)
+
+	return notify.Notification {
+		Source: "aws:sns",
+		DetailType: "Subscription Confirmation",
+		Account: AccountFromARN(message.TopicArn),
+		Region: region,
+		Event: TemplateData(message),
+		Title: "Confirmed subscription to SNS topic",
+		Summary: "Confirmed subscription to " + message.TopicArn,
+		Severity: notify.SeverityInfo,
+		Fields: []notify.Field {
+			{
+				Title: "Topic",
+				Value: message.TopicArn,
+				Short: false,
+			},
+		},
+		Time: RawTimestamp(message.Timestamp),
+		ConsoleURL: SNSTopicConsoleURL(region, message.TopicArn),
+	}
+}
+
+// Returns the value of a (String) message attribute, or an empty string if it isn't set
+func SNSAttribute(message SNSMessage, name string) string {
+	attribute, ok := message.MessageAttributes[name].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	value, _ := attribute["Value"].(string)
+	return value
+}
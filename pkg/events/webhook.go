@@ -0,0 +1,66 @@
+package events
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+)
+
+// Payloads of the generic webhook, for tools without a handler of their own
+
+type GenericWebhook struct {
+	Source string `json:"source"`
+	Title string `json:"title"`
+	Text string `json:"text"`
+	Severity string `json:"severity"` // Defaults to info
+	URL string `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+// The payload is expected to be validated (and its defaults filled in) by the caller
+func GenericWebhookNotification(payload GenericWebhook) notify.Notification {
+	summary := payload.Text
+	if summary == "" {
+		summary = payload.Title
+	}
+
+	fields := []notify.Field {
+		{
+			Title: payload.Title,
+			Value: payload.Text,
+			Short: false,
+		},
+	}
+
+	// Sorted, since maps don't keep the order they were sent in
+	var names []string
+	for name := range payload.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fields = append(fields, notify.Field {
+			Title: name,
+			Value: payload.Fields[name],
+			Short: true,
+		})
+	}
+
+	if payload.URL != "" {
+		fields = append(fields, notify.Field {
+			Title: "Link",
+			Value: payload.URL,
+			Short: true,
+		})
+	}
+
+	return notify.Notification {
+		Source: payload.Source,
+		DetailType: "Webhook",
+		Event: TemplateData(payload),
+		Title: payload.Title,
+		Summary: summary,
+		Severity: payload.Severity,
+		Fields: fields,
+	}
+}
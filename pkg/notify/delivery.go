@@ -0,0 +1,135 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Shared by all notifiers for delivering notifications over HTTP
+
+// Used by notifiers which aren't given their own client. Kept around across warm invocations, so that
+// connections to Slack and Pagerduty can be reused.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notifiers use the shared client unless they are given their own (like one for an httptest server)
+func ClientOrShared(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+
+	return HTTPClient
+}
+
+// Like client.Post, but cancelled along with the context (ie. when the Lambda deadline approaches)
+func PostWithContext(ctx context.Context, client *http.Client, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	return ClientOrShared(client).Do(req.WithContext(ctx))
+}
+
+const DeliveryMaxAttempts = 3
+const DeliveryMaxRetryWait = 30 * time.Second
+
+// Retries have to finish this long before the Lambda deadline, so that the invocation can wrap up cleanly
+const DeliveryDeadlineMargin = 2 * time.Second
+
+// Errors which know whether they are worth retrying (network errors, rate limiting, server errors)
+type temporaryError interface {
+	Temporary() bool
+}
+
+// Errors which know how long the other end asked us to back off for
+type retryAfterError interface {
+	retryAfter() time.Duration
+}
+
+// Returned for failed HTTP requests, with enough information to decide whether to retry
+type HTTPError struct {
+	Service string // Like "Pagerduty"
+	StatusCode int // HTTP status code, or 0 if we didn't get a response
+	RetryAfter time.Duration // How long we were asked to back off for (when rate limited)
+	Err error // Underlying error, if we didn't get a response
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return e.Service + " request failed - got error: " + e.Err.Error()
+	}
+
+	return e.Service + " request failed with status " + strconv.Itoa(e.StatusCode)
+}
+
+// Timeouts, connection resets and the like surface as errors without a response
+func (e *HTTPError) Temporary() bool {
+	return e.Err != nil || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func (e *HTTPError) retryAfter() time.Duration {
+	return e.RetryAfter
+}
+
+// Returns an *HTTPError for any non-2xx response
+func CheckHTTPResponse(service string, res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+
+	return &HTTPError{
+		Service: service,
+		StatusCode: res.StatusCode,
+		RetryAfter: time.Duration(retryAfter) * time.Second,
+	}
+}
+
+// Makes up to DeliveryMaxAttempts while the error is temporary, backing off exponentially (or as
+// instructed by Retry-After) with jitter. Gives up early if waiting would run past the deadline of ctx.
+func RetryDelivery(ctx context.Context, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+
+		temporary, ok := err.(temporaryError)
+		if err == nil || !ok || !temporary.Temporary() || attempt == DeliveryMaxAttempts {
+			return err
+		}
+
+		var wait time.Duration
+		if r, ok := err.(retryAfterError); ok {
+			wait = r.retryAfter()
+		}
+
+		if wait == 0 {
+			wait = time.Duration(1 << uint(attempt - 1)) * time.Second
+		}
+
+		if wait > DeliveryMaxRetryWait {
+			Logger(ctx).Warn("Not retrying, as the wait is too long", "error", err.Error(), "wait", wait.String())
+			return err
+		}
+
+		wait += time.Duration(rand.Int63n(int64(500 * time.Millisecond)))
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait + DeliveryDeadlineMargin {
+			Logger(ctx).Warn("Not retrying, as the invocation is about to time out", "error", err.Error())
+			return err
+		}
+
+		Logger(ctx).Info("Retrying", "error", err.Error(), "attempt", attempt, "wait", wait.String())
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// Returns a context carrying the logger, which notifiers log what they're sending with
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// Returns the logger for the context, or the default logger if it doesn't have one
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+
+	return slog.Default()
+}
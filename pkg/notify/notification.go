@@ -0,0 +1,83 @@
+// Package notify has the channel agnostic description of notifications, which handlers produce and notifiers
+// render, along with the Notifier interface and the severities notifications are classified by.
+package notify
+
+import (
+	"context"
+)
+
+// How a notification relates to earlier notifications with the same ThreadKey
+const ThreadNone = ""
+const ThreadStart = "start" // Starts a new thread (eg. an alarm going into ALARM)
+const ThreadReply = "reply" // Follow-up on an existing thread
+const ThreadResolve = "resolve" // Resolution of an existing thread (eg. an alarm going back to OK)
+const ThreadCorrelate = "correlate" // Joins the thread if it was started recently, or starts a new one (eg. events for the same instance)
+
+// Channel agnostic description of something worth notifying about - handlers produce these,
+// and each Notifier renders them in its own format
+type Notification struct {
+	Source string // Event source, like "aws.ec2" or "aws.cloudwatch" (for alarms)
+	DetailType string // Event type within the source
+	Account string // AWS account ID the event originated from
+	AccountName string // Name configured for the account (if any)
+	Region string // AWS region (code) the event originated from
+	AlarmName string // Only set for alarms (Cloudwatch Alarms, and alerts from monitoring tools like Alertmanager)
+	Event interface{} // The original event as generic maps (decoded from JSON)
+	Title string // Short title, like the subject of an alarm
+	Summary string // One line summary, for places where fields can't be displayed
+	Severity string // One of SeverityInfo, SeveritySuccess, SeverityWarn, SeverityError or SeverityCritical
+	Color string // Overrides the color derived from the severity, where supported
+	Fields []Field
+	Time string // Raw event timestamp
+	ConsoleURL string // Link to the relevant page of the AWS Management Console
+	RunbookURL string // Link to the runbook for dealing with the notification
+	ImageURL string // Image to show along with the notification (like a graph of an alarm's metric), where supported
+	Images []Image // Further images to show after the notification, where supported
+	Resources []string // ARNs of the resources involved
+	ThreadKey string // Related notifications are grouped by this key, where supported
+	ThreadAction string
+	Actions []Action // Interactive buttons, where supported
+	IncidentKey string // Used for de-duplicating incidents
+	DedupeKey string // What tells repeats of the event apart from other events of its type (like an instance and its state)
+	Details map[string]string // Extra details to attach to incidents
+	Template string // Name of the message template to use, instead of the one for the event type
+	Channels []string // Channels requested by the publisher, overriding routing
+	Page bool // Page someone (where supported), regardless of the severity
+	Catalog Catalog `json:"-"` // Translations for the channel it's being sent to, for labels added by notifiers
+}
+
+type Field struct {
+	Title string
+	Value string
+	Short bool
+	Code bool // Preformatted text (like pretty-printed JSON), shown as a code block where supported
+}
+
+type Image struct {
+	Title string
+	URL string
+}
+
+type Action struct {
+	CallbackId string // Identifies the handler for the action on the interactivity ingest path
+	Name string
+	Text string
+	Value string
+	Style string
+}
+
+type Notifier interface {
+	Send(ctx context.Context, notification Notification) error
+}
+
+// Translations of labels, keyed by the English text
+type Catalog map[string]string
+
+// Returns the text as is if there's no translation for it
+func (c Catalog) Translate(text string) string {
+	if translated, exists := c[text]; exists && translated != "" {
+		return translated
+	}
+
+	return text
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"bytes"
+	"errors"
+	"net/http"
+)
+
+type PagerdutyIncidentDetails struct {
+	Fields map[string]string `json:"fields"`
+}
+
+type PagerdutyIncident struct {
+	Description string `json:"description"`
+	IncidentKey string `json:"incident_key"`
+	Details PagerdutyIncidentDetails `json:"details"`
+	Contexts []PagerdutyContext `json:"contexts,omitempty"`
+}
+
+// Links (or images) shown on the incident
+type PagerdutyContext struct {
+	Type string `json:"type"`
+	Href string `json:"href,omitempty"`
+	Src string `json:"src,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+type PagerdutyIncidentRequest struct {
+	ServiceKey string `json:"service_key"`
+	EventType string `json:"event_type"`
+	Description string `json:"description"`
+	IncidentKey string `json:"incident_key"`
+	Client string `json:"client"`
+	Details PagerdutyIncidentDetails `json:"details"`
+	Contexts []PagerdutyContext `json:"contexts,omitempty"`
+}
+
+const PagerdutyEventsURL = "https://events.pagerduty.com/generic/2010-04-15/create_event.json"
+
+type PagerdutyNotifier struct {
+	ServiceKey string
+	MinSeverity string // Empty for the default (error)
+	Client *http.Client // Uses the shared client if nil
+	EventsURL string
+}
+
+// An empty minSeverity means the default (error)
+func NewPagerdutyNotifier(serviceKey string, minSeverity string) *PagerdutyNotifier {
+	return &PagerdutyNotifier{
+		ServiceKey: serviceKey,
+		MinSeverity: minSeverity,
+		EventsURL: PagerdutyEventsURL,
+	}
+}
+
+// Only notifications which warrant paging someone (errors or worse, by default, or ones explicitly asking
+// for it) trigger an incident
+func (p *PagerdutyNotifier) Send(ctx context.Context, notification Notification) error {
+	minSeverity := p.MinSeverity
+	if minSeverity == "" {
+		minSeverity = SeverityError
+	}
+
+	if !notification.Page && !SeverityAtLeast(notification.Severity, minSeverity) {
+		return nil
+	}
+
+	incident := PagerdutyIncident {
+		Description: notification.Title + "-" + notification.Summary,
+		IncidentKey: notification.IncidentKey,
+		Details: PagerdutyIncidentDetails{
+			Fields: notification.Details,
+		},
+	}
+
+	if notification.RunbookURL != "" {
+		incident.Contexts = append(incident.Contexts, PagerdutyContext {
+			Type: "link",
+			Href: notification.RunbookURL,
+			Text: "Runbook",
+		})
+	}
+
+	return p.triggerIncident(ctx, incident)
+}
+
+func (p *PagerdutyNotifier) triggerIncident(ctx context.Context, incident PagerdutyIncident) error {
+	Logger(ctx).Debug("Triggering Pagerduty incident...")
+
+	req := PagerdutyIncidentRequest {
+		ServiceKey: p.ServiceKey,
+		EventType: "trigger",
+		Description: incident.Description,
+		IncidentKey: incident.IncidentKey,
+		Client: "AWS Event Processor",
+		Details: incident.Details,
+		Contexts: incident.Contexts,
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return errors.New("failed to marshal Pagerduty request: " + err.Error())
+	}
+
+	err = RetryDelivery(ctx, func() error {
+		res, err := PostWithContext(
+			ctx,
+			p.Client,
+			p.EventsURL,
+			"application/json",
+			bytes.NewBuffer(payload))
+
+		if err != nil {
+			return &HTTPError{Service: "Pagerduty", Err: err}
+		}
+		defer res.Body.Close()
+
+		return CheckHTTPResponse("Pagerduty", res)
+	})
+
+	// Returned as is, so that callers can tell whether it's temporary
+	if err != nil {
+		Logger(ctx).Error("Failed to trigger Pagerduty incident", "error", err.Error())
+		return err
+	}
+
+	Logger(ctx).Info("Pagerduty incident triggered", "incident_key", incident.IncidentKey)
+
+	return nil
+}
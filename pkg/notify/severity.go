@@ -0,0 +1,28 @@
+package notify
+
+// Handlers classify each notification by severity, and notifiers derive everything else from that
+// (colors, prefixes, mentions, whether to page someone), so that it can be tuned in one place
+const SeverityInfo = "info"
+const SeveritySuccess = "success"
+const SeverityWarn = "warn"
+const SeverityError = "error"
+const SeverityCritical = "critical"
+
+// Lowest to highest. Success ranks with info, since it's good news.
+var severityRanks = map[string]int{
+	SeverityInfo: 0,
+	SeveritySuccess: 0,
+	SeverityWarn: 1,
+	SeverityError: 2,
+	SeverityCritical: 3,
+}
+
+func ValidSeverity(severity string) bool {
+	_, exists := severityRanks[severity]
+	return exists
+}
+
+// Whether the severity is the same as, or higher than the threshold
+func SeverityAtLeast(severity string, threshold string) bool {
+	return severityRanks[severity] >= severityRanks[threshold]
+}
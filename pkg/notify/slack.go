@@ -0,0 +1,785 @@
+package notify
+
+import (
+	"net/http"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ColorInfo = "#00BFFF" // Deep Sky Blue
+const ColorSuccess = "#00FF00" // Lime
+const ColorWarn = "#FFD700" // Gold
+const ColorError = "#DC143C" // Crimson
+const ColorCritical = "#8B0000" // Dark Red
+
+// Emoji prefixed to messages, keyed by the severity implied by their color
+var DefaultSeverityPrefixes = map[string]string{
+	"critical": ":rotating_light:",
+	"error": ":fire:",
+	"warn": ":warning:",
+	"success": ":white_check_mark:",
+}
+
+func severityForColor(color string) string {
+	switch color {
+	case ColorCritical:
+		return SeverityCritical
+	case ColorError:
+		return SeverityError
+	case ColorWarn:
+		return SeverityWarn
+	case ColorSuccess:
+		return SeveritySuccess
+	default:
+		return SeverityInfo
+	}
+}
+
+func colorForSeverity(severity string) string {
+	switch severity {
+	case SeverityCritical:
+		return ColorCritical
+	case SeverityError:
+		return ColorError
+	case SeverityWarn:
+		return ColorWarn
+	case SeveritySuccess:
+		return ColorSuccess
+	default:
+		return ColorInfo
+	}
+}
+
+const SlackAPIURL = "https://slack.com/api/"
+
+// Events for the same instance (like an autoscaling activity, and the state changes of the instance) are
+// grouped into one thread if they arrive within this long of the first one
+const DefaultCorrelationWindow = 5 * time.Minute
+
+type SlackMessage struct {
+	Source string `json:"-"` // Event source the message was generated for, used to pick the identity
+	DetailType string `json:"-"` // Event type the message was generated for, used to pick the template
+	Template string `json:"-"` // Explicitly selected template, overriding the one for the event type
+	Severity string `json:"-"` // Severity of the notification, for when it can't be told from the color
+	Event interface{} `json:"-"` // The original event as generic maps, which templates are rendered against
+	Channel string `json:"channel,omitempty"`
+	ThreadTs string `json:"thread_ts,omitempty"`
+	Text string `json:"text,omitempty"`
+	ReplaceOriginal bool `json:"replace_original,omitempty"`
+	Username string `json:"username,omitempty"`
+	IconEmoji string `json:"icon_emoji,omitempty"`
+	IconUrl string `json:"icon_url,omitempty"`
+	Attachments []SlackAttachment `json:"attachments"`
+}
+
+// Username and icon to post messages as, instead of the defaults set up for the webhook/app
+type SlackIdentity struct {
+	Username string `json:"username"`
+	IconEmoji string `json:"icon_emoji"`
+	IconUrl string `json:"icon_url"`
+}
+
+type SlackAttachment struct {
+	Fallback string `json:"fallback"`
+	Title string `json:"title,omitempty"`
+	Color string `json:"color"`
+	Fields []SlackField `json:"fields"`
+	MrkdwnIn []string `json:"mrkdwn_in,omitempty"`
+	CallbackId string `json:"callback_id,omitempty"`
+	Actions []SlackAction `json:"actions,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+// Interactive button attached to a message - clicks are sent to the interactivity ingest path
+type SlackAction struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+	Type string `json:"type"`
+	Value string `json:"value"`
+	Style string `json:"style,omitempty"`
+}
+
+type SlackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool `json:"short"`
+	code bool // Wrapped in a code block just before sending, so that truncation doesn't cut off the closing fence
+}
+
+type SlackUpdateRequest struct {
+	Channel string `json:"channel"`
+	Ts string `json:"ts"`
+	Attachments []SlackAttachment `json:"attachments"`
+}
+
+// Response returned by the Slack Web API methods
+type SlackAPIResponse struct {
+	Ok bool `json:"ok"`
+	Error string `json:"error"`
+	Channel string `json:"channel"`
+	Ts string `json:"ts"`
+	UploadUrl string `json:"upload_url"`
+	FileId string `json:"file_id"`
+}
+
+const WebhookFormatAttachments = "attachments"
+const WebhookFormatWorkflow = "workflow" // Flat key/value format expected by Workflow Builder web hooks
+
+type SlackNotifier struct {
+	Webhook string
+	WebhookFormat string
+	Token string // Bot token for the Slack Web API - used instead of the webhook when set
+	Channel string
+	SigningSecret string // Used for verifying requests sent to the interactivity ingest path
+	Threads ThreadStore // Where threads are recorded, for replying to them (optional)
+	UpdateOnResolve bool // Edit the original ALARM message on resolution, instead of posting a reply
+	CorrelationWindow time.Duration // How long correlated notifications are replied to the same thread for
+	Mention string // Default mention added to error messages (eg. "@here")
+	ChannelMentions map[string]string // Per-channel overrides for the above
+	Identities map[string]SlackIdentity // Identities keyed by event source, with "default" as fallback
+	Templates MessageRenderer // Message templates for the event types (optional)
+	SeverityPrefixes map[string]string
+	Times *TimeFormatter
+	MaxValueLength int // Field values longer than this are truncated
+	Payloads PayloadStore // Where to store full payloads for truncated messages (optional)
+	Client *http.Client // Uses the shared client if nil
+	APIURL string // Base URL of the Web API, with a trailing slash
+	OnUnauthorized func() // Called when the web hook or token is rejected, like to reload rotated secrets (optional)
+}
+
+// Rewrites messages before they're posted, like with the message template for their event type
+type MessageRenderer interface {
+	Render(msg SlackMessage) SlackMessage
+}
+
+// Stores full event payloads somewhere, for messages which had to be truncated, and returns a link to them
+type PayloadStore interface {
+	Store(source string, payload interface{}) (string, error)
+}
+
+// Posts via the web hook, or via the Web API if a token is given. Everything else is left at its defaults,
+// and can be set on the returned notifier.
+func NewSlackNotifier(webhook string, token string) *SlackNotifier {
+	return &SlackNotifier{
+		Webhook: webhook,
+		WebhookFormat: WebhookFormatAttachments,
+		Token: token,
+		SeverityPrefixes: DefaultSeverityPrefixes,
+		MaxValueLength: DefaultMaxValueLength,
+		APIURL: SlackAPIURL,
+		CorrelationWindow: DefaultCorrelationWindow,
+	}
+}
+
+func (n *SlackNotifier) Send(ctx context.Context, notification Notification) error {
+	msg := n.renderNotification(notification)
+
+	var err error
+
+	switch notification.ThreadAction {
+	case ThreadStart:
+		err = n.startThread(ctx, notification.ThreadKey, msg)
+	case ThreadReply:
+		err = n.replyInThread(ctx, notification.ThreadKey, msg)
+	case ThreadResolve:
+		err = n.resolveThread(ctx, notification.ThreadKey, msg)
+	case ThreadCorrelate:
+		err = n.correlateInThread(ctx, notification.ThreadKey, msg)
+	default:
+		err = n.sendMessage(ctx, msg)
+	}
+
+	// The web hook or token may have been rotated since we fetched it
+	if slackError, ok := err.(*SlackError); ok && slackError.Unauthorized() && n.OnUnauthorized != nil {
+		n.OnUnauthorized()
+	}
+
+	return err
+}
+
+func (n *SlackNotifier) renderNotification(notification Notification) SlackMessage {
+	attachment := SlackAttachment {
+		Fallback: notification.Summary,
+		Color: colorForSeverity(notification.Severity),
+		ImageURL: notification.ImageURL,
+	}
+
+	if notification.Color != "" {
+		attachment.Color = notification.Color
+	}
+
+	for _, f := range notification.Fields {
+		attachment.Fields = append(attachment.Fields, SlackField {
+			Title: f.Title,
+			Value: f.Value,
+			Short: f.Short,
+			code: f.Code,
+		})
+	}
+
+	for _, a := range notification.Actions {
+		attachment.CallbackId = a.CallbackId
+		attachment.Actions = append(attachment.Actions, SlackAction {
+			Name: a.Name,
+			Text: a.Text,
+			Type: "button",
+			Value: a.Value,
+			Style: a.Style,
+		})
+	}
+
+	msg := SlackMessage {
+		Source: notification.Source,
+		DetailType: notification.DetailType,
+		Template: notification.Template,
+		Severity: notification.Severity,
+		Event: notification.Event,
+		Attachments: []SlackAttachment{attachment},
+	}
+
+	// One attachment per image, since each can only have one
+	for _, image := range notification.Images {
+		msg.Attachments = append(msg.Attachments, SlackAttachment {
+			Fallback: image.Title,
+			Title: image.Title,
+			Color: attachment.Color,
+			ImageURL: image.URL,
+		})
+	}
+
+	if notification.Time != "" {
+		addField(&msg, n.Times.field("Time", notification.Time))
+	}
+
+	addConsoleLink(&msg, notification.ConsoleURL)
+	addRunbookLink(&msg, notification.RunbookURL)
+
+	// Labels of the fields added here still need translating
+	for i := range msg.Attachments[0].Fields {
+		msg.Attachments[0].Fields[i].Title = notification.Catalog.Translate(msg.Attachments[0].Fields[i].Title)
+	}
+
+	return msg
+}
+
+func (n *SlackNotifier) render(msg SlackMessage) SlackMessage {
+	if n.Templates == nil {
+		return msg
+	}
+
+	return n.Templates.Render(msg)
+}
+
+func (n *SlackNotifier) sendMessage(ctx context.Context, msg SlackMessage) error {
+	_, err := n.postMessage(ctx, msg)
+	return err
+}
+
+// Sends the message via the Web API if we have a token, or the webhook otherwise. Returns the
+// timestamp of the posted message, which is only available via the Web API.
+func (n *SlackNotifier) postMessage(ctx context.Context, msg SlackMessage) (string, error) {
+	msg = n.withMention(n.withIdentity(n.withSeverityPrefix(n.render(msg))))
+
+	// Long values are truncated, with the full payload made available via S3 (if configured),
+	// or uploaded into the thread of the message (when using the Web API)
+	msg, truncated := truncateMessage(msg, n.MaxValueLength)
+	msg = renderCodeBlocks(msg)
+	uploadToThread := false
+
+	if truncated && msg.Event != nil {
+		if n.Payloads != nil {
+			link, err := n.Payloads.Store(msg.Source, msg.Event)
+			if err != nil {
+				Logger(ctx).Warn("Could not store full payload", "error", err.Error())
+			} else {
+				addField(&msg, SlackField{Title: "Full Payload", Value: "<" + link + "|View in S3>", Short: true})
+			}
+		} else if n.Token != "" {
+			addField(&msg, SlackField{Title: "Full Payload", Value: "Attached in thread", Short: true})
+			uploadToThread = true
+		}
+	}
+
+	if n.Token == "" {
+		return "", n.sendWebhookMessage(ctx, msg)
+	}
+
+	Logger(ctx).Debug("Posting Slack message via Web API...")
+
+	if msg.Channel == "" {
+		msg.Channel = n.Channel
+	}
+
+	apiRes, err := n.callAPI(ctx, "chat.postMessage", msg)
+	if err != nil {
+		return "", err
+	}
+
+	Logger(ctx).Info("Slack message posted")
+
+	if uploadToThread {
+		threadTs := msg.ThreadTs
+		if threadTs == "" {
+			threadTs = apiRes.Ts
+		}
+
+		if err := n.uploadPayload(ctx, apiRes.Channel, threadTs, msg.Event); err != nil {
+			Logger(ctx).Warn("Could not upload full payload to Slack", "error", err.Error())
+		}
+	}
+
+	return apiRes.Ts, nil
+}
+
+// Replaces the contents of a previously posted message
+func (n *SlackNotifier) updateMessage(ctx context.Context, channel string, ts string, msg SlackMessage) error {
+	Logger(ctx).Debug("Updating Slack message via Web API...")
+
+	req := SlackUpdateRequest {
+		Channel: channel,
+		Ts: ts,
+		Attachments: msg.Attachments,
+	}
+
+	if _, err := n.callAPI(ctx, "chat.update", req); err != nil {
+		return err
+	}
+
+	Logger(ctx).Info("Slack message updated")
+
+	return nil
+}
+
+// Sets the username and icon configured for the event source of the message
+func (n *SlackNotifier) withIdentity(msg SlackMessage) SlackMessage {
+	identity, exists := n.Identities[msg.Source]
+	if !exists {
+		identity, exists = n.Identities["default"]
+	}
+
+	if !exists {
+		return msg
+	}
+
+	msg.Username = identity.Username
+	msg.IconEmoji = identity.IconEmoji
+	msg.IconUrl = identity.IconUrl
+
+	return msg
+}
+
+// Prefixes the fallback (used in notifications) and the first field title of each attachment
+// with the emoji/text configured for its severity
+func (n *SlackNotifier) withSeverityPrefix(msg SlackMessage) SlackMessage {
+	attachments := make([]SlackAttachment, len(msg.Attachments))
+
+	for i, a := range msg.Attachments {
+		severity := msg.Severity
+		if severity == "" {
+			severity = severityForColor(a.Color)
+		}
+
+		prefix := n.SeverityPrefixes[severity]
+
+		if prefix != "" {
+			a.Fallback = prefix + " " + a.Fallback
+
+			if len(a.Fields) != 0 {
+				a.Fields = append([]SlackField{}, a.Fields...)
+				a.Fields[0].Title = prefix + " " + a.Fields[0].Title
+			}
+		}
+
+		attachments[i] = a
+	}
+
+	msg.Attachments = attachments
+
+	return msg
+}
+
+// Prepends the configured mention for the target channel to messages reporting an error (or worse)
+func (n *SlackNotifier) withMention(msg SlackMessage) SlackMessage {
+	isError := SeverityAtLeast(msg.Severity, SeverityError)
+	for _, a := range msg.Attachments {
+		if SeverityAtLeast(severityForColor(a.Color), SeverityError) {
+			isError = true
+		}
+	}
+
+	if !isError {
+		return msg
+	}
+
+	channel := msg.Channel
+	if channel == "" {
+		channel = n.Channel
+	}
+
+	mention, exists := n.ChannelMentions[channel]
+	if !exists {
+		mention = n.Mention
+	}
+
+	if mention == "" {
+		return msg
+	}
+
+	if msg.Text == "" {
+		msg.Text = formatMention(mention)
+	} else {
+		msg.Text = formatMention(mention) + " " + msg.Text
+	}
+
+	return msg
+}
+
+// Converts the human-friendly mention config (@here, @channel, subteam^ID, a user ID) into
+// Slack's markup for special mentions
+func formatMention(mention string) string {
+	if strings.HasPrefix(mention, "<") {
+		return mention
+	}
+
+	if contains([]string{"@here", "@channel", "@everyone"}, mention) {
+		return "<!" + strings.TrimPrefix(mention, "@") + ">"
+	}
+
+	if strings.HasPrefix(mention, "subteam^") || strings.HasPrefix(mention, "!subteam^") {
+		return "<!" + strings.TrimPrefix(mention, "!") + ">"
+	}
+
+	return "<@" + strings.TrimPrefix(mention, "@") + ">"
+}
+
+func (n *SlackNotifier) callAPI(ctx context.Context, method string, body interface{}) (SlackAPIResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return SlackAPIResponse{}, errors.New("Failed to marshal Slack API request: " + err.Error())
+	}
+
+	return n.doAPIRequest(ctx, method, "application/json; charset=utf-8", payload)
+}
+
+// Some API methods (like files.getUploadURLExternal) only accept form encoded arguments
+func (n *SlackNotifier) callAPIForm(ctx context.Context, method string, form url.Values) (SlackAPIResponse, error) {
+	return n.doAPIRequest(ctx, method, "application/x-www-form-urlencoded", []byte(form.Encode()))
+}
+
+func (n *SlackNotifier) doAPIRequest(ctx context.Context, method string, contentType string, payload []byte) (SlackAPIResponse, error) {
+	var apiRes SlackAPIResponse
+
+	err := RetryDelivery(ctx, func() error {
+		req, err := http.NewRequest("POST", n.APIURL + method, bytes.NewBuffer(payload))
+		if err != nil {
+			return errors.New("Failed to create Slack API request: " + err.Error())
+		}
+
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer " + n.Token)
+
+		res, err := ClientOrShared(n.Client).Do(req)
+		if err != nil {
+			return &SlackError{Err: err}
+		}
+		defer res.Body.Close()
+
+		if err := checkSlackResponse(res, ""); err != nil {
+			return err
+		}
+
+		if err := json.NewDecoder(res.Body).Decode(&apiRes); err != nil {
+			return errors.New("Failed to decode Slack API response: " + err.Error())
+		}
+
+		// The Web API reports errors with a 200 status code
+		if !apiRes.Ok {
+			return &SlackError{StatusCode: res.StatusCode, Code: apiRes.Error}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return apiRes, err
+	}
+
+	return apiRes, nil
+}
+
+// Posts the message and records it as the root of the thread for the given key, so that
+// later messages for the same key can be posted as replies
+func (n *SlackNotifier) startThread(ctx context.Context, key string, msg SlackMessage) error {
+	if msg.Channel == "" {
+		msg.Channel = n.Channel
+	}
+
+	ts, err := n.postMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	if n.Threads == nil || ts == "" {
+		return nil
+	}
+
+	return n.Threads.Put(key, SlackThread {
+		Ts: ts,
+		Channel: msg.Channel,
+		Attachments: msg.Attachments,
+		StartedAt: time.Now(),
+	})
+}
+
+// Posts the message as a reply to the thread recorded for the given key, or as a new
+// message if there isn't one
+func (n *SlackNotifier) replyInThread(ctx context.Context, key string, msg SlackMessage) error {
+	if thread := n.lookupThread(ctx, key); thread != nil {
+		msg.ThreadTs = thread.Ts
+	}
+
+	return n.sendMessage(ctx, msg)
+}
+
+// Posts the message as a reply to the thread recorded for the given key if it was started within the
+// correlation window, or starts a new thread otherwise
+func (n *SlackNotifier) correlateInThread(ctx context.Context, key string, msg SlackMessage) error {
+	if thread := n.lookupThread(ctx, key); thread != nil && time.Since(thread.StartedAt) < n.CorrelationWindow {
+		msg.ThreadTs = thread.Ts
+		return n.sendMessage(ctx, msg)
+	}
+
+	return n.startThread(ctx, key, msg)
+}
+
+// Marks the thread for the given key as resolved. If updateOnResolve is enabled, the original
+// message is edited in place (struck through and turned green) instead of posting a reply.
+func (n *SlackNotifier) resolveThread(ctx context.Context, key string, msg SlackMessage) error {
+	if !n.UpdateOnResolve {
+		return n.replyInThread(ctx, key, msg)
+	}
+
+	thread := n.lookupThread(ctx, key)
+	if thread == nil {
+		return n.sendMessage(ctx, msg)
+	}
+
+	resolved := SlackMessage {
+		Attachments: resolvedAttachments(thread.Attachments, msg.Attachments),
+	}
+
+	if err := n.updateMessage(ctx, thread.Channel, thread.Ts, resolved); err != nil {
+		Logger(ctx).Warn("Could not update original Slack message, posting reply instead", "error", err.Error())
+		msg.ThreadTs = thread.Ts
+		return n.sendMessage(ctx, msg)
+	}
+
+	return nil
+}
+
+func (n *SlackNotifier) lookupThread(ctx context.Context, key string) *SlackThread {
+	if n.Threads == nil || n.Token == "" {
+		return nil
+	}
+
+	thread, err := n.Threads.Get(key)
+	if err != nil {
+		Logger(ctx).Warn("Could not look up Slack thread", "thread_key", key, "error", err.Error())
+		return nil
+	}
+
+	return thread
+}
+
+// Turns the original attachments green, strikes through their values, and adds the
+// resolution fields underneath
+func resolvedAttachments(original []SlackAttachment, resolution []SlackAttachment) []SlackAttachment {
+	var attachments []SlackAttachment
+
+	for _, a := range original {
+		a.Color = ColorSuccess
+		a.MrkdwnIn = []string{"fields"}
+
+		var fields []SlackField
+		for _, f := range a.Fields {
+			// Strike through doesn't apply inside code blocks
+			if f.Value != "" && !strings.HasPrefix(f.Value, "```") {
+				f.Value = "~" + f.Value + "~"
+			}
+
+			fields = append(fields, f)
+		}
+
+		for _, r := range resolution {
+			fields = append(fields, r.Fields...)
+		}
+
+		a.Fields = fields
+		attachments = append(attachments, a)
+	}
+
+	return attachments
+}
+
+func (n *SlackNotifier) sendWebhookMessage(ctx context.Context, msg SlackMessage) error {
+	Logger(ctx).Debug("Sending Slack message...")
+
+	var body interface{} = msg
+	if n.WebhookFormat == WebhookFormatWorkflow {
+		body = workflowPayload(msg)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return errors.New("Failed to marshal Slack message: " + err.Error())
+	}
+
+	err = RetryDelivery(ctx, func() error {
+		res, err := PostWithContext(ctx, n.Client, n.Webhook, "application/json", bytes.NewBuffer(payload))
+		if err != nil {
+			return &SlackError{Err: err}
+		}
+		defer res.Body.Close()
+
+		// Web hooks respond with a plain text error string, like "invalid_payload"
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+
+		return checkSlackResponse(res, strings.TrimSpace(string(body)))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	Logger(ctx).Info("Slack message sent")
+
+	return nil
+}
+
+// Workflow Builder web hooks reject attachments, and only accept a flat object of string values,
+// which are mapped to the variables defined for the workflow. Fields are included under their
+// title in snake case (eg. "instance_id"), along with a few fixed keys.
+func workflowPayload(msg SlackMessage) map[string]string {
+	payload := map[string]string{
+		"source": msg.Source,
+		"detail_type": msg.DetailType,
+		"text": msg.Text,
+	}
+
+	var summary []string
+
+	for _, a := range msg.Attachments {
+		if payload["text"] == "" {
+			payload["text"] = a.Fallback
+		}
+
+		if _, exists := payload["color"]; !exists {
+			payload["color"] = a.Color
+		}
+
+		for _, f := range a.Fields {
+			payload[workflowVariableName(f.Title)] = f.Value
+			summary = append(summary, f.Title + ": " + f.Value)
+		}
+	}
+
+	// Everything in one, for workflows which just want to post the whole message
+	payload["message"] = strings.Join(summary, "\n")
+
+	return payload
+}
+
+func workflowVariableName(title string) string {
+	var name []rune
+	lastUnderscore := true
+
+	for _, r := range strings.ToLower(title) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			name = append(name, r)
+			lastUnderscore = false
+		} else if !lastUnderscore {
+			name = append(name, '_')
+			lastUnderscore = true
+		}
+	}
+
+	return strings.TrimSuffix(string(name), "_")
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Rate limiting and retries (see delivery.go)
+
+// Returned for failed Slack requests, with enough information to decide whether to retry
+type SlackError struct {
+	StatusCode int // HTTP status code, or 0 if we didn't get a response
+	Code string // Slack error string, like "invalid_payload" or "channel_not_found"
+	RetryAfter time.Duration // How long Slack asked us to back off for (when rate limited)
+	Err error // Underlying error, if we didn't get a response
+}
+
+func (e *SlackError) Error() string {
+	if e.Err != nil {
+		return "Slack request failed - got error: " + e.Err.Error()
+	}
+
+	msg := "Slack request failed with status " + strconv.Itoa(e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+
+	return msg
+}
+
+// Network errors, rate limiting and server errors are worth retrying, while any other failure
+// (invalid_payload, channel_not_found, etc.) is permanent
+func (e *SlackError) Temporary() bool {
+	return e.Err != nil || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 ||
+		e.Code == "ratelimited"
+}
+
+func (e *SlackError) retryAfter() time.Duration {
+	return e.RetryAfter
+}
+
+// Revoked web hooks get a 403 or 404, while the Web API reports invalid tokens via the error code
+func (e *SlackError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden ||
+		e.StatusCode == http.StatusNotFound ||
+		contains([]string{"invalid_auth", "not_authed", "token_revoked", "token_expired", "account_inactive"}, e.Code)
+}
+
+// Returns a *SlackError for any non-2xx response
+func checkSlackResponse(res *http.Response, slackError string) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+
+	return &SlackError{
+		StatusCode: res.StatusCode,
+		Code: slackError,
+		RetryAfter: time.Duration(retryAfter) * time.Second,
+	}
+}
+
+func contains(s []string, el string) bool {
+	for _, v := range s {
+		if v == el {
+			return true
+		}
+	}
+
+	return false
+}
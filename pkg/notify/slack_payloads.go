@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Slack starts mangling (or rejecting) messages with field values much longer than this
+const DefaultMaxValueLength = 2000
+
+const TruncationSuffix = "… (truncated)"
+
+func addField(msg *SlackMessage, field SlackField) {
+	if len(msg.Attachments) == 0 {
+		return
+	}
+
+	msg.Attachments[0].Fields = append(msg.Attachments[0].Fields, field)
+}
+
+// Cuts values longer than maxLength characters, and reports whether anything was truncated
+func truncateMessage(msg SlackMessage, maxLength int) (SlackMessage, bool) {
+	if maxLength <= 0 {
+		return msg, false
+	}
+
+	truncated := false
+	attachments := make([]SlackAttachment, len(msg.Attachments))
+
+	for i, a := range msg.Attachments {
+		fields := make([]SlackField, len(a.Fields))
+
+		for j, f := range a.Fields {
+			if utf8.RuneCountInString(f.Value) > maxLength {
+				f.Value = string([]rune(f.Value)[:maxLength]) + TruncationSuffix
+				truncated = true
+			}
+
+			fields[j] = f
+		}
+
+		a.Fields = fields
+		attachments[i] = a
+	}
+
+	msg.Attachments = attachments
+
+	if utf8.RuneCountInString(msg.Text) > maxLength {
+		msg.Text = string([]rune(msg.Text)[:maxLength]) + TruncationSuffix
+		truncated = true
+	}
+
+	return msg, truncated
+}
+
+// Wraps code fields in code blocks, enabling markdown for the fields of the attachment. Slack doesn't
+// highlight syntax, so the blocks don't name a language (which it would show as the first line).
+func renderCodeBlocks(msg SlackMessage) SlackMessage {
+	attachments := make([]SlackAttachment, len(msg.Attachments))
+
+	for i, a := range msg.Attachments {
+		fields := make([]SlackField, len(a.Fields))
+
+		for j, f := range a.Fields {
+			if f.code {
+				f.Value = "```\n" + f.Value + "\n```"
+				f.code = false
+
+				if !contains(a.MrkdwnIn, "fields") {
+					a.MrkdwnIn = append(append([]string{}, a.MrkdwnIn...), "fields")
+				}
+			}
+
+			fields[j] = f
+		}
+
+		a.Fields = fields
+		attachments[i] = a
+	}
+
+	msg.Attachments = attachments
+
+	return msg
+}
+
+// Adds a "View in Console" link to the first attachment of the message
+func addConsoleLink(msg *SlackMessage, link string) {
+	if link == "" {
+		return
+	}
+
+	addField(msg, SlackField {
+		Title: "Console",
+		Value: "<" + link + "|View in Console>",
+		Short: true,
+	})
+}
+
+// Adds an "Open Runbook" link to the first attachment of the message
+func addRunbookLink(msg *SlackMessage, link string) {
+	if link == "" {
+		return
+	}
+
+	addField(msg, SlackField {
+		Title: "Runbook",
+		Value: "<" + link + "|Open Runbook>",
+		Short: true,
+	})
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Uploads the payload as a JSON snippet into the given thread. See:
+// https://api.slack.com/messaging/files#uploading_files
+func (n *SlackNotifier) uploadPayload(ctx context.Context, channel string, threadTs string, payload interface{}) error {
+	body, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return errors.New("failed to marshal payload: " + err.Error())
+	}
+
+	Logger(ctx).Debug("Uploading full payload to Slack...")
+
+	upload, err := n.callAPIForm(ctx, "files.getUploadURLExternal", url.Values{
+		"filename": {"payload.json"},
+		"length": {strconv.Itoa(len(body))},
+		"snippet_type": {"json"},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = RetryDelivery(ctx, func() error {
+		res, err := PostWithContext(ctx, n.Client, upload.UploadUrl, "application/octet-stream", bytes.NewBuffer(body))
+		if err != nil {
+			return &HTTPError{Service: "Slack file upload", Err: err}
+		}
+		defer res.Body.Close()
+
+		return CheckHTTPResponse("Slack file upload", res)
+	})
+
+	if err != nil {
+		return errors.New("failed to upload payload: " + err.Error())
+	}
+
+	_, err = n.callAPI(ctx, "files.completeUploadExternal", map[string]interface{}{
+		"files": []map[string]string{{"id": upload.FileId, "title": "Full Payload"}},
+		"channel_id": channel,
+		"thread_ts": threadTs,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	Logger(ctx).Info("Full payload uploaded")
+
+	return nil
+}
@@ -0,0 +1,21 @@
+package notify
+
+import (
+	"time"
+)
+
+// The root message of a Slack thread, along with the original attachments so that it can be
+// edited later on
+type SlackThread struct {
+	Ts string
+	Channel string
+	Attachments []SlackAttachment
+	StartedAt time.Time // Zero for threads saved before this was recorded
+}
+
+// Keeps track of which Slack thread belongs to which thread key, so that later notifications with the same key
+// can be posted as replies
+type ThreadStore interface {
+	Get(key string) (*SlackThread, error) // Returns nil if there's no thread for the key
+	Put(key string, thread SlackThread) error
+}
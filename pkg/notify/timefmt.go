@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const DefaultTimeFormat = "2006-01-02 15:04:05 MST"
+
+// Layouts used for timestamps across the supported event types
+var timestampLayouts = []string{
+	time.RFC3339Nano, // Cloudwatch Events, SNS
+	"2006-01-02T15:04:05.000-0700", // Cloudwatch Alarms
+	"2006-01-02T15:04:05-0700",
+}
+
+// Renders event timestamps in the given timezone and format, along with a relative time
+type TimeFormatter struct {
+	location *time.Location
+	layout string
+	relative bool
+}
+
+// A nil location means UTC, and an empty layout means DefaultTimeFormat
+func NewTimeFormatter(location *time.Location, layout string, relative bool) *TimeFormatter {
+	if location == nil {
+		location = time.UTC
+	}
+
+	if layout == "" {
+		layout = DefaultTimeFormat
+	}
+
+	return &TimeFormatter{
+		location: location,
+		layout: layout,
+		relative: relative,
+	}
+}
+
+func ParseTimestamp(raw string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New("unsupported timestamp: " + raw)
+}
+
+// Falls back to the raw string if the timestamp cannot be parsed
+func (f *TimeFormatter) Format(raw string) string {
+	if f == nil {
+		return raw
+	}
+
+	t, err := ParseTimestamp(raw)
+	if err != nil {
+		return raw
+	}
+
+	formatted := t.In(f.location).Format(f.layout)
+
+	if f.relative {
+		formatted += " (" + RelativeTime(t, time.Now()) + ")"
+	}
+
+	return formatted
+}
+
+// Describes t relative to now, in the largest whole unit (eg. "3 minutes ago", "in 2 hours")
+func RelativeTime(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	var amount int64
+	var unit string
+
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		amount, unit = int64(d / time.Minute), "minute"
+	case d < 24 * time.Hour:
+		amount, unit = int64(d / time.Hour), "hour"
+	default:
+		amount, unit = int64(d / (24 * time.Hour)), "day"
+	}
+
+	if amount != 1 {
+		unit += "s"
+	}
+
+	if future {
+		return "in " + strconv.FormatInt(amount, 10) + " " + unit
+	}
+
+	return strconv.FormatInt(amount, 10) + " " + unit + " ago"
+}
+
+// Describes a duration in its largest unit, and the next one if that isn't zero (like "34s", "2m 5s", "1h" or "2d 4h")
+func HumanDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+
+	sizes := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	suffixes := []string{"d", "h", "m", "s"}
+
+	for i, size := range sizes {
+		if d < size && size != time.Second {
+			continue
+		}
+
+		formatted := strconv.FormatInt(int64(d / size), 10) + suffixes[i]
+
+		if i + 1 < len(sizes) {
+			if next := (d % size) / sizes[i + 1]; next != 0 {
+				formatted += " " + strconv.FormatInt(int64(next), 10) + suffixes[i + 1]
+			}
+		}
+
+		return formatted
+	}
+
+	return ""
+}
+
+func (f *TimeFormatter) field(title string, raw string) SlackField {
+	return SlackField {
+		Title: title,
+		Value: f.Format(raw),
+		Short: true,
+	}
+}
@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2019, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		t time.Time
+		expected string
+	}{
+		{"same instant", now, "just now"},
+		{"seconds ago", now.Add(-59 * time.Second), "just now"},
+		{"seconds ahead", now.Add(30 * time.Second), "just now"},
+		{"one minute ago", now.Add(-time.Minute), "1 minute ago"},
+		{"minutes ago", now.Add(-3 * time.Minute - 20 * time.Second), "3 minutes ago"},
+		{"one hour ago", now.Add(-time.Hour), "1 hour ago"},
+		{"hours ago", now.Add(-23 * time.Hour - 59 * time.Minute), "23 hours ago"},
+		{"one day ago", now.Add(-24 * time.Hour), "1 day ago"},
+		{"days ago", now.Add(-50 * time.Hour), "2 days ago"},
+		{"minutes ahead", now.Add(2 * time.Minute), "in 2 minutes"},
+		{"one hour ahead", now.Add(time.Hour), "in 1 hour"},
+		{"days ahead", now.Add(72 * time.Hour), "in 3 days"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := RelativeTime(test.t, now); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	expected := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		raw string
+		valid bool
+	}{
+		{"Cloudwatch Event", "2019-01-01T00:00:00Z", true},
+		{"SNS message", "2019-01-01T00:00:00.000Z", true},
+		{"Cloudwatch Alarm", "2019-01-01T00:00:00.000+0000", true},
+		{"Cloudwatch Alarm in another zone", "2019-01-01T01:00:00.000+0100", true},
+		{"without milliseconds", "2019-01-01T00:00:00+0000", true},
+		{"date only", "2019-01-01", false},
+		{"empty", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := ParseTimestamp(test.raw)
+
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected %q to be rejected, got %v", test.raw, actual)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected %q to parse, got: %v", test.raw, err)
+			}
+
+			if !actual.Equal(expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestTimeFormatterFormat(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone database available: " + err.Error())
+	}
+
+	tests := []struct {
+		name string
+		formatter *TimeFormatter
+		raw string
+		expected string
+	}{
+		{"defaults", NewTimeFormatter(nil, "", false), "2019-01-01T00:00:00Z", "2019-01-01 00:00:00 UTC"},
+		{"in another timezone", NewTimeFormatter(berlin, "", false), "2019-01-01T00:00:00Z", "2019-01-01 01:00:00 CET"},
+		{"with daylight saving", NewTimeFormatter(berlin, "", false), "2019-07-01T00:00:00Z", "2019-07-01 02:00:00 CEST"},
+		{"custom layout", NewTimeFormatter(nil, time.Kitchen, false), "2019-01-01T15:04:00Z", "3:04PM"},
+		{"unparseable timestamps are kept", NewTimeFormatter(berlin, "", false), "yesterday", "yesterday"},
+		{"without a formatter", nil, "2019-01-01T00:00:00Z", "2019-01-01T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.formatter.Format(test.raw); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		d time.Duration
+		expected string
+	}{
+		{"zero", 0, "0s"},
+		{"below a second", 300 * time.Millisecond, "0s"},
+		{"seconds", 34 * time.Second, "34s"},
+		{"one minute", time.Minute, "1m"},
+		{"minutes and seconds", 2 * time.Minute + 5 * time.Second, "2m 5s"},
+		{"seconds are dropped after hours", time.Hour + 5 * time.Second, "1h"},
+		{"hours and minutes", 3 * time.Hour + 20 * time.Minute + 10 * time.Second, "3h 20m"},
+		{"one day", 24 * time.Hour, "1d"},
+		{"days and hours", 52 * time.Hour + 30 * time.Minute, "2d 4h"},
+		{"weeks are days", 15 * 24 * time.Hour, "15d"},
+		{"negative", -90 * time.Second, "1m 30s"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := HumanDuration(test.d); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package route
+
+import (
+	"errors"
+	"github.com/google/cel-go/cel"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"log/slog"
+)
+
+// Conditions have the original event payload as "event" (with its "detail" also available by itself),
+// and the basic properties of the notification as "notification", like:
+//   event.source == 'aws.ec2' && detail.state in ['stopped', 'terminated']
+//   notification.account == '123456789012' && notification.alarm_name.startsWith('payments-')
+func CompileCondition(condition string) (cel.Program, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("event", cel.DynType),
+		cel.Variable("detail", cel.DynType),
+		cel.Variable("notification", cel.MapType(cel.StringType, cel.StringType)),
+	)
+	if err != nil {
+		return nil, errors.New("failed to create CEL environment: " + err.Error())
+	}
+
+	ast, issues := env.Compile(condition)
+	if issues != nil && issues.Err() != nil {
+		return nil, errors.New("invalid condition " + condition + ": " + issues.Err().Error())
+	}
+
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, errors.New("condition doesn't evaluate to a bool: " + condition)
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, errors.New("invalid condition " + condition + ": " + err.Error())
+	}
+
+	return program, nil
+}
+
+// Conditions which fail to evaluate (eg. because they refer to a field missing from the event) don't hold.
+// A nil program (no condition) always holds.
+func ConditionHolds(program cel.Program, condition string, notification notify.Notification) bool {
+	if program == nil {
+		return true
+	}
+
+	var detail interface{}
+	if event, ok := notification.Event.(map[string]interface{}); ok {
+		detail = event["detail"]
+	}
+
+	result, _, err := program.Eval(map[string]interface{}{
+		"event": notification.Event,
+		"detail": detail,
+		"notification": map[string]string{
+			"source": notification.Source,
+			"detail_type": notification.DetailType,
+			"account": notification.Account,
+			"account_name": notification.AccountName,
+			"region": notification.Region,
+			"alarm_name": notification.AlarmName,
+			"title": notification.Title,
+			"severity": notification.Severity,
+		},
+	})
+
+	if err != nil {
+		slog.Warn("Failed to evaluate condition", "condition", condition, "error", err.Error())
+		return false
+	}
+
+	holds, ok := result.Value().(bool)
+	return ok && holds
+}
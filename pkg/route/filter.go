@@ -0,0 +1,95 @@
+package route
+
+import (
+	"github.com/jmespath/go-jmespath"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"log/slog"
+)
+
+// Filters are applied before routing. If there are any allow rules, a notification has to match at
+// least one of them, and it's dropped if it matches any of the deny rules.
+type Filters struct {
+	Allow []FilterRule `json:"allow"`
+	Deny []FilterRule `json:"deny"`
+}
+
+func (f Filters) Allows(notification notify.Notification) bool {
+	if len(f.Allow) != 0 && !anyFilterMatches(f.Allow, notification) {
+		return false
+	}
+
+	return !anyFilterMatches(f.Deny, notification)
+}
+
+func anyFilterMatches(rules []FilterRule, notification notify.Notification) bool {
+	for _, rule := range rules {
+		if rule.Matches(notification) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// All set fields have to match. Values are patterns, where "*" matches any sequence of characters.
+type FilterRule struct {
+	Source string `json:"source"`
+	DetailType string `json:"detail_type"`
+	Account string `json:"account"`
+	Region string `json:"region"`
+	AlarmName string `json:"alarm_name"`
+	Expression string `json:"expression"` // JMESPath expression evaluated against the event, matching if the result is truthy
+	compiled *jmespath.JMESPath
+}
+
+// Compiles the expression (if there is one), which has to happen before the rule is matched against anything
+func (r *FilterRule) Compile() error {
+	if r.Expression == "" {
+		return nil
+	}
+
+	compiled, err := jmespath.Compile(r.Expression)
+	if err != nil {
+		return err
+	}
+
+	r.compiled = compiled
+	return nil
+}
+
+func (r FilterRule) Matches(notification notify.Notification) bool {
+	return MatchPattern(r.Source, notification.Source) &&
+		MatchPattern(r.DetailType, notification.DetailType) &&
+		MatchPattern(r.Account, notification.Account) &&
+		MatchPattern(r.Region, notification.Region) &&
+		MatchPattern(r.AlarmName, notification.AlarmName) &&
+		(r.compiled == nil || searchTruthy(r.compiled, notification.Event))
+}
+
+// Uses the JMESPath definition of truthiness: false, null, and empty strings, arrays and objects are false
+func searchTruthy(expression *jmespath.JMESPath, data interface{}) bool {
+	if data == nil {
+		return false
+	}
+
+	result, err := expression.Search(data)
+	if err != nil {
+		slog.Warn("Failed to evaluate filter expression", "error", err.Error())
+		return false
+	}
+
+	switch v := result.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) != 0
+	case map[string]interface{}:
+		return len(v) != 0
+	default:
+		return true
+	}
+}
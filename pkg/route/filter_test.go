@@ -0,0 +1,107 @@
+package route
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"testing"
+)
+
+func TestFiltersAllows(t *testing.T) {
+	notification := notify.Notification {
+		Source: "aws.ec2",
+		DetailType: "EC2 Instance State-change Notification",
+		Account: "123456789012",
+		Region: "eu-west-1",
+		Event: map[string]interface{}{
+			"detail": map[string]interface{}{"state": "stopped"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		filters Filters
+		allows bool
+	}{
+		{"no rules", Filters{}, true},
+		{"allowed", Filters{Allow: []FilterRule{{Source: "aws.*"}}}, true},
+		{"not allowed", Filters{Allow: []FilterRule{{Source: "aws.guardduty"}}}, false},
+		{"denied", Filters{Deny: []FilterRule{{Region: "eu-*"}}}, false},
+		{"allowed but denied", Filters{Allow: []FilterRule{{Source: "aws.ec2"}}, Deny: []FilterRule{{Account: "123456789012"}}}, false},
+		{"expression holds", Filters{Deny: []FilterRule{{Expression: "detail.state == 'stopped'"}}}, false},
+		{"expression doesn't hold", Filters{Deny: []FilterRule{{Expression: "detail.state == 'running'"}}}, true},
+		{"expression on a missing field", Filters{Allow: []FilterRule{{Expression: "detail.missing"}}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, rules := range [][]FilterRule{test.filters.Allow, test.filters.Deny} {
+				for i := range rules {
+					if err := rules[i].Compile(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if allows := test.filters.Allows(notification); allows != test.allows {
+				t.Errorf("expected allows: %v, got: %v", test.allows, allows)
+			}
+		})
+	}
+}
+
+func TestFilterRuleCompile(t *testing.T) {
+	rule := FilterRule{Expression: "detail.["}
+	if err := rule.Compile(); err == nil {
+		t.Error("expected an invalid expression to fail to compile")
+	}
+}
+
+func TestConditionHolds(t *testing.T) {
+	notification := notify.Notification {
+		Source: "aws.ec2",
+		Account: "123456789012",
+		AlarmName: "payments-latency",
+		Severity: notify.SeverityWarn,
+		Event: map[string]interface{}{
+			"source": "aws.ec2",
+			"detail": map[string]interface{}{"state": "terminated"},
+		},
+	}
+
+	tests := []struct {
+		condition string
+		holds bool
+	}{
+		{"", true},
+		{"event.source == 'aws.ec2' && detail.state in ['stopped', 'terminated']", true},
+		{"detail.state == 'running'", false},
+		{"notification.alarm_name.startsWith('payments-')", true},
+		{"notification.severity == 'critical'", false},
+		{"detail.missing == 'x'", false}, // Fails to evaluate
+	}
+
+	for _, test := range tests {
+		t.Run(test.condition, func(t *testing.T) {
+			var err error
+			program := cel.Program(nil)
+
+			if test.condition != "" {
+				if program, err = CompileCondition(test.condition); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if holds := ConditionHolds(program, test.condition, notification); holds != test.holds {
+				t.Errorf("expected holds: %v, got: %v", test.holds, holds)
+			}
+		})
+	}
+}
+
+func TestCompileConditionInvalid(t *testing.T) {
+	for _, condition := range []string{"detail.state ==", "'not a bool'"} {
+		if _, err := CompileCondition(condition); err == nil {
+			t.Errorf("expected %q to fail to compile", condition)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Package route has the rules for matching notifications (patterns, filters and CEL conditions), which the
+// routing config (routes, severity rules, sampling rules and the like) is built from.
+package route
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// All set fields have to match. Values are patterns, where "*" matches any sequence of characters.
+type Match struct {
+	Source string `json:"source"`
+	DetailType string `json:"detail_type"`
+	Title string `json:"title"`
+	Severity string `json:"severity"`
+	Region string `json:"region"` // Region code, like "eu-west-1"
+	Account string `json:"account"` // Account ID, or the name configured for it under accounts
+}
+
+func (m Match) Matches(notification notify.Notification) bool {
+	return MatchPattern(m.Source, notification.Source) &&
+		MatchPattern(m.DetailType, notification.DetailType) &&
+		MatchPattern(m.Title, notification.Title) &&
+		MatchPattern(m.Severity, notification.Severity) &&
+		MatchPattern(m.Region, notification.Region) &&
+		(MatchPattern(m.Account, notification.Account) || (notification.AccountName != "" && MatchPattern(m.Account, notification.AccountName)))
+}
+
+// Compiled patterns, keyed by the pattern. Patterns come from the routing config, so there are only ever a few
+// of them, while they're matched against every notification.
+var patterns sync.Map
+
+// An empty pattern matches anything
+func MatchPattern(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	return compilePattern(pattern).MatchString(value)
+}
+
+func compilePattern(pattern string) *regexp.Regexp {
+	if compiled, exists := patterns.Load(pattern); exists {
+		return compiled.(*regexp.Regexp)
+	}
+
+	// Everything but "*" is quoted, so the expression always compiles
+	compiled := regexp.MustCompile("^" + strings.Replace(regexp.QuoteMeta(pattern), "\\*", ".*", -1) + "$")
+	patterns.Store(pattern, compiled)
+
+	return compiled
+}
@@ -0,0 +1,75 @@
+package route
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value string
+		matches bool
+	}{
+		{"", "anything", true},
+		{"", "", true},
+		{"aws.ec2", "aws.ec2", true},
+		{"aws.ec2", "aws.ec2x", false},
+		{"aws.ec2", "xaws.ec2", false},
+		{"aws.*", "aws.ec2", true},
+		{"aws.*", "aws.", true},
+		{"aws.*", "custom.aws.ec2", false},
+		{"*Alarm*", "ALARM: \"cpu\" Alarm in eu-west-1", true},
+		{"*-prod-*", "payments-prod-eu", true},
+		{"*-prod-*", "payments-staging-eu", false},
+		// Everything but "*" is literal
+		{"aws.ec2", "awsxec2", false},
+		{"a+b", "a+b", true},
+		{"a+b", "aab", false},
+		{"[prod]", "[prod]", true},
+		{"[prod]", "p", false},
+		{"(", "(", true},
+	}
+
+	for _, test := range tests {
+		// Twice, so that the compiled pattern gets used as well
+		for i := 0; i < 2; i++ {
+			if matches := MatchPattern(test.pattern, test.value); matches != test.matches {
+				t.Errorf("expected %q matching %q to be %v", test.pattern, test.value, test.matches)
+			}
+		}
+	}
+}
+
+func TestMatchMatches(t *testing.T) {
+	notification := notify.Notification {
+		Source: "aws.cloudwatch",
+		DetailType: "Alarm",
+		Title: "ALARM: \"cpu-high\"",
+		Severity: notify.SeverityError,
+		Region: "eu-west-1",
+		Account: "123456789012",
+		AccountName: "production",
+	}
+
+	tests := []struct {
+		name string
+		match Match
+		matches bool
+	}{
+		{"empty", Match{}, true},
+		{"all fields", Match{Source: "aws.cloudwatch", DetailType: "Alarm", Title: "ALARM:*", Severity: "error", Region: "eu-*", Account: "123456789012"}, true},
+		{"one field differs", Match{Source: "aws.cloudwatch", Severity: "critical"}, false},
+		{"account by name", Match{Account: "prod*"}, true},
+		{"another account", Match{Account: "staging"}, false},
+		{"another region", Match{Region: "us-*"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matches := test.match.Matches(notification); matches != test.matches {
+				t.Errorf("expected matches: %v, got: %v", test.matches, matches)
+			}
+		})
+	}
+}
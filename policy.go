@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/open-policy-agent/opa/rego"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/**
+Example routing policy (bundled with the function as policy.rego):
+
+package notifier
+
+default decision := {}
+
+decision := {"suppress": true} {
+	input.notification.source == "aws.ec2"
+	input.event.detail.state == "pending"
+}
+
+decision := {"channels": ["payments", "oncall"], "severity": "error", "page": true} {
+	startswith(input.notification.alarm_name, "payments-")
+}
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const DefaultPolicyQuery = "data.notifier.decision"
+
+// What the policy decides for a notification. Everything left out is decided by the routing config as usual.
+type PolicyDecision struct {
+	Suppress bool `json:"suppress"`
+	Channels []string `json:"channels"` // Overrides the channels from the routing config
+	Severity string `json:"severity"`
+	Page bool `json:"page"`
+}
+
+// Evaluates routing decisions against a Rego policy, with the notification (and the original event) as input
+type RoutingPolicy struct {
+	query rego.PreparedEvalQuery
+}
+
+// Reads the policy from routing_policy (relative to the function's root, if it's not an absolute path), and
+// prepares routing_policy_query against it. Returns nil if there is no policy.
+func loadRoutingPolicy(ctx context.Context) (*RoutingPolicy, error) {
+	path, exists := lookupSetting("routing_policy")
+	if !exists {
+		return nil, nil
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(os.Getenv("LAMBDA_TASK_ROOT"), path)
+	}
+
+	module, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read routing policy: " + err.Error())
+	}
+
+	query := DefaultPolicyQuery
+	if value, exists := lookupSetting("routing_policy_query"); exists {
+		query = value
+	}
+
+	prepared, err := rego.New(
+		rego.Query(query),
+		rego.Module(filepath.Base(path), string(module)),
+	).PrepareForEval(ctx)
+
+	if err != nil {
+		return nil, errors.New("invalid routing policy: " + err.Error())
+	}
+
+	return &RoutingPolicy{query: prepared}, nil
+}
+
+// Returns nil if the policy doesn't make a decision for the notification (the query is undefined)
+func (p *RoutingPolicy) decide(ctx context.Context, notification notify.Notification, channels []string) (*PolicyDecision, error) {
+	input := map[string]interface{}{
+		"event": notification.Event,
+		"notification": map[string]interface{}{
+			"source": notification.Source,
+			"detail_type": notification.DetailType,
+			"account": notification.Account,
+			"account_name": notification.AccountName,
+			"region": notification.Region,
+			"alarm_name": notification.AlarmName,
+			"title": notification.Title,
+			"summary": notification.Summary,
+			"severity": notification.Severity,
+			"channels": channels, // As routed by the config
+		},
+	}
+
+	results, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, errors.New("failed to evaluate routing policy: " + err.Error())
+	}
+
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, nil
+	}
+
+	// Round-trip via JSON, rather than picking the generic result apart
+	raw, err := json.Marshal(results[0].Expressions[0].Value)
+	if err != nil {
+		return nil, errors.New("failed to marshal routing policy decision: " + err.Error())
+	}
+
+	var decision PolicyDecision
+	if err := json.Unmarshal(raw, &decision); err != nil {
+		return nil, errors.New("invalid routing policy decision: " + err.Error())
+	}
+
+	if decision.Severity != "" && !notify.ValidSeverity(decision.Severity) {
+		return nil, errors.New("invalid severity in routing policy decision: " + decision.Severity)
+	}
+
+	return &decision, nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Applies the policy decision on top of the routing config. Returns false if the notification is suppressed.
+// If the policy fails to evaluate, the routing config decides on its own, rather than dropping the notification.
+func (r *NotifierRegistry) applyPolicy(ctx context.Context, notification notify.Notification, names []string) (notify.Notification, []string, bool) {
+	if r.policy == nil {
+		return notification, names, true
+	}
+
+	decision, err := r.policy.decide(ctx, notification, names)
+	if err != nil {
+		logger(ctx).Warn(err.Error(), "title", notification.Title)
+		return notification, names, true
+	} else if decision == nil {
+		return notification, names, true
+	}
+
+	if decision.Suppress {
+		return notification, names, false
+	}
+
+	if len(decision.Channels) != 0 {
+		names = decision.Channels
+	}
+
+	if decision.Severity != "" {
+		notification.Severity = decision.Severity
+	}
+
+	if decision.Page {
+		notification.Page = true
+	}
+
+	return notification, names, true
+}
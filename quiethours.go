@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// During quiet hours, notifications to the destination below the minimum severity are queued, and
+// only sent once quiet hours are over. Start and end are times of day like "22:00" and "07:00".
+type QuietHours struct {
+	Start string `json:"start"`
+	End string `json:"end"`
+	TimeZone string `json:"time_zone"` // Defaults to UTC
+	MinSeverity string `json:"min_severity"` // Lowest severity to send straight away, defaults to error
+	start int // Minutes since midnight
+	end int
+	location *time.Location
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New("invalid time of day " + value + ": " + err.Error())
+	}
+
+	return t.Hour() * 60 + t.Minute(), nil
+}
+
+func (q *QuietHours) compile() error {
+	var err error
+
+	if q.start, err = parseTimeOfDay(q.Start); err != nil {
+		return err
+	}
+
+	if q.end, err = parseTimeOfDay(q.End); err != nil {
+		return err
+	}
+
+	q.location = time.UTC
+	if q.TimeZone != "" {
+		if q.location, err = time.LoadLocation(q.TimeZone); err != nil {
+			return errors.New("invalid quiet hours time_zone: " + err.Error())
+		}
+	}
+
+	if q.MinSeverity == "" {
+		q.MinSeverity = notify.SeverityError
+	} else if !notify.ValidSeverity(q.MinSeverity) {
+		return errors.New("invalid quiet hours min_severity: " + q.MinSeverity)
+	}
+
+	return nil
+}
+
+// Quiet hours can span midnight (eg. 22:00 - 07:00)
+func (q *QuietHours) active(now time.Time) bool {
+	local := now.In(q.location)
+	minute := local.Hour() * 60 + local.Minute()
+
+	if q.start <= q.end {
+		return minute >= q.start && minute < q.end
+	}
+
+	return minute >= q.start || minute < q.end
+}
+
+func (q *QuietHours) holds(notification notify.Notification, now time.Time) bool {
+	return q.active(now) && !notify.SeverityAtLeast(notification.Severity, q.MinSeverity)
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Notifications held back by quiet hours (or an open circuit breaker) are queued in a DynamoDB table (with
+// a string hash key called "queue_key"), and sent by the "Notification Queue" scheduled task once quiet hours
+// are over (and the circuit breaker is closed). They are given up on after QueueMaxAttempts failed sends.
+type NotificationQueue struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+// Returns nil if quiet_hours_table isn't set
+func newNotificationQueueFromSettings(sess *session.Session) *NotificationQueue {
+	table, exists := lookupSetting("quiet_hours_table")
+	if !exists {
+		return nil
+	}
+
+	return &NotificationQueue{
+		db: dynamodb.New(sess),
+		table: table,
+	}
+}
+
+const QueueMaxAttempts = 5
+
+type QueuedNotification struct {
+	Key string
+	Channel string
+	Notification notify.Notification
+	Attempts int // How many times sending (or resending) it has failed
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Notification Queue",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.queue == nil {
+				return nil
+			}
+
+			return notifiers.flushQueue(ctx)
+		},
+	})
+}
+
+func (q *NotificationQueue) add(channel string, notification notify.Notification) error {
+	encoded, err := json.Marshal(notification)
+	if err != nil {
+		return errors.New("failed to marshal queued notification: " + err.Error())
+	}
+
+	_, err = q.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(q.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"queue_key": {S: aws.String(channel + "/" + strconv.FormatInt(time.Now().UnixNano(), 10))},
+			"channel": {S: aws.String(channel)},
+			"notification": {S: aws.String(string(encoded))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to queue notification in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+func (q *NotificationQueue) list() ([]QueuedNotification, error) {
+	var queued []QueuedNotification
+	var decodeErr error
+
+	err := q.db.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(q.table),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			entry := QueuedNotification {
+				Key: aws.StringValue(item["queue_key"].S),
+			}
+
+			if item["channel"] != nil {
+				entry.Channel = aws.StringValue(item["channel"].S)
+			}
+
+			if item["attempts"] != nil {
+				entry.Attempts, _ = strconv.Atoi(aws.StringValue(item["attempts"].N))
+			}
+
+			if item["notification"] != nil {
+				if err := json.Unmarshal([]byte(aws.StringValue(item["notification"].S)), &entry.Notification); err != nil {
+					decodeErr = errors.New("failed to unmarshal queued notification: " + err.Error())
+					return false
+				}
+			}
+
+			queued = append(queued, entry)
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read notification queue from DynamoDB: " + err.Error())
+	}
+
+	return queued, decodeErr
+}
+
+// Records another failed attempt at sending a queued notification
+func (q *NotificationQueue) recordAttempt(key string) error {
+	_, err := q.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(q.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"queue_key": {S: aws.String(key)},
+		},
+		UpdateExpression: aws.String("ADD attempts :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to record attempt in DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+func (q *NotificationQueue) remove(key string) error {
+	_, err := q.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(q.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"queue_key": {S: aws.String(key)},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to remove queued notification from DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+// Sends (in the order they were queued) the notifications for destinations which are no longer in quiet
+// hours, and don't have an open circuit breaker. Notifications failing to send are left for the next run,
+// without holding back the ones queued after them.
+func (r *NotifierRegistry) flushQueue(ctx context.Context) error {
+	queued, err := r.queue.list()
+	if err != nil {
+		return err
+	}
+
+	// Keys end with the time they were queued at
+	sort.Slice(queued, func(i, j int) bool { return queued[i].Key < queued[j].Key })
+
+	now := time.Now()
+
+	for _, entry := range queued {
+		if r.config != nil {
+			if quiet, exists := r.config.QuietHours[entry.Channel]; exists && quiet.active(now) {
+				continue
+			}
+		}
+
+		if r.breaker != nil {
+			if open, _, err := r.breaker.state(entry.Channel); err == nil && open {
+				continue
+			}
+		}
+
+		notifier, exists := r.notifiers[entry.Channel]
+		if !exists {
+			logger(ctx).Warn("Dropping queued notification for unknown channel", "channel", entry.Channel)
+		} else if err := notifier.Send(ctx, entry.Notification); err != nil {
+			if entry.Attempts + 1 < QueueMaxAttempts {
+				logger(ctx).Warn("Failed to send queued notification, will try again later", "channel", entry.Channel,
+					"attempts", entry.Attempts + 1, "error", err.Error())
+
+				if err := r.queue.recordAttempt(entry.Key); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			logger(ctx).Error("Giving up on queued notification", "channel", entry.Channel, "attempts", QueueMaxAttempts,
+				"title", entry.Notification.Title)
+		}
+
+		if err := r.queue.remove(entry.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
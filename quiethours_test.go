@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/motns/aws-notifier/pkg/notify"
+	"testing"
+	"time"
+)
+
+func TestQuietHoursActive(t *testing.T) {
+	tests := []struct {
+		name string
+		start string
+		end string
+		timeZone string
+		now time.Time
+		active bool
+	}{
+		// Within a day
+		{"before daytime hours", "09:00", "17:00", "", time.Date(2019, 1, 1, 8, 59, 0, 0, time.UTC), false},
+		{"start of daytime hours", "09:00", "17:00", "", time.Date(2019, 1, 1, 9, 0, 0, 0, time.UTC), true},
+		{"during daytime hours", "09:00", "17:00", "", time.Date(2019, 1, 1, 12, 30, 0, 0, time.UTC), true},
+		{"end of daytime hours", "09:00", "17:00", "", time.Date(2019, 1, 1, 17, 0, 0, 0, time.UTC), false},
+
+		// Spanning midnight
+		{"evening before overnight hours", "22:00", "07:00", "", time.Date(2019, 1, 1, 21, 59, 0, 0, time.UTC), false},
+		{"start of overnight hours", "22:00", "07:00", "", time.Date(2019, 1, 1, 22, 0, 0, 0, time.UTC), true},
+		{"just before midnight", "22:00", "07:00", "", time.Date(2019, 1, 1, 23, 59, 0, 0, time.UTC), true},
+		{"midnight", "22:00", "07:00", "", time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC), true},
+		{"early morning", "22:00", "07:00", "", time.Date(2019, 1, 2, 6, 59, 0, 0, time.UTC), true},
+		{"end of overnight hours", "22:00", "07:00", "", time.Date(2019, 1, 2, 7, 0, 0, 0, time.UTC), false},
+		{"middle of the day", "22:00", "07:00", "", time.Date(2019, 1, 2, 12, 0, 0, 0, time.UTC), false},
+
+		// Empty when start and end are the same
+		{"same start and end", "22:00", "22:00", "", time.Date(2019, 1, 1, 22, 0, 0, 0, time.UTC), false},
+
+		// In the configured time zone (UTC+1 in winter, UTC+2 in summer)
+		{"in time zone, before", "22:00", "07:00", "Europe/Berlin", time.Date(2019, 1, 1, 20, 59, 0, 0, time.UTC), false},
+		{"in time zone, during", "22:00", "07:00", "Europe/Berlin", time.Date(2019, 1, 1, 21, 0, 0, 0, time.UTC), true},
+		{"in time zone, after", "22:00", "07:00", "Europe/Berlin", time.Date(2019, 1, 2, 6, 0, 0, 0, time.UTC), false},
+		{"in time zone, summer", "22:00", "07:00", "Europe/Berlin", time.Date(2019, 7, 1, 20, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quiet := &QuietHours{Start: test.start, End: test.end, TimeZone: test.timeZone}
+			if err := quiet.compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			if active := quiet.active(test.now); active != test.active {
+				t.Errorf("expected active: %v, got: %v", test.active, active)
+			}
+		})
+	}
+}
+
+func TestQuietHoursHolds(t *testing.T) {
+	night := time.Date(2019, 1, 1, 23, 0, 0, 0, time.UTC)
+	day := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		minSeverity string
+		severity string
+		now time.Time
+		holds bool
+	}{
+		{"info at night", "", notify.SeverityInfo, night, true},
+		{"warning at night", "", notify.SeverityWarn, night, true},
+		{"error at night", "", notify.SeverityError, night, false},
+		{"critical at night", "", notify.SeverityCritical, night, false},
+		{"info during the day", "", notify.SeverityInfo, day, false},
+		{"error below a critical minimum", notify.SeverityCritical, notify.SeverityError, night, true},
+		{"warning at a warning minimum", notify.SeverityWarn, notify.SeverityWarn, night, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quiet := &QuietHours{Start: "22:00", End: "07:00", MinSeverity: test.minSeverity}
+			if err := quiet.compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			if holds := quiet.holds(notify.Notification{Severity: test.severity}, test.now); holds != test.holds {
+				t.Errorf("expected holds: %v, got: %v", test.holds, holds)
+			}
+		})
+	}
+}
+
+func TestQuietHoursCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		quiet QuietHours
+		valid bool
+	}{
+		{"valid", QuietHours{Start: "22:00", End: "07:00", TimeZone: "Europe/London", MinSeverity: notify.SeverityWarn}, true},
+		{"invalid start", QuietHours{Start: "10pm", End: "07:00"}, false},
+		{"invalid end", QuietHours{Start: "22:00", End: "25:00"}, false},
+		{"missing end", QuietHours{Start: "22:00"}, false},
+		{"invalid time zone", QuietHours{Start: "22:00", End: "07:00", TimeZone: "Mars/Olympus_Mons"}, false},
+		{"invalid min severity", QuietHours{Start: "22:00", End: "07:00", MinSeverity: "urgent"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.quiet.compile()
+			if test.valid && err != nil {
+				t.Errorf("expected the quiet hours to be valid, got: %v", err)
+			} else if !test.valid && err == nil {
+				t.Error("expected the quiet hours to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const DefaultRateLimitPerMinute = 10
+
+// How many times to retry when another invocation updated the same bucket in the meantime
+const RateLimitMaxAttempts = 5
+
+// Token bucket for a destination: it holds up to burst tokens, and refills at perMinute tokens a minute
+type RateLimit struct {
+	perMinute float64
+	burst float64
+}
+
+// Keeps the token bucket of each rate limited destination in a DynamoDB table (with a string hash key
+// called "limit_key"), so that the limits apply across concurrent invocations
+type RateLimiter struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+// Returns nil if rate_limit_table isn't set, along with the limit for Slack
+func newRateLimiterFromSettings(sess *session.Session) (*RateLimiter, RateLimit, error) {
+	limit := RateLimit{perMinute: DefaultRateLimitPerMinute}
+
+	table, exists := lookupSetting("rate_limit_table")
+	if !exists {
+		return nil, limit, nil
+	}
+
+	var err error
+
+	if perMinute, exists := lookupSetting("rate_limit_per_minute"); exists {
+		if limit.perMinute, err = strconv.ParseFloat(perMinute, 64); err != nil {
+			return nil, limit, errors.New("could not parse rate_limit_per_minute: " + err.Error())
+		}
+	}
+
+	limit.burst = defaultBurst(limit.perMinute)
+
+	if burst, exists := lookupSetting("rate_limit_burst"); exists {
+		if limit.burst, err = strconv.ParseFloat(burst, 64); err != nil {
+			return nil, limit, errors.New("could not parse rate_limit_burst: " + err.Error())
+		}
+
+		if limit.burst < 1 {
+			return nil, limit, errors.New("rate_limit_burst has to be at least 1: " + burst)
+		}
+	}
+
+	return &RateLimiter{db: dynamodb.New(sess), table: table}, limit, nil
+}
+
+// The burst defaults to a minute's worth of messages, but a bucket holding less than a token would drop everything
+// (with fractional rates like 0.5 a minute)
+func defaultBurst(perMinute float64) float64 {
+	if perMinute < 1 {
+		return 1
+	}
+
+	return perMinute
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Rate Limits",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.limiter == nil {
+				return nil
+			}
+
+			return notifiers.reportSuppressed(ctx)
+		},
+	})
+}
+
+// Tells each rate limited channel how many notifications were suppressed since the last one that got through, so
+// that the count isn't held back until the next allowed message (which may not come for a long time after a storm)
+func (r *NotifierRegistry) reportSuppressed(ctx context.Context) error {
+	var names []string
+	for name := range r.rateLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures MultiError
+
+	for _, name := range names {
+		notifier, exists := r.notifiers[name]
+		if !exists {
+			continue
+		}
+
+		suppressed, err := r.limiter.flush(name, r.rateLimits[name])
+		if err != nil {
+			failures.add("channel " + name, err)
+			continue
+		} else if suppressed == 0 {
+			continue
+		}
+
+		logger(ctx).Info("Reporting notifications suppressed by rate limit", "channel", name, "suppressed", suppressed)
+
+		if err := r.sendVia(ctx, name, notifier, suppressedNotification(suppressed)); err != nil {
+			failures.add("channel " + name, err)
+		}
+	}
+
+	return failures.errorOrNil()
+}
+
+func suppressedNotification(suppressed int) notify.Notification {
+	title := "Rate Limited - " + strconv.Itoa(suppressed) + " notification(s) suppressed"
+
+	return notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Rate Limit",
+		Title: title,
+		Summary: title,
+		Severity: notify.SeverityWarn,
+		Fields: []notify.Field {
+			{
+				Title: "Rate Limited",
+				Value: strconv.Itoa(suppressed) + " more suppressed",
+				Short: true,
+			},
+		},
+		Time: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// A token bucket as read from DynamoDB, refilled up to now
+type rateLimitBucket struct {
+	tokens float64
+	suppressed int
+	updatedAt string // As stored, empty if the bucket doesn't exist yet
+	now time.Time
+}
+
+func (l *RateLimiter) read(key string, limit RateLimit) (rateLimitBucket, error) {
+	res, err := l.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(l.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"limit_key": {S: aws.String(key)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+
+	if err != nil {
+		return rateLimitBucket{}, errors.New("failed to read rate limit from DynamoDB: " + err.Error())
+	}
+
+	bucket := rateLimitBucket{tokens: limit.burst, now: time.Now()}
+
+	if item := res.Item; item != nil && item["updated_at"] != nil && item["updated_at"].N != nil {
+		bucket.updatedAt = *item["updated_at"].N
+		lastUpdate, _ := strconv.ParseInt(bucket.updatedAt, 10, 64)
+
+		if item["tokens"] != nil && item["tokens"].N != nil {
+			bucket.tokens, _ = strconv.ParseFloat(*item["tokens"].N, 64)
+		}
+
+		if item["suppressed"] != nil && item["suppressed"].N != nil {
+			bucket.suppressed, _ = strconv.Atoi(*item["suppressed"].N)
+		}
+
+		elapsed := bucket.now.Sub(time.Unix(0, lastUpdate * int64(time.Millisecond)))
+		bucket.tokens += elapsed.Minutes() * limit.perMinute
+		if bucket.tokens > limit.burst {
+			bucket.tokens = limit.burst
+		}
+	}
+
+	return bucket, nil
+}
+
+// Returns false if another invocation updated the bucket since it was read
+func (l *RateLimiter) write(key string, bucket rateLimitBucket, tokens float64, suppressed int) (bool, error) {
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"limit_key": {S: aws.String(key)},
+			"tokens": {N: aws.String(strconv.FormatFloat(tokens, 'f', 3, 64))},
+			"suppressed": {N: aws.String(strconv.Itoa(suppressed))},
+			"updated_at": {N: aws.String(strconv.FormatInt(bucket.now.UnixNano() / int64(time.Millisecond), 10))},
+		},
+	}
+
+	// Only write if nobody else has updated the bucket since we read it
+	if bucket.updatedAt == "" {
+		input.ConditionExpression = aws.String("attribute_not_exists(limit_key)")
+	} else {
+		input.ConditionExpression = aws.String("updated_at = :updated_at")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":updated_at": {N: aws.String(bucket.updatedAt)},
+		}
+	}
+
+	_, err := l.db.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	} else if err != nil {
+		return false, errors.New("failed to update rate limit in DynamoDB: " + err.Error())
+	}
+
+	return true, nil
+}
+
+// Takes a token from the bucket of the destination, and returns whether there was one. When there is,
+// it also returns how many notifications were suppressed since the last one that got through.
+func (l *RateLimiter) take(key string, limit RateLimit) (bool, int, error) {
+	for attempt := 1; attempt <= RateLimitMaxAttempts; attempt++ {
+		bucket, err := l.read(key, limit)
+		if err != nil {
+			return false, 0, err
+		}
+
+		tokens := bucket.tokens
+		suppressed := bucket.suppressed
+
+		allowed := tokens >= 1
+		if allowed {
+			tokens--
+		} else {
+			suppressed++
+		}
+
+		stored := suppressed
+		if allowed {
+			stored = 0
+		}
+
+		written, err := l.write(key, bucket, tokens, stored)
+		if err != nil {
+			return false, 0, err
+		} else if !written {
+			continue
+		}
+
+		if allowed {
+			return true, suppressed, nil
+		}
+
+		return false, 0, nil
+	}
+
+	return false, 0, errors.New("failed to update rate limit in DynamoDB: too much contention")
+}
+
+// Resets the count of suppressed notifications in the bucket of the destination (without taking a token), and
+// returns what it was
+func (l *RateLimiter) flush(key string, limit RateLimit) (int, error) {
+	for attempt := 1; attempt <= RateLimitMaxAttempts; attempt++ {
+		bucket, err := l.read(key, limit)
+		if err != nil {
+			return 0, err
+		} else if bucket.suppressed == 0 {
+			return 0, nil
+		}
+
+		written, err := l.write(key, bucket, bucket.tokens, 0)
+		if err != nil {
+			return 0, err
+		} else if written {
+			return bucket.suppressed, nil
+		}
+	}
+
+	return 0, errors.New("failed to update rate limit in DynamoDB: too much contention")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A bucket as stored by the rate limiter, last updated the given time ago
+func rateLimitItem(key string, tokens float64, suppressed int, age time.Duration) map[string]map[string]string {
+	updatedAt := time.Now().Add(-age).UnixNano() / int64(time.Millisecond)
+
+	return map[string]map[string]string{
+		"limit_key": {"S": key},
+		"tokens": {"N": strconv.FormatFloat(tokens, 'f', 3, 64)},
+		"suppressed": {"N": strconv.Itoa(suppressed)},
+		"updated_at": {"N": strconv.FormatInt(updatedAt, 10)},
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	tests := []struct {
+		name string
+		existing map[string]map[string]string
+		limit RateLimit
+		allowed bool
+		suppressed int // Reported as suppressed since the last one that got through
+		storedTokens float64
+		storedSuppressed int
+	}{
+		{
+			name: "new bucket starts full",
+			limit: RateLimit{perMinute: 10, burst: 5},
+			allowed: true,
+			storedTokens: 4,
+		},
+		{
+			name: "tokens left",
+			existing: rateLimitItem("slack", 2, 0, 0),
+			limit: RateLimit{perMinute: 10, burst: 5},
+			allowed: true,
+			storedTokens: 1,
+		},
+		{
+			name: "bucket empty",
+			existing: rateLimitItem("slack", 0, 0, 0),
+			limit: RateLimit{perMinute: 10, burst: 5},
+			allowed: false,
+			storedSuppressed: 1,
+		},
+		{
+			name: "less than a token left",
+			existing: rateLimitItem("slack", 0.5, 3, 0),
+			limit: RateLimit{perMinute: 10, burst: 5},
+			allowed: false,
+			storedTokens: 0.5,
+			storedSuppressed: 4,
+		},
+		{
+			name: "refilled over time",
+			existing: rateLimitItem("slack", 0, 3, 30 * time.Second),
+			limit: RateLimit{perMinute: 4, burst: 5},
+			allowed: true,
+			suppressed: 3,
+			storedTokens: 1,
+		},
+		{
+			name: "refill capped at the burst",
+			existing: rateLimitItem("slack", 0, 0, time.Hour),
+			limit: RateLimit{perMinute: 10, burst: 2},
+			allowed: true,
+			storedTokens: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, db := newFakeDynamoDB(t, "limit_key")
+			limiter := &RateLimiter{db: db, table: "rate-limits"}
+
+			if test.existing != nil {
+				fake.put(test.existing)
+			}
+
+			allowed, suppressed, err := limiter.take("slack", test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if allowed != test.allowed {
+				t.Errorf("expected allowed: %v, got: %v", test.allowed, allowed)
+			}
+
+			if suppressed != test.suppressed {
+				t.Errorf("expected %d suppressed, got %d", test.suppressed, suppressed)
+			}
+
+			item := fake.item("slack")
+			tokens, _ := strconv.ParseFloat(item["tokens"]["N"], 64)
+			storedSuppressed, _ := strconv.Atoi(item["suppressed"]["N"])
+
+			// Allows for the time passing while the test runs
+			if tokens < test.storedTokens || tokens > test.storedTokens + 0.01 {
+				t.Errorf("expected %v tokens left, got %v", test.storedTokens, tokens)
+			}
+
+			if storedSuppressed != test.storedSuppressed {
+				t.Errorf("expected %d suppressed to be stored, got %d", test.storedSuppressed, storedSuppressed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterTakeUntilEmpty(t *testing.T) {
+	_, db := newFakeDynamoDB(t, "limit_key")
+	limiter := &RateLimiter{db: db, table: "rate-limits"}
+	limit := RateLimit{perMinute: 1, burst: 3}
+
+	var results []bool
+	for i := 0; i < 5; i++ {
+		allowed, _, err := limiter.take("slack", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results = append(results, allowed)
+	}
+
+	expected := []bool{true, true, true, false, false}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, results)
+		}
+	}
+}
+
+// Suppressed notifications are dropped, and the next one that gets through says how many there were
+func TestDispatchRateLimited(t *testing.T) {
+	fake, db := newFakeDynamoDB(t, "limit_key")
+
+	notifier := &recordingNotifier{}
+	registry := newNotifierRegistry()
+	registry.register("test", notifier)
+	registry.limiter = &RateLimiter{db: db, table: "rate-limits"}
+	registry.rateLimits["test"] = RateLimit{perMinute: 1, burst: 1}
+
+	notification := notify.Notification {
+		Source: "aws.cloudwatch",
+		Title: "ALARM: \"cpu-high\"",
+		Severity: notify.SeverityError,
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := registry.dispatch(context.Background(), "test", notification); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sent := notifier.count(); sent != 1 {
+		t.Fatalf("expected 1 notification to get through, got %d", sent)
+	}
+
+	// A minute later, the bucket has a token again
+	fake.put(rateLimitItem("test", 0, 2, time.Minute))
+
+	if err := registry.dispatch(context.Background(), "test", notification); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := notifier.count(); sent != 2 {
+		t.Fatalf("expected 2 notifications to get through, got %d", sent)
+	}
+
+	fields := notifier.sent[1].Fields
+	if len(fields) != 1 || fields[0].Title != "Rate Limited" || fields[0].Value != "2 more suppressed" {
+		t.Errorf("expected the suppressed count to be added, got %+v", fields)
+	}
+
+	if len(notification.Fields) != 0 {
+		t.Error("expected the original notification to be left alone")
+	}
+}
+
+func TestNewRateLimiterFromSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		perMinute string
+		burst string
+		expected RateLimit
+		valid bool
+	}{
+		{"defaults", "", "", RateLimit{perMinute: 10, burst: 10}, true},
+		{"burst follows the rate", "30", "", RateLimit{perMinute: 30, burst: 30}, true},
+		{"fractional rate holds a whole token", "0.5", "", RateLimit{perMinute: 0.5, burst: 1}, true},
+		{"explicit burst", "0.5", "3", RateLimit{perMinute: 0.5, burst: 3}, true},
+		{"burst below a token", "10", "0.5", RateLimit{}, false},
+		{"invalid rate", "lots", "", RateLimit{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("rate_limit_table", "rate-limits")
+			if test.perMinute != "" {
+				t.Setenv("rate_limit_per_minute", test.perMinute)
+			}
+			if test.burst != "" {
+				t.Setenv("rate_limit_burst", test.burst)
+			}
+
+			_, limit, err := newRateLimiterFromSettings(session.Must(session.NewSession()))
+
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected the settings to be rejected, got %+v", limit)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if limit != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, limit)
+			}
+		})
+	}
+}
+
+// The scheduled task reports suppressed notifications straight away, instead of with the next one getting through
+func TestReportSuppressed(t *testing.T) {
+	tests := []struct {
+		name string
+		existing map[string]map[string]string
+		reported string // Empty if nothing should be sent
+	}{
+		{"nothing suppressed", rateLimitItem("test", 0, 0, 0), ""},
+		{"no bucket yet", nil, ""},
+		{"suppressed since the last one", rateLimitItem("test", 0, 4, 0), "4 more suppressed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, db := newFakeDynamoDB(t, "limit_key")
+
+			notifier := &recordingNotifier{}
+			registry := newNotifierRegistry()
+			registry.register("test", notifier)
+			registry.limiter = &RateLimiter{db: db, table: "rate-limits"}
+			registry.rateLimits["test"] = RateLimit{perMinute: 1, burst: 1}
+
+			if test.existing != nil {
+				fake.put(test.existing)
+			}
+
+			for i := 0; i < 2; i++ {
+				if err := registry.reportSuppressed(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.reported == "" {
+				if notifier.count() != 0 {
+					t.Errorf("expected nothing to be sent, got %d notifications", notifier.count())
+				}
+				return
+			}
+
+			// Only reported once, and without using up the token of the next notification
+			if notifier.count() != 1 {
+				t.Fatalf("expected the count to be reported once, got %d notifications", notifier.count())
+			}
+
+			if fields := notifier.sent[0].Fields; len(fields) != 1 || fields[0].Value != test.reported {
+				t.Errorf("expected %q, got %+v", test.reported, fields)
+			}
+
+			if tokens, _ := strconv.ParseFloat(fake.item("test")["tokens"]["N"], 64); tokens > 0.01 {
+				t.Errorf("expected the tokens to be left alone, got %v", tokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strings"
+)
+
+const RedactedValue = "[REDACTED]"
+
+// Keys containing any of these (ignoring case) have their values masked, unless redact_keys says otherwise
+var DefaultRedactedKeys = []string{"password", "passwd", "secret", "token", "authorization", "api_key", "apikey",
+	"private_key", "credentials"}
+
+// Masks the values of sensitive keys (like passwords and tokens, which do turn up in CloudTrail events and SNS
+// messages) anywhere in a notification, before it's rendered, stored or archived
+type Redactor struct {
+	keys []string
+}
+
+// Returns nil (which redacts nothing) if redact_keys is set to an empty string
+func newRedactor() *Redactor {
+	keys := DefaultRedactedKeys
+
+	if value, exists := lookupSetting("redact_keys"); exists {
+		keys = nil
+
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return &Redactor{keys: keys}
+}
+
+func (r *Redactor) redact(notification notify.Notification) notify.Notification {
+	if r == nil {
+		return notification
+	}
+
+	notification.Event = r.redactValue(notification.Event)
+
+	// Field titles are keys (or paths of keys, for flattened JSON), and values may be JSON documents
+	fields := make([]notify.Field, len(notification.Fields))
+	for i, field := range notification.Fields {
+		title := field.Title
+		if i := strings.LastIndexAny(title, ".]"); i != -1 {
+			title = title[i + 1:]
+		}
+
+		if r.sensitive(title) {
+			field.Value = RedactedValue
+		} else {
+			field.Value = r.redactString(field.Value)
+		}
+
+		fields[i] = field
+	}
+	notification.Fields = fields
+
+	if notification.Details != nil {
+		details := make(map[string]string, len(notification.Details))
+		for key, value := range notification.Details {
+			if r.sensitive(key) {
+				value = RedactedValue
+			}
+
+			details[key] = value
+		}
+		notification.Details = details
+	}
+
+	return notification
+}
+
+// For logging raw payloads
+func (r *Redactor) redactPayload(raw json.RawMessage) string {
+	if r == nil {
+		return string(raw)
+	}
+
+	return r.redactString(string(raw))
+}
+
+func (r *Redactor) sensitive(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, pattern := range r.keys {
+		if strings.Contains(key, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns a redacted copy of the generic value (see templateData), leaving the original as it was
+func (r *Redactor) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+
+		for key, item := range v {
+			if r.sensitive(key) && item != nil {
+				redacted[key] = RedactedValue
+			} else {
+				redacted[key] = r.redactValue(item)
+			}
+		}
+
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+
+		for i, item := range v {
+			redacted[i] = r.redactValue(item)
+		}
+
+		return redacted
+	case string:
+		return r.redactString(v)
+	default:
+		return v
+	}
+}
+
+// Strings holding JSON (like the message of an SNS notification) are redacted too, keeping their formatting
+// (indented or not) where possible
+func (r *Redactor) redactString(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return value
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return value
+	}
+
+	redacted := r.redactValue(document)
+
+	encoded, err := json.Marshal(redacted)
+	if err != nil {
+		return value
+	}
+
+	if strings.Contains(trimmed, "\n") {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, encoded, "", "  "); err == nil {
+			return indented.String()
+		}
+	}
+
+	return string(encoded)
+}
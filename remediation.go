@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/jmespath/go-jmespath"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strconv"
+)
+
+// Remediations run for notifications matching a route, alongside sending them. Either an SSM Automation document
+// is started (in the region of the event), or a Lambda function is invoked asynchronously with the original event.
+type RemediationConfig struct {
+	Document string `json:"document"` // SSM Automation document, like "AWS-RestartEC2Instance"
+	Parameters map[string]string `json:"parameters"` // JMESPath expressions evaluated against the event, like detail."instance-id"
+	Lambda string `json:"lambda"` // Name or ARN of the function to invoke
+	compiled map[string]*jmespath.JMESPath
+}
+
+type Remediator struct {
+	sess *session.Session
+}
+
+func (c *RemediationConfig) compile() error {
+	if (c.Document == "") == (c.Lambda == "") {
+		return errors.New("remediation needs exactly one of document or lambda")
+	}
+
+	if len(c.Parameters) != 0 && c.Document == "" {
+		return errors.New("remediation parameters are only supported for SSM Automation documents")
+	}
+
+	c.compiled = make(map[string]*jmespath.JMESPath)
+
+	for name, expression := range c.Parameters {
+		compiled, err := jmespath.Compile(expression)
+		if err != nil {
+			return errors.New("invalid remediation parameter " + name + ": " + err.Error())
+		}
+
+		c.compiled[name] = compiled
+	}
+
+	return nil
+}
+
+// Failing to remediate doesn't stop the notification, but it's added to it, so that people know to step in
+func (r *NotifierRegistry) remediate(ctx context.Context, config *RemediationConfig, notification notify.Notification) notify.Notification {
+	if config == nil || r.remediator == nil {
+		return notification
+	}
+
+	var outcome string
+
+	started, err := r.remediator.run(ctx, config, notification)
+	if err != nil {
+		logger(ctx).Error(err.Error(), "title", notification.Title)
+		metrics(ctx).count("RemediationsFailed", "Source", notification.Source)
+		outcome = "Failed: " + err.Error()
+	} else {
+		logger(ctx).Info("Started remediation", "remediation", started, "title", notification.Title)
+		metrics(ctx).count("RemediationsStarted", "Source", notification.Source)
+		outcome = started
+	}
+
+	notification.Fields = append(append([]notify.Field{}, notification.Fields...), notify.Field {
+		Title: "Remediation",
+		Value: outcome,
+		Short: false,
+	})
+
+	return notification
+}
+
+// Returns a description of what was started
+func (r *Remediator) run(ctx context.Context, config *RemediationConfig, notification notify.Notification) (string, error) {
+	if config.Lambda != "" {
+		payload, err := json.Marshal(notification.Event)
+		if err != nil {
+			return "", errors.New("failed to marshal event for remediation: " + err.Error())
+		}
+
+		svc := awslambda.New(r.sess)
+		traceAWSClient(svc.Client)
+
+		_, err = svc.InvokeWithContext(ctx, &awslambda.InvokeInput{
+			FunctionName: aws.String(config.Lambda),
+			InvocationType: aws.String(awslambda.InvocationTypeEvent),
+			Payload: payload,
+		})
+
+		if err != nil {
+			return "", errors.New("failed to invoke remediation function " + config.Lambda + ": " + err.Error())
+		}
+
+		return "Invoked " + config.Lambda, nil
+	}
+
+	parameters, err := config.parameters(notification)
+	if err != nil {
+		return "", err
+	}
+
+	cfg := aws.NewConfig()
+	if notification.Region != "" {
+		cfg = cfg.WithRegion(notification.Region)
+	}
+
+	svc := ssm.New(r.sess, cfg)
+	traceAWSClient(svc.Client)
+
+	input := &ssm.StartAutomationExecutionInput{
+		DocumentName: aws.String(config.Document),
+	}
+
+	// An empty map fails validation, for documents which don't take any parameters
+	if len(parameters) != 0 {
+		input.Parameters = parameters
+	}
+
+	res, err := svc.StartAutomationExecutionWithContext(ctx, input)
+
+	if err != nil {
+		return "", errors.New("failed to start SSM Automation " + config.Document + ": " + err.Error())
+	}
+
+	return "Started " + config.Document + " (" + aws.StringValue(res.AutomationExecutionId) + ")", nil
+}
+
+// Parameters which don't yield anything from the event are an error, since the automation would fail without them
+func (c *RemediationConfig) parameters(notification notify.Notification) (map[string][]*string, error) {
+	parameters := make(map[string][]*string)
+
+	var names []string
+	for name := range c.compiled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		result, err := c.compiled[name].Search(notification.Event)
+		if err != nil {
+			return nil, errors.New("failed to evaluate remediation parameter " + name + ": " + err.Error())
+		}
+
+		values := parameterValues(result)
+		if len(values) == 0 {
+			return nil, errors.New("remediation parameter " + name + " not found in event")
+		}
+
+		parameters[name] = values
+	}
+
+	return parameters, nil
+}
+
+func parameterValues(result interface{}) []*string {
+	var values []*string
+
+	switch value := result.(type) {
+	case string:
+		values = append(values, aws.String(value))
+	case float64:
+		values = append(values, aws.String(strconv.FormatFloat(value, 'f', -1, 64)))
+	case bool:
+		values = append(values, aws.String(strconv.FormatBool(value)))
+	case []interface{}:
+		for _, item := range value {
+			values = append(values, parameterValues(item)...)
+		}
+	}
+
+	return values
+}
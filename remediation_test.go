@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Answers both StartAutomationExecution (SSM) and Invoke (Lambda) calls, recording what was called with what
+type fakeRemediationAPI struct {
+	calls []string // Like "AmazonSSM.StartAutomationExecution" or "/2015-03-31/functions/restart/invocations"
+	bodies []string
+	failing bool
+}
+
+func (f *fakeRemediationAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	call := r.Header.Get("X-Amz-Target")
+	if call == "" {
+		call = r.URL.Path
+	}
+
+	f.calls = append(f.calls, call)
+	f.bodies = append(f.bodies, string(body))
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	if f.failing {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "InvalidAutomationExecutionParametersException", "message": "bad parameters"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"AutomationExecutionId": "exec-1"})
+}
+
+func newRemediationRegistry(t *testing.T) (*NotifierRegistry, *fakeRemediationAPI) {
+	api := &fakeRemediationAPI{}
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String("eu-west-1"),
+		Endpoint: aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		MaxRetries: aws.Int(0),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := newNotifierRegistry()
+	registry.remediator = &Remediator{sess: sess}
+
+	return registry, api
+}
+
+func TestRemediate(t *testing.T) {
+	notification := notify.Notification {
+		Source: "aws.ec2",
+		Title: "Instance i-123 stopped",
+		Region: "eu-west-1",
+		Event: map[string]interface{}{
+			"detail": map[string]interface{}{"instance-id": "i-123"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		config RemediationConfig
+		failing bool
+		call string
+		outcome string
+	}{
+		{
+			"SSM Automation",
+			RemediationConfig{Document: "AWS-RestartEC2Instance", Parameters: map[string]string{"InstanceId": `detail."instance-id"`}},
+			false, "AmazonSSM.StartAutomationExecution", "Started AWS-RestartEC2Instance (exec-1)",
+		},
+		{
+			"Lambda function",
+			RemediationConfig{Lambda: "restart"},
+			false, "/2015-03-31/functions/restart/invocations", "Invoked restart",
+		},
+		{
+			"failing to start",
+			RemediationConfig{Document: "AWS-RestartEC2Instance"},
+			true, "AmazonSSM.StartAutomationExecution", "Failed: failed to start SSM Automation AWS-RestartEC2Instance: ",
+		},
+		{
+			"parameter missing from the event",
+			RemediationConfig{Document: "AWS-RestartEC2Instance", Parameters: map[string]string{"InstanceId": "detail.missing"}},
+			false, "", "Failed: remediation parameter InstanceId not found in event",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry, api := newRemediationRegistry(t)
+			api.failing = test.failing
+
+			if err := test.config.compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			remediated := registry.remediate(context.Background(), &test.config, notification)
+
+			if test.call == "" && len(api.calls) != 0 {
+				t.Errorf("expected no calls, got: %v", api.calls)
+			} else if test.call != "" && (len(api.calls) != 1 || api.calls[0] != test.call) {
+				t.Errorf("expected a call to %s, got: %v", test.call, api.calls)
+			}
+
+			if len(remediated.Fields) != 1 || remediated.Fields[0].Title != "Remediation" {
+				t.Fatalf("expected a Remediation field, got: %v", remediated.Fields)
+			}
+
+			if outcome := remediated.Fields[0].Value; !strings.HasPrefix(outcome, test.outcome) {
+				t.Errorf("expected the outcome to start with %q, got: %q", test.outcome, outcome)
+			}
+
+			// The handler's notification is left alone
+			if len(notification.Fields) != 0 {
+				t.Errorf("expected the original notification to be unchanged, got: %v", notification.Fields)
+			}
+		})
+	}
+}
+
+func TestRemediationParameters(t *testing.T) {
+	config := RemediationConfig {
+		Document: "AWS-StopEC2Instance",
+		Parameters: map[string]string{
+			"InstanceId": `detail."instance-id"`,
+			"Force": "detail.force",
+			"Volumes": "detail.volumes[].id",
+			"Count": "detail.count",
+		},
+	}
+
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	parameters, err := config.parameters(notify.Notification {
+		Event: map[string]interface{}{
+			"detail": map[string]interface{}{
+				"instance-id": "i-123",
+				"force": true,
+				"count": float64(2),
+				"volumes": []interface{}{
+					map[string]interface{}{"id": "vol-1"},
+					map[string]interface{}{"id": "vol-2"},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"InstanceId": {"i-123"},
+		"Force": {"true"},
+		"Count": {"2"},
+		"Volumes": {"vol-1", "vol-2"},
+	}
+
+	for name, values := range expected {
+		if actual := aws.StringValueSlice(parameters[name]); !reflect.DeepEqual(actual, values) {
+			t.Errorf("expected %s to be %v, got: %v", name, values, actual)
+		}
+	}
+}
+
+func TestCompileRemediation(t *testing.T) {
+	tests := []struct {
+		name string
+		config RemediationConfig
+		fails bool
+	}{
+		{"document", RemediationConfig{Document: "AWS-RestartEC2Instance"}, false},
+		{"lambda", RemediationConfig{Lambda: "restart"}, false},
+		{"neither", RemediationConfig{}, true},
+		{"both", RemediationConfig{Document: "AWS-RestartEC2Instance", Lambda: "restart"}, true},
+		{"parameters for lambda", RemediationConfig{Lambda: "restart", Parameters: map[string]string{"InstanceId": "detail.id"}}, true},
+		{"invalid parameter", RemediationConfig{Document: "AWS-RestartEC2Instance", Parameters: map[string]string{"InstanceId": "detail.["}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.config.compile(); (err != nil) != test.fails {
+				t.Errorf("expected failure: %v, got: %v", test.fails, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+)
+
+// Links notifications to the runbook for dealing with them. Runbooks are evaluated in order, and the first
+// one matching a notification (the same way as filter rules) is linked from it.
+type RunbookConfig struct {
+	Match route.FilterRule `json:"match"`
+	URL string `json:"url"`
+}
+
+func (c *Config) compileRunbooks() error {
+	for i := range c.Runbooks {
+		if c.Runbooks[i].URL == "" {
+			return errors.New("runbook without url")
+		}
+
+		if err := c.Runbooks[i].Match.Compile(); err != nil {
+			return errors.New("invalid runbook expression " + c.Runbooks[i].Match.Expression + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Handlers may have set a runbook already (like from an alert's annotations), which takes precedence
+func (c *Config) linkRunbook(notification notify.Notification) notify.Notification {
+	if notification.RunbookURL != "" {
+		return notification
+	}
+
+	for _, runbook := range c.Runbooks {
+		if runbook.Match.Matches(notification) {
+			notification.RunbookURL = runbook.URL
+			break
+		}
+	}
+
+	return notification
+}
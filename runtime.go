@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Lambda runs the function either on the go1.x runtime, which calls into the executable over RPC (on the port in
+// _LAMBDA_SERVER_PORT), or on an OS-only runtime like provided.al2023, where the executable is the bootstrap and
+// polls the Runtime API (at AWS_LAMBDA_RUNTIME_API) for events itself. lambda.Start speaks both, so the handler is
+// wired up the same way on either - only how the executable is built and named differs (see build.sh).
+func startLambda() error {
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") == "" && os.Getenv("_LAMBDA_SERVER_PORT") == "" {
+		return errors.New("not running in Lambda - use --file to process an event locally")
+	}
+
+	lambda.Start(HandleRequest)
+	return nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Everything built from the settings and config (the AWS session, the notifiers and the routing config),
+// which is kept across warm invocations, so that they skip fetching the config and building the notifiers,
+// and reuse their connections. It's only rebuilt when the secret is rotated, the message templates are
+// reloaded, or the config has changed.
+type Runtime struct {
+	sess *session.Session
+	notifiers *NotifierRegistry
+	slackNotifier *notify.SlackNotifier
+	generation string
+}
+
+var currentRuntime *Runtime
+var runtimeLock sync.Mutex
+var awsSession *session.Session
+
+func loadRuntime() (*Runtime, error) {
+	runtimeLock.Lock()
+	defer runtimeLock.Unlock()
+
+	if awsSession == nil {
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, errors.New("failed to create AWS session: " + err.Error())
+		}
+
+		awsSession = sess
+	}
+
+	sess := awsSession
+
+	if err := loadSettings(sess); err != nil {
+		return nil, err
+	}
+
+	if err := loadSecrets(sess); err != nil {
+		return nil, err
+	}
+
+	if err := configureLogging(); err != nil {
+		return nil, err
+	}
+
+	if err := configureTracing(); err != nil {
+		return nil, err
+	}
+
+	if err := configureErrorReporting(); err != nil {
+		return nil, err
+	}
+
+	// Everything wrong with the configuration is reported at once, before any events are processed
+	var problems ConfigError
+
+	templates, err := loadMessageTemplates(sess)
+	if err = problems.merge(err); err != nil {
+		return nil, err
+	}
+
+	generation := runtimeGeneration(currentConfigVersion(sess))
+	if currentRuntime != nil && currentRuntime.generation == generation && len(problems.Problems) == 0 {
+		return currentRuntime, nil
+	}
+
+	rt, err := reloadRuntime(sess, templates, &problems)
+	if err != nil {
+		if currentRuntime == nil {
+			return nil, err
+		}
+
+		// Rather than failing every event until the config is fixed, carry on with the previous one (and don't
+		// retry until something changes again)
+		slog.Error("Failed to reload notifiers, using the previous ones", "error", err.Error())
+		currentRuntime.generation = generation
+		return currentRuntime, nil
+	}
+
+	rt.generation = runtimeGeneration(configVersion)
+	currentRuntime = rt
+
+	slog.Info("Initialized notifiers", "notifiers", len(rt.notifiers.names))
+
+	return rt, nil
+}
+
+// Changes whenever something the runtime is built from does
+func runtimeGeneration(configVersion string) string {
+	return secretsVersion() + "/" + strconv.Itoa(messageTemplatesGeneration()) + "/" + configVersion
+}
+
+func reloadRuntime(sess *session.Session, templates map[string]*MessageTemplate, problems *ConfigError) (*Runtime, error) {
+	problems.merge(validateSettings())
+
+	config, err := loadConfig(sess)
+	if err = problems.merge(err); err != nil {
+		return nil, err
+	}
+
+	if err := problems.errorOrNil(); err != nil {
+		slog.Error("Invalid configuration", "problems", problems.Problems)
+		return nil, err
+	}
+
+	return buildRuntime(sess, templates, config)
+}
+
+func buildRuntime(sess *session.Session, templates map[string]*MessageTemplate, config *Config) (*Runtime, error) {
+	clientConfig, err := httpClientConfigFromSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	httpClientConfig = clientConfig
+	notify.HTTPClient = newHTTPClient(httpClientConfig)
+
+	slackNotifier, err := newSlackNotifierFromSettings(sess, templates, config)
+	if err != nil {
+		return nil, err
+	}
+
+	notifiers, err := newNotifierRegistryFromSettings(sess, slackNotifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		if err := registerConfiguredChannels(config, notifiers, slackNotifier); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(notifiers.names) == 0 {
+		return nil, errors.New("no notifiers configured - set slack_webhook, slack_token or pagerduty_key, " +
+			"or configure channels")
+	}
+
+	if dryRun {
+		notifiers.makeDryRun()
+	}
+
+	return &Runtime{sess: sess, notifiers: notifiers, slackNotifier: slackNotifier}, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+	"strconv"
+	"time"
+)
+
+const DefaultSamplingWindow = time.Hour
+
+// Sampling rules are evaluated in order, and the first one matching a notification applies. Of the matching
+// notifications, only the first one in every `rate` is sent, and the "Sampling" scheduled task posts how many were
+// received in total once the window is over, to the channels they were routed to.
+type SamplingRule struct {
+	Name string `json:"name"` // What the notifications are called in the aggregate, like "DynamoDB stream events"
+	Match route.Match `json:"match"`
+	Rate int `json:"rate"` // Send 1 in this many
+	Window string `json:"window"` // How often to post the aggregate (eg. "30m"), defaults to 1h
+	window time.Duration
+}
+
+// Counts the notifications matching each sampling rule in a DynamoDB table (with a string hash key called
+// "sampling_key"), keyed by the name of the rule
+type SamplingStore struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+// Returns nil if sampling_table isn't set
+func newSamplingStoreFromSettings(sess *session.Session) *SamplingStore {
+	table, exists := lookupSetting("sampling_table")
+	if !exists {
+		return nil
+	}
+
+	return &SamplingStore{
+		db: dynamodb.New(sess),
+		table: table,
+	}
+}
+
+// What was counted for a rule over a window, as of resetting it
+type SamplingWindow struct {
+	Received int64
+	Channels []string
+	Start time.Time
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Sampling",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.sampling == nil || notifiers.config == nil {
+				return nil
+			}
+
+			return notifiers.postSamplingAggregates(ctx, time.Now())
+		},
+	})
+}
+
+func (c *Config) compileSampling() error {
+	names := make(map[string]bool)
+
+	for i := range c.Sampling {
+		rule := &c.Sampling[i]
+
+		if rule.Name == "" {
+			return errors.New("sampling rule without name")
+		} else if names[rule.Name] {
+			return errors.New("duplicate sampling rule: " + rule.Name)
+		}
+
+		names[rule.Name] = true
+
+		if rule.Rate < 1 {
+			return errors.New("invalid rate for sampling rule " + rule.Name + ": " + strconv.Itoa(rule.Rate))
+		}
+
+		rule.window = DefaultSamplingWindow
+
+		if rule.Window != "" {
+			window, err := time.ParseDuration(rule.Window)
+			if err != nil {
+				return errors.New("invalid window for sampling rule " + rule.Name + ": " + err.Error())
+			}
+
+			rule.window = window
+		}
+	}
+
+	return nil
+}
+
+// Returns the first sampling rule matching the notification, or nil if there isn't one
+func (c *Config) samplingRule(notification notify.Notification) *SamplingRule {
+	for i := range c.Sampling {
+		if c.Sampling[i].Match.Matches(notification) {
+			return &c.Sampling[i]
+		}
+	}
+
+	return nil
+}
+
+// Counts the notification, and returns how many were received in the current window (including this one). The
+// window starts with the first notification counted after the last reset.
+func (s *SamplingStore) count(rule *SamplingRule, channels []string, now time.Time) (int64, error) {
+	encodedChannels, err := json.Marshal(channels)
+	if err != nil {
+		return 0, errors.New("failed to marshal sampling channels: " + err.Error())
+	}
+
+	res, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"sampling_key": {S: aws.String(rule.Name)},
+		},
+		UpdateExpression: aws.String("ADD received :one SET channels = :channels, window_start = if_not_exists(window_start, :now)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":channels": {S: aws.String(string(encodedChannels))},
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+	})
+
+	if err != nil {
+		return 0, errors.New("failed to count sampled notification in DynamoDB: " + err.Error())
+	}
+
+	received, err := strconv.ParseInt(aws.StringValue(res.Attributes["received"].N), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid sampling count: " + err.Error())
+	}
+
+	return received, nil
+}
+
+// Starts a new window if the current one is over, returning what was counted in it (or nil if it isn't over yet).
+// Uses a conditional write, so that concurrent invocations can't both post the same aggregate.
+func (s *SamplingStore) reset(rule *SamplingRule, now time.Time) (*SamplingWindow, error) {
+	res, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"sampling_key": {S: aws.String(rule.Name)},
+		},
+		ConditionExpression: aws.String("window_start <= :cutoff"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":cutoff": {N: aws.String(strconv.FormatInt(now.Add(-rule.window).Unix(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("failed to reset sampling window in DynamoDB: " + err.Error())
+	}
+
+	window := &SamplingWindow{}
+
+	if received, exists := res.Attributes["received"]; exists {
+		window.Received, _ = strconv.ParseInt(aws.StringValue(received.N), 10, 64)
+	}
+
+	if channels, exists := res.Attributes["channels"]; exists {
+		json.Unmarshal([]byte(aws.StringValue(channels.S)), &window.Channels)
+	}
+
+	if start, exists := res.Attributes["window_start"]; exists {
+		seconds, _ := strconv.ParseInt(aws.StringValue(start.N), 10, 64)
+		window.Start = time.Unix(seconds, 0)
+	}
+
+	return window, nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Returns whether the notification should be sent. If the count can't be updated, it's sent.
+func (r *NotifierRegistry) sample(ctx context.Context, notification notify.Notification, names []string) (notify.Notification, bool) {
+	if r.config == nil {
+		return notification, true
+	}
+
+	rule := r.config.samplingRule(notification)
+	if rule == nil {
+		return notification, true
+	}
+
+	if r.sampling == nil {
+		logger(ctx).Warn("Notification matches sampling rule, but sampling_table is not configured - sending it",
+			"rule", rule.Name)
+		return notification, true
+	}
+
+	received, err := r.sampling.count(rule, names, time.Now())
+	if err != nil {
+		logger(ctx).Warn(err.Error(), "rule", rule.Name)
+		return notification, true
+	}
+
+	if (received - 1) % int64(rule.Rate) != 0 {
+		return notification, false
+	}
+
+	notification.Fields = append(append([]notify.Field{}, notification.Fields...), notify.Field {
+		Title: "Sampled",
+		Value: "1 in " + strconv.Itoa(rule.Rate) + " " + rule.Name + " sent (" + strconv.FormatInt(received, 10) +
+			" received so far)",
+		Short: false,
+	})
+
+	return notification, true
+}
+
+// Posts the aggregate of every sampling rule whose window is over
+func (r *NotifierRegistry) postSamplingAggregates(ctx context.Context, now time.Time) error {
+	var failures MultiError
+
+	for i := range r.config.Sampling {
+		rule := &r.config.Sampling[i]
+
+		window, err := r.sampling.reset(rule, now)
+		if err != nil {
+			failures.add("sampling rule " + rule.Name, err)
+			continue
+		} else if window == nil || window.Received == 0 {
+			continue
+		}
+
+		notification := samplingNotification(rule, window, now)
+
+		for _, name := range window.Channels {
+			notifier, exists := r.notifiers[name]
+			if !exists {
+				logger(ctx).Warn("Skipping sampling aggregate for unknown channel", "channel", name)
+				continue
+			}
+
+			failures.add("sampling aggregate via " + name, notifier.Send(ctx, notification))
+		}
+	}
+
+	return failures.errorOrNil()
+}
+
+// Like "Received 243 DynamoDB stream events in the last hour"
+func samplingNotification(rule *SamplingRule, window *SamplingWindow, now time.Time) notify.Notification {
+	period := "hour"
+	if rule.Window != "" {
+		period = rule.Window
+	}
+
+	title := "Received " + strconv.FormatInt(window.Received, 10) + " " + rule.Name + " in the last " + period
+
+	return notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Sampling Aggregate",
+		Title: title,
+		Summary: title,
+		Severity: notify.SeverityInfo,
+		Fields: []notify.Field {
+			{
+				Title: "Sampling",
+				Value: "1 in " + strconv.Itoa(rule.Rate) + " sent",
+				Short: true,
+			},
+			{
+				Title: "Received",
+				Value: strconv.FormatInt(window.Received, 10),
+				Short: true,
+			},
+		},
+		Time: now.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newSamplingRegistry(t *testing.T) (*NotifierRegistry, *fakeDynamoDB, *recordingNotifier) {
+	counts, db := newFakeDynamoDB(t, "sampling_key")
+
+	config := &Config{
+		Sampling: []SamplingRule {
+			{Name: "stream events", Match: route.Match{Source: "aws.dynamodb"}, Rate: 3, Window: "30m"},
+		},
+	}
+
+	if err := config.compileSampling(); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := &recordingNotifier{}
+
+	registry := newNotifierRegistry()
+	registry.register("ops", ops)
+	registry.config = config
+	registry.sampling = &SamplingStore{db: db, table: "sampling"}
+
+	return registry, counts, ops
+}
+
+// Of the matching notifications, the first one in every rate is sent
+func TestSample(t *testing.T) {
+	registry, counts, _ := newSamplingRegistry(t)
+	ctx := context.Background()
+
+	var sent []int
+
+	for i := 1; i <= 7; i++ {
+		notification, send := registry.sample(ctx, notify.Notification{Source: "aws.dynamodb"}, []string{"ops"})
+		if !send {
+			continue
+		}
+
+		sent = append(sent, i)
+
+		if len(notification.Fields) != 1 || notification.Fields[0].Value != "1 in 3 stream events sent (" +
+			strconv.Itoa(i) + " received so far)" {
+			t.Errorf("expected a Sampled field on notification %d, got: %v", i, notification.Fields)
+		}
+	}
+
+	if len(sent) != 3 || sent[0] != 1 || sent[1] != 4 || sent[2] != 7 {
+		t.Errorf("expected notifications 1, 4 and 7 to be sent, got: %v", sent)
+	}
+
+	if received := counts.item("stream events")["received"]["N"]; received != "7" {
+		t.Errorf("expected 7 to be counted, got: %s", received)
+	}
+
+	// Notifications not matching any rule aren't counted
+	if _, send := registry.sample(ctx, notify.Notification{Source: "aws.ec2"}, []string{"ops"}); !send {
+		t.Error("expected a notification not matching any rule to be sent")
+	}
+
+	if received := counts.item("stream events")["received"]["N"]; received != "7" {
+		t.Errorf("expected the count to stay at 7, got: %s", received)
+	}
+}
+
+// Notifications are sent if they can't be counted, rather than dropped
+func TestSampleFailingTable(t *testing.T) {
+	registry, counts, _ := newSamplingRegistry(t)
+	counts.setFailing(true)
+
+	for i := 0; i < 2; i++ {
+		if _, send := registry.sample(context.Background(), notify.Notification{Source: "aws.dynamodb"}, []string{"ops"}); !send {
+			t.Errorf("expected notification %d to be sent", i + 1)
+		}
+	}
+}
+
+func TestPostSamplingAggregates(t *testing.T) {
+	registry, counts, ops := newSamplingRegistry(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		registry.sample(ctx, notify.Notification{Source: "aws.dynamodb"}, []string{"ops"})
+	}
+
+	// The window isn't over yet
+	if err := registry.postSamplingAggregates(ctx, now.Add(10 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ops.count() != 0 || counts.count() != 1 {
+		t.Fatalf("expected nothing to be posted yet, got %d posted and %d counts", ops.count(), counts.count())
+	}
+
+	if err := registry.postSamplingAggregates(ctx, now.Add(31 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ops.count() != 1 {
+		t.Fatalf("expected the aggregate to be posted, got %d notifications", ops.count())
+	}
+
+	if title := ops.sent[0].Title; title != "Received 5 stream events in the last 30m" {
+		t.Errorf("unexpected aggregate title: %s", title)
+	}
+
+	// A new window starts with the next notification
+	if counts.count() != 0 {
+		t.Errorf("expected the window to be reset, got %d counts", counts.count())
+	}
+
+	if err := registry.postSamplingAggregates(ctx, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ops.count() != 1 {
+		t.Errorf("expected the aggregate to be posted once only, got %d notifications", ops.count())
+	}
+}
+
+func TestCompileSampling(t *testing.T) {
+	tests := []struct {
+		name string
+		rules []SamplingRule
+		fails bool
+	}{
+		{"valid", []SamplingRule{{Name: "events", Rate: 10}}, false},
+		{"without name", []SamplingRule{{Rate: 10}}, true},
+		{"duplicate", []SamplingRule{{Name: "events", Rate: 10}, {Name: "events", Rate: 5}}, true},
+		{"invalid rate", []SamplingRule{{Name: "events", Rate: 0}}, true},
+		{"invalid window", []SamplingRule{{Name: "events", Rate: 10, Window: "hourly"}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{Sampling: test.rules}
+
+			if err := config.compileSampling(); (err != nil) != test.fails {
+				t.Errorf("expected failure: %v, got: %v", test.fails, err)
+			}
+		})
+	}
+}
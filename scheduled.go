@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"strings"
+)
+
+// Tasks which run on a schedule, triggered by a Cloudwatch Events rule like "rate(1 hour)" targeting the
+// function. They register themselves (from an init function in their own file), like handlers do.
+type ScheduledTask struct {
+	name string
+	run func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error
+}
+
+var scheduledTasks []ScheduledTask
+
+func registerScheduledTask(task ScheduledTask) {
+	scheduledTasks = append(scheduledTasks, task)
+}
+
+// Whether the event was triggered by the schedule rule with the given name, for tasks which run on a schedule of
+// their own (like reports). Scheduled events list the ARN of their rule, like
+// "arn:aws:events:eu-west-1:123456789012:rule/aws-notifier-cost-report".
+func scheduledBy(event lambdaevents.CloudWatchEvent, rule string) bool {
+	for _, resource := range event.Resources {
+		if strings.HasSuffix(resource, ":rule/" + rule) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func processScheduledEvent(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+	if viaWebhook(ctx) {
+		return &InvalidPayloadError{Err: errors.New("scheduled events are not accepted via webhooks")}
+	}
+
+	// Tasks are independent, so one failing doesn't stop the others from running
+	var failures MultiError
+
+	for _, task := range scheduledTasks {
+		logger(ctx).Info("Running scheduled task", "task", task.name)
+
+		if err := task.run(ctx, notifiers, event); err != nil {
+			logger(ctx).Error("Failed to run scheduled task", "task", task.name, "error", err.Error())
+			failures.add("scheduled task " + task.name, err)
+		}
+	}
+
+	if err := failures.errorOrNil(); err != nil {
+		return err
+	}
+
+	pingHeartbeat(ctx)
+
+	return nil
+}
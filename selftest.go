@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"time"
+)
+
+/**
+Example self-test payload (like the constant input of a Cloudwatch Events rule with a schedule of "rate(1 day)"):
+
+{
+  "test": "heartbeat",
+  "channels": ["slack"],
+  "page": false
+}
+
+Without channels, every registered notifier is tested.
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const SelfTestOK = "ok"
+const SelfTestSkipped = "skipped"
+
+type SelfTest struct {
+	Test string `json:"test"`
+	Channels []string `json:"channels"`
+	Page bool `json:"page"` // Pagerduty is only tested if this is set, since it triggers a real incident
+}
+
+// Outcome of the test per channel: "ok", "skipped", or the error
+type SelfTestReport map[string]string
+
+func init() {
+	registerPayloadHandler(PayloadHandler {
+		name: "Self Test",
+		matches: func(payload GenericEvent) bool { return len(payload.Records) == 0 && payload.Test != "" },
+		respond: processSelfTest,
+	})
+}
+
+// Sends a test notification via every notifier directly (bypassing routing, filters, rate limits and
+// circuit breakers), so that a scheduled test doubles as a canary for the notifier itself
+func processSelfTest(ctx context.Context, notifiers *NotifierRegistry, raw json.RawMessage) (interface{}, error) {
+	if viaWebhook(ctx) {
+		return nil, &InvalidPayloadError{Err: errors.New("self-tests are not accepted via webhooks")}
+	}
+
+	var test SelfTest
+
+	if err := json.Unmarshal(raw, &test); err != nil {
+		return nil, errors.New("unsupported self-test payload: " + err.Error())
+	}
+
+	ctx = withLogAttrs(ctx, "test", test.Test)
+
+	names := test.Channels
+	if len(names) == 0 {
+		names = notifiers.names
+	}
+
+	notification := notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Self Test",
+		Title: "Self-test: " + test.Test,
+		Summary: "This is a test notification - no action is needed",
+		Severity: notify.SeverityInfo,
+		Time: time.Now().UTC().Format(time.RFC3339),
+		IncidentKey: "aws-notifier/self-test/" + test.Test,
+		Page: test.Page,
+		Fields: []notify.Field {
+			{
+				Title: "Self-test: " + test.Test,
+				Value: "This is a test notification - no action is needed",
+				Short: false,
+			},
+			{
+				Title: "Version",
+				Value: buildVersion(),
+				Short: true,
+			},
+		},
+	}
+
+	if notifiers.config != nil {
+		notification.Fields = append(notification.Fields, notify.Field {
+			Title: "Config",
+			Value: notifiers.config.fingerprint,
+			Short: true,
+		})
+	}
+
+	report := make(SelfTestReport)
+	var failures MultiError
+
+	for _, name := range names {
+		notifier, exists := notifiers.notifiers[name]
+		if !exists {
+			report[name] = "unknown channel"
+			failures.add("self-test via " + name, errors.New("unknown channel"))
+			continue
+		}
+
+		if _, paging := notifier.(*notify.PagerdutyNotifier); paging && !test.Page {
+			report[name] = SelfTestSkipped
+			continue
+		}
+
+		localized := notification
+		if notifiers.config != nil {
+			localized = notifiers.config.localize(name, notification)
+		}
+
+		err := traceSegment(ctx, "Self-test via " + name, func(ctx context.Context) error {
+			return notifier.Send(ctx, localized)
+		})
+
+		if err != nil {
+			logger(ctx).Error("Self-test failed", "channel", name, "error", err.Error())
+			metrics(ctx).add("SelfTestSucceeded", "Count", 0, "Channel", name)
+			report[name] = err.Error()
+			failures.add("self-test via " + name, err)
+			continue
+		}
+
+		logger(ctx).Info("Self-test succeeded", "channel", name)
+		metrics(ctx).add("SelfTestSucceeded", "Count", 1, "Channel", name)
+		report[name] = SelfTestOK
+	}
+
+	return report, failures.errorOrNil()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strings"
+)
+
+/**
+Example Sentry issue alert payload (POST to /webhooks/sentry, from an internal integration with the
+"Alert Rule Action" enabled, with a Sentry-Hook-Resource header of event_alert):
+
+{
+  "action": "triggered",
+  "data": {
+    "event": {
+      "event_id": "0e5e4d1c9a7e4d1e9f0c1f6b2a3c4d5e",
+      "issue_id": "1170820242",
+      "title": "ZeroDivisionError: division by zero",
+      "culprit": "payments.views in refund",
+      "level": "error",
+      "environment": "production",
+      "web_url": "https://sentry.io/organizations/example/issues/1170820242/events/0e5e4d1c9a7e4d1e9f0c1f6b2a3c4d5e/",
+      "issue_url": "https://sentry.io/api/0/issues/1170820242/"
+    },
+    "triggered_rule": "Notify on new errors"
+  },
+  "installation": {"uuid": "a8e5d37a-696c-4c54-adb5-b3f28d64c7de"}
+}
+
+Issue payloads (with a Sentry-Hook-Resource header of issue) carry the issue instead, with the project and event count:
+
+{
+  "action": "created",
+  "data": {
+    "issue": {
+      "id": "1170820242",
+      "shortId": "PAYMENTS-API-4",
+      "title": "ZeroDivisionError: division by zero",
+      "culprit": "payments.views in refund",
+      "level": "error",
+      "status": "unresolved",
+      "count": "12",
+      "userCount": 3,
+      "permalink": "https://sentry.io/organizations/example/issues/1170820242/",
+      "project": {"id": "2", "name": "payments-api", "slug": "payments-api"}
+    }
+  }
+}
+
+The legacy webhook plugin sends a flat payload like:
+
+{
+  "id": "1170820242",
+  "project": "payments-api",
+  "project_name": "Payments API",
+  "level": "error",
+  "culprit": "payments.views in refund",
+  "message": "ZeroDivisionError: division by zero",
+  "url": "https://sentry.io/organizations/example/issues/1170820242/",
+  "triggering_rules": ["Notify on new errors"],
+  "event": {"title": "ZeroDivisionError: division by zero", "environment": "production"}
+}
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Event processor
+
+func init() {
+	registerWebhookHandler(WebhookHandler {
+		name: "sentry",
+		handle: processSentryWebhook,
+	})
+}
+
+func processSentryWebhook(ctx context.Context, notifiers *NotifierRegistry, req HTTPRequest) error {
+	var payload events.SentryWebhook
+
+	if err := json.Unmarshal([]byte(req.Body), &payload); err != nil {
+		return &InvalidPayloadError{Err: err}
+	}
+
+	var alert events.SentryAlert
+	action := notify.ThreadStart
+
+	if issue := payload.Data.Issue; issue != nil {
+		// Only new issues (and ones which came back) are worth notifying about, and resolving closes their thread
+		switch payload.Action {
+		case "created", "unresolved":
+		case "resolved":
+			action = notify.ThreadResolve
+		default:
+			logger(ctx).Debug("Ignoring Sentry issue webhook", "action", payload.Action)
+			return nil
+		}
+
+		project := issue.Project.Name
+		if project == "" {
+			project = issue.Project.Slug
+		}
+
+		alert = events.SentryAlert {
+			IssueID: issue.ID,
+			Project: project,
+			Title: issue.Title,
+			Culprit: issue.Culprit,
+			Level: issue.Level,
+			Count: issue.Count,
+			URL: issue.Permalink,
+		}
+	} else if event := payload.Data.Event; event != nil {
+		alert = events.SentryAlert {
+			IssueID: event.IssueID,
+			Title: event.Title,
+			Culprit: event.Culprit,
+			Level: event.Level,
+			Environment: event.Environment,
+			Rule: payload.Data.TriggeredRule,
+			URL: event.WebURL,
+		}
+	} else if payload.ID != "" {
+		project := payload.ProjectName
+		if project == "" {
+			project = payload.Project
+		}
+
+		alert = events.SentryAlert {
+			IssueID: payload.ID,
+			Project: project,
+			Title: payload.Message,
+			Culprit: payload.Culprit,
+			Level: payload.Level,
+			Rule: strings.Join(payload.TriggeringRules, ", "),
+			URL: payload.URL,
+		}
+
+		if payload.Event != nil {
+			if payload.Event.Title != "" {
+				alert.Title = payload.Event.Title
+			}
+
+			alert.Environment = payload.Event.Environment
+		}
+	} else {
+		return &InvalidPayloadError{Err: errors.New("no issue or event in payload")}
+	}
+
+	notification := events.SentryNotification(alert, action)
+	notification.Event = events.TemplateData(payload)
+
+	return notifiers.send(ctx, notification)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSecretRefreshInterval = 5 * time.Minute
+
+// Settings (webhooks, keys, etc.) are read from the environment, falling back to the ones loaded from external
+// stores. These are only loaded once per container (ie. at cold start), and kept around for warm invocations.
+var settings map[string]string
+
+// Loads all parameters under the path set via ssm_prefix (eg. "/aws-notifier/prod/"), with SecureString
+// values decrypted. Parameters are named after the settings they provide, like "/aws-notifier/prod/slack_webhook".
+func loadSettings(sess *session.Session) error {
+	if settings != nil {
+		return nil
+	}
+
+	loaded := make(map[string]string)
+
+	if prefix, exists := os.LookupEnv("ssm_prefix"); exists {
+		if !strings.HasSuffix(prefix, "/") {
+			prefix = prefix + "/"
+		}
+
+		slog.Debug("Loading settings from SSM...", "prefix", prefix)
+
+		err := ssm.New(sess).GetParametersByPathPages(&ssm.GetParametersByPathInput{
+			Path: aws.String(strings.TrimSuffix(prefix, "/")),
+			Recursive: aws.Bool(true),
+			WithDecryption: aws.Bool(true),
+		}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+			for _, parameter := range page.Parameters {
+				name := strings.TrimPrefix(aws.StringValue(parameter.Name), prefix)
+				loaded[name] = aws.StringValue(parameter.Value)
+			}
+
+			return true
+		})
+
+		if err != nil {
+			return errors.New("failed to load settings from SSM: " + err.Error())
+		}
+
+		slog.Info("Loaded settings from SSM", "prefix", prefix, "settings", len(loaded))
+	}
+
+	// KMS encrypted environment variables, like slack_webhook_enc, provide the setting without the suffix
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasSuffix(parts[0], "_enc") {
+			continue
+		}
+
+		value, err := decryptSetting(kms.New(sess), parts[1])
+		if err != nil {
+			return errors.New("failed to decrypt " + parts[0] + ": " + err.Error())
+		}
+
+		loaded[strings.TrimSuffix(parts[0], "_enc")] = value
+	}
+
+	settings = loaded
+
+	return nil
+}
+
+// Decrypts a base64 encoded ciphertext. Values encrypted via the Lambda console's encryption helpers
+// are bound to the function name via the encryption context, while ones encrypted via the CLI aren't.
+func decryptSetting(svc *kms.KMS, encrypted string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", errors.New("value is not base64 encoded: " + err.Error())
+	}
+
+	input := &kms.DecryptInput{
+		CiphertextBlob: ciphertext,
+	}
+
+	if functionName, exists := os.LookupEnv("AWS_LAMBDA_FUNCTION_NAME"); exists {
+		input.EncryptionContext = map[string]*string{"LambdaFunctionName": aws.String(functionName)}
+	}
+
+	res, err := svc.Decrypt(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == kms.ErrCodeInvalidCiphertextException && input.EncryptionContext != nil {
+		input.EncryptionContext = nil
+		res, err = svc.Decrypt(input)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(res.Plaintext), nil
+}
+
+// Settings from Secrets Manager are cached across warm invocations, but (unlike the ones from SSM)
+// refreshed periodically, so that rotated secrets get picked up without a cold start
+type SecretCache struct {
+	id string
+	values map[string]string
+	versionId string
+	fetchedAt time.Time
+	refreshInterval time.Duration
+}
+
+var secrets *SecretCache
+var secretsLock sync.Mutex // Notifiers can expire the secret concurrently
+
+// Loads the JSON secret set via secret_id (eg. {"slack_webhook": "...", "pagerduty_key": "..."}), if it
+// hasn't been loaded yet, or is due a refresh. If a refresh fails, we carry on with the values we have.
+func loadSecrets(sess *session.Session) error {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	secretId := getSetting("secret_id")
+	if secretId == "" {
+		return nil
+	}
+
+	if secrets == nil || secrets.id != secretId {
+		refreshInterval := DefaultSecretRefreshInterval
+
+		if interval, exists := lookupSetting("secret_refresh_interval"); exists {
+			var err error
+			if refreshInterval, err = time.ParseDuration(interval); err != nil {
+				return errors.New("could not parse secret_refresh_interval: " + err.Error())
+			}
+		}
+
+		secrets = &SecretCache{
+			id: secretId,
+			refreshInterval: refreshInterval,
+		}
+	}
+
+	if secrets.values != nil && time.Since(secrets.fetchedAt) < secrets.refreshInterval {
+		return nil
+	}
+
+	slog.Info("Fetching secret from Secrets Manager...", "secret_id", secretId)
+
+	res, err := secretsmanager.New(sess).GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretId),
+	})
+
+	if err == nil && res.SecretString == nil {
+		err = errors.New("secret has no string value")
+	}
+
+	var values map[string]string
+	if err == nil {
+		if err = json.Unmarshal([]byte(aws.StringValue(res.SecretString)), &values); err != nil {
+			err = errors.New("secret is not a JSON object of strings: " + err.Error())
+		}
+	}
+
+	if err != nil {
+		if secrets.values != nil {
+			slog.Warn("Failed to refresh secret, using cached values", "secret_id", secretId, "error", err.Error())
+			return nil
+		}
+
+		return errors.New("failed to fetch secret " + secretId + ": " + err.Error())
+	}
+
+	versionId := aws.StringValue(res.VersionId)
+	if secrets.versionId != "" && secrets.versionId != versionId {
+		slog.Info("Secret has been rotated", "secret_id", secretId, "version_id", versionId)
+	}
+
+	secrets.values = values
+	secrets.versionId = versionId
+	secrets.fetchedAt = time.Now()
+
+	return nil
+}
+
+// Changes whenever the secret is rotated (or an empty string if there isn't one)
+func secretsVersion() string {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	if secrets == nil {
+		return ""
+	}
+
+	return secrets.versionId
+}
+
+// Forces a refresh of the secret on the next invocation, for when credentials from it get rejected
+func expireSecrets() {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	if secrets != nil {
+		secrets.fetchedAt = time.Time{}
+	}
+}
+
+// Environment variables take precedence over Secrets Manager, which takes precedence over SSM
+func lookupSetting(name string) (string, bool) {
+	if value, exists := os.LookupEnv(name); exists {
+		return value, true
+	}
+
+	if secrets != nil {
+		if value, exists := secrets.values[name]; exists {
+			return value, true
+		}
+	}
+
+	value, exists := settings[name]
+	return value, exists
+}
+
+func getSetting(name string) string {
+	value, _ := lookupSetting(name)
+	return value
+}
+
+// Comma-separated list, without blank entries, or the defaults if the setting isn't set
+func getListSetting(name string, defaults ...string) []string {
+	value, exists := lookupSetting(name)
+	if !exists {
+		return defaults
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func contains(s []string, el string) bool {
+	for _, v := range s {
+		if v == el {
+			return true
+		}
+	}
+
+	return false
+}
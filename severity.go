@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"github.com/motns/aws-notifier/pkg/route"
+)
+
+// Severity rules are evaluated in order, and the first one matching a notification overrides the severity
+// assigned by its handler
+type SeverityRule struct {
+	Match route.Match `json:"match"`
+	Condition string `json:"condition"` // CEL expression, which has to evaluate to true on top of the match
+	Severity string `json:"severity"`
+	program cel.Program
+}
+
+func (c *Config) classify(notification notify.Notification) notify.Notification {
+	for _, rule := range c.Severities {
+		if rule.Match.Matches(notification) && route.ConditionHolds(rule.program, rule.Condition, notification) {
+			notification.Severity = rule.Severity
+			break
+		}
+	}
+
+	return notification
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/http"
+)
+
+/**
+Example payload POSTed to webhook channels:
+
+{
+  "source": "aws.cloudwatch",
+  "detail_type": "CloudWatch Alarm State Change",
+  "account": "123456789012",
+  "region": "eu-west-1",
+  "title": "High CPU on payments-api",
+  "summary": "Threshold Crossed: 1 datapoint [92.5] was greater than the threshold (80.0)",
+  "severity": "error",
+  "time": "2024-03-01T12:00:00Z",
+  "console_url": "https://console.aws.amazon.com/cloudwatch/home?region=eu-west-1#alarmsV2:alarm/High%20CPU",
+  "fields": [
+    {"title": "Reason", "value": "Threshold Crossed"}
+  ],
+  "event": {...}
+}
+
+With a signing secret, the body is signed with HMAC-SHA256, and the signature sent in a header like:
+
+X-Signature-256: sha256=3f1c9a...
+*/
+
+const DefaultSignatureHeader = "X-Signature-256"
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Types for the webhook payload
+
+type WebhookPayload struct {
+	Source string `json:"source"`
+	DetailType string `json:"detail_type"`
+	Account string `json:"account,omitempty"`
+	Region string `json:"region,omitempty"`
+	Title string `json:"title"`
+	Summary string `json:"summary"`
+	Severity string `json:"severity"`
+	Time string `json:"time,omitempty"`
+	ConsoleURL string `json:"console_url,omitempty"`
+	RunbookURL string `json:"runbook_url,omitempty"`
+	Fields []WebhookField `json:"fields"`
+	Event interface{} `json:"event,omitempty"`
+}
+
+type WebhookField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Forwards notifications as JSON to an arbitrary HTTP endpoint, so that other systems can act on them
+type WebhookNotifier struct {
+	url string
+	secret string // Signs the body if set, so that receivers can verify it came from us
+	signatureHeader string
+	client *http.Client // Uses the shared client if nil
+}
+
+func newWebhookNotifier(url string, secret string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url: url,
+		secret: secret,
+		signatureHeader: DefaultSignatureHeader,
+	}
+}
+
+func (n *WebhookNotifier) Send(ctx context.Context, notification notify.Notification) error {
+	payload := WebhookPayload {
+		Source: notification.Source,
+		DetailType: notification.DetailType,
+		Account: notification.Account,
+		Region: notification.Region,
+		Title: notification.Title,
+		Summary: notification.Summary,
+		Severity: notification.Severity,
+		Time: notification.Time,
+		ConsoleURL: notification.ConsoleURL,
+		RunbookURL: notification.RunbookURL,
+		Fields: []WebhookField{},
+		Event: notification.Event,
+	}
+
+	for _, f := range notification.Fields {
+		payload.Fields = append(payload.Fields, WebhookField{Title: f.Title, Value: f.Value})
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.New("failed to marshal webhook payload: " + err.Error())
+	}
+
+	err = notify.RetryDelivery(ctx, func() error {
+		req, err := http.NewRequest("POST", n.url, bytes.NewBuffer(body))
+		if err != nil {
+			return errors.New("failed to create webhook request: " + err.Error())
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+
+		if n.secret != "" {
+			req.Header.Set(n.signatureHeader, signPayload(n.secret, body))
+		}
+
+		res, err := notify.ClientOrShared(n.client).Do(req.WithContext(ctx))
+		if err != nil {
+			return &notify.HTTPError{Service: "Webhook", Err: err}
+		}
+		defer res.Body.Close()
+
+		return notify.CheckHTTPResponse("Webhook", res)
+	})
+
+	// Returned as is, so that callers can tell whether it's temporary
+	if err != nil {
+		logger(ctx).Error("Failed to post to webhook", "error", err.Error())
+		return err
+	}
+
+	logger(ctx).Info("Posted to webhook")
+
+	return nil
+}
+
+// In the same format as GitHub's X-Hub-Signature-256, which most receivers know how to verify
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
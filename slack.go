@@ -1,53 +1,127 @@
 package main
 
 import (
-	"net/http"
-	"bytes"
 	"encoding/json"
 	"errors"
-	"log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"time"
 )
 
-const ColorInfo = "#00BFFF" // Deep Sky Blue
-const ColorSuccess = "#00FF00" // Lime
-const ColorWarn = "#FFD700" // Gold
-const ColorError = "#DC143C" // Crimson
+// The Slack notifier itself lives in pkg/notify - this sets it up from the settings, with the thread and payload
+// stores, message templates and secret rotation of the Lambda function plugged in
 
-type SlackMessage struct {
-	Attachments []SlackAttachment `json:"attachments"`
-}
+// Configured even without a web hook or token, since Slack channels from the config are based on it. Templates
+// from the config file take precedence over the ones loaded from the template bucket.
+func newSlackNotifierFromSettings(sess *session.Session, templates map[string]*MessageTemplate, config *Config) (*notify.SlackNotifier, error) {
+	var err error
 
-type SlackAttachment struct {
-	Fallback string `json:"fallback"`
-	Color string `json:"color"`
-	Fields []SlackField `json:"fields"`
-}
+	slackNotifier := notify.NewSlackNotifier(getSetting("slack_webhook"), getSetting("slack_token"))
+	slackNotifier.SigningSecret = getSetting("slack_signing_secret")
 
-type SlackField struct {
-	Title string `json:"title"`
-	Value string `json:"value"`
-	Short bool `json:"short"`
-}
+	if webhookFormat, exists := lookupSetting("slack_webhook_format"); exists {
+		if !contains([]string{notify.WebhookFormatAttachments, notify.WebhookFormatWorkflow}, webhookFormat) {
+			return nil, errors.New("unsupported slack_webhook_format: " + webhookFormat)
+		}
 
-type SlackNotifier struct {
-	webhook string
-}
+		slackNotifier.WebhookFormat = webhookFormat
+	}
 
-func (n *SlackNotifier) sendMessage(msg SlackMessage) error {
-	log.Print("Sending Slack message...")
+	// Replaces the defaults entirely, so that "{}" disables prefixes
+	if severityPrefixes, exists := lookupSetting("severity_prefixes"); exists {
+		var prefixes map[string]string
 
-	payload, err := json.Marshal(msg)
-	if err != nil {
-		return errors.New("Failed to marshal Slack message: " + err.Error())
+		if err := json.Unmarshal([]byte(severityPrefixes), &prefixes); err != nil {
+			return nil, errors.New("could not parse severity_prefixes: " + err.Error())
+		}
+
+		slackNotifier.SeverityPrefixes = prefixes
 	}
 
-	res, err := http.Post(n.webhook, "application/json", bytes.NewBuffer(payload))
-	res.Body.Close()
+	slackNotifier.Mention = getSetting("slack_mention")
+
+	if channelMentions, exists := lookupSetting("slack_channel_mentions"); exists {
+		if err := json.Unmarshal([]byte(channelMentions), &slackNotifier.ChannelMentions); err != nil {
+			return nil, errors.New("could not parse slack_channel_mentions: " + err.Error())
+		}
+	}
+
+	if identities, exists := lookupSetting("slack_identities"); exists {
+		if err := json.Unmarshal([]byte(identities), &slackNotifier.Identities); err != nil {
+			return nil, errors.New("could not parse slack_identities: " + err.Error())
+		}
+	}
+
+	slackNotifier.Times, err = newTimeFormatterFromEnv()
 	if err != nil {
-		return errors.New("Failed to send Slack message - got error: " + err.Error())
+		return nil, err
 	}
 
-	log.Print("Slack message sent")
+	if maxValueLength, exists := lookupSetting("slack_max_value_length"); exists {
+		if slackNotifier.MaxValueLength, err = strconv.Atoi(maxValueLength); err != nil {
+			return nil, errors.New("could not parse slack_max_value_length: " + err.Error())
+		}
+	}
+
+	if payloadBucket, exists := lookupSetting("payload_bucket"); exists {
+		slackNotifier.Payloads = &PayloadStore{
+			s3: s3.New(sess),
+			bucket: payloadBucket,
+			prefix: getSetting("payload_prefix"),
+		}
+	}
+
+	if config != nil && len(config.Templates) != 0 {
+		configTemplates, err := compileMessageTemplates(config.Templates)
+		if err != nil {
+			return nil, err
+		}
+
+		// Merged into a copy, since the ones loaded from S3 or SSM are cached
+		merged := make(map[string]*MessageTemplate)
+
+		for key, tmpl := range templates {
+			merged[key] = tmpl
+		}
+
+		for key, tmpl := range configTemplates {
+			merged[key] = tmpl
+		}
+
+		templates = merged
+	}
+
+	if templates != nil {
+		slackNotifier.Templates = MessageTemplates(templates)
+	}
+
+	slackNotifier.OnUnauthorized = expireSecrets
+
+	if _, exists := lookupSetting("slack_token"); exists {
+		slackChannel, exists := lookupSetting("slack_channel")
+		if !exists {
+			return nil, errors.New("could not read slack_channel from environment")
+		}
+
+		slackNotifier.Channel = slackChannel
+		slackNotifier.UpdateOnResolve = getSetting("slack_update_on_resolve") == "true"
+
+		if threadTable, exists := lookupSetting("slack_thread_table"); exists {
+			slackNotifier.Threads = &SlackThreadStore{
+				db: dynamodb.New(sess),
+				table: threadTable,
+			}
+		}
+
+		if window, exists := lookupSetting("correlation_window"); exists {
+			if slackNotifier.CorrelationWindow, err = time.ParseDuration(window); err != nil {
+				return nil, errors.New("could not parse correlation_window: " + err.Error())
+			}
+		}
+	}
 
-	return nil
+	return slackNotifier, nil
 }
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
 	"errors"
 )
@@ -69,222 +76,172 @@ Example Cloudwatch Alarm payload (encoded in Message):
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Types for reading SNS payloads
+// Event processor
 
-type SNSRecordList struct {
-	Records []SNSRecord `json:"Records"`
+func init() {
+	registerPayloadHandler(PayloadHandler {
+		name: "SNS Records",
+		matches: func(payload GenericEvent) bool {
+			return len(payload.Records) != 0 && payload.Records[0]["EventSource"] == "aws:sns"
+		},
+		handle: processSNSRecords,
+	})
 }
 
-type SNSRecord struct {
-	EventVersion string `json:"EventVersion"`
-	EventSubscriptionArn string `json:"EventSubscriptionArn"`
-	EventSource string `json:"EventSource"`
-	Sns SNSMessage `json:"Sns"`
-}
+func processSNSRecords(ctx context.Context, notifiers *NotifierRegistry, raw json.RawMessage) error {
+	var snsEvent lambdaevents.SNSEvent
 
-type SNSMessage struct {
-	SignatureVersion string `json:"SignatureVersion"`
-	Timestamp string `json:"Timestamp"`
-	Signature string `json:"Signature"`
-	SigningCertUrl string `json:"SigningCertUrl"`
-	MessageId string `json:"MessageId"`
-	Message string `json:"Message"`
-	MessageAttributes map[string]SMSMessageAttribute `json:"MessageAttributes"`
-	Type string `json:"Type"`
-	UnsubscribeUrl string `json:"UnsubscribeUrl"`
-	TopicArn string `json:"TopicArn"`
-	Subject string `json:"Subject"`
-}
+	err := json.Unmarshal(raw, &snsEvent)
+	if err != nil {
+		return errors.New("could not unmarshal SNS record list: " + err.Error())
+	}
 
-type SMSMessageAttribute struct {
-	Type string `json:"Type"`
-	Value string `json:"Value"`
-}
+	// Carry on with the rest of the records if one fails, and report all the failures at the end
+	var failures MultiError
 
-type CloudwatchAlarm struct {
-	AlarmName string `json:"AlarmName"`
-	AlarmDescription string `json:"AlarmDescription"`
-	AWSAccountId string `json:"AWSAccountId"`
-	NewStateValue string `json:"NewStateValue"`
-	NewStateReason string `json:"NewStateReason"`
-	StateChangeTime string `json:"StateChangeTime"`
-	Region string `json:"Region"`
-	OldStateValue string `json:"OldStateValue"`
-	Trigger CloudwatchAlarmTrigger `json:"Trigger"`
-}
+	for _, record := range snsEvent.Records {
+		err := processSNSMessage(ctx, notifiers, events.SNSMessage{SNSEntity: record.SNS})
 
-type CloudwatchAlarmTrigger struct {
-	MetricName string `json:"MetricName"`
-	Namespace string `json:"Namespace"`
-	Statistic string `json:"Statistic"`
-	Unit string `json:"Unit,omitempty"`
-	Dimensions []CloudwatchAlarmTriggerDimension
-	Period int `json:"Period"`
-	EvaluationPeriods int `json:"EvaluationPeriods"`
-	ComparisonOperator string `json:"ComparisonOperator"`
-	Threshold float32 `json:"Threshold"`
-}
+		if err != nil {
+			logger(ctx).Error("Could not process SNS record", "sns_message_id", record.SNS.MessageID, "error", err.Error())
+			failures.add("SNS record " + record.SNS.MessageID, err)
+		}
+	}
 
-type CloudwatchAlarmTriggerDimension struct {
-	Name string `json:"name"`
-	Value string `json:"value"`
+	return failures.errorOrNil()
 }
 
+func processSNSMessage(ctx context.Context, notifiers *NotifierRegistry, message events.SNSMessage) error {
+	ctx = withLogAttrs(ctx, "sns_message_id", message.MessageID, "topic_arn", message.TopicArn, "subject", message.Subject)
+	metrics(ctx).count("EventsReceived", "Source", "aws:sns")
 
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Event processor
+	invocationEvent(ctx).add("events", 1)
+	invocationEvent(ctx).include("sns_message_ids", message.MessageID)
 
-func processSNSRecords(slackNotifier *SlackNotifier, pagerdutyNotifier *PagerdutyNotifier, raw []byte) error {
-	var recordList SNSRecordList
+	// SNS delivers messages at least once, and failed invocations are retried
+	return notifiers.once(ctx, "sns/" + message.MessageID, "aws:sns", func() error {
+		return handleSNSMessage(ctx, notifiers, message)
+	})
+}
 
-	err := json.Unmarshal(raw, &recordList)
-	if err != nil {
-		return errors.New("could not unmarshal SNS record list: " + err.Error())
+// SNS messages delivered via an SQS queue or an HTTP endpoint (see webhooks.go) can be subscription confirmations,
+// which carry the SubscribeURL. Lambda subscriptions are confirmed by SNS itself, so the records the function is
+// invoked with never are.
+func processDeliveredSNSMessage(ctx context.Context, notifiers *NotifierRegistry, message events.SNSMessage) error {
+	if message.Type != "SubscriptionConfirmation" {
+		return processSNSMessage(ctx, notifiers, message)
 	}
 
-	for _, record := range recordList.Records {
-		err := processSNSRecord(slackNotifier, pagerdutyNotifier, record)
-
-		if err != nil {
-			return errors.New("could not process SNS record: " + err.Error())
-		}
-	}
+	ctx = withLogAttrs(ctx, "sns_message_id", message.MessageID, "topic_arn", message.TopicArn)
+	metrics(ctx).count("EventsReceived", "Source", "aws:sns")
 
-	return nil
+	return notifiers.once(ctx, "sns/" + message.MessageID, "aws:sns", func() error {
+		return confirmSNSSubscription(ctx, notifiers, message)
+	})
 }
 
-func processSNSRecord(slackNotifier *SlackNotifier, pagerdutyNotifier *PagerdutyNotifier, record SNSRecord) error {
+func handleSNSMessage(ctx context.Context, notifiers *NotifierRegistry, message events.SNSMessage) error {
 	// Cloudwatch Alarm
-	if strings.Contains(record.Sns.Subject, "ALARM:") || strings.Contains(record.Sns.Subject, "OK:") {
-		var alarm CloudwatchAlarm
-		isFailing := strings.Contains(record.Sns.Subject, "ALARM:")
+	if strings.Contains(message.Subject, "ALARM:") || strings.Contains(message.Subject, "OK:") ||
+		strings.Contains(message.Subject, "INSUFFICIENT_DATA:") {
+		var alarm events.CloudwatchAlarm
 
-		err := json.Unmarshal([]byte(record.Sns.Message), &alarm)
+		err := json.Unmarshal([]byte(message.Message), &alarm)
 		if err != nil {
 			return errors.New("could not unmarshal Cloudwatch Alarm payload: " + err.Error())
 		}
 
-		fields := []SlackField {
-			{
-				Title: record.Sns.Subject,
-				Value: alarm.NewStateReason,
-				Short: false,
-			},
-		}
+		notification := events.SNSAlarmNotification(message, alarm, notifiers.alarmTransition(ctx, alarm))
 
-		for _, d := range alarm.Trigger.Dimensions {
-			fields = append(fields, SlackField {
-				Title: d.Name,
-				Value: d.Value,
-				Short: true,
-			})
-		}
-
-		var color string
-		if isFailing {
-			color = ColorError
+		if graph, err := notifiers.graphs.render(ctx, notification.Region, alarm); err != nil {
+			logger(ctx).Warn("Could not render metric graph", "error", err.Error())
 		} else {
-			color = ColorSuccess
+			notification.ImageURL = graph
 		}
 
-		fields = append(fields, SlackField {
-			Title: "Namespace",
-			Value: alarm.Trigger.Namespace,
-			Short: true,
-		})
-
-		fields = append(fields, SlackField {
-			Title: "MetricName",
-			Value: alarm.Trigger.MetricName,
-			Short: true,
-		})
-
-		slackMessage := SlackMessage {
-			Attachments: []SlackAttachment {
-				{
-					Fallback: alarm.NewStateReason,
-					Color: color,
-					Fields: fields,
-				},
-			},
-		}
+		return notifiers.send(ctx, applySNSOverrides(ctx, message, notification))
+	} else if strings.Contains(message.Subject, "RDS Notification Message") {
+		// Treat as plain message for now
+		// TODO - Implement proper handling (need to work out structure)
+		notification := events.RDSNotification(message)
 
-		if err := slackNotifier.sendMessage(slackMessage); err != nil {
-			return err
-		}
+		return notifiers.send(ctx, applySNSOverrides(ctx, message, notification))
+	} else if event := gitHubEventFromSNS(message); event != "" {
+		return processGitHubEvent(ctx, notifiers, event, []byte(message.Message))
+	} else {
+		// Basic processing for all other (plain) SNS messages
+		notification := events.SNSNotification(message)
 
-		if isFailing {
-			incidentKey := "incident"
-			detailFields := make(map[string]string)
+		return notifiers.send(ctx, applySNSOverrides(ctx, message, notification))
+	}
+}
 
-			for _, dv := range alarm.Trigger.Dimensions {
-				incidentKey += dv.Value
-				detailFields[dv.Name] = dv.Value
-			}
+// Hosts SubscribeURLs are expected on
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
 
-			incident := PagerdutyIncident {
-				Description: record.Sns.Subject + "-" + alarm.NewStateReason,
-				IncidentKey: incidentKey,
-				Details: PagerdutyIncidentDetails{
-					Fields: detailFields,
-				},
-			}
+// Subscriptions which need confirming (like an SQS queue in another account, or an HTTP endpoint) are confirmed by
+// visiting the SubscribeURL, which we only do for SNS endpoints, so that we can't be made to request arbitrary URLs
+func confirmSNSSubscription(ctx context.Context, notifiers *NotifierRegistry, message events.SNSMessage) error {
+	subscribeURL, err := url.Parse(message.SubscribeURL)
+	if err != nil || subscribeURL.Scheme != "https" || !snsHostPattern.MatchString(subscribeURL.Host) {
+		return errors.New("refusing to confirm SNS subscription via unexpected SubscribeURL: " + message.SubscribeURL)
+	}
 
-			if err := pagerdutyNotifier.triggerIncident(incident); err != nil {
-				return err
-			}
+	logger(ctx).Info("Confirming SNS subscription")
 
-			return nil
-		} else {
-			return nil
-		}
-	} else if strings.Contains(record.Sns.Subject, "RDS Notification Message") {
-		// Treat as plain message for now
-		// TODO - Implement proper handling (need to work out structure)
-		slackMessage := SlackMessage {
-			Attachments: []SlackAttachment {
-				{
-					Fallback:record.Sns.Message,
-					Color: ColorInfo,
-					Fields: []SlackField {
-						{
-							Title: record.Sns.Subject,
-							Value: record.Sns.Message,
-							Short: false,
-						},
-					},
-				},
-			},
-		}
+	req, err := http.NewRequest("GET", message.SubscribeURL, nil)
+	if err != nil {
+		return errors.New("failed to create SNS subscription confirmation request: " + err.Error())
+	}
 
-		if err := slackNotifier.sendMessage(slackMessage); err != nil {
-			return err
+	err = notify.RetryDelivery(ctx, func() error {
+		res, err := notify.ClientOrShared(nil).Do(req.WithContext(ctx))
+		if err != nil {
+			return &notify.HTTPError{Service: "SNS", Err: err}
 		}
+		defer res.Body.Close()
 
-		return nil
-	} else {
-		// Basic processing for all other (plain) SNS messages
-		slackMessage := SlackMessage {
-			Attachments: []SlackAttachment {
-				{
-					Fallback:record.Sns.Message,
-					Color: ColorInfo,
-					Fields: []SlackField {
-						{
-							Title: record.Sns.Subject,
-							Value: record.Sns.Message,
-							Short: false,
-						},
-					},
-				},
-			},
+		return notify.CheckHTTPResponse("SNS", res)
+	})
+
+	if err != nil {
+		return errors.New("failed to confirm SNS subscription: " + err.Error())
+	}
+
+	return notifiers.send(ctx, events.SNSSubscriptionNotification(message))
+}
+
+// Publishers can influence how their messages are handled via message attributes (unless sns_overrides is
+// set to "false"):
+//   notifier.channel: Comma-separated channels to send to, instead of the routed ones
+//   notifier.severity: Overrides the severity assigned by the handler
+//   notifier.page: "true" to trigger a Pagerduty incident, regardless of the severity
+func applySNSOverrides(ctx context.Context, message events.SNSMessage, notification notify.Notification) notify.Notification {
+	if getSetting("sns_overrides") == "false" {
+		return notification
+	}
+
+	if channels := events.SNSAttribute(message, "notifier.channel"); channels != "" {
+		notification.Channels = nil
+
+		for _, channel := range strings.Split(channels, ",") {
+			if channel = strings.TrimSpace(channel); channel != "" {
+				notification.Channels = append(notification.Channels, channel)
+			}
 		}
+	}
 
-		if err := slackNotifier.sendMessage(slackMessage); err != nil {
-			return err
+	if severity := events.SNSAttribute(message, "notifier.severity"); severity != "" {
+		if notify.ValidSeverity(severity) {
+			notification.Severity = severity
+		} else {
+			logger(ctx).Warn("Ignoring invalid severity in message attributes", "severity", severity)
 		}
+	}
 
-		return nil
+	if events.SNSAttribute(message, "notifier.page") == "true" {
+		notification.Page = true
 	}
-}
\ No newline at end of file
+
+	return notification
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Answers every request with a 200, and records the URLs requested
+type recordingTransport struct {
+	requested []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requested = append(t.requested, req.URL.String())
+
+	return &http.Response{
+		StatusCode: 200,
+		Body: io.NopCloser(strings.NewReader("<ConfirmSubscriptionResponse/>")),
+		Header: make(http.Header),
+		Request: req,
+	}, nil
+}
+
+func TestConfirmSNSSubscription(t *testing.T) {
+	tests := []struct {
+		name string
+		subscribeURL string
+		confirmed bool
+	}{
+		{"SNS endpoint", "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription&Token=abc", true},
+		{"SNS endpoint in China", "https://sns.cn-north-1.amazonaws.com.cn/?Action=ConfirmSubscription&Token=abc", true},
+		{"plain HTTP", "http://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription&Token=abc", false},
+		{"another host", "https://example.com/?Action=ConfirmSubscription&Token=abc", false},
+		{"another AWS service", "https://sqs.eu-west-1.amazonaws.com/?Action=ConfirmSubscription", false},
+		{"SNS endpoint as a subdomain", "https://sns.eu-west-1.amazonaws.com.example.com/", false},
+		{"SNS endpoint as the user info", "https://sns.eu-west-1.amazonaws.com@example.com/", false},
+		{"another port", "https://sns.eu-west-1.amazonaws.com:8443/", false},
+		{"internal address", "https://169.254.169.254/latest/meta-data/", false},
+		{"relative URL", "/?Action=ConfirmSubscription", false},
+		{"missing", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := &recordingTransport{}
+
+			shared := notify.HTTPClient
+			notify.HTTPClient = &http.Client{Transport: transport}
+			defer func() { notify.HTTPClient = shared }()
+
+			notifier := &recordingNotifier{}
+			registry := newNotifierRegistry()
+			registry.register("test", notifier)
+
+			message := events.SNSMessage {
+				SNSEntity: lambdaevents.SNSEntity {
+					Type: "SubscriptionConfirmation",
+					MessageID: "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+					TopicArn: "arn:aws:sns:eu-west-1:123456789012:alerts",
+				},
+				SubscribeURL: test.subscribeURL,
+			}
+
+			err := confirmSNSSubscription(context.Background(), registry, message)
+
+			if !test.confirmed {
+				if err == nil {
+					t.Error("expected the SubscribeURL to be refused")
+				}
+
+				if len(transport.requested) != 0 || notifier.count() != 0 {
+					t.Errorf("expected nothing to be requested or sent, got %v", transport.requested)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(transport.requested) != 1 || transport.requested[0] != test.subscribeURL {
+				t.Errorf("expected the SubscribeURL to be requested once, got %v", transport.requested)
+			}
+
+			if notifier.count() != 1 {
+				t.Errorf("expected the confirmation to be notified about, got %d notifications", notifier.count())
+			}
+		})
+	}
+}
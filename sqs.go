@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/events"
+)
+
+/**
+Example SQS payload:
+
+{
+  "Records": [
+    {
+      "messageId": "059f36b4-87a3-44ab-83d2-661975830a7d",
+      "receiptHandle": "AQEBwJnKyrHigUMZj6rYigCgxlaS3SLy0a...",
+      "body": "{\"version\":\"0\",\"id\":\"...\",\"detail-type\":\"EC2 Instance State-change Notification\",...}",
+      "attributes": {
+        "ApproximateReceiveCount": "1",
+        "SentTimestamp": "1545082649183",
+        "SenderId": "AIDAIENQZJOLO23YVJ4VO",
+        "ApproximateFirstReceiveTimestamp": "1545082649185"
+      },
+      "messageAttributes": {},
+      "md5OfBody": "e4e68fb7bd0e697a0ae8f1bb342846b3",
+      "eventSource": "aws:sqs",
+      "eventSourceARN": "arn:aws:sqs:us-east-2:123456789012:my-queue",
+      "awsRegion": "us-east-2"
+    }
+  ]
+}
+
+The body is either a Cloudwatch Event (from an Eventbridge rule targeting the queue), or an SNS notification
+(from a topic subscription without raw message delivery), like:
+
+{
+  "Type": "Notification",
+  "MessageId": "95df01b4-ee98-5cb9-9903-4c221d41eb5e",
+  "TopicArn": "arn:aws:sns:us-east-2:123456789012:alarms",
+  "Subject": "ALARM: \"Example alarm name\" in EU - Ireland",
+  "Message": "{\"AlarmName\":\"Example alarm name\",...}",
+  ...
+}
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Types for reading SQS payloads
+
+// Returned to Lambda, so that only the failed messages are retried (requires ReportBatchItemFailures to be
+// enabled on the event source mapping - otherwise the whole batch is deleted, even if some messages failed)
+type SQSBatchResponse struct {
+	BatchItemFailures []SQSBatchItemFailure `json:"batchItemFailures"`
+}
+
+type SQSBatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Event processor
+
+func init() {
+	registerPayloadHandler(PayloadHandler {
+		name: "SQS Records",
+		matches: func(payload GenericEvent) bool {
+			return len(payload.Records) != 0 && payload.Records[0]["eventSource"] == "aws:sqs"
+		},
+		respond: processSQSRecords,
+	})
+}
+
+func processSQSRecords(ctx context.Context, notifiers *NotifierRegistry, raw json.RawMessage) (interface{}, error) {
+	var sqsEvent lambdaevents.SQSEvent
+
+	err := json.Unmarshal(raw, &sqsEvent)
+	if err != nil {
+		return nil, errors.New("could not unmarshal SQS record list: " + err.Error())
+	}
+
+	response := SQSBatchResponse{BatchItemFailures: []SQSBatchItemFailure{}}
+
+	for _, record := range sqsEvent.Records {
+		if err := processSQSRecord(ctx, notifiers, record); err != nil {
+			logger(ctx).Error("Could not process SQS message", "sqs_message_id", record.MessageId, "error", err.Error())
+
+			response.BatchItemFailures = append(response.BatchItemFailures, SQSBatchItemFailure {
+				ItemIdentifier: record.MessageId,
+			})
+		}
+	}
+
+	return &response, nil
+}
+
+func processSQSRecord(ctx context.Context, notifiers *NotifierRegistry, record lambdaevents.SQSMessage) error {
+	ctx = withLogAttrs(ctx, "sqs_message_id", record.MessageId)
+
+	var message events.SNSMessage
+
+	if err := json.Unmarshal([]byte(record.Body), &message); err != nil {
+		return errors.New("message body is not JSON: " + err.Error())
+	}
+
+	// SNS notifications (and subscription confirmations) are handled like the ones delivered by SNS directly
+	if (message.Type == "Notification" || message.Type == "SubscriptionConfirmation") && message.TopicArn != "" {
+		return processDeliveredSNSMessage(ctx, notifiers, message)
+	}
+
+	_, err := processMessage(ctx, notifiers, json.RawMessage(record.Body))
+
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+// Buttons for silencing incidents are offered for these durations
+var SilenceDurations = []time.Duration{1 * time.Hour, 4 * time.Hour, 24 * time.Hour}
+
+// Keeps track of silenced incidents (like an alarm someone is already working on), keyed by their thread key.
+// Backed by a DynamoDB table with a string hash key called "suppression_key", and (ideally) TTL enabled on the
+// "expires_at" attribute, so that expired silences get cleaned up.
+type SuppressionStore struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+// Returns nil if suppression_table isn't set
+func newSuppressionStoreFromSettings(sess *session.Session) *SuppressionStore {
+	table, exists := lookupSetting("suppression_table")
+	if !exists {
+		return nil
+	}
+
+	return &SuppressionStore{
+		db: dynamodb.New(sess),
+		table: table,
+	}
+}
+
+func (s *SuppressionStore) silence(key string, until time.Time, user string) error {
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"suppression_key": {S: aws.String(key)},
+			"expires_at": {N: aws.String(strconv.FormatInt(until.Unix(), 10))},
+			"silenced_by": {S: aws.String(user)},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to save silence to DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+// DynamoDB only deletes expired items eventually, so the expiry is checked here as well
+func (s *SuppressionStore) silenced(key string, now time.Time) (bool, error) {
+	res, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"suppression_key": {S: aws.String(key)},
+		},
+	})
+
+	if err != nil {
+		return false, errors.New("failed to read silence from DynamoDB: " + err.Error())
+	}
+
+	expiresAt, exists := res.Item["expires_at"]
+	if !exists || expiresAt.N == nil {
+		return false, nil
+	}
+
+	until, err := strconv.ParseInt(*expiresAt.N, 10, 64)
+	if err != nil {
+		return false, errors.New("invalid silence expiry: " + *expiresAt.N)
+	}
+
+	return now.Unix() < until, nil
+}
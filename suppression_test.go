@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"testing"
+	"time"
+)
+
+func TestSilencedNotifications(t *testing.T) {
+	tests := []struct {
+		name string
+		threadKey string
+		threadAction string
+		silencedFor time.Duration // Negative for a silence which has expired already
+		failingTable bool
+		sent bool
+	}{
+		{"silenced", "cpu-high", notify.ThreadReply, time.Hour, false, false},
+		{"resolution of a silenced incident", "cpu-high", notify.ThreadResolve, time.Hour, false, true},
+		{"expired silence", "cpu-high", notify.ThreadReply, -time.Minute, false, true},
+		{"another incident", "disk-full", notify.ThreadStart, time.Hour, false, true},
+		{"silence can't be checked", "cpu-high", notify.ThreadReply, time.Hour, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			silences, db := newFakeDynamoDB(t, "suppression_key")
+			store := &SuppressionStore{db: db, table: "suppressions"}
+
+			if err := store.silence("cpu-high", time.Now().Add(test.silencedFor), "U123"); err != nil {
+				t.Fatal(err)
+			}
+
+			silences.setFailing(test.failingTable)
+
+			ops := &recordingNotifier{}
+
+			registry := newNotifierRegistry()
+			registry.register("ops", ops)
+			registry.suppressions = store
+
+			err := registry.send(context.Background(), notify.Notification {
+				Source: "aws.cloudwatch",
+				Title: "ALARM: \"" + test.threadKey + "\"",
+				ThreadKey: test.threadKey,
+				ThreadAction: test.threadAction,
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sent := ops.count() == 1; sent != test.sent {
+				t.Errorf("expected sent: %v, got %d notifications", test.sent, ops.count())
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"log/slog"
+	"strings"
+)
+
+// Looks up the tags of the resources involved in a notification, for EC2 instances (and other EC2
+// resources), Autoscaling Groups and Cloudwatch Alarms
+type TagResolver struct {
+	sess *session.Session
+	cache map[string]map[string]string // Keyed by ARN
+}
+
+func newTagResolver(sess *session.Session) *TagResolver {
+	return &TagResolver{
+		sess: sess,
+		cache: make(map[string]map[string]string),
+	}
+}
+
+// Merges the tags of all resources, with the first resource taking precedence. Resources we can't get
+// the tags of are skipped.
+func (t *TagResolver) resolve(ctx context.Context, notification notify.Notification) map[string]string {
+	tags := make(map[string]string)
+
+	for _, arn := range notification.Resources {
+		resourceTags, err := t.tagsFor(ctx, arn)
+		if err != nil {
+			slog.Warn("Could not look up tags", "arn", arn, "error", err.Error())
+			continue
+		}
+
+		for key, value := range resourceTags {
+			if _, exists := tags[key]; !exists {
+				tags[key] = value
+			}
+		}
+	}
+
+	return tags
+}
+
+func (t *TagResolver) tagsFor(ctx context.Context, arn string) (map[string]string, error) {
+	if tags, exists := t.cache[arn]; exists {
+		return tags, nil
+	}
+
+	parts := events.ParseARN(arn)
+	if parts == nil {
+		return nil, errors.New("invalid ARN")
+	}
+
+	service, region, resource := parts[2], parts[3], parts[5]
+	config := aws.NewConfig().WithRegion(region)
+	tags := make(map[string]string)
+
+	switch service {
+	case "ec2":
+		id := resource[strings.LastIndex(resource, "/") + 1:]
+
+		svc := ec2.New(t.sess, config)
+		traceAWSClient(svc.Client)
+
+		res, err := svc.DescribeTagsWithContext(ctx, &ec2.DescribeTagsInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("resource-id"), Values: []*string{aws.String(id)}},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range res.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	case "autoscaling":
+		i := strings.Index(resource, "autoScalingGroupName/")
+		if i == -1 {
+			return nil, errors.New("not an Autoscaling Group")
+		}
+
+		svc := autoscaling.New(t.sess, config)
+		traceAWSClient(svc.Client)
+
+		res, err := svc.DescribeTagsWithContext(ctx, &autoscaling.DescribeTagsInput{
+			Filters: []*autoscaling.Filter{
+				{Name: aws.String("auto-scaling-group"), Values: []*string{aws.String(resource[i + len("autoScalingGroupName/"):])}},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range res.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	case "cloudwatch":
+		svc := cloudwatch.New(t.sess, config)
+		traceAWSClient(svc.Client)
+
+		res, err := svc.ListTagsForResourceWithContext(ctx, &cloudwatch.ListTagsForResourceInput{
+			ResourceARN: aws.String(arn),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range res.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	default:
+		return nil, errors.New("unsupported service: " + service)
+	}
+
+	t.cache[arn] = tags
+
+	return tags, nil
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/**
+Example template definitions, keyed by "<source>/<detail-type>":
+
+{
+  "aws.ec2/EC2 Instance State-change Notification": {
+    "text": "Instance {{index .detail \"instance-id\"}} is now *{{.detail.state}}*",
+    "fields": [
+      {"title": "Region", "value": "{{.region}}", "short": true}
+    ]
+  },
+  "aws.cloudwatch/Alarm": {
+    "text": "{{.AlarmName}} is {{.NewStateValue}}"
+  }
+}
+
+Templates are executed against the original event, decoded into generic maps, so any field
+in the payload can be referenced (use the "index" function for keys containing dashes).
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type MessageTemplateDefinition struct {
+	Text string `json:"text"`
+	Fields []FieldTemplateDefinition `json:"fields"`
+}
+
+type FieldTemplateDefinition struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool `json:"short"`
+}
+
+// Parsed version of the above
+type MessageTemplate struct {
+	text *template.Template
+	fields []FieldTemplate
+}
+
+type FieldTemplate struct {
+	title *template.Template
+	value *template.Template
+	short bool
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Templates stored in S3 or SSM are reused for this long (across warm invocations), before being reloaded
+const DefaultMessageTemplatesTTL = 5 * time.Minute
+
+var cachedTemplates map[string]*MessageTemplate
+var cachedTemplatesExpiry time.Time
+var cachedTemplatesGeneration int // Counts changes, so that whatever holds on to the templates knows to rebuild
+var cachedTemplatesHash string // Of the definitions the templates were compiled from
+var cachedTemplatesLock sync.Mutex
+
+// Loads the template definitions from (in order of precedence) the message_templates env variable,
+// an S3 object via message_templates_s3 (s3://bucket/key), or an SSM parameter via message_templates_ssm.
+// Returns nil if none of these are configured. Templates from S3 or SSM are cached for message_templates_ttl,
+// and if reloading them fails, the previous ones are kept.
+func loadMessageTemplates(sess *session.Session) (map[string]*MessageTemplate, error) {
+	if inline, exists := lookupSetting("message_templates"); exists {
+		return parseMessageTemplates([]byte(inline))
+	}
+
+	ttl := DefaultMessageTemplatesTTL
+	if value, exists := lookupSetting("message_templates_ttl"); exists {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil {
+			return nil, errors.New("could not parse message_templates_ttl: " + err.Error())
+		}
+	}
+
+	cachedTemplatesLock.Lock()
+	defer cachedTemplatesLock.Unlock()
+
+	if cachedTemplates != nil && time.Now().Before(cachedTemplatesExpiry) {
+		return cachedTemplates, nil
+	}
+
+	definitions, err := fetchMessageTemplates(sess)
+	if err == nil && definitions == nil {
+		return nil, nil
+	}
+
+	var hash string
+	if err == nil {
+		hash, err = hashMessageTemplates(definitions)
+	}
+
+	// Unchanged templates are kept as they are, so that the runtime built from them isn't rebuilt every TTL
+	if err == nil && cachedTemplates != nil && hash == cachedTemplatesHash {
+		cachedTemplatesExpiry = time.Now().Add(ttl)
+		return cachedTemplates, nil
+	}
+
+	var templates map[string]*MessageTemplate
+	if err == nil {
+		templates, err = compileMessageTemplates(definitions)
+	}
+
+	if err != nil {
+		if cachedTemplates == nil {
+			return nil, err
+		}
+
+		slog.Warn("Failed to reload message templates, using the previous ones", "error", err.Error())
+		return cachedTemplates, nil
+	}
+
+	slog.Info("Loaded message templates", "templates", len(templates))
+
+	cachedTemplates = templates
+	cachedTemplatesExpiry = time.Now().Add(ttl)
+	cachedTemplatesGeneration++
+	cachedTemplatesHash = hash
+
+	return templates, nil
+}
+
+// Maps are marshalled with their keys sorted, so the same definitions always hash the same
+func hashMessageTemplates(definitions map[string]MessageTemplateDefinition) (string, error) {
+	encoded, err := json.Marshal(definitions)
+	if err != nil {
+		return "", errors.New("failed to marshal message templates: " + err.Error())
+	}
+
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func messageTemplatesGeneration() int {
+	cachedTemplatesLock.Lock()
+	defer cachedTemplatesLock.Unlock()
+
+	return cachedTemplatesGeneration
+}
+
+// Template definitions can be split across the objects under an S3 prefix (when message_templates_s3 ends
+// with a "/"), or the parameters under an SSM path (when message_templates_ssm does), like one per event type.
+// These are merged together. Returns nil if neither is configured.
+func fetchMessageTemplates(sess *session.Session) (map[string]MessageTemplateDefinition, error) {
+	var documents [][]byte
+
+	if s3Uri, exists := lookupSetting("message_templates_s3"); exists {
+		if strings.HasSuffix(s3Uri, "/") {
+			objects, err := readS3Prefix(sess, s3Uri)
+			if err != nil {
+				return nil, err
+			}
+
+			documents = objects
+		} else {
+			body, err := readS3Object(sess, s3Uri)
+			if err != nil {
+				return nil, err
+			}
+
+			documents = append(documents, body)
+		}
+	} else if parameter, exists := lookupSetting("message_templates_ssm"); exists {
+		if strings.HasSuffix(parameter, "/") {
+			values, err := readSSMPath(sess, parameter)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, value := range values {
+				documents = append(documents, []byte(value))
+			}
+		} else {
+			value, err := readSSMParameter(sess, parameter)
+			if err != nil {
+				return nil, err
+			}
+
+			documents = append(documents, []byte(value))
+		}
+	} else {
+		return nil, nil
+	}
+
+	definitions := make(map[string]MessageTemplateDefinition)
+
+	for _, document := range documents {
+		var partial map[string]MessageTemplateDefinition
+
+		if err := json.Unmarshal(document, &partial); err != nil {
+			return nil, errors.New("failed to unmarshal message templates: " + err.Error())
+		}
+
+		for key, definition := range partial {
+			definitions[key] = definition
+		}
+	}
+
+	return definitions, nil
+}
+
+func parseMessageTemplates(raw []byte) (map[string]*MessageTemplate, error) {
+	var definitions map[string]MessageTemplateDefinition
+
+	if err := json.Unmarshal(raw, &definitions); err != nil {
+		return nil, errors.New("failed to unmarshal message templates: " + err.Error())
+	}
+
+	return compileMessageTemplates(definitions)
+}
+
+// Every template which fails to parse is reported (as a ConfigError), not just the first one
+func compileMessageTemplates(definitions map[string]MessageTemplateDefinition) (map[string]*MessageTemplate, error) {
+	templates := make(map[string]*MessageTemplate)
+	var problems ConfigError
+
+	for key, def := range definitions {
+		var err error
+		tmpl := &MessageTemplate{}
+
+		if def.Text != "" {
+			if tmpl.text, err = newTemplate(key + ":text", def.Text); err != nil {
+				problems.add(err.Error())
+			}
+		}
+
+		for _, f := range def.Fields {
+			var field FieldTemplate
+			field.short = f.Short
+
+			if field.title, err = newTemplate(key + ":fields.title", f.Title); err != nil {
+				problems.add(err.Error())
+			}
+
+			if field.value, err = newTemplate(key + ":fields.value", f.Value); err != nil {
+				problems.add(err.Error())
+			}
+
+			tmpl.fields = append(tmpl.fields, field)
+		}
+
+		templates[key] = tmpl
+	}
+
+	if err := problems.errorOrNil(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+func newTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, errors.New("failed to parse message template " + name + ": " + err.Error())
+	}
+
+	return tmpl, nil
+}
+
+func executeTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Message templates keyed by "<source>/<detail-type>", which the Slack notifier renders messages with
+type MessageTemplates map[string]*MessageTemplate
+
+func (t MessageTemplates) Render(msg notify.SlackMessage) notify.SlackMessage {
+	return renderMessageTemplate(t, msg)
+}
+
+// Renders the template for the event type of the message (if there is one), replacing the text
+// and fields of the default message. Falls back to the default message if rendering fails.
+func renderMessageTemplate(templates map[string]*MessageTemplate, msg notify.SlackMessage) notify.SlackMessage {
+	key := msg.Template
+	if key == "" {
+		key = msg.Source + "/" + msg.DetailType
+	}
+
+	tmpl, exists := templates[key]
+	if !exists || msg.Event == nil {
+		return msg
+	}
+
+	rendered := msg
+	var err error
+
+	if tmpl.text != nil {
+		if rendered.Text, err = executeTemplate(tmpl.text, msg.Event); err != nil {
+			slog.Warn("Failed to render message template, using default message", "error", err.Error())
+			return msg
+		}
+	}
+
+	if len(tmpl.fields) != 0 && len(rendered.Attachments) != 0 {
+		var fields []notify.SlackField
+
+		for _, f := range tmpl.fields {
+			var field notify.SlackField
+			field.Short = f.short
+
+			if field.Title, err = executeTemplate(f.title, msg.Event); err != nil {
+				slog.Warn("Failed to render message template, using default message", "error", err.Error())
+				return msg
+			}
+
+			if field.Value, err = executeTemplate(f.value, msg.Event); err != nil {
+				slog.Warn("Failed to render message template, using default message", "error", err.Error())
+				return msg
+			}
+
+			fields = append(fields, field)
+		}
+
+		attachments := make([]notify.SlackAttachment, len(rendered.Attachments))
+		copy(attachments, rendered.Attachments)
+		attachments[0].Fields = fields
+		rendered.Attachments = attachments
+	}
+
+	return rendered
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func readS3Object(sess *session.Session, s3Uri string) ([]byte, error) {
+	u, err := url.Parse(s3Uri)
+	if err != nil || u.Scheme != "s3" {
+		return nil, errors.New("invalid S3 URI: " + s3Uri)
+	}
+
+	res, err := s3.New(sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key: aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read " + s3Uri + ": " + err.Error())
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.New("failed to read " + s3Uri + ": " + err.Error())
+	}
+
+	return body, nil
+}
+
+// Reads every object under the prefix, in the order of their keys
+func readS3Prefix(sess *session.Session, s3Uri string) ([][]byte, error) {
+	u, err := url.Parse(s3Uri)
+	if err != nil || u.Scheme != "s3" {
+		return nil, errors.New("invalid S3 URI: " + s3Uri)
+	}
+
+	var keys []string
+
+	err = s3.New(sess).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(u.Host),
+		Prefix: aws.String(strings.TrimPrefix(u.Path, "/")),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if key := aws.StringValue(object.Key); !strings.HasSuffix(key, "/") {
+				keys = append(keys, key)
+			}
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to list " + s3Uri + ": " + err.Error())
+	}
+
+	var objects [][]byte
+
+	for _, key := range keys {
+		body, err := readS3Object(sess, "s3://" + u.Host + "/" + key)
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, body)
+	}
+
+	return objects, nil
+}
+
+func readSSMParameter(sess *session.Session, name string) (string, error) {
+	res, err := ssm.New(sess).GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+
+	if err != nil {
+		return "", errors.New("failed to read SSM parameter " + name + ": " + err.Error())
+	}
+
+	return aws.StringValue(res.Parameter.Value), nil
+}
+
+// Reads every parameter under the path (recursively), in the order of their names
+func readSSMPath(sess *session.Session, path string) ([]string, error) {
+	var names []string
+	values := make(map[string]string)
+
+	err := ssm.New(sess).GetParametersByPathPages(&ssm.GetParametersByPathInput{
+		Path: aws.String(strings.TrimSuffix(path, "/")),
+		Recursive: aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, parameter := range page.Parameters {
+			name := aws.StringValue(parameter.Name)
+			names = append(names, name)
+			values[name] = aws.StringValue(parameter.Value)
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read SSM parameters under " + path + ": " + err.Error())
+	}
+
+	sort.Strings(names)
+
+	var ordered []string
+	for _, name := range names {
+		ordered = append(ordered, values[name])
+	}
+
+	return ordered, nil
+}
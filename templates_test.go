@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serves whatever body is set as every S3 object
+type fakeS3 struct {
+	body string
+	lock sync.Mutex
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	w.Write([]byte(f.body))
+}
+
+func (f *fakeS3) set(body string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.body = body
+}
+
+// Reloading templates which haven't changed keeps the generation (and so the runtime built from them) as it is
+func TestLoadMessageTemplatesGeneration(t *testing.T) {
+	fake := &fakeS3{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String("eu-west-1"),
+		Endpoint: aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		MaxRetries: aws.Int(0),
+	}))
+
+	t.Setenv("message_templates_s3", "s3://config/templates.json")
+	t.Setenv("message_templates_ttl", "0s") // Reloaded on every call
+
+	t.Cleanup(func() {
+		cachedTemplates = nil
+		cachedTemplatesHash = ""
+		cachedTemplatesExpiry = time.Time{}
+	})
+
+	original := `{"aws.ec2/EC2 Instance State-change Notification": {"text": "{{.detail.state}}"}}`
+
+	tests := []struct {
+		name string
+		body string
+		changed bool
+	}{
+		{"first load", original, true},
+		{"reloaded unchanged", original, false},
+		{"reformatted", "{\n  \"aws.ec2/EC2 Instance State-change Notification\": {\"text\": \"{{.detail.state}}\"}\n}", false},
+		{"changed", `{"aws.ec2/EC2 Instance State-change Notification": {"text": "now {{.detail.state}}"}}`, true},
+		{"invalid, so the previous ones are kept", `{"aws.ec2/EC2 Instance State-change Notification": {"text": "{{"}}`, false},
+		{"changed back", original, true},
+	}
+
+	// Each step reloads the templates left by the one before
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake.set(test.body)
+
+			before := runtimeGeneration("config-1")
+
+			templates, err := loadMessageTemplates(sess)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(templates) != 1 {
+				t.Fatalf("expected 1 template, got %d", len(templates))
+			}
+
+			if changed := runtimeGeneration("config-1") != before; changed != test.changed {
+				t.Errorf("expected the runtime generation to change: %v, got: %v", test.changed, changed)
+			}
+		})
+	}
+}
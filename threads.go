@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"time"
+)
+
+// Keeps track of which Slack thread belongs to which alarm, so that subsequent state
+// transitions can be posted as replies. Backed by a DynamoDB table with a string hash key
+// called "thread_key".
+type SlackThreadStore struct {
+	db *dynamodb.DynamoDB
+	table string
+}
+
+func (s *SlackThreadStore) Get(key string) (*notify.SlackThread, error) {
+	res, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"thread_key": {S: aws.String(key)},
+		},
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read Slack thread from DynamoDB: " + err.Error())
+	}
+
+	ts, exists := res.Item["thread_ts"]
+	if !exists || ts.S == nil {
+		return nil, nil
+	}
+
+	thread := &notify.SlackThread{Ts: *ts.S}
+
+	if channel, exists := res.Item["channel"]; exists && channel.S != nil {
+		thread.Channel = *channel.S
+	}
+
+	if attachments, exists := res.Item["attachments"]; exists && attachments.S != nil {
+		if err := json.Unmarshal([]byte(*attachments.S), &thread.Attachments); err != nil {
+			return nil, errors.New("failed to unmarshal Slack thread attachments: " + err.Error())
+		}
+	}
+
+	if startedAt, exists := res.Item["started_at"]; exists && startedAt.N != nil {
+		if seconds, err := strconv.ParseInt(*startedAt.N, 10, 64); err == nil {
+			thread.StartedAt = time.Unix(seconds, 0)
+		}
+	}
+
+	return thread, nil
+}
+
+func (s *SlackThreadStore) Put(key string, thread notify.SlackThread) error {
+	attachments, err := json.Marshal(thread.Attachments)
+	if err != nil {
+		return errors.New("failed to marshal Slack thread attachments: " + err.Error())
+	}
+
+	_, err = s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"thread_key": {S: aws.String(key)},
+			"thread_ts": {S: aws.String(thread.Ts)},
+			"channel": {S: aws.String(thread.Channel)},
+			"attachments": {S: aws.String(string(attachments))},
+			"started_at": {N: aws.String(strconv.FormatInt(thread.StartedAt.Unix(), 10))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to save Slack thread to DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
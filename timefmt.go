@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"time"
+	_ "time/tzdata" // Lambda runtimes don't necessarily come with a timezone database
+)
+
+// Reads the time_zone (eg. "Europe/London"), time_format (a Go time layout) and time_relative
+// ("false" to disable relative times) environment variables
+func newTimeFormatterFromEnv() (*notify.TimeFormatter, error) {
+	location := time.UTC
+
+	if tz, exists := lookupSetting("time_zone"); exists {
+		var err error
+		if location, err = time.LoadLocation(tz); err != nil {
+			return nil, errors.New("invalid time_zone: " + err.Error())
+		}
+	}
+
+	return notify.NewTimeFormatter(location, getSetting("time_format"), getSetting("time_relative") != "false"), nil
+}
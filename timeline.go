@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultTimelineRetention = 30 * 24 * time.Hour
+const MaxHistoryEntries = 10 // Only the most recent entries are shown in resolution messages
+
+// Labels for the entries of a timeline, by the thread action of the notification they were recorded for
+var timelineLabels = map[string]string{
+	notify.ThreadStart: "Triggered",
+	notify.ThreadReply: "Updated",
+	notify.ThreadCorrelate: "Related event",
+	notify.ThreadResolve: "Resolved",
+}
+
+// What happened to an incident (anything with a thread key, like an alarm or an alert), and where it was
+// sent to
+type TimelineEntry struct {
+	Time time.Time `json:"time"`
+	Action string `json:"action"`
+	Title string `json:"title"`
+	Severity string `json:"severity"`
+	Channels []string `json:"channels"`
+}
+
+// Keeps the timeline of each incident in a DynamoDB table (with a string hash key called "timeline_key"),
+// keyed by the thread key of its notifications. Entries are kept as a list of JSON documents, and the item
+// expires (given TTL is enabled on "expires_at") once the incident has been quiet for the retention period.
+type TimelineStore struct {
+	db *dynamodb.DynamoDB
+	table string
+	retention time.Duration
+}
+
+// Returns nil if timeline_table isn't set
+func newTimelineStoreFromSettings(sess *session.Session) (*TimelineStore, error) {
+	table, exists := lookupSetting("timeline_table")
+	if !exists {
+		return nil, nil
+	}
+
+	timeline := &TimelineStore{
+		db: dynamodb.New(sess),
+		table: table,
+		retention: DefaultTimelineRetention,
+	}
+
+	if retention, exists := lookupSetting("timeline_retention"); exists {
+		var err error
+		if timeline.retention, err = time.ParseDuration(retention); err != nil {
+			return nil, errors.New("could not parse timeline_retention: " + err.Error())
+		}
+	}
+
+	return timeline, nil
+}
+
+func (s *TimelineStore) get(key string) ([]TimelineEntry, error) {
+	res, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"timeline_key": {S: aws.String(key)},
+		},
+	})
+
+	if err != nil {
+		return nil, errors.New("failed to read timeline from DynamoDB: " + err.Error())
+	}
+
+	var entries []TimelineEntry
+
+	if list, exists := res.Item["entries"]; exists {
+		for _, item := range list.L {
+			if item.S == nil {
+				continue
+			}
+
+			var entry TimelineEntry
+			if err := json.Unmarshal([]byte(*item.S), &entry); err != nil {
+				return nil, errors.New("failed to unmarshal timeline entry: " + err.Error())
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Appends to the timeline in a single update, so that concurrent invocations don't overwrite each other's entries
+func (s *TimelineStore) add(key string, entry TimelineEntry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return errors.New("failed to marshal timeline entry: " + err.Error())
+	}
+
+	_, err = s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"timeline_key": {S: aws.String(key)},
+		},
+		UpdateExpression: aws.String("SET entries = list_append(if_not_exists(entries, :empty), :entry), expires_at = :expires_at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":empty": {L: []*dynamodb.AttributeValue{}},
+			":entry": {L: []*dynamodb.AttributeValue{{S: aws.String(string(encoded))}}},
+			":expires_at": {N: aws.String(strconv.FormatInt(entry.Time.Add(s.retention).Unix(), 10))},
+		},
+	})
+
+	if err != nil {
+		return errors.New("failed to save timeline entry to DynamoDB: " + err.Error())
+	}
+
+	return nil
+}
+
+// Resolutions get a "History" field with the timeline of the incident so far. A missing timeline doesn't hold
+// up the notification.
+func (r *NotifierRegistry) addHistory(ctx context.Context, notification notify.Notification) notify.Notification {
+	if r.timeline == nil || notification.ThreadKey == "" || notification.ThreadAction != notify.ThreadResolve {
+		return notification
+	}
+
+	entries, err := r.timeline.get(notification.ThreadKey)
+	if err != nil {
+		logger(ctx).Warn(err.Error(), "thread_key", notification.ThreadKey)
+		return notification
+	} else if len(entries) == 0 {
+		return notification
+	}
+
+	notification.Fields = append(append([]notify.Field{}, notification.Fields...), notify.Field {
+		Title: "History",
+		Value: formatHistory(entries),
+		Short: false,
+	})
+
+	return notification
+}
+
+func (r *NotifierRegistry) recordTimeline(ctx context.Context, notification notify.Notification, channels []string) {
+	if r.timeline == nil || notification.ThreadKey == "" || len(channels) == 0 {
+		return
+	}
+
+	label, exists := timelineLabels[notification.ThreadAction]
+	if !exists {
+		return
+	}
+
+	err := r.timeline.add(notification.ThreadKey, TimelineEntry {
+		Time: time.Now().UTC(),
+		Action: label,
+		Title: notification.Title,
+		Severity: notification.Severity,
+		Channels: channels,
+	})
+
+	if err != nil {
+		logger(ctx).Warn(err.Error(), "thread_key", notification.ThreadKey)
+	}
+}
+
+// Records something done about the incident by hand (like acknowledging it)
+func (r *NotifierRegistry) recordIncidentAction(ctx context.Context, key string, action string) {
+	if r.timeline == nil {
+		return
+	}
+
+	if err := r.timeline.add(key, TimelineEntry{Time: time.Now().UTC(), Action: action}); err != nil {
+		logger(ctx).Warn(err.Error(), "thread_key", key)
+	}
+}
+
+// One line per entry, like "Jan 2 14:02 UTC Triggered: High CPU (error) - sent to ops, pagerduty"
+func formatHistory(entries []TimelineEntry) string {
+	var lines []string
+
+	if len(entries) > MaxHistoryEntries {
+		lines = append(lines, strconv.Itoa(len(entries) - MaxHistoryEntries) + " earlier entries")
+		entries = entries[len(entries) - MaxHistoryEntries:]
+	}
+
+	for _, entry := range entries {
+		line := entry.Time.UTC().Format("Jan 2 15:04 MST") + " " + entry.Action
+
+		if entry.Title != "" {
+			line += ": " + entry.Title
+		}
+
+		if entry.Severity != "" {
+			line += " (" + entry.Severity + ")"
+		}
+
+		if len(entry.Channels) != 0 {
+			line += " - sent to " + strings.Join(entry.Channels, ", ")
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
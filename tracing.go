@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/motns/aws-notifier/pkg/notify"
+)
+
+// X-Ray tracing (which also needs active tracing to be enabled on the Lambda function). Each handler and
+// notifier gets a subsegment, as do outbound HTTP calls (to Slack and Pagerduty), and AWS SDK calls made
+// by clients passed to traceAWSClient (with a context).
+
+var tracingEnabled bool
+
+func configureTracing() error {
+	enabled := getSetting("xray_tracing") == "true"
+	if enabled == tracingEnabled {
+		return nil
+	}
+
+	if enabled {
+		// Rather than panicking when there's no segment to add to (like outside Lambda)
+		err := xray.Configure(xray.Config{ContextMissingStrategy: ctxmissing.NewDefaultLogErrorStrategy()})
+		if err != nil {
+			return errors.New("failed to configure X-Ray: " + err.Error())
+		}
+	}
+
+	tracingEnabled = enabled
+	notify.HTTPClient = newHTTPClient(httpClientConfig)
+
+	return nil
+}
+
+func traceAWSClient(c *client.Client) {
+	if tracingEnabled {
+		xray.AWS(c)
+	}
+}
+
+// Runs fn in a subsegment with the given name, if tracing is enabled
+func traceSegment(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	if !tracingEnabled {
+		return fn(ctx)
+	}
+
+	return xray.Capture(ctx, name, fn)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	lambdaevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/support"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const TrustedAdvisorTopChecks = 10
+
+// The Support API only has an endpoint in us-east-1
+const SupportAPIRegion = "us-east-1"
+
+// Trusted Advisor categories, in the order they're listed in the report
+var trustedAdvisorCategories = []struct {
+	id string
+	name string
+}{
+	{"cost_optimizing", "Cost Optimization"},
+	{"security", "Security"},
+	{"fault_tolerance", "Fault Tolerance"},
+	{"performance", "Performance"},
+	{"service_limits", "Service Limits"},
+}
+
+// Check statuses, worst first (checks which are "ok" or "not_available" aren't flagged)
+var trustedAdvisorStatuses = map[string]string{
+	"error": "action recommended",
+	"warning": "investigation recommended",
+}
+
+// Posts a summary of the Trusted Advisor checks which flag something, by category, once a week. Triggered by a
+// dedicated Cloudwatch Events schedule rule (like "cron(0 8 ? * MON *)"), like the cost report. Needs a Business
+// (or higher) support plan, since the Support API isn't available otherwise.
+type TrustedAdvisorReport struct {
+	sess *session.Session
+	rule string // Name of the schedule rule which triggers the report
+	channels []string
+}
+
+// Returns nil if trusted_advisor_rule isn't set
+func newTrustedAdvisorReportFromSettings(sess *session.Session) *TrustedAdvisorReport {
+	rule, exists := lookupSetting("trusted_advisor_rule")
+	if !exists {
+		return nil
+	}
+
+	return &TrustedAdvisorReport{
+		sess: sess,
+		rule: rule,
+		channels: getListSetting("trusted_advisor_channels", "slack"),
+	}
+}
+
+// A Trusted Advisor check with a warning or error status
+type FlaggedCheck struct {
+	Name string
+	Category string
+	Status string
+	ResourcesFlagged int64
+	EstimatedMonthlySavings float64 // Only for cost optimization checks
+}
+
+func init() {
+	registerScheduledTask(ScheduledTask {
+		name: "Trusted Advisor Report",
+		run: func(ctx context.Context, notifiers *NotifierRegistry, event lambdaevents.CloudWatchEvent) error {
+			if notifiers.trustedAdvisor == nil || !scheduledBy(event, notifiers.trustedAdvisor.rule) {
+				return nil
+			}
+
+			return notifiers.postTrustedAdvisorReport(ctx, time.Now())
+		},
+	})
+}
+
+// Returns the flagged checks, worst first. Check results are as of the last time Trusted Advisor refreshed them,
+// which it does by itself (at least weekly) for accounts with a Business support plan.
+func (t *TrustedAdvisorReport) fetch(ctx context.Context) ([]FlaggedCheck, error) {
+	svc := support.New(t.sess, aws.NewConfig().WithRegion(SupportAPIRegion))
+	traceAWSClient(svc.Client)
+
+	checks, err := svc.DescribeTrustedAdvisorChecksWithContext(ctx, &support.DescribeTrustedAdvisorChecksInput{
+		Language: aws.String("en"),
+	})
+	if err != nil {
+		return nil, errors.New("failed to list Trusted Advisor checks: " + err.Error())
+	}
+
+	descriptions := make(map[string]*support.TrustedAdvisorCheckDescription)
+	var ids []*string
+
+	for _, check := range checks.Checks {
+		descriptions[aws.StringValue(check.Id)] = check
+		ids = append(ids, check.Id)
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	summaries, err := svc.DescribeTrustedAdvisorCheckSummariesWithContext(ctx, &support.DescribeTrustedAdvisorCheckSummariesInput{
+		CheckIds: ids,
+	})
+	if err != nil {
+		return nil, errors.New("failed to get Trusted Advisor check summaries: " + err.Error())
+	}
+
+	var flagged []FlaggedCheck
+
+	for _, summary := range summaries.Summaries {
+		status := aws.StringValue(summary.Status)
+		description := descriptions[aws.StringValue(summary.CheckId)]
+
+		if _, exists := trustedAdvisorStatuses[status]; !exists || description == nil {
+			continue
+		}
+
+		check := FlaggedCheck{
+			Name: aws.StringValue(description.Name),
+			Category: aws.StringValue(description.Category),
+			Status: status,
+		}
+
+		if summary.ResourcesSummary != nil {
+			check.ResourcesFlagged = aws.Int64Value(summary.ResourcesSummary.ResourcesFlagged)
+		}
+
+		if specific := summary.CategorySpecificSummary; specific != nil && specific.CostOptimizing != nil {
+			check.EstimatedMonthlySavings = aws.Float64Value(specific.CostOptimizing.EstimatedMonthlySavings)
+		}
+
+		flagged = append(flagged, check)
+	}
+
+	sort.Slice(flagged, func(i, j int) bool {
+		if flagged[i].Status != flagged[j].Status {
+			return flagged[i].Status == "error"
+		}
+
+		if flagged[i].ResourcesFlagged != flagged[j].ResourcesFlagged {
+			return flagged[i].ResourcesFlagged > flagged[j].ResourcesFlagged
+		}
+
+		return flagged[i].Name < flagged[j].Name
+	})
+
+	return flagged, nil
+}
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Sends the report straight to the report channels, bypassing routing (like the daily report)
+func (r *NotifierRegistry) postTrustedAdvisorReport(ctx context.Context, now time.Time) error {
+	flagged, err := r.trustedAdvisor.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	notification := trustedAdvisorNotification(flagged, now)
+
+	var failures MultiError
+
+	for _, name := range r.trustedAdvisor.channels {
+		notifier, exists := r.notifiers[name]
+		if !exists {
+			logger(ctx).Warn("Skipping Trusted Advisor report for unknown channel", "channel", name)
+			continue
+		}
+
+		failures.add("Trusted Advisor report via " + name, notifier.Send(ctx, notification))
+	}
+
+	return failures.errorOrNil()
+}
+
+func trustedAdvisorNotification(flagged []FlaggedCheck, now time.Time) notify.Notification {
+	errorCounts := make(map[string]int)
+	warningCounts := make(map[string]int)
+	savings := 0.0
+
+	var top []string
+
+	for i, check := range flagged {
+		if check.Status == "error" {
+			errorCounts[check.Category]++
+		} else {
+			warningCounts[check.Category]++
+		}
+
+		savings += check.EstimatedMonthlySavings
+
+		if i < TrustedAdvisorTopChecks {
+			top = append(top, check.Name + ": " + strconv.FormatInt(check.ResourcesFlagged, 10) + " resource(s), " +
+				trustedAdvisorStatuses[check.Status])
+		}
+	}
+
+	if len(top) == 0 {
+		top = []string{"None"}
+	}
+
+	title := "Trusted Advisor - " + strconv.Itoa(len(flagged)) + " check(s) flagged"
+
+	severity := notify.SeverityInfo
+	if len(errorCounts) != 0 {
+		severity = notify.SeverityWarn
+	}
+
+	var fields []notify.Field
+
+	for _, category := range trustedAdvisorCategories {
+		value := strconv.Itoa(errorCounts[category.id]) + " red, " + strconv.Itoa(warningCounts[category.id]) + " yellow"
+
+		if category.id == "cost_optimizing" && savings > 0 {
+			value += "\nEstimated savings: " + formatCost(savings, "USD") + "/month"
+		}
+
+		fields = append(fields, notify.Field {
+			Title: category.name,
+			Value: value,
+			Short: true,
+		})
+	}
+
+	fields = append(fields, notify.Field {
+		Title: "Top Checks",
+		Value: strings.Join(top, "\n"),
+		Short: false,
+	})
+
+	return notify.Notification {
+		Source: "aws-notifier",
+		DetailType: "Trusted Advisor Report",
+		Title: title,
+		Summary: title,
+		Severity: severity,
+		Fields: fields,
+		ConsoleURL: "https://console.aws.amazon.com/trustedadvisor/home#/dashboard",
+		Time: now.UTC().Format(time.RFC3339),
+	}
+}
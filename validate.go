@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// Settings are checked up front (before any events are processed), so that a misconfigured deployment fails
+// with every problem listed, rather than on the first event which happens to need the broken setting
+
+var durationSettings = []string{"config_ttl", "dedupe_window", "circuit_breaker_cooldown", "message_templates_ttl",
+	"secret_refresh_interval", "correlation_window",
+	"timeline_retention"}
+var intSettings = []string{"slack_max_value_length", "dispatch_concurrency", "circuit_breaker_threshold",
+	"failed_notifications_max_attempts"}
+var floatSettings = []string{"rate_limit_per_minute", "rate_limit_burst"}
+var jsonSettings = []string{"severity_prefixes", "slack_channel_mentions", "slack_identities"}
+
+func validateSettings() error {
+	var problems ConfigError
+
+	slackWebhook, webhookExists := lookupSetting("slack_webhook")
+	_, tokenExists := lookupSetting("slack_token")
+
+	if webhookExists && !validWebhookURL(slackWebhook) {
+		problems.add("slack_webhook is not a valid https URL")
+	}
+
+	if heartbeatURL, exists := lookupSetting("heartbeat_url"); exists && !validWebhookURL(heartbeatURL) {
+		problems.add("heartbeat_url is not a valid https URL")
+	}
+
+	if _, exists := lookupSetting("slack_channel"); tokenExists && !exists {
+		problems.add("slack_channel is required with slack_token")
+	}
+
+	if format, exists := lookupSetting("slack_webhook_format"); exists &&
+		!contains([]string{notify.WebhookFormatAttachments, notify.WebhookFormatWorkflow}, format) {
+		problems.add("unsupported slack_webhook_format: " + format)
+	}
+
+	for _, account := range strings.Split(getSetting("allowed_accounts"), ",") {
+		if account = strings.TrimSpace(account); account != "" && !accountIDPattern.MatchString(account) {
+			problems.add("invalid account ID in allowed_accounts: " + account)
+		}
+	}
+
+	if format, exists := lookupSetting("raw_detail_format"); exists && format != RawDetailFields && format != RawDetailCode {
+		problems.add("unsupported raw_detail_format: " + format)
+	}
+
+	if severity := getSetting("pagerduty_min_severity"); severity != "" && !notify.ValidSeverity(severity) {
+		problems.add("invalid pagerduty_min_severity: " + severity)
+	}
+
+	if _, err := httpClientConfigFromSettings(); err != nil {
+		problems.add(err.Error())
+	}
+
+	for _, name := range durationSettings {
+		if value, exists := lookupSetting(name); exists {
+			if _, err := time.ParseDuration(value); err != nil {
+				problems.add("could not parse " + name + ": " + err.Error())
+			}
+		}
+	}
+
+	for _, name := range intSettings {
+		if value, exists := lookupSetting(name); exists {
+			if _, err := strconv.Atoi(value); err != nil {
+				problems.add("could not parse " + name + ": " + err.Error())
+			}
+		}
+	}
+
+	for _, name := range floatSettings {
+		if value, exists := lookupSetting(name); exists {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				problems.add("could not parse " + name + ": " + err.Error())
+			}
+		}
+	}
+
+	for _, name := range jsonSettings {
+		if value, exists := lookupSetting(name); exists {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+				problems.add("could not parse " + name + ": " + err.Error())
+			}
+		}
+	}
+
+	return problems.errorOrNil()
+}
+
+// The channels configured via settings rather than the config, which are only enabled when configured
+func settingsChannels() []string {
+	var names []string
+
+	_, webhookExists := lookupSetting("slack_webhook")
+	_, tokenExists := lookupSetting("slack_token")
+
+	if webhookExists || tokenExists {
+		names = append(names, "slack")
+	}
+
+	if _, exists := lookupSetting("pagerduty_key"); exists {
+		names = append(names, "pagerduty")
+	}
+
+	return names
+}
+
+// Checks the configured channels, and that everything referring to channels refers to ones which exist
+func (c *Config) validateChannels(problems *ConfigError) {
+	known := make(map[string]bool)
+	for _, name := range settingsChannels() {
+		known[name] = true
+	}
+
+	for name, channel := range c.Channels {
+		known[name] = true
+
+		switch channel.Type {
+		case "slack":
+			if channel.Webhook != "" && !validWebhookURL(channel.Webhook) {
+				problems.add("webhook for channel " + name + " is not a valid https URL")
+			}
+
+			if channel.WebhookFormat != "" &&
+				!contains([]string{notify.WebhookFormatAttachments, notify.WebhookFormatWorkflow}, channel.WebhookFormat) {
+				problems.add("unsupported webhook_format for channel " + name + ": " + channel.WebhookFormat)
+			}
+		case "pagerduty":
+			if channel.ServiceKey == "" {
+				problems.add("channel " + name + " is missing service_key")
+			}
+
+			if channel.MinSeverity != "" && !notify.ValidSeverity(channel.MinSeverity) {
+				problems.add("invalid min_severity for channel " + name + ": " + channel.MinSeverity)
+			}
+		case "webhook":
+			if !validWebhookURL(channel.Webhook) {
+				problems.add("webhook for channel " + name + " is not a valid https URL")
+			}
+		default:
+			problems.add("unsupported type for channel " + name + ": " + channel.Type)
+		}
+	}
+
+	check := func(where string, names []string) {
+		for _, name := range names {
+			if !known[name] {
+				problems.add(where + " refers to unknown channel: " + name)
+			}
+		}
+	}
+
+	check("default_channels", c.DefaultChannels)
+	check("delivery order", c.Delivery.Order)
+	check("delivery best_effort", c.Delivery.BestEffort)
+
+	for i, route := range c.Routes {
+		check("route " + strconv.Itoa(i + 1), route.Channels)
+	}
+
+	for i, rule := range c.Escalations {
+		check("escalation " + strconv.Itoa(i + 1), rule.Channels)
+	}
+
+	for id, account := range c.Accounts {
+		check("account " + id, account.Channels)
+	}
+
+	if c.TagRouting != nil {
+		for value, channels := range c.TagRouting.Channels {
+			check("tag_routing for " + value, channels)
+		}
+	}
+
+	for name := range c.QuietHours {
+		check("quiet_hours", []string{name})
+	}
+}
+
+func validWebhookURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != ""
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Set at build time, via:
+//   go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD)"
+// so that we can tell which build handled a given event
+var version = "dev"
+var commit = "unknown"
+
+func buildVersion() string {
+	return version + " (" + commit + ")"
+}
+
+// Short hash of the raw config, for telling which revision of it handled a given event
+func configFingerprint(raw []byte) string {
+	hash := sha256.Sum256(raw)
+	return hex.EncodeToString(hash[:])[:12]
+}
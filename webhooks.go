@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/motns/aws-notifier/pkg/events"
+	"github.com/motns/aws-notifier/pkg/notify"
+	"net/url"
+	"strings"
+)
+
+/**
+Example generic webhook payload (POST to /webhooks/generic):
+
+{
+  "source": "jenkins",
+  "title": "Deploy of payments-api failed",
+  "text": "Stage 'migrate' failed after 3 attempts",
+  "severity": "error",
+  "url": "https://jenkins.example.com/job/deploy/123/",
+  "fields": {
+    "Environment": "production",
+    "Triggered By": "alice"
+  }
+}
+
+AWS event payloads (like Cloudwatch Events or SNS records) can be POSTed to /webhooks/aws as is, and SNS topics
+can be subscribed to it as an HTTPS endpoint.
+*/
+
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Webhooks from external tools, coming in via a Lambda Function URL or API Gateway at /webhooks/<name>, where
+// the handler registered under that name turns the request into notifications (which go through the usual
+// routing). Requests need to carry the webhook_token, as a bearer token or a "token" query parameter, unless
+// the handler authenticates them some other way (like by verifying a signature).
+
+type WebhookHandler struct {
+	name string
+	authenticate func(ctx context.Context, req HTTPRequest) *HTTPResponse // Optional, returns nil if authenticated
+	handle func(ctx context.Context, notifiers *NotifierRegistry, req HTTPRequest) error
+}
+
+var webhookHandlers = make(map[string]WebhookHandler)
+
+func registerWebhookHandler(handler WebhookHandler) {
+	webhookHandlers[handler.name] = handler
+}
+
+// Returned by webhook handlers for payloads they can't make sense of, so that the caller gets a 400
+type InvalidPayloadError struct {
+	Err error
+}
+
+func (e *InvalidPayloadError) Error() string {
+	return "invalid payload: " + e.Err.Error()
+}
+
+func init() {
+	registerWebhookHandler(WebhookHandler {
+		name: "generic",
+		handle: processGenericWebhook,
+	})
+
+	registerWebhookHandler(WebhookHandler {
+		name: "aws",
+		handle: func(ctx context.Context, notifiers *NotifierRegistry, req HTTPRequest) error {
+			if isHTTPRequest(json.RawMessage(req.Body)) {
+				return &InvalidPayloadError{Err: errors.New("nested HTTP requests are not supported")}
+			}
+
+			// Scheduled events and self-tests only come from our own schedule rules, and would otherwise let anyone
+			// with the token run the reports and tasks (or page people)
+			var payload GenericEvent
+			if err := json.Unmarshal([]byte(req.Body), &payload); err == nil && isInternalPayload(payload) {
+				return &InvalidPayloadError{Err: errors.New("scheduled events and self-tests are not accepted via webhooks")}
+			}
+
+			// SNS topics can deliver to the webhook directly, by subscribing it as an HTTPS endpoint
+			var message events.SNSMessage
+			if err := json.Unmarshal([]byte(req.Body), &message); err == nil && message.TopicArn != "" &&
+				(message.Type == "Notification" || message.Type == "SubscriptionConfirmation") {
+				return processDeliveredSNSMessage(ctx, notifiers, message)
+			}
+
+			_, err := processMessage(ctx, notifiers, json.RawMessage(req.Body))
+			return err
+		},
+	})
+}
+
+func processWebhook(ctx context.Context, notifiers *NotifierRegistry, name string, req HTTPRequest) *HTTPResponse {
+	handler, exists := webhookHandlers[name]
+	if !exists {
+		return textResponse(404, "Not Found")
+	}
+
+	ctx = withLogAttrs(ctx, "webhook", name)
+	ctx = context.WithValue(ctx, webhookKey{}, name)
+
+	authenticate := handler.authenticate
+	if authenticate == nil {
+		authenticate = authenticateWebhookToken
+	}
+
+	if res := authenticate(ctx, req); res != nil {
+		return res
+	}
+
+	metrics(ctx).count("EventsReceived", "Source", "webhook:" + name)
+
+	if err := handler.handle(ctx, notifiers, req); err != nil {
+		logger(ctx).Error("Failed to process webhook", "error", err.Error())
+
+		if _, ok := err.(*InvalidPayloadError); ok {
+			return textResponse(400, "Bad Request")
+		}
+
+		return textResponse(500, "Internal Server Error")
+	}
+
+	return textResponse(200, "OK")
+}
+
+type webhookKey struct{}
+
+// Whether the event being processed came in via a webhook, rather than from AWS. Payloads only our own schedule
+// rules send (see isInternalPayload) are refused for these, including ones nested in an SQS batch or SNS message.
+func viaWebhook(ctx context.Context) bool {
+	_, ok := ctx.Value(webhookKey{}).(string)
+	return ok
+}
+
+func isInternalPayload(payload GenericEvent) bool {
+	return payload.Test != "" || (payload.Source == "aws.events" && payload.DetailType == "Scheduled Event")
+}
+
+func authenticateWebhookToken(ctx context.Context, req HTTPRequest) *HTTPResponse {
+	token := getSetting("webhook_token")
+	if token == "" {
+		logger(ctx).Warn("Rejecting webhook, as webhook_token is not configured")
+		return textResponse(403, "Forbidden")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(webhookToken(req)), []byte(token)) != 1 {
+		logger(ctx).Warn("Rejecting webhook with invalid token")
+		return textResponse(401, "Unauthorized")
+	}
+
+	return nil
+}
+
+func webhookToken(req HTTPRequest) string {
+	if auth := req.Headers["authorization"]; strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	if token, exists := req.QueryStringParameters["token"]; exists {
+		return token
+	}
+
+	query, err := url.ParseQuery(req.RawQueryString)
+	if err != nil {
+		return ""
+	}
+
+	return query.Get("token")
+}
+
+func processGenericWebhook(ctx context.Context, notifiers *NotifierRegistry, req HTTPRequest) error {
+	var payload events.GenericWebhook
+
+	if err := json.Unmarshal([]byte(req.Body), &payload); err != nil {
+		return &InvalidPayloadError{Err: err}
+	}
+
+	if payload.Title == "" {
+		return &InvalidPayloadError{Err: errors.New("missing title")}
+	}
+
+	if payload.Severity == "" {
+		payload.Severity = notify.SeverityInfo
+	} else if !notify.ValidSeverity(payload.Severity) {
+		return &InvalidPayloadError{Err: errors.New("invalid severity: " + payload.Severity)}
+	}
+
+	if payload.Source == "" {
+		payload.Source = "webhook"
+	}
+
+	return notifiers.send(ctx, events.GenericWebhookNotification(payload))
+}
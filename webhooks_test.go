@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWebhookToken(t *testing.T) {
+	tests := []struct {
+		name string
+		req HTTPRequest
+		expected string
+	}{
+		{
+			name: "bearer token",
+			req: HTTPRequest{Headers: map[string]string{"authorization": "Bearer s3cret"}},
+			expected: "s3cret",
+		},
+		{
+			name: "query parameter (payload format 1.0)",
+			req: HTTPRequest{QueryStringParameters: map[string]string{"token": "s3cret"}},
+			expected: "s3cret",
+		},
+		{
+			name: "raw query string (payload format 2.0)",
+			req: HTTPRequest{RawQueryString: "source=ci&token=s3cret"},
+			expected: "s3cret",
+		},
+		{
+			name: "escaped query string",
+			req: HTTPRequest{RawQueryString: "token=s3cret%2B1"},
+			expected: "s3cret+1",
+		},
+		{
+			name: "bearer token takes precedence",
+			req: HTTPRequest {
+				Headers: map[string]string{"authorization": "Bearer s3cret"},
+				RawQueryString: "token=other",
+			},
+			expected: "s3cret",
+		},
+		{
+			name: "basic auth is ignored",
+			req: HTTPRequest{Headers: map[string]string{"authorization": "Basic czNjcmV0"}, RawQueryString: "token=s3cret"},
+			expected: "s3cret",
+		},
+		{
+			name: "invalid query string",
+			req: HTTPRequest{RawQueryString: "token=%zz"},
+			expected: "",
+		},
+		{
+			name: "missing",
+			req: HTTPRequest{},
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := webhookToken(test.req); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWebhookToken(t *testing.T) {
+	tests := []struct {
+		name string
+		configured string
+		req HTTPRequest
+		status int // Zero if authenticated
+	}{
+		{"valid bearer token", "s3cret", HTTPRequest{Headers: map[string]string{"authorization": "Bearer s3cret"}}, 0},
+		{"valid query parameter", "s3cret", HTTPRequest{RawQueryString: "token=s3cret"}, 0},
+		{"wrong token", "s3cret", HTTPRequest{Headers: map[string]string{"authorization": "Bearer s3cre"}}, 401},
+		{"token with a prefix", "s3cret", HTTPRequest{Headers: map[string]string{"authorization": "Bearer s3cret2"}}, 401},
+		{"different case", "s3cret", HTTPRequest{RawQueryString: "token=S3CRET"}, 401},
+		{"missing token", "s3cret", HTTPRequest{}, 401},
+		{"not configured", "", HTTPRequest{}, 403},
+		{"not configured, with an empty token", "", HTTPRequest{RawQueryString: "token="}, 403},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("webhook_token", test.configured)
+
+			res := authenticateWebhookToken(context.Background(), test.req)
+
+			if test.status == 0 && res != nil {
+				t.Errorf("expected the request to be authenticated, got %d", res.StatusCode)
+			} else if test.status != 0 && (res == nil || res.StatusCode != test.status) {
+				t.Errorf("expected %d, got %+v", test.status, res)
+			}
+		})
+	}
+}
+
+// Scheduled events and self-tests only come from our own schedule rules, and are refused via webhooks
+func TestProcessWebhookAWS(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		status int
+	}{
+		{"scheduled event", `{"source": "aws.events", "detail-type": "Scheduled Event", "id": "1"}`, 400},
+		{"self-test", `{"test": "slack"}`, 400},
+		{"nested HTTP request", `{"rawPath": "/webhooks/aws", "headers": {}, "body": "{}", "requestContext": {"http": {"method": "POST"}}}`, 400},
+		{"Cloudwatch Event", `{"source": "aws.ec2", "detail-type": "EC2 Instance State-change Notification", "id": "1", "detail": {"instance-id": "i-1", "state": "running"}}`, 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("webhook_token", "s3cret")
+
+			notifier := &recordingNotifier{}
+			registry := newNotifierRegistry()
+			registry.register("test", notifier)
+
+			req := HTTPRequest {
+				Headers: map[string]string{"authorization": "Bearer s3cret"},
+				Body: test.body,
+			}
+
+			res := processWebhook(context.Background(), registry, "aws", req)
+			if res.StatusCode != test.status {
+				t.Errorf("expected %d, got %d (%s)", test.status, res.StatusCode, res.Body)
+			}
+
+			if test.status != 200 && notifier.count() != 0 {
+				t.Errorf("expected nothing to be sent, got %d notifications", notifier.count())
+			}
+		})
+	}
+}
+
+func TestProcessWebhookUnknown(t *testing.T) {
+	t.Setenv("webhook_token", "s3cret")
+
+	req := HTTPRequest{Headers: map[string]string{"authorization": "Bearer s3cret"}, Body: "{}"}
+
+	if res := processWebhook(context.Background(), newNotifierRegistry(), "nagios", req); res.StatusCode != 404 {
+		t.Errorf("expected 404, got %d", res.StatusCode)
+	}
+}